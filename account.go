@@ -4,17 +4,40 @@ package wallet
 import (
 	"context"
 	"errors"
+	"time"
 
 	"github.com/cockroachdb/apd"
-	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 )
 
 var (
 	// ErrNoAccount is returned when an account is not found in storage by ID
 	ErrNoAccount = errors.New("Account does not exist")
+	// ErrAccountExists is returned when storing an account with an ID that is already used
+	ErrAccountExists = errors.New("Account already exists")
+	// ErrNoPayment is returned when a payment is not found in storage
+	ErrNoPayment = errors.New("Payment does not exist")
+	// ErrNoCurrency is returned when a currency is not found in storage by code
+	ErrNoCurrency = errors.New("Currency does not exist")
+	// ErrCurrencyExponentChanged is returned when storing a currency with a
+	// different exponent than the one already stored
+	ErrCurrencyExponentChanged = errors.New("The exponent of an existing currency cannot be changed")
+	// ErrNoExchangeRate is returned when there is no exchange rate between two currencies
+	ErrNoExchangeRate = errors.New("No exchange rate between the currencies")
+	// ErrNoFeeSchedule is returned when there is no fee schedule for a currency
+	ErrNoFeeSchedule = errors.New("No fee schedule for the currency")
+	// ErrInvalidCursor is returned when a page cursor is malformed
+	ErrInvalidCursor = errors.New("Invalid page cursor")
+	// ErrNegativeBalance is returned when storing a payment that would make
+	// the balance of a user account negative
+	ErrNegativeBalance = errors.New("Account balance cannot be negative")
+	// ErrIdempotencyKeyReused is returned when an idempotency key is reused
+	// for a request that is different from the original request
+	ErrIdempotencyKeyReused = errors.New("Idempotency key was already used for a different request")
 )
 
+// Codes of currencies that are created by the database migrations.
+// Other currencies can be added at runtime, see CurrencyRepository.
 const (
 	// USD United States Dollar
 	USD string = "USD"
@@ -24,38 +47,204 @@ const (
 	SGD string = "SGD"
 	// GBP British Pound
 	GBP string = "GBP"
+	// JPY Japanese Yen, disabled by default
+	JPY string = "JPY"
+	// KWD Kuwaiti Dinar, disabled by default
+	KWD string = "KWD"
 )
 
-// IsValidCurrency returns true if the currency code is valid
-func IsValidCurrency(currency string) bool {
-	switch currency {
-	case USD, EUR, SGD, GBP:
-		return true
-	}
-	return false
+// Currency is a currency that accounts can hold
+type Currency struct {
+	// Code is the ISO 4217 currency code
+	Code string
+	// Exponent is the number of decimal places of the currency's minor unit,
+	// e.g. 2 for USD and 0 for JPY. Amounts in the currency must not have more decimal places.
+	Exponent int32
+	// Enabled is true if the currency can be used for new accounts and exchange rates
+	Enabled bool
+}
+
+// CurrencyRepository is the storage interface for currencies
+type CurrencyRepository interface {
+	// Get returns a currency. ErrNoCurrency is returned if the currency does not exist.
+	Get(ctx context.Context, code string) (*Currency, error)
+	// Store creates a currency or updates whether it is enabled.
+	// ErrCurrencyExponentChanged is returned if the currency exists with a different exponent.
+	Store(ctx context.Context, currency *Currency) error
+	// All returns all currencies ordered by code
+	All(ctx context.Context) ([]Currency, error)
 }
 
-// Account represents a user account in the wallet system
+const (
+	// AccountKindUser is an account held by a user of the wallet system
+	AccountKindUser = "user"
+	// AccountKindExternal is a system account that is the counterparty of deposits into
+	// and withdrawals out of the wallet system. There is one external account per currency.
+	AccountKindExternal = "external"
+	// AccountKindExchange is a system account that converts between currencies.
+	// A payment between accounts of different currencies is posted to the exchange
+	// account of each currency, see PaymentPostings. There is one exchange account per currency.
+	AccountKindExchange = "exchange"
+	// AccountKindRevenue is a system account that is credited the fees charged on transfers.
+	// There is one revenue account per currency.
+	AccountKindRevenue = "revenue"
+)
+
+// Statuses of an account
+const (
+	// AccountActive is an account that can send and receive payments
+	AccountActive = "active"
+	// AccountFrozen is an account that can receive payments, but can't send them
+	AccountFrozen = "frozen"
+	// AccountClosed is an account that can't send or receive payments, and can't be reopened
+	AccountClosed = "closed"
+)
+
+// Account represents an account in the wallet system
 type Account struct {
-	ID       uuid.UUID
-	Balance  *apd.Decimal
+	ID uuid.UUID
+	// Balance is the ledger balance, the sum of the account's postings
+	Balance *apd.Decimal
+	// Held is the sum of the amounts of the account's active holds, or nil if it is not known
+	Held     *apd.Decimal
 	Currency string
+	// Kind is AccountKindUser for accounts held by users, otherwise the account is a system account
+	Kind string
+	// Status is AccountActive, AccountFrozen or AccountClosed
+	Status string
+	// OwnerID is the customer that holds the account, or nil if it has no owner.
+	// System accounts have no owner.
+	OwnerID   *uuid.UUID
+	CreatedAt time.Time
+}
+
+// IsUser returns true if the account is held by a user of the wallet system
+func (a Account) IsUser() bool {
+	return a.Kind == AccountKindUser
+}
+
+// Available returns the balance that can be spent, which is the balance less the held amount
+func (a Account) Available() (*apd.Decimal, error) {
+	if a.Held == nil {
+		return a.Balance, nil
+	}
+
+	var d apd.Decimal
+	if _, err := apd.BaseContext.Sub(&d, a.Balance, a.Held); err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
+
+// Tx is a storage transaction, created by UnitOfWork.Do.
+// Its implementation depends on the storage backend, and it must only be
+// passed to repositories of the same backend as the UnitOfWork that created it.
+type Tx interface{}
+
+// UnitOfWork runs functions in storage transactions
+type UnitOfWork interface {
+	// Do calls f in a transaction. The transaction is committed if f returns nil,
+	// otherwise it is rolled back and the error is returned.
+	// The transaction is added to the context passed to f, see ContextWithTx,
+	// and repository methods called with that context use it.
+	// If ctx already has a transaction of the same storage, f is called in a
+	// savepoint of that transaction instead, which is rolled back if f returns an error
+	// without rolling back the rest of the transaction.
+	Do(ctx context.Context, f func(ctx context.Context, tx Tx) error) error
+}
+
+type txContextKey struct{}
+
+// ContextWithTx returns a copy of ctx that carries tx
+func ContextWithTx(ctx context.Context, tx Tx) context.Context {
+	return context.WithValue(ctx, txContextKey{}, tx)
+}
+
+// TxFromContext returns the transaction carried by ctx, or nil if there is none
+func TxFromContext(ctx context.Context) Tx {
+	return ctx.Value(txContextKey{})
 }
 
-// AccountRepository is the storage interface for accounts
+// AccountRepository is the storage interface for accounts.
+// Methods without a Tx parameter use the transaction of their context, if it has one.
 type AccountRepository interface {
+	// Store creates an account. If the account's Kind is empty, a user account is created.
+	// ErrNoCustomer is returned if the account's owner does not exist.
 	Store(ctx context.Context, account *Account) error
-	GetTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*Account, error)
-	All(ctx context.Context) ([]Account, error)
+	Get(ctx context.Context, id uuid.UUID) (*Account, error)
+	// GetTx returns an account and locks it until the transaction completes
+	GetTx(ctx context.Context, tx Tx, id uuid.UUID) (*Account, error)
+	// UpdateStatusTx sets the status of an account to account.Status.
+	// The account should be locked by the transaction, see GetTx.
+	UpdateStatusTx(ctx context.Context, tx Tx, account *Account) error
+	// SystemAccountIDTx returns the ID of the system account of a kind for a currency,
+	// such as AccountKindExternal, creating the account if it does not exist.
+	// The account is not locked.
+	SystemAccountIDTx(ctx context.Context, tx Tx, kind, currency string) (uuid.UUID, error)
+	// List returns a page of user accounts ordered by ID, and the cursor for the next page.
+	// The next cursor is empty if there are no more accounts.
+	List(ctx context.Context, page Page) ([]Account, string, error)
+	// ListByOwner returns a page of a customer's accounts ordered by ID, and the cursor for the next page
+	ListByOwner(ctx context.Context, ownerID uuid.UUID, page Page) ([]Account, string, error)
+	// BalanceDrifts recomputes the balance of every account from its journal postings, and
+	// returns the accounts whose stored balance is different, ordered by ID
+	BalanceDrifts(ctx context.Context) ([]BalanceDrift, error)
+}
+
+// BalanceDrift is an account whose stored balance is different from the sum of its postings
+type BalanceDrift struct {
+	AccountID uuid.UUID
+	// Balance is the stored balance
+	Balance *apd.Decimal
+	// LedgerBalance is the sum of the account's postings
+	LedgerBalance *apd.Decimal
+}
+
+// Page describes a page of results to fetch from a list.
+// Lists are paginated by a cursor, which is an opaque position in the list
+// returned along with the previous page.
+type Page struct {
+	// Cursor is the position to start from, or empty for the first page
+	Cursor string
+	// Limit is the maximum number of results to return
+	Limit int
 }
 
 // Payment represent a transfer from one account to another.
-// A payment with a null "From" field is a credit to the "To" account.
+// Deposits are payments from an external account and withdrawals are payments to an external account.
+// A payment with a null "From" field is a credit to the "To" account, made before external accounts existed,
+// which is posted to the journal as a debit of the external account of the "To" account's currency.
 type Payment struct {
 	ID     uuid.UUID
 	To     uuid.UUID
 	From   *uuid.UUID
 	Amount *apd.Decimal
+	// ToAmount is the amount credited to the "To" account, in its currency,
+	// if the accounts have different currencies. Otherwise it is nil.
+	ToAmount *apd.Decimal
+	// Rate is the exchange rate used to convert Amount to ToAmount, if
+	// the accounts have different currencies. Otherwise it is nil.
+	Rate *apd.Decimal
+	// IdempotencyKey is an optional client-supplied key that identifies the
+	// request that created the payment
+	IdempotencyKey string
+	// ReversalOf is the ID of the payment that this payment refunds, or nil if it is not a refund
+	ReversalOf *uuid.UUID
+	// RefundedAmount is the total amount of the payment that has been refunded,
+	// in the currency credited to the "To" account
+	RefundedAmount *apd.Decimal
+	// Fee is the fee charged to the "From" account in addition to Amount, in its currency,
+	// which is credited to the revenue account of the currency. It is nil if no fee was charged.
+	Fee *apd.Decimal
+	// APIKeyID is the ID of the API key that authenticated the request that created the payment,
+	// or nil if it was not created by an authenticated request
+	APIKeyID *uuid.UUID
+	// Postings are the postings of the payment's journal entry. If they are nil when the
+	// payment is stored, the postings returned by PaymentPostings are stored.
+	// They are set when the payment is stored, and are not loaded with the payment,
+	// see PaymentRepository.JournalEntry.
+	Postings  []Posting
+	CreatedAt time.Time
 }
 
 // FromUUIDString returns the From field's UUID string if set,
@@ -67,20 +256,245 @@ func (p Payment) FromUUIDString() string {
 	return p.From.String()
 }
 
-// PaymentRepository is the storage interface for payments
+// AccountPayment is a payment from the point of view of one of its accounts.
+// Credits to the account have a positive amount and debits have a negative amount.
+type AccountPayment struct {
+	AccountID uuid.UUID
+	PaymentID uuid.UUID
+	// Counterparty is the payment's other account, or nil for a credit from outside the system
+	Counterparty *uuid.UUID
+	// Amount is the total of the account's journal postings for the payment,
+	// which includes the fee of a debit
+	Amount *apd.Decimal
+	// Fee is the fee charged to the account for a debit, or nil if there was none
+	Fee *apd.Decimal
+	// Balance is the account's balance after the payment
+	Balance   *apd.Decimal
+	CreatedAt time.Time
+}
+
+// TimeRange filters results by time. Since is inclusive and Until is exclusive.
+// A zero value for either means the range is unbounded on that side.
+type TimeRange struct {
+	Since time.Time
+	Until time.Time
+}
+
+// CreditAmount returns the amount credited to the "To" account
+func (p Payment) CreditAmount() *apd.Decimal {
+	if p.ToAmount != nil {
+		return p.ToAmount
+	}
+	return p.Amount
+}
+
+// PaymentRepository is the storage interface for payments.
+// Methods without a Tx parameter use the transaction of their context, if it has one.
 type PaymentRepository interface {
-	WithTx(ctx context.Context, f func(ctx context.Context, tx *sqlx.Tx) error) error
-	StoreTx(ctx context.Context, tx *sqlx.Tx, payment *Payment) error
+	// StoreTx stores a payment and its journal entry, and adds the amount of each posting
+	// to the balance of its account. The payment's amounts are rounded to the decimal places
+	// of their currencies, and are updated to the stored amounts.
+	// ErrUnbalancedEntry is returned if the postings are not balanced, and ErrNegativeBalance
+	// is returned if the balance of a user account would become negative. If the payment is a refund,
+	// the refunded amount of the original payment is increased by the payment's amount.
+	StoreTx(ctx context.Context, tx Tx, payment *Payment) error
+	// GetTx returns a payment and locks it until the transaction completes
+	GetTx(ctx context.Context, tx Tx, id uuid.UUID) (*Payment, error)
+	// JournalEntry returns the journal entry of a payment
+	JournalEntry(ctx context.Context, paymentID uuid.UUID) (*JournalEntry, error)
+	// GetByIdempotencyKeyTx returns the payment created with an idempotency key
+	GetByIdempotencyKeyTx(ctx context.Context, tx Tx, key string) (*Payment, error)
 	Store(ctx context.Context, payment *Payment) error
-	All(ctx context.Context) ([]Payment, error)
+	// List returns a page of payments ordered by creation time, and the cursor for the next page.
+	// The next cursor is empty if there are no more payments.
+	List(ctx context.Context, page Page) ([]Payment, string, error)
+	// ListByOwner returns a page of the payments from or to a customer's accounts,
+	// ordered by creation time, and the cursor for the next page
+	ListByOwner(ctx context.Context, ownerID uuid.UUID, page Page) ([]Payment, string, error)
+	// ListAccountPayments returns a page of an account's payments within a time range,
+	// newest first, and the cursor for the next page
+	ListAccountPayments(ctx context.Context, accountID uuid.UUID, r TimeRange, page Page) ([]AccountPayment, string, error)
+}
+
+// ExchangeRate is the rate to convert an amount in one currency to another currency
+type ExchangeRate struct {
+	From      string
+	To        string
+	Rate      *apd.Decimal
+	UpdatedAt time.Time
+}
+
+// RateProvider provides exchange rates between currencies
+type RateProvider interface {
+	// Rate returns the rate to convert an amount in the "from" currency to the "to" currency.
+	// ErrNoExchangeRate is returned if there is no rate between the currencies.
+	Rate(ctx context.Context, from, to string) (*apd.Decimal, error)
+}
+
+// RateRepository is the storage interface for exchange rates
+type RateRepository interface {
+	RateProvider
+	// Store creates or replaces the rate between two currencies
+	Store(ctx context.Context, rate *ExchangeRate) error
+	All(ctx context.Context) ([]ExchangeRate, error)
+}
+
+// FeeSchedule is the fee charged on transfers from accounts of a currency, in that currency.
+// The fee is Flat plus Percent percent of the transferred amount, and is then raised to Min
+// or lowered to Max, if they are set.
+type FeeSchedule struct {
+	Currency string
+	Flat     *apd.Decimal
+	Percent  *apd.Decimal
+	// Min is the minimum fee, or nil for no minimum
+	Min *apd.Decimal
+	// Max is the maximum fee, or nil for no maximum
+	Max       *apd.Decimal
+	UpdatedAt time.Time
+}
+
+// FeeRepository is the storage interface for fee schedules
+type FeeRepository interface {
+	// Schedule returns the fee schedule of a currency.
+	// ErrNoFeeSchedule is returned if transfers in the currency have no fee.
+	Schedule(ctx context.Context, currency string) (*FeeSchedule, error)
+	// Store creates or replaces the fee schedule of a currency
+	Store(ctx context.Context, schedule *FeeSchedule) error
+	All(ctx context.Context) ([]FeeSchedule, error)
+}
+
+const (
+	// BatchModeAtomic makes all transfers of a batch, or none of them if any transfer fails
+	BatchModeAtomic = "atomic"
+	// BatchModeBestEffort makes each transfer of a batch that succeeds, and reports
+	// the error of each transfer that fails
+	BatchModeBestEffort = "best_effort"
+)
+
+// TransferRequest is a transfer of an amount from one account to another, in a batch
+type TransferRequest struct {
+	To     uuid.UUID
+	From   uuid.UUID
+	Amount *apd.Decimal
+}
+
+// TransferResult is the result of a transfer in a batch.
+// Payment is set if the transfer was made, otherwise Err is set.
+type TransferResult struct {
+	Payment *Payment
+	Err     error
 }
 
 // Service defines the payment transfer service
 type Service interface {
-	// Transfer transfers an amount of money from one account to another
-	Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal) (*Payment, error)
-	// Payments returns all payments
-	Payments(ctx context.Context) ([]Payment, error)
-	// Accounts returns all accounts
-	Accounts(ctx context.Context) ([]Account, error)
+	// Transfer transfers an amount of money from one account to another.
+	// The fee of the sending account's currency is charged to the sender, see FeeSchedule.
+	// If idempotencyKey is not empty and a payment was already made with the same key,
+	// the original payment is returned instead of making a new one.
+	Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*Payment, error)
+	// TransferBatch makes transfers in one transaction, in order, with a mode of BatchModeAtomic
+	// or BatchModeBestEffort. A result is returned for each transfer, in the same order.
+	// In BatchModeAtomic, the first error of a transfer is returned and no transfers are made.
+	TransferBatch(ctx context.Context, transfers []TransferRequest, mode string) ([]TransferResult, error)
+	// ScheduleTransfer schedules a transfer to be made after executeAt, which must be in the future
+	ScheduleTransfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, executeAt time.Time) (*ScheduledTransfer, error)
+	// ScheduledTransfers returns a page of scheduled transfers with a status, or with any status
+	// if status is empty, ordered by their execution time, and the cursor for the next page
+	ScheduledTransfers(ctx context.Context, status string, page Page) ([]ScheduledTransfer, string, error)
+	// CancelScheduledTransfer cancels a pending scheduled transfer
+	CancelScheduledTransfer(ctx context.Context, id uuid.UUID) (*ScheduledTransfer, error)
+	// ExecuteScheduledTransfers makes the scheduled transfers that are due with Transfer, each in
+	// its own transaction, and records whether they were executed or failed.
+	// The payments record the API key that scheduled each transfer.
+	// It returns the number of scheduled transfers that were processed.
+	ExecuteScheduledTransfers(ctx context.Context) (int, error)
+	// CreateStandingOrder creates a standing order from the To, From, Amount, Frequency, Interval,
+	// StartAt and EndAt of order. StartAt must be in the future.
+	CreateStandingOrder(ctx context.Context, order StandingOrder) (*StandingOrder, error)
+	// StandingOrders returns a page of standing orders ordered by creation time, and the cursor for the next page
+	StandingOrders(ctx context.Context, page Page) ([]StandingOrder, string, error)
+	// StandingOrder returns a standing order
+	StandingOrder(ctx context.Context, id uuid.UUID) (*StandingOrder, error)
+	// UpdateStandingOrder changes the amount and end of an active standing order.
+	// A nil endAt removes the end. The order is completed if its next occurrence is after the new end.
+	UpdateStandingOrder(ctx context.Context, id uuid.UUID, amount *apd.Decimal, endAt *time.Time) (*StandingOrder, error)
+	// CancelStandingOrder cancels an active standing order, so that no more occurrences are made
+	CancelStandingOrder(ctx context.Context, id uuid.UUID) (*StandingOrder, error)
+	// StandingOrderOccurrences returns a page of the occurrences of a standing order that have been
+	// attempted, ordered by their scheduled time, and the cursor for the next page
+	StandingOrderOccurrences(ctx context.Context, id uuid.UUID, page Page) ([]StandingOrderOccurrence, string, error)
+	// ExecuteStandingOrders makes the occurrences of standing orders that are due with Transfer,
+	// each in its own transaction, and records the result of each occurrence.
+	// A failed occurrence is retried later, until it has been attempted a limited number of times.
+	// The payments record the API key that created each order.
+	// It returns the occurrences that were attempted, so that failures can be reported.
+	ExecuteStandingOrders(ctx context.Context) ([]StandingOrderOccurrence, error)
+	// Hold reserves an amount of an account's available balance until expiresAt, so that it can only
+	// be spent by capturing the hold. A zero expiresAt uses a default expiry.
+	Hold(ctx context.Context, from uuid.UUID, amount *apd.Decimal, expiresAt time.Time) (*Hold, error)
+	// GetHold returns a hold
+	GetHold(ctx context.Context, id uuid.UUID) (*Hold, error)
+	// Capture transfers an amount of an active hold to an account with Transfer, and releases the rest of the hold.
+	// If amount is nil, the whole hold is captured.
+	Capture(ctx context.Context, holdID, to uuid.UUID, amount *apd.Decimal) (*Payment, error)
+	// Release releases an active hold without a transfer, so that its amount is available again
+	Release(ctx context.Context, holdID uuid.UUID) (*Hold, error)
+	// ExpireHolds expires the active holds that have passed their expiry, and returns the number of holds expired
+	ExpireHolds(ctx context.Context) (int, error)
+	// Deposit credits an amount from outside the wallet system to an account.
+	// The payment is made from the external account of the account's currency.
+	// idempotencyKey is handled the same as for Transfer.
+	Deposit(ctx context.Context, to uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*Payment, error)
+	// Withdraw debits an amount from an account to outside the wallet system.
+	// The payment is made to the external account of the account's currency.
+	// idempotencyKey is handled the same as for Transfer.
+	Withdraw(ctx context.Context, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*Payment, error)
+	// Refund refunds an amount of a payment to its sender, in the currency credited to the receiver.
+	// If amount is nil, the remaining unrefunded amount is refunded.
+	// idempotencyKey is handled the same as for Transfer.
+	Refund(ctx context.Context, paymentID uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*Payment, error)
+	// Payments returns a page of payments, oldest first, and the cursor for the next page
+	Payments(ctx context.Context, page Page) ([]Payment, string, error)
+	// Accounts returns a page of accounts and the cursor for the next page
+	Accounts(ctx context.Context, page Page) ([]Account, string, error)
+	// Account returns an account
+	Account(ctx context.Context, id uuid.UUID) (*Account, error)
+	// AccountPayments returns a page of an account's payments within a time range,
+	// newest first, and the cursor for the next page
+	AccountPayments(ctx context.Context, id uuid.UUID, r TimeRange, page Page) ([]AccountPayment, string, error)
+	// ExchangeRates returns all exchange rates
+	ExchangeRates(ctx context.Context) ([]ExchangeRate, error)
+	// SetExchangeRate sets the rate used to convert amounts from one currency to another
+	SetExchangeRate(ctx context.Context, from, to string, rate *apd.Decimal) (*ExchangeRate, error)
+	// FeeSchedules returns the fee schedules of all currencies
+	FeeSchedules(ctx context.Context) ([]FeeSchedule, error)
+	// SetFeeSchedule creates or replaces the fee schedule of a currency.
+	// A nil Flat or Percent is zero.
+	SetFeeSchedule(ctx context.Context, schedule FeeSchedule) (*FeeSchedule, error)
+	// SpendingLimits returns the spending limits of all currencies and accounts
+	SpendingLimits(ctx context.Context) ([]SpendingLimit, error)
+	// SetSpendingLimit creates or replaces the spending limit of an account, or of a currency
+	// if the limit's AccountID is nil. Transfers that would exceed the limit are rejected.
+	SetSpendingLimit(ctx context.Context, limit SpendingLimit) (*SpendingLimit, error)
+	// Currencies returns all currencies
+	Currencies(ctx context.Context) ([]Currency, error)
+	// SetCurrency creates a currency or enables or disables an existing currency
+	SetCurrency(ctx context.Context, code string, exponent int32, enabled bool) (*Currency, error)
+	// SetAccountStatus freezes, unfreezes or closes a user account, see AccountActive.
+	// A closed account can't be reopened, and an account must have a zero balance to be closed.
+	SetAccountStatus(ctx context.Context, id uuid.UUID, status string) (*Account, error)
+	// CreateAccount creates an account with a zero balance, held by the customer ownerID if it is not nil.
+	// If id is uuid.Nil, a new ID is generated.
+	CreateAccount(ctx context.Context, id uuid.UUID, currency string, ownerID *uuid.UUID) (*Account, error)
+	// CreateCustomer creates a customer. If id is uuid.Nil, a new ID is generated.
+	CreateCustomer(ctx context.Context, id uuid.UUID, name string) (*Customer, error)
+	// Customer returns a customer
+	Customer(ctx context.Context, id uuid.UUID) (*Customer, error)
+	// CustomerAccounts returns a page of a customer's accounts and the cursor for the next page
+	CustomerAccounts(ctx context.Context, id uuid.UUID, page Page) ([]Account, string, error)
+	// CustomerBalances returns the total balance of a customer's accounts in each currency
+	CustomerBalances(ctx context.Context, id uuid.UUID) ([]CustomerBalance, error)
+	// CustomerPayments returns a page of the payments from or to a customer's accounts, oldest first,
+	// and the cursor for the next page
+	CustomerPayments(ctx context.Context, id uuid.UUID, page Page) ([]Payment, string, error)
 }
@@ -0,0 +1,114 @@
+package wallet
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// Scopes of an API key, which are the operations that requests authenticated with it can perform
+const (
+	// ScopeAccountsRead allows reading accounts, customers and their balances
+	ScopeAccountsRead = "accounts:read"
+	// ScopeAccountsWrite allows creating accounts and customers
+	ScopeAccountsWrite = "accounts:write"
+	// ScopePaymentsRead allows reading payments, scheduled transfers, standing orders and holds
+	ScopePaymentsRead = "payments:read"
+	// ScopeTransferWrite allows moving money: transfers, deposits, withdrawals, refunds,
+	// scheduled transfers, standing orders and holds
+	ScopeTransferWrite = "transfer:write"
+	// ScopeAdmin allows the /v1/admin endpoints
+	ScopeAdmin = "admin"
+)
+
+// Scopes are all of the scopes of API keys
+var Scopes = []string{ScopeAccountsRead, ScopeAccountsWrite, ScopePaymentsRead, ScopeTransferWrite, ScopeAdmin}
+
+var (
+	// ErrNoAPIKey is returned if an API key does not exist
+	ErrNoAPIKey = errors.New("API key does not exist")
+	// ErrAPIKeyRevoked is returned when revoking an API key that was already revoked
+	ErrAPIKeyRevoked = errors.New("API key has already been revoked")
+)
+
+// apiKeyTokenPrefix is the prefix of API key tokens, which makes them recognizable
+const apiKeyTokenPrefix = "wk_"
+
+// APIKey authenticates requests to the API. Only the hash of its token is stored,
+// see NewAPIKeyToken and HashAPIKeyToken.
+type APIKey struct {
+	ID   uuid.UUID
+	Name string
+	// Scopes are the operations that requests authenticated with the key can perform
+	Scopes    []string
+	CreatedAt time.Time
+	// RevokedAt is the time the key was revoked, or nil if it is active
+	RevokedAt *time.Time
+}
+
+// HasScope returns true if the key has the scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidScope returns true if scope is one of Scopes
+func IsValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// NewAPIKeyToken generates a random API key token. The token is shown to the key's holder once,
+// and only its hash is stored.
+func NewAPIKeyToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return apiKeyTokenPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashAPIKeyToken returns the hash of an API key token, which is stored instead of the token.
+// Tokens are random, so an unsalted hash is sufficient to look them up without storing them.
+func HashAPIKeyToken(token string) []byte {
+	h := sha256.Sum256([]byte(token))
+	return h[:]
+}
+
+// APIKeyRepository is the storage interface for API keys
+type APIKeyRepository interface {
+	// Store creates an API key with the hash of its token
+	Store(ctx context.Context, key *APIKey, hash []byte) error
+	// GetByHash returns the API key with the hash of a token, including revoked keys.
+	// ErrNoAPIKey is returned if there is no such key.
+	GetByHash(ctx context.Context, hash []byte) (*APIKey, error)
+	// Revoke revokes an API key. ErrNoAPIKey is returned if it does not exist,
+	// and ErrAPIKeyRevoked if it was already revoked.
+	Revoke(ctx context.Context, id uuid.UUID) (*APIKey, error)
+}
+
+type apiKeyIDContextKey struct{}
+
+// ContextWithAPIKeyID returns a copy of ctx that carries the ID of the API key that authenticated a request
+func ContextWithAPIKeyID(ctx context.Context, id uuid.UUID) context.Context {
+	return context.WithValue(ctx, apiKeyIDContextKey{}, id)
+}
+
+// APIKeyIDFromContext returns the ID of the API key carried by ctx, and false if there is none
+func APIKeyIDFromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(apiKeyIDContextKey{}).(uuid.UUID)
+	return id, ok
+}
@@ -40,6 +40,7 @@ func main() {
 	}
 	defer db.Close()
 
+	unitOfWork := postgres.NewUnitOfWork(db, log.With(logger, "pkg", "postgres"))
 	accountStorage := postgres.NewAccountRepository(db, log.With(logger, "pkg", "postgres"))
 	paymentStorage := postgres.NewPaymentRepository(db, log.With(logger, "pkg", "postgres"))
 
@@ -50,10 +51,22 @@ func main() {
 	}
 
 	payments := makeTestPayments(logger, accounts)
-	for _, p := range payments {
-		err := paymentStorage.Store(ctx, &p)
-		exitOnErr(logger, err)
-	}
+	err = unitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		for i := range payments {
+			// Deposits are made from the external account of the account's currency
+			externalID, err := accountStorage.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, accounts[i].Currency)
+			if err != nil {
+				return err
+			}
+			payments[i].From = &externalID
+
+			if err := paymentStorage.StoreTx(ctx, tx, &payments[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	exitOnErr(logger, err)
 }
 
 func makeUUIDs(logger log.Logger, uuidStrings []string) []uuid.UUID {
@@ -116,7 +129,7 @@ func makeTestPayments(logger log.Logger, accounts []wallet.Account) []wallet.Pay
 		"38f4b350-c848-400d-bc91-a112fb4f58df",
 	})
 
-	// Credits to account (no "From" field)
+	// Deposits to each account. The "From" field is set to the external account when stored.
 	payments := make([]wallet.Payment, len(accounts))
 	for i, a := range accounts {
 		amount, cond, err := apd.NewFromString("100.00")
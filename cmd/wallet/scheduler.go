@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// runScheduler executes the scheduled transfers and standing order occurrences that are due,
+// and expires holds that have passed their expiry, every interval, until ctx is canceled.
+// Errors are logged, and the work is tried again at the next interval.
+// Several servers can run the worker with the same database, since each due transfer
+// or standing order is claimed by only one of them.
+func runScheduler(ctx context.Context, logger log.Logger, s wallet.Service, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.ExecuteScheduledTransfers(ctx); err != nil && ctx.Err() == nil {
+			log.With(logger, "err", err).Log("msg", "Executing scheduled transfers failed")
+		}
+		if _, err := s.ExecuteStandingOrders(ctx); err != nil && ctx.Err() == nil {
+			log.With(logger, "err", err).Log("msg", "Executing standing orders failed")
+		}
+		if _, err := s.ExpireHolds(ctx); err != nil && ctx.Err() == nil {
+			log.With(logger, "err", err).Log("msg", "Expiring holds failed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
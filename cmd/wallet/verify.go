@@ -0,0 +1,33 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/go-kit/kit/log"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errBalanceDrift is returned by verifyBalances if any account balance has drifted
+var errBalanceDrift = errors.New("Stored account balances are different from their journal postings")
+
+// verifyBalances recomputes the balance of every account from its journal postings,
+// and logs the accounts whose stored balance is different
+func verifyBalances(ctx context.Context, logger log.Logger, accounts wallet.AccountRepository) error {
+	drifts, err := accounts.BalanceDrifts(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, d := range drifts {
+		logger.Log("msg", "Balance drift", "account", d.AccountID, "balance", d.Balance, "ledger_balance", d.LedgerBalance)
+	}
+
+	if len(drifts) != 0 {
+		return errBalanceDrift
+	}
+
+	logger.Log("msg", "Balances verified")
+	return nil
+}
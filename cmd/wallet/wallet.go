@@ -3,15 +3,18 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/cockroachdb/apd"
 	"github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
 
+	"github.com/xsleonard/gokit-example/decimal"
 	"github.com/xsleonard/gokit-example/postgres"
 	"github.com/xsleonard/gokit-example/transfer"
 
@@ -30,13 +33,35 @@ const (
 	serverIdleTimeout  = time.Second * 120
 
 	defaultDatabaseURL = "postgresql://postgres@localhost:54320/wallet?sslmode=disable"
+
+	defaultScheduleInterval = time.Second * 10
 )
 
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s: [flags] [command]\n", os.Args[0])
+	fmt.Fprintln(out, "Runs the HTTP server if no command is given.")
+	fmt.Fprintln(out, "\nCommands:")
+	fmt.Fprintln(out, "  verify-balances")
+	fmt.Fprintln(out, "    \tRecompute account balances from their journal postings and report any drift")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
 func main() {
 	var httpAddr string
 	var databaseURL string
+	var rounding string
+	var scheduleInterval time.Duration
+	var jwtHMACKeyFile string
+	var jwtRSAKeyFile string
 	flag.StringVar(&httpAddr, "addr", "localhost:8888", "HTTP listen address")
 	flag.StringVar(&databaseURL, "db", defaultDatabaseURL, "Postgres DB URL")
+	flag.StringVar(&rounding, "rounding", apd.RoundHalfEven, "Rounding mode for currency conversion (half_even, half_up, half_down, up, down, ceiling, floor, 05up)")
+	flag.DurationVar(&scheduleInterval, "schedule-interval", defaultScheduleInterval, "Interval at which due scheduled transfers and standing orders are executed, and expired holds are released")
+	flag.StringVar(&jwtHMACKeyFile, "jwt-hmac-key", "", "File with the HMAC secret of end user JWTs signed with HS256, HS384 or HS512")
+	flag.StringVar(&jwtRSAKeyFile, "jwt-rsa-key", "", "PEM file with the RSA public key of end user JWTs signed with RS256, RS384 or RS512")
+	flag.Usage = usage
 	flag.Parse()
 
 	ctx := context.Background()
@@ -45,6 +70,28 @@ func main() {
 	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
 	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
 
+	if !decimal.IsValidRounding(rounding) {
+		log.With(logger, "rounding", rounding).Log("msg", "Invalid rounding mode")
+		os.Exit(1)
+	}
+
+	// Setup JWT verification, which lets end users authenticate with bearer tokens
+	var jwtVerifier *transfer.JWTVerifier
+	var err error
+	switch {
+	case jwtHMACKeyFile != "" && jwtRSAKeyFile != "":
+		logger.Log("msg", "Only one of -jwt-hmac-key and -jwt-rsa-key can be given")
+		os.Exit(1)
+	case jwtHMACKeyFile != "":
+		jwtVerifier, err = transfer.LoadHMACVerifier(jwtHMACKeyFile)
+	case jwtRSAKeyFile != "":
+		jwtVerifier, err = transfer.LoadRSAVerifier(jwtRSAKeyFile)
+	}
+	if err != nil {
+		log.With(logger, "err", err).Log("msg", "Unable to load JWT key")
+		os.Exit(1)
+	}
+
 	// Setup DB
 	db, err := sqlx.ConnectContext(ctx, "postgres", databaseURL)
 	if err != nil {
@@ -53,15 +100,39 @@ func main() {
 	}
 	defer db.Close()
 
+	unitOfWork := postgres.NewUnitOfWork(db, log.With(logger, "pkg", "postgres"))
 	accountStorage := postgres.NewAccountRepository(db, log.With(logger, "pkg", "postgres"))
 	paymentStorage := postgres.NewPaymentRepository(db, log.With(logger, "pkg", "postgres"))
+	rateStorage := postgres.NewRateRepository(db, log.With(logger, "pkg", "postgres"))
+	currencyStorage := postgres.NewCurrencyRepository(db, log.With(logger, "pkg", "postgres"))
+	feeStorage := postgres.NewFeeRepository(db, log.With(logger, "pkg", "postgres"))
+	scheduledTransferStorage := postgres.NewScheduledTransferRepository(db, log.With(logger, "pkg", "postgres"))
+	standingOrderStorage := postgres.NewStandingOrderRepository(db, log.With(logger, "pkg", "postgres"))
+	holdStorage := postgres.NewHoldRepository(db, log.With(logger, "pkg", "postgres"))
+	limitStorage := postgres.NewLimitRepository(db, log.With(logger, "pkg", "postgres"))
+	customerStorage := postgres.NewCustomerRepository(db, log.With(logger, "pkg", "postgres"))
+	apiKeyStorage := postgres.NewAPIKeyRepository(db, log.With(logger, "pkg", "postgres"))
+
+	switch command := flag.Arg(0); command {
+	case "":
+		// Run the server
+	case "verify-balances":
+		if err := verifyBalances(ctx, logger, accountStorage); err != nil {
+			log.With(logger, "err", err).Log("msg", "Balance verification failed")
+			os.Exit(1)
+		}
+		return
+	default:
+		log.With(logger, "command", command).Log("msg", "Unknown command")
+		os.Exit(1)
+	}
 
 	transferLogger := log.With(logger, "pkg", "transfer")
-	service := transfer.NewService(accountStorage, paymentStorage)
+	service := transfer.NewService(unitOfWork, accountStorage, paymentStorage, rateStorage, currencyStorage, feeStorage, scheduledTransferStorage, standingOrderStorage, holdStorage, limitStorage, customerStorage, rounding)
 	service = transfer.NewLoggingService(transferLogger, service)
 
 	// Setup HTTP server
-	handler := transfer.MakeHandler(service, log.With(transferLogger, "transport", "http"))
+	handler := transfer.MakeHandler(service, apiKeyStorage, jwtVerifier, log.With(transferLogger, "transport", "http"))
 
 	httpServer := &http.Server{
 		Addr:         httpAddr,
@@ -71,6 +142,14 @@ func main() {
 		IdleTimeout:  serverIdleTimeout,
 	}
 
+	// Start the worker that executes scheduled transfers and standing orders
+	workerCtx, cancelWorker := context.WithCancel(ctx)
+	workerDone := make(chan struct{})
+	go func() {
+		defer close(workerDone)
+		runScheduler(workerCtx, log.With(transferLogger, "worker", "scheduler"), service, scheduleInterval)
+	}()
+
 	errs := make(chan error, 2)
 	go func() {
 		logger.Log("transport", "http", "address", httpAddr, "msg", "listening")
@@ -92,4 +171,9 @@ func main() {
 	}()
 
 	logger.Log("terminated", <-errs)
+
+	// Stop the worker. A scheduled transfer or standing order occurrence in progress
+	// is rolled back, and is executed after a restart.
+	cancelWorker()
+	<-workerDone
 }
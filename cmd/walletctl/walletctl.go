@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/postgres"
+
+	_ "github.com/lib/pq" // load postgres driver
+)
+
+const (
+	defaultDatabaseURL = "postgresql://postgres@localhost:54320/wallet?sslmode=disable"
+)
+
+var (
+	errNameRequired   = errors.New("-name is required")
+	errScopesRequired = errors.New("-scopes is required")
+	errIDRequired     = errors.New("The ID of the API key is required")
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s: [flags] command [command flags]\n", os.Args[0])
+	fmt.Fprintln(out, "\nCommands:")
+	fmt.Fprintln(out, "  create-key -name name -scopes scope,...")
+	fmt.Fprintf(out, "    \tCreate an API key and print its token. Scopes: %s\n", strings.Join(wallet.Scopes, ", "))
+	fmt.Fprintln(out, "  revoke-key id")
+	fmt.Fprintln(out, "    \tRevoke an API key")
+	fmt.Fprintln(out, "\nFlags:")
+	flag.PrintDefaults()
+}
+
+func main() {
+	var databaseURL string
+	flag.StringVar(&databaseURL, "db", defaultDatabaseURL, "Postgres DB URL")
+	flag.Usage = usage
+	flag.Parse()
+
+	ctx := context.Background()
+
+	// Setup logger
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC)
+
+	db, err := sqlx.ConnectContext(ctx, "postgres", databaseURL)
+	if err != nil {
+		log.With(logger, "err", err).Log("Unable to connect to DB")
+		os.Exit(1)
+	}
+	defer db.Close()
+
+	apiKeyStorage := postgres.NewAPIKeyRepository(db, log.With(logger, "pkg", "postgres"))
+
+	switch command := flag.Arg(0); command {
+	case "create-key":
+		err = createKey(ctx, logger, apiKeyStorage, flag.Args()[1:])
+	case "revoke-key":
+		err = revokeKey(ctx, logger, apiKeyStorage, flag.Args()[1:])
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		log.With(logger, "err", err).Log("msg", "Command failed")
+		os.Exit(1)
+	}
+}
+
+// createKey creates an API key and prints its token to stdout.
+// The token can't be recovered later, since only its hash is stored.
+func createKey(ctx context.Context, logger log.Logger, keys wallet.APIKeyRepository, args []string) error {
+	fs := flag.NewFlagSet("create-key", flag.ExitOnError)
+	name := fs.String("name", "", "Name of the API key, which identifies its holder")
+	scopes := fs.String("scopes", "", "Comma-separated scopes of the API key")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if *name == "" {
+		return errNameRequired
+	}
+	if *scopes == "" {
+		return errScopesRequired
+	}
+
+	key := &wallet.APIKey{
+		Name:   *name,
+		Scopes: strings.Split(*scopes, ","),
+	}
+	for _, s := range key.Scopes {
+		if !wallet.IsValidScope(s) {
+			return fmt.Errorf("Invalid scope %q, scopes are %s", s, strings.Join(wallet.Scopes, ", "))
+		}
+	}
+
+	var err error
+	if key.ID, err = uuid.NewV4(); err != nil {
+		return err
+	}
+
+	token, err := wallet.NewAPIKeyToken()
+	if err != nil {
+		return err
+	}
+
+	if err := keys.Store(ctx, key, wallet.HashAPIKeyToken(token)); err != nil {
+		return err
+	}
+
+	logger.Log("msg", "API key created", "id", key.ID, "name", key.Name, "scopes", strings.Join(key.Scopes, ","))
+	fmt.Println(token)
+	return nil
+}
+
+// revokeKey revokes an API key, after which requests authenticated with it are rejected
+func revokeKey(ctx context.Context, logger log.Logger, keys wallet.APIKeyRepository, args []string) error {
+	if len(args) != 1 {
+		return errIDRequired
+	}
+
+	id, err := uuid.FromString(args[0])
+	if err != nil {
+		return err
+	}
+
+	key, err := keys.Revoke(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	logger.Log("msg", "API key revoked", "id", key.ID, "name", key.Name)
+	return nil
+}
@@ -0,0 +1,80 @@
+// Package cursor encodes and decodes the opaque cursors used to paginate lists.
+// A cursor holds the sort key values of the last result of a page.
+package cursor
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// Encode encodes the sort key values of the last result of a page
+// into an opaque cursor string
+func Encode(values ...string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(values, ",")))
+}
+
+// Decode decodes a cursor created by Encode with n values.
+// wallet.ErrInvalidCursor is returned if the cursor is malformed.
+func Decode(cursor string, n int) ([]string, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, wallet.ErrInvalidCursor
+	}
+
+	values := strings.Split(string(b), ",")
+	if len(values) != n {
+		return nil, wallet.ErrInvalidCursor
+	}
+
+	return values, nil
+}
+
+// EncodeID encodes a cursor for lists that are ordered by ID
+func EncodeID(id uuid.UUID) string {
+	return Encode(id.String())
+}
+
+// DecodeID decodes a cursor created by EncodeID
+func DecodeID(cursor string) (uuid.UUID, error) {
+	values, err := Decode(cursor, 1)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	id, err := uuid.FromString(values[0])
+	if err != nil {
+		return uuid.Nil, wallet.ErrInvalidCursor
+	}
+
+	return id, nil
+}
+
+// EncodeTimeID encodes a cursor for lists that are ordered by time and then ID
+func EncodeTimeID(t time.Time, id uuid.UUID) string {
+	return Encode(t.UTC().Format(time.RFC3339Nano), id.String())
+}
+
+// DecodeTimeID decodes a cursor created by EncodeTimeID
+func DecodeTimeID(cursor string) (time.Time, uuid.UUID, error) {
+	values, err := Decode(cursor, 2)
+	if err != nil {
+		return time.Time{}, uuid.Nil, err
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, values[0])
+	if err != nil {
+		return time.Time{}, uuid.Nil, wallet.ErrInvalidCursor
+	}
+
+	id, err := uuid.FromString(values[1])
+	if err != nil {
+		return time.Time{}, uuid.Nil, wallet.ErrInvalidCursor
+	}
+
+	return t, id, nil
+}
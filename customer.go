@@ -0,0 +1,43 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+var (
+	// ErrNoCustomer is returned if a customer does not exist
+	ErrNoCustomer = errors.New("Customer does not exist")
+	// ErrCustomerExists is returned when creating a customer with an ID that is already used
+	ErrCustomerExists = errors.New("Customer already exists")
+)
+
+// Customer is a holder of user accounts, see Account.OwnerID
+type Customer struct {
+	ID        uuid.UUID
+	Name      string
+	CreatedAt time.Time
+}
+
+// CustomerBalance is the total balance of a customer's accounts in a currency
+type CustomerBalance struct {
+	Currency string
+	Balance  *apd.Decimal
+	// Accounts is the number of the customer's accounts in the currency
+	Accounts int
+}
+
+// CustomerRepository is the storage interface for customers.
+// Methods use the transaction of their context, if it has one.
+type CustomerRepository interface {
+	// Store creates a customer. ErrCustomerExists is returned if its ID is already used.
+	Store(ctx context.Context, customer *Customer) error
+	Get(ctx context.Context, id uuid.UUID) (*Customer, error)
+	// Balances returns the total balance of a customer's accounts in each currency, ordered by currency.
+	// Currencies in which the customer has no accounts are not returned.
+	Balances(ctx context.Context, id uuid.UUID) ([]CustomerBalance, error)
+}
@@ -8,9 +8,9 @@ import (
 )
 
 var (
-	// ErrInvalidPrecision is returned if an amount string does not have exactly two digits of precision.
-	// That is, "1", "1.1" and "1.100" are invalid but "1.10" is valid.
-	ErrInvalidPrecision = errors.New("Amount strings must have exactly two digits of precision")
+	// ErrInvalidPrecision is returned if an amount has more decimal places than its currency's minor unit.
+	// For example, "1", "1.1" and "1.10" are valid for a currency with two decimal places but "1.100" is invalid.
+	ErrInvalidPrecision = errors.New("Amount has more decimal places than the currency allows")
 	// ErrNegative is returned when parsing a negative amount
 	ErrNegative = errors.New("Amount must not be negative")
 	// ErrInvalid is returned when parsing an amount that can't be precisely represented
@@ -21,12 +21,19 @@ var (
 	ErrAmountNotMoreThanZero = errors.New("Amount must be greater than 0")
 	// ErrAmountNil is returned if the amount is nil
 	ErrAmountNil = errors.New("Amount must not be nil")
+	// ErrRateNotMoreThanZero is returned when an exchange rate is not > 0
+	ErrRateNotMoreThanZero = errors.New("Rate must be greater than 0")
+	// ErrInvalidRounding is returned for an unrecognized rounding mode
+	ErrInvalidRounding = errors.New("Invalid rounding mode")
 )
 
-// ParseCurrency parses a string to a fixed-precision decimal and ensures that
-// not more than 2 decimal precision is used by the string and that the value
-// is not negative.
-func ParseCurrency(amount string) (*apd.Decimal, error) {
+// maxConvertPrecision is the maximum number of digits in a converted amount
+const maxConvertPrecision = 64
+
+// ParseAmount parses a string to a fixed-precision decimal and ensures that
+// the value is not negative. The number of decimal places is not checked,
+// since that depends on the currency, see ParseCurrency.
+func ParseAmount(amount string) (*apd.Decimal, error) {
 	dec, condition, err := apd.NewFromString(amount)
 	if err != nil {
 		return nil, err
@@ -46,16 +53,28 @@ func ParseCurrency(amount string) (*apd.Decimal, error) {
 		return nil, ErrNegative
 	}
 
-	// The decimal should not have more than 2 digits of precision
-	if dec.Exponent < -2 {
-		return nil, ErrInvalidPrecision
+	return dec, nil
+}
+
+// ParseCurrency parses a string to a fixed-precision decimal and ensures that
+// not more than exponent decimal places are used by the string and that the value
+// is not negative. exponent is the number of decimal places of the currency's minor unit.
+func ParseCurrency(amount string, exponent int32) (*apd.Decimal, error) {
+	dec, err := ParseAmount(amount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := validatePrecision(dec, exponent); err != nil {
+		return nil, err
 	}
 
 	return dec, nil
 }
 
-// ValidateTransferAmount validates a decimal amount for transfers
-func ValidateTransferAmount(amount *apd.Decimal) error {
+// ValidateAmount validates that an amount is a finite number greater than 0.
+// The number of decimal places is not checked, see ValidateTransferAmount.
+func ValidateAmount(amount *apd.Decimal) error {
 	if amount == nil {
 		return ErrAmountNil
 	}
@@ -68,9 +87,107 @@ func ValidateTransferAmount(amount *apd.Decimal) error {
 		return ErrAmountNotMoreThanZero
 	}
 
-	if amount.Exponent < -2 {
-		return ErrInvalidPrecision
+	return nil
+}
+
+// ValidateTransferAmount validates a decimal amount for transfers in a currency
+// whose minor unit has exponent decimal places
+func ValidateTransferAmount(amount *apd.Decimal, exponent int32) error {
+	if err := ValidateAmount(amount); err != nil {
+		return err
 	}
 
+	return validatePrecision(amount, exponent)
+}
+
+// validatePrecision checks that an amount does not have more than exponent decimal places
+func validatePrecision(amount *apd.Decimal, exponent int32) error {
+	if amount.Exponent < -exponent {
+		return ErrInvalidPrecision
+	}
 	return nil
 }
+
+// ParseRate parses a string to an exchange rate and ensures that it is greater than 0.
+// Unlike amounts, rates may have any number of decimal places.
+func ParseRate(rate string) (*apd.Decimal, error) {
+	dec, condition, err := apd.NewFromString(rate)
+	if err != nil {
+		return nil, err
+	}
+
+	if condition.Any() {
+		return nil, ErrInvalid
+	}
+
+	if dec.Form != apd.Finite {
+		return nil, ErrNotFinite
+	}
+
+	if dec.Sign() != 1 {
+		return nil, ErrRateNotMoreThanZero
+	}
+
+	return dec, nil
+}
+
+// IsValidRounding returns true if rounding is a rounding mode known to apd, such as apd.RoundHalfEven
+func IsValidRounding(rounding string) bool {
+	_, ok := apd.Roundings[rounding]
+	return ok
+}
+
+// Convert converts an amount to another currency by multiplying it by an exchange rate.
+// The result is rounded to exponent decimal places, the minor unit of the other currency,
+// using the rounding mode, which must be a rounding mode known to apd, such as apd.RoundHalfEven.
+func Convert(amount, rate *apd.Decimal, exponent int32, rounding string) (*apd.Decimal, error) {
+	if !IsValidRounding(rounding) {
+		return nil, ErrInvalidRounding
+	}
+
+	// Multiply without rounding, so that the result is only rounded once, by Quantize
+	var d apd.Decimal
+	if _, err := apd.BaseContext.Mul(&d, amount, rate); err != nil {
+		return nil, err
+	}
+
+	c := apd.BaseContext.WithPrecision(maxConvertPrecision)
+	c.Rounding = rounding
+	if _, err := c.Quantize(&d, &d, -exponent); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
+
+// Prorate returns the share of total that part is of whole, i.e. total * part / whole.
+// The result is rounded to exponent decimal places using the rounding mode, as for Convert.
+// If part equals whole, the result equals total.
+func Prorate(total, part, whole *apd.Decimal, exponent int32, rounding string) (*apd.Decimal, error) {
+	if !IsValidRounding(rounding) {
+		return nil, ErrInvalidRounding
+	}
+
+	if whole.Sign() == 0 {
+		return nil, ErrAmountNotMoreThanZero
+	}
+
+	// Multiply without rounding, so that the result is only rounded by
+	// the division if it does not terminate, and then by Quantize
+	var d apd.Decimal
+	if _, err := apd.BaseContext.Mul(&d, total, part); err != nil {
+		return nil, err
+	}
+
+	c := apd.BaseContext.WithPrecision(maxConvertPrecision)
+	c.Rounding = rounding
+	if _, err := c.Quo(&d, &d, whole); err != nil {
+		return nil, err
+	}
+
+	if _, err := c.Quantize(&d, &d, -exponent); err != nil {
+		return nil, err
+	}
+
+	return &d, nil
+}
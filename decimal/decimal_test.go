@@ -2,6 +2,7 @@ package decimal
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/cockroachdb/apd"
@@ -10,63 +11,96 @@ import (
 
 func TestParseCurrency(t *testing.T) {
 	cases := []struct {
-		a   string
-		exp *apd.Decimal
-		err error
+		a        string
+		exponent int32
+		exp      *apd.Decimal
+		err      error
 	}{
 		{
-			a:   "ten",
-			err: errors.New(`parse exponent: n: strconv.ParseInt: parsing "n": invalid syntax`),
+			a:        "ten",
+			exponent: 2,
+			err:      errors.New(`parse exponent: n: strconv.ParseInt: parsing "n": invalid syntax`),
 		},
 		{
-			a:   "-1",
-			err: ErrNegative,
+			a:        "-1",
+			exponent: 2,
+			err:      ErrNegative,
 		},
 		{
-			a:   "-0",
-			exp: apd.New(0, 0),
+			a:        "-0",
+			exponent: 2,
+			exp:      apd.New(0, 0),
 		},
 		{
-			a:   "0",
-			exp: apd.New(0, 0),
+			a:        "0",
+			exponent: 2,
+			exp:      apd.New(0, 0),
 		},
 		{
-			a:   "Inf",
-			err: ErrNotFinite,
+			a:        "Inf",
+			exponent: 2,
+			err:      ErrNotFinite,
 		},
 		{
-			a:   "NaN",
-			err: ErrNotFinite,
+			a:        "NaN",
+			exponent: 2,
+			err:      ErrNotFinite,
 		},
 		{
-			a:   "1.1234",
-			err: ErrInvalidPrecision,
+			a:        "1.1234",
+			exponent: 2,
+			err:      ErrInvalidPrecision,
 		},
 		{
-			a:   "1",
-			exp: apd.New(1, 0),
+			a:        "1",
+			exponent: 2,
+			exp:      apd.New(1, 0),
+		},
+		{
+			a:        "1.1",
+			exponent: 2,
+			exp:      apd.New(11, -1),
+		},
+		{
+			a:        "0.00",
+			exponent: 2,
+			exp:      apd.New(0, -2),
 		},
 		{
-			a:   "1.1",
-			exp: apd.New(11, -1),
+			a:        "1.10",
+			exponent: 2,
+			exp:      apd.New(110, -2),
 		},
 		{
-			a:   "0.00",
-			exp: apd.New(0, -2),
+			a:        "123.45",
+			exponent: 2,
+			exp:      apd.New(12345, -2),
 		},
 		{
-			a:   "1.10",
-			exp: apd.New(110, -2),
+			a:        "123.4",
+			exponent: 0,
+			err:      ErrInvalidPrecision,
 		},
 		{
-			a:   "123.45",
-			exp: apd.New(12345, -2),
+			a:        "123",
+			exponent: 0,
+			exp:      apd.New(123, 0),
+		},
+		{
+			a:        "1.234",
+			exponent: 3,
+			exp:      apd.New(1234, -3),
+		},
+		{
+			a:        "1.2345",
+			exponent: 3,
+			err:      ErrInvalidPrecision,
 		},
 	}
 
 	for _, tc := range cases {
-		t.Run(tc.a, func(t *testing.T) {
-			exp, err := ParseCurrency(tc.a)
+		t.Run(fmt.Sprintf("%s/%d", tc.a, tc.exponent), func(t *testing.T) {
+			exp, err := ParseCurrency(tc.a, tc.exponent)
 			if tc.err != nil {
 				require.Error(t, err)
 				require.Equal(t, tc.err.Error(), err.Error(), "%v != %v", tc.err, err)
@@ -91,9 +125,10 @@ func mustNewFromString(t *testing.T, a string) *apd.Decimal {
 
 func TestValidateTransferAmount(t *testing.T) {
 	cases := []struct {
-		name   string
-		amount *apd.Decimal
-		err    error
+		name     string
+		amount   *apd.Decimal
+		exponent int32
+		err      error
 	}{
 		{
 			name:   "nil amount",
@@ -121,9 +156,16 @@ func TestValidateTransferAmount(t *testing.T) {
 			err:    ErrAmountNotMoreThanZero,
 		},
 		{
-			name:   "invalid precision",
-			amount: apd.New(123, -3),
-			err:    ErrInvalidPrecision,
+			name:     "invalid precision",
+			amount:   apd.New(123, -3),
+			exponent: 2,
+			err:      ErrInvalidPrecision,
+		},
+		{
+			name:     "invalid precision, no minor unit",
+			amount:   apd.New(12, -1),
+			exponent: 0,
+			err:      ErrInvalidPrecision,
 		},
 
 		// Valid cases
@@ -138,14 +180,22 @@ func TestValidateTransferAmount(t *testing.T) {
 			err:    nil,
 		},
 		{
-			name:   "1.2",
-			amount: apd.New(12, -1),
-			err:    nil,
+			name:     "1.2",
+			amount:   apd.New(12, -1),
+			exponent: 2,
+			err:      nil,
 		},
 		{
-			name:   "1.23",
-			amount: apd.New(123, -2),
-			err:    nil,
+			name:     "1.23",
+			amount:   apd.New(123, -2),
+			exponent: 2,
+			err:      nil,
+		},
+		{
+			name:     "1.234",
+			amount:   apd.New(1234, -3),
+			exponent: 3,
+			err:      nil,
 		},
 		{
 			name:   "1.0",
@@ -156,8 +206,229 @@ func TestValidateTransferAmount(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			err := ValidateTransferAmount(tc.amount)
+			err := ValidateTransferAmount(tc.amount, tc.exponent)
 			require.Equal(t, tc.err, err)
 		})
 	}
 }
+
+func TestParseRate(t *testing.T) {
+	cases := []struct {
+		a   string
+		exp *apd.Decimal
+		err error
+	}{
+		{
+			a:   "ten",
+			err: errors.New(`parse exponent: n: strconv.ParseInt: parsing "n": invalid syntax`),
+		},
+		{
+			a:   "-1",
+			err: ErrRateNotMoreThanZero,
+		},
+		{
+			a:   "0",
+			err: ErrRateNotMoreThanZero,
+		},
+		{
+			a:   "Inf",
+			err: ErrNotFinite,
+		},
+		{
+			a:   "NaN",
+			err: ErrNotFinite,
+		},
+		{
+			a:   "1",
+			exp: apd.New(1, 0),
+		},
+		{
+			a:   "0.912345",
+			exp: apd.New(912345, -6),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.a, func(t *testing.T) {
+			exp, err := ParseRate(tc.a)
+			if tc.err != nil {
+				require.Error(t, err)
+				require.Equal(t, tc.err.Error(), err.Error(), "%v != %v", tc.err, err)
+				require.Nil(t, exp)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, exp)
+			require.Equal(t, 0, tc.exp.Cmp(exp))
+		})
+	}
+}
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		name     string
+		amount   *apd.Decimal
+		rate     *apd.Decimal
+		exponent int32
+		rounding string
+		exp      *apd.Decimal
+		err      error
+	}{
+		{
+			name:     "invalid rounding",
+			amount:   apd.New(100, 0),
+			rate:     apd.New(9, -1),
+			exponent: 2,
+			rounding: "sideways",
+			err:      ErrInvalidRounding,
+		},
+		{
+			name:     "exact",
+			amount:   apd.New(100, 0),
+			rate:     apd.New(9, -1),
+			exponent: 2,
+			rounding: apd.RoundHalfEven,
+			exp:      apd.New(9000, -2),
+		},
+		{
+			name:     "half even rounds down to even",
+			amount:   apd.New(1, 0),
+			rate:     apd.New(1125, -3),
+			exponent: 2,
+			rounding: apd.RoundHalfEven,
+			exp:      apd.New(112, -2),
+		},
+		{
+			name:     "half up rounds up",
+			amount:   apd.New(1, 0),
+			rate:     apd.New(1125, -3),
+			exponent: 2,
+			rounding: apd.RoundHalfUp,
+			exp:      apd.New(113, -2),
+		},
+		{
+			name:     "down truncates",
+			amount:   apd.New(123, -2),
+			rate:     apd.New(1999, -3),
+			exponent: 2,
+			rounding: apd.RoundDown,
+			exp:      apd.New(245, -2),
+		},
+		{
+			name:     "rounds only once",
+			amount:   apd.New(1, 0),
+			rate:     apd.New(11249, -4),
+			exponent: 2,
+			rounding: apd.RoundHalfUp,
+			exp:      apd.New(112, -2),
+		},
+		{
+			name:     "rounds to zero",
+			amount:   apd.New(1, -2),
+			rate:     apd.New(1, -3),
+			exponent: 2,
+			rounding: apd.RoundHalfEven,
+			exp:      apd.New(0, -2),
+		},
+		{
+			name:     "no minor unit",
+			amount:   apd.New(1, 0),
+			rate:     apd.New(1575, -1),
+			exponent: 0,
+			rounding: apd.RoundHalfEven,
+			exp:      apd.New(158, 0),
+		},
+		{
+			name:     "three decimal places",
+			amount:   apd.New(1, 0),
+			rate:     apd.New(30745, -5),
+			exponent: 3,
+			rounding: apd.RoundHalfEven,
+			exp:      apd.New(307, -3),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Convert(tc.amount, tc.rate, tc.exponent, tc.rounding)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				require.Nil(t, d)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, 0, tc.exp.Cmp(d), "%s != %s", tc.exp, d)
+			require.Equal(t, -tc.exponent, d.Exponent)
+		})
+	}
+}
+
+func TestProrate(t *testing.T) {
+	cases := []struct {
+		name     string
+		total    *apd.Decimal
+		part     *apd.Decimal
+		whole    *apd.Decimal
+		exponent int32
+		exp      *apd.Decimal
+		err      error
+	}{
+		{
+			name:     "zero whole",
+			total:    apd.New(1000, -2),
+			part:     apd.New(1, 0),
+			whole:    apd.New(0, 0),
+			exponent: 2,
+			err:      ErrAmountNotMoreThanZero,
+		},
+		{
+			name:     "part equals whole",
+			total:    apd.New(1000, -2),
+			part:     apd.New(734, -2),
+			whole:    apd.New(734, -2),
+			exponent: 2,
+			exp:      apd.New(1000, -2),
+		},
+		{
+			name:     "half",
+			total:    apd.New(1000, -2),
+			part:     apd.New(367, -2),
+			whole:    apd.New(734, -2),
+			exponent: 2,
+			exp:      apd.New(500, -2),
+		},
+		{
+			name:     "non-terminating",
+			total:    apd.New(1000, -2),
+			part:     apd.New(1, 0),
+			whole:    apd.New(3, 0),
+			exponent: 2,
+			exp:      apd.New(333, -2),
+		},
+		{
+			name:     "no minor unit",
+			total:    apd.New(1575, 0),
+			part:     apd.New(250, -2),
+			whole:    apd.New(1000, -2),
+			exponent: 0,
+			exp:      apd.New(394, 0),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			d, err := Prorate(tc.total, tc.part, tc.whole, tc.exponent, apd.RoundHalfEven)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				require.Nil(t, d)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, 0, tc.exp.Cmp(d), "%s != %s", tc.exp, d)
+			require.Equal(t, -tc.exponent, d.Exponent)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Statuses of a hold
+const (
+	// HoldActive is a hold whose amount is reserved, and can be captured or released
+	HoldActive = "active"
+	// HoldCaptured is a hold that was captured by a transfer
+	HoldCaptured = "captured"
+	// HoldReleased is a hold that was released without a transfer
+	HoldReleased = "released"
+	// HoldExpired is a hold that was not captured or released before it expired
+	HoldExpired = "expired"
+)
+
+var (
+	// ErrNoHold is returned if a hold does not exist
+	ErrNoHold = errors.New("Hold does not exist")
+	// ErrHoldNotActive is returned when capturing or releasing a hold that is not active
+	ErrHoldNotActive = errors.New("Hold is not active")
+)
+
+// Hold reserves an amount of an account's balance, so that it can't be spent by other payments.
+// The amount is transferred when the hold is captured, and is available again when the hold
+// is released or expires.
+type Hold struct {
+	ID     uuid.UUID
+	From   uuid.UUID
+	Amount *apd.Decimal
+	Status string
+	// ExpiresAt is the time after which the hold can't be captured, and is expired
+	ExpiresAt time.Time
+	// PaymentID is the payment made when the hold was captured, if it was captured
+	PaymentID *uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// HoldRepository is the storage interface for holds.
+// The held amount of an account is the sum of the amounts of its active holds, see Account.Held.
+type HoldRepository interface {
+	// StoreTx creates an active hold. The account should be locked by the transaction,
+	// so that its available balance can't change before the hold is stored.
+	// ErrNoAccount is returned if the account does not exist.
+	StoreTx(ctx context.Context, tx Tx, hold *Hold) error
+	// Get returns a hold. ErrNoHold is returned if it does not exist.
+	Get(ctx context.Context, id uuid.UUID) (*Hold, error)
+	// GetTx returns a hold and locks it until the transaction completes
+	GetTx(ctx context.Context, tx Tx, id uuid.UUID) (*Hold, error)
+	// UpdateTx updates the status and payment ID of a hold
+	UpdateTx(ctx context.Context, tx Tx, hold *Hold) error
+	// Expire expires the active holds whose ExpiresAt is not after the current time,
+	// and returns the number of holds that were expired
+	Expire(ctx context.Context) (int, error)
+}
@@ -0,0 +1,100 @@
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+var (
+	// errEmptyAPIKeyID is returned when creating an API key without an ID
+	errEmptyAPIKeyID = errors.New("API key ID must not be empty")
+	// errAPIKeyExists is returned when storing an API key with an ID or hash that is already used
+	errAPIKeyExists = errors.New("API key already exists")
+)
+
+// apiKey is a stored API key and the hash of its token
+type apiKey struct {
+	key  wallet.APIKey
+	hash []byte
+}
+
+type apiKeyRepository struct {
+	db *DB
+}
+
+// NewAPIKeyRepository creates a wallet.APIKeyRepository that stores API keys in db
+func NewAPIKeyRepository(db *DB) wallet.APIKeyRepository {
+	return &apiKeyRepository{
+		db: db,
+	}
+}
+
+func copyAPIKey(k wallet.APIKey) *wallet.APIKey {
+	k.Scopes = append([]string(nil), k.Scopes...)
+	if k.RevokedAt != nil {
+		revokedAt := *k.RevokedAt
+		k.RevokedAt = &revokedAt
+	}
+	return &k
+}
+
+func (r *apiKeyRepository) Store(ctx context.Context, key *wallet.APIKey, hash []byte) error {
+	if uuid.Equal(key.ID, uuid.Nil) {
+		return errEmptyAPIKeyID
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		for id, k := range t.data.apiKeys {
+			if uuid.Equal(id, key.ID) || bytes.Equal(k.hash, hash) {
+				return errAPIKeyExists
+			}
+		}
+
+		key.CreatedAt = t.now
+		key.RevokedAt = nil
+		t.data.apiKeys[key.ID] = apiKey{
+			key:  *copyAPIKey(*key),
+			hash: append([]byte(nil), hash...),
+		}
+		return nil
+	})
+}
+
+func (r *apiKeyRepository) GetByHash(ctx context.Context, hash []byte) (*wallet.APIKey, error) {
+	var key *wallet.APIKey
+	err := r.db.read(ctx, func(d *data) error {
+		for _, k := range d.apiKeys {
+			if bytes.Equal(k.hash, hash) {
+				key = copyAPIKey(k.key)
+				return nil
+			}
+		}
+		return wallet.ErrNoAPIKey
+	})
+	return key, err
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id uuid.UUID) (*wallet.APIKey, error) {
+	var key *wallet.APIKey
+	err := r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		k, ok := t.data.apiKeys[id]
+		if !ok {
+			return wallet.ErrNoAPIKey
+		}
+		if k.key.RevokedAt != nil {
+			return wallet.ErrAPIKeyRevoked
+		}
+
+		now := t.now
+		k.key.RevokedAt = &now
+		t.data.apiKeys[id] = k
+		key = copyAPIKey(k.key)
+		return nil
+	})
+	return key, err
+}
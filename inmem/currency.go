@@ -0,0 +1,85 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"sort"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+var (
+	// errInvalidCurrency is returned for unrecognized currency codes
+	errInvalidCurrency = errors.New("Invalid currency code")
+	// errInvalidExponent is returned when storing a currency with an exponent out of range
+	errInvalidExponent = errors.New("Currency exponent must be between 0 and 4")
+)
+
+// currencyCodeRegexp matches ISO 4217 currency codes
+var currencyCodeRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type currencyRepository struct {
+	db *DB
+}
+
+// NewCurrencyRepository creates a wallet.CurrencyRepository that stores currencies in db
+func NewCurrencyRepository(db *DB) wallet.CurrencyRepository {
+	return &currencyRepository{
+		db: db,
+	}
+}
+
+func (r *currencyRepository) Get(ctx context.Context, code string) (*wallet.Currency, error) {
+	var c wallet.Currency
+	err := r.db.read(ctx, func(d *data) error {
+		var ok bool
+		c, ok = d.currencies[code]
+		if !ok {
+			return wallet.ErrNoCurrency
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *currencyRepository) Store(ctx context.Context, c *wallet.Currency) error {
+	if !currencyCodeRegexp.MatchString(c.Code) {
+		return errInvalidCurrency
+	}
+	if c.Exponent < 0 || c.Exponent > 4 {
+		return errInvalidExponent
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		// The exponent of an existing currency is never updated, since that would
+		// change the meaning of amounts already stored in the currency
+		if existing, ok := t.data.currencies[c.Code]; ok && existing.Exponent != c.Exponent {
+			return wallet.ErrCurrencyExponentChanged
+		}
+
+		t.data.currencies[c.Code] = *c
+		return nil
+	})
+}
+
+func (r *currencyRepository) All(ctx context.Context) ([]wallet.Currency, error) {
+	var currencies []wallet.Currency
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, c := range d.currencies {
+			currencies = append(currencies, c)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(currencies, func(i, j int) bool {
+		return currencies[i].Code < currencies[j].Code
+	})
+
+	return currencies, nil
+}
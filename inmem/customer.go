@@ -0,0 +1,93 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errEmptyCustomerID is returned when creating a customer without an ID
+var errEmptyCustomerID = errors.New("Customer ID must not be empty")
+
+type customerRepository struct {
+	db *DB
+}
+
+// NewCustomerRepository creates a wallet.CustomerRepository that stores customers in db
+func NewCustomerRepository(db *DB) wallet.CustomerRepository {
+	return &customerRepository{
+		db: db,
+	}
+}
+
+func (r *customerRepository) Store(ctx context.Context, customer *wallet.Customer) error {
+	if uuid.Equal(customer.ID, uuid.Nil) {
+		return errEmptyCustomerID
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		if _, ok := t.data.customers[customer.ID]; ok {
+			return wallet.ErrCustomerExists
+		}
+
+		customer.CreatedAt = t.now
+		t.data.customers[customer.ID] = *customer
+		return nil
+	})
+}
+
+func (r *customerRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Customer, error) {
+	var c wallet.Customer
+	err := r.db.read(ctx, func(d *data) error {
+		var ok bool
+		c, ok = d.customers[id]
+		if !ok {
+			return wallet.ErrNoCustomer
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+func (r *customerRepository) Balances(ctx context.Context, id uuid.UUID) ([]wallet.CustomerBalance, error) {
+	byCurrency := make(map[string]*wallet.CustomerBalance)
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, a := range d.accounts {
+			if a.OwnerID == nil || !uuid.Equal(*a.OwnerID, id) {
+				continue
+			}
+			b, ok := byCurrency[a.Currency]
+			if !ok {
+				b = &wallet.CustomerBalance{
+					Currency: a.Currency,
+					Balance:  new(apd.Decimal),
+				}
+				byCurrency[a.Currency] = b
+			}
+			if _, err := apd.BaseContext.Add(b.Balance, b.Balance, a.Balance); err != nil {
+				return err
+			}
+			b.Accounts++
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	balances := make([]wallet.CustomerBalance, 0, len(byCurrency))
+	for _, b := range byCurrency {
+		balances = append(balances, *b)
+	}
+	sort.Slice(balances, func(i, j int) bool {
+		return balances[i].Currency < balances[j].Currency
+	})
+	return balances, nil
+}
@@ -0,0 +1,106 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidFeeSchedule is returned when storing a fee schedule with a negative amount,
+// a percent over 100, or a minimum fee that is more than the maximum fee
+var errInvalidFeeSchedule = errors.New("Invalid fee schedule")
+
+type feeRepository struct {
+	db *DB
+}
+
+// NewFeeRepository creates a wallet.FeeRepository that stores fee schedules in db
+func NewFeeRepository(db *DB) wallet.FeeRepository {
+	return &feeRepository{
+		db: db,
+	}
+}
+
+func copyFeeSchedule(f wallet.FeeSchedule) wallet.FeeSchedule {
+	f.Flat = copyDecimal(f.Flat)
+	f.Percent = copyDecimal(f.Percent)
+	f.Min = copyDecimal(f.Min)
+	f.Max = copyDecimal(f.Max)
+	return f
+}
+
+func (r *feeRepository) Schedule(ctx context.Context, currency string) (*wallet.FeeSchedule, error) {
+	var schedule *wallet.FeeSchedule
+	err := r.db.read(ctx, func(d *data) error {
+		f, ok := d.fees[currency]
+		if !ok {
+			return wallet.ErrNoFeeSchedule
+		}
+		f = copyFeeSchedule(f)
+		schedule = &f
+		return nil
+	})
+	return schedule, err
+}
+
+func (r *feeRepository) Store(ctx context.Context, schedule *wallet.FeeSchedule) error {
+	flat := schedule.Flat
+	if flat == nil {
+		flat = apd.New(0, 0)
+	}
+	percent := schedule.Percent
+	if percent == nil {
+		percent = apd.New(0, 0)
+	}
+	if !isValidFeeSchedule(flat, percent, schedule.Min, schedule.Max) {
+		return errInvalidFeeSchedule
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		if _, ok := t.data.currencies[schedule.Currency]; !ok {
+			return wallet.ErrNoCurrency
+		}
+
+		schedule.Flat = flat
+		schedule.Percent = percent
+		schedule.UpdatedAt = t.now
+		t.data.fees[schedule.Currency] = copyFeeSchedule(*schedule)
+		return nil
+	})
+}
+
+func (r *feeRepository) All(ctx context.Context) ([]wallet.FeeSchedule, error) {
+	var schedules []wallet.FeeSchedule
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, f := range d.fees {
+			schedules = append(schedules, copyFeeSchedule(f))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].Currency < schedules[j].Currency
+	})
+
+	return schedules, nil
+}
+
+// isValidFeeSchedule returns true if the amounts of a fee schedule are not negative,
+// percent is at most 100, and min is not more than max
+func isValidFeeSchedule(flat, percent, min, max *apd.Decimal) bool {
+	for _, d := range []*apd.Decimal{flat, percent, min, max} {
+		if d != nil && (d.Form != apd.Finite || d.Sign() < 0) {
+			return false
+		}
+	}
+	if percent.Cmp(apd.New(100, 0)) > 0 {
+		return false
+	}
+	return min == nil || max == nil || min.Cmp(max) <= 0
+}
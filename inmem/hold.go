@@ -0,0 +1,115 @@
+package inmem
+
+import (
+	"context"
+
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+type holdRepository struct {
+	db *DB
+}
+
+// NewHoldRepository creates a wallet.HoldRepository that stores holds in db
+func NewHoldRepository(db *DB) wallet.HoldRepository {
+	return &holdRepository{
+		db: db,
+	}
+}
+
+func copyHold(h wallet.Hold) *wallet.Hold {
+	h.Amount = copyDecimal(h.Amount)
+	if h.PaymentID != nil {
+		id := *h.PaymentID
+		h.PaymentID = &id
+	}
+	return &h
+}
+
+func (r *holdRepository) StoreTx(ctx context.Context, wtx wallet.Tx, hold *wallet.Hold) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.data.accounts[hold.From]; !ok {
+		return wallet.ErrNoAccount
+	}
+
+	stored := copyHold(*hold)
+	stored.ExpiresAt = truncateTime(stored.ExpiresAt)
+	stored.Status = wallet.HoldActive
+	stored.PaymentID = nil
+	stored.CreatedAt = t.now
+	stored.UpdatedAt = t.now
+	t.data.holds[stored.ID] = *stored
+
+	*hold = *copyHold(*stored)
+	return nil
+}
+
+func (r *holdRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Hold, error) {
+	var h *wallet.Hold
+	err := r.db.read(ctx, func(d *data) error {
+		dh, ok := d.holds[id]
+		if !ok {
+			return wallet.ErrNoHold
+		}
+		h = copyHold(dh)
+		return nil
+	})
+	return h, err
+}
+
+func (r *holdRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Hold, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// No lock is needed, since transactions are serialized
+	h, ok := t.data.holds[id]
+	if !ok {
+		return nil, wallet.ErrNoHold
+	}
+	return copyHold(h), nil
+}
+
+func (r *holdRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, hold *wallet.Hold) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	stored, ok := t.data.holds[hold.ID]
+	if !ok {
+		return wallet.ErrNoHold
+	}
+
+	updated := copyHold(*hold)
+	stored.Status = updated.Status
+	stored.PaymentID = updated.PaymentID
+	stored.UpdatedAt = t.now
+	t.data.holds[stored.ID] = stored
+
+	*hold = *copyHold(stored)
+	return nil
+}
+
+func (r *holdRepository) Expire(ctx context.Context) (int, error) {
+	var n int
+	err := r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		for id, h := range t.data.holds {
+			if h.Status == wallet.HoldActive && !h.ExpiresAt.After(t.now) {
+				h.Status = wallet.HoldExpired
+				h.UpdatedAt = t.now
+				t.data.holds[id] = h
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
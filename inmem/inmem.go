@@ -0,0 +1,1039 @@
+// Package inmem implements storage in memory, with the same semantics as package postgres.
+// It is used by tests that do not need a database.
+package inmem
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
+)
+
+var (
+	// errEmptyAccountID is returned when creating an account without an ID
+	errEmptyAccountID = errors.New("Account ID must not be empty")
+	// errEmptyPaymentID is returned when creating a payment without an ID
+	errEmptyPaymentID = errors.New("Payment ID must not be empty")
+	// errPaymentExists is returned when storing a payment with an ID that is already used
+	errPaymentExists = errors.New("Payment already exists")
+	// errRefundExceedsPayment is returned when storing a refund that would make the
+	// refunded amount of the original payment larger than the payment
+	errRefundExceedsPayment = errors.New("Refunded amount exceeds the payment amount")
+	// errForeignTx is returned when a wallet.Tx was not created by this DB
+	errForeignTx = errors.New("Transaction was not created by this in-memory DB")
+	// errTxDone is returned when a wallet.Tx is used after it was committed or rolled back
+	errTxDone = errors.New("Transaction has already been committed or rolled back")
+	// errInvalidFee is returned when storing a payment with a fee that is not positive
+	errInvalidFee = errors.New("Fee must be greater than 0")
+	// errNotSystemAccount is returned when looking up a system account of a user account kind
+	errNotSystemAccount = errors.New("Account kind is not a system account kind")
+	// errSystemAccountOwner is returned when creating a system account with an owner
+	errSystemAccountOwner = errors.New("System accounts cannot have an owner")
+)
+
+// DB holds the data of the in-memory repositories.
+// The repositories created with the same DB share its data and transactions.
+// Transactions are run one at a time.
+type DB struct {
+	// txMu serializes transactions, which takes the place of row locks
+	txMu sync.Mutex
+	// mu guards data, which is replaced when a transaction commits
+	mu   sync.RWMutex
+	data *data
+	// lastTime is the time of the last transaction
+	lastTime time.Time
+}
+
+// NewDB creates a DB with the currencies created by the postgres migrations
+func NewDB() *DB {
+	d := &data{
+		currencies:      make(map[string]wallet.Currency),
+		accounts:        make(map[uuid.UUID]wallet.Account),
+		payments:        make(map[uuid.UUID]wallet.Payment),
+		journal:         make(map[uuid.UUID]wallet.JournalEntry),
+		idempotencyKeys: make(map[string]uuid.UUID),
+		rates:           make(map[ratePair]wallet.ExchangeRate),
+		fees:            make(map[string]wallet.FeeSchedule),
+		scheduled:       make(map[uuid.UUID]wallet.ScheduledTransfer),
+		standingOrders:  make(map[uuid.UUID]wallet.StandingOrder),
+		occurrences:     make(map[uuid.UUID]wallet.StandingOrderOccurrence),
+		holds:           make(map[uuid.UUID]wallet.Hold),
+		currencyLimits:  make(map[string]wallet.SpendingLimit),
+		accountLimits:   make(map[uuid.UUID]wallet.SpendingLimit),
+		customers:       make(map[uuid.UUID]wallet.Customer),
+		apiKeys:         make(map[uuid.UUID]apiKey),
+	}
+
+	for _, c := range []wallet.Currency{
+		{Code: wallet.USD, Exponent: 2, Enabled: true},
+		{Code: wallet.EUR, Exponent: 2, Enabled: true},
+		{Code: wallet.SGD, Exponent: 2, Enabled: true},
+		{Code: wallet.GBP, Exponent: 2, Enabled: true},
+		{Code: wallet.JPY, Exponent: 0, Enabled: false},
+		{Code: wallet.KWD, Exponent: 3, Enabled: false},
+	} {
+		d.currencies[c.Code] = c
+	}
+
+	return &DB{
+		data: d,
+	}
+}
+
+type ratePair struct {
+	from string
+	to   string
+}
+
+// data is a snapshot of everything stored in a DB.
+// Decimals are never modified after they are stored, so snapshots can share them.
+type data struct {
+	currencies map[string]wallet.Currency
+	accounts   map[uuid.UUID]wallet.Account
+	payments   map[uuid.UUID]wallet.Payment
+	// journal has the journal entry of each payment, by payment ID
+	journal         map[uuid.UUID]wallet.JournalEntry
+	idempotencyKeys map[string]uuid.UUID
+	rates           map[ratePair]wallet.ExchangeRate
+	fees            map[string]wallet.FeeSchedule
+	scheduled       map[uuid.UUID]wallet.ScheduledTransfer
+	standingOrders  map[uuid.UUID]wallet.StandingOrder
+	// occurrences has the occurrences of all standing orders, by occurrence ID
+	occurrences map[uuid.UUID]wallet.StandingOrderOccurrence
+	holds       map[uuid.UUID]wallet.Hold
+	// currencyLimits and accountLimits have the spending limits of currencies and accounts
+	currencyLimits map[string]wallet.SpendingLimit
+	accountLimits  map[uuid.UUID]wallet.SpendingLimit
+	customers      map[uuid.UUID]wallet.Customer
+	apiKeys        map[uuid.UUID]apiKey
+}
+
+func (d *data) clone() *data {
+	c := &data{
+		currencies:      make(map[string]wallet.Currency, len(d.currencies)),
+		accounts:        make(map[uuid.UUID]wallet.Account, len(d.accounts)),
+		payments:        make(map[uuid.UUID]wallet.Payment, len(d.payments)),
+		journal:         make(map[uuid.UUID]wallet.JournalEntry, len(d.journal)),
+		idempotencyKeys: make(map[string]uuid.UUID, len(d.idempotencyKeys)),
+		rates:           make(map[ratePair]wallet.ExchangeRate, len(d.rates)),
+		fees:            make(map[string]wallet.FeeSchedule, len(d.fees)),
+		scheduled:       make(map[uuid.UUID]wallet.ScheduledTransfer, len(d.scheduled)),
+		standingOrders:  make(map[uuid.UUID]wallet.StandingOrder, len(d.standingOrders)),
+		occurrences:     make(map[uuid.UUID]wallet.StandingOrderOccurrence, len(d.occurrences)),
+		holds:           make(map[uuid.UUID]wallet.Hold, len(d.holds)),
+		currencyLimits:  make(map[string]wallet.SpendingLimit, len(d.currencyLimits)),
+		accountLimits:   make(map[uuid.UUID]wallet.SpendingLimit, len(d.accountLimits)),
+		customers:       make(map[uuid.UUID]wallet.Customer, len(d.customers)),
+		apiKeys:         make(map[uuid.UUID]apiKey, len(d.apiKeys)),
+	}
+	for k, v := range d.currencies {
+		c.currencies[k] = v
+	}
+	for k, v := range d.accounts {
+		c.accounts[k] = v
+	}
+	for k, v := range d.payments {
+		c.payments[k] = v
+	}
+	for k, v := range d.journal {
+		c.journal[k] = v
+	}
+	for k, v := range d.idempotencyKeys {
+		c.idempotencyKeys[k] = v
+	}
+	for k, v := range d.rates {
+		c.rates[k] = v
+	}
+	for k, v := range d.fees {
+		c.fees[k] = v
+	}
+	for k, v := range d.scheduled {
+		c.scheduled[k] = v
+	}
+	for k, v := range d.standingOrders {
+		c.standingOrders[k] = v
+	}
+	for k, v := range d.occurrences {
+		c.occurrences[k] = v
+	}
+	for k, v := range d.holds {
+		c.holds[k] = v
+	}
+	for k, v := range d.currencyLimits {
+		c.currencyLimits[k] = v
+	}
+	for k, v := range d.accountLimits {
+		c.accountLimits[k] = v
+	}
+	for k, v := range d.customers {
+		c.customers[k] = v
+	}
+	for k, v := range d.apiKeys {
+		c.apiKeys[k] = v
+	}
+	return c
+}
+
+// tx is a transaction. It modifies a copy of the DB's data,
+// which replaces the DB's data if the transaction commits.
+type tx struct {
+	db   *DB
+	data *data
+	// now is the time of the transaction, which is used for all timestamps
+	// that it stores, like CURRENT_TIMESTAMP in postgres
+	now  time.Time
+	done bool
+}
+
+type unitOfWork struct {
+	db *DB
+}
+
+// NewUnitOfWork creates a wallet.UnitOfWork that runs transactions of db
+func NewUnitOfWork(db *DB) wallet.UnitOfWork {
+	return &unitOfWork{
+		db: db,
+	}
+}
+
+func (u *unitOfWork) Do(ctx context.Context, f func(ctx context.Context, tx wallet.Tx) error) error {
+	return u.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		return f(ctx, t)
+	})
+}
+
+// contextTx returns the transaction of ctx if it was created by this DB, otherwise nil
+func (db *DB) contextTx(ctx context.Context) *tx {
+	t, err := db.dbTx(wallet.TxFromContext(ctx))
+	if err != nil {
+		return nil
+	}
+	return t
+}
+
+// read calls f with the data of the transaction of ctx, or with the DB's committed data
+func (db *DB) read(ctx context.Context, f func(d *data) error) error {
+	if t := db.contextTx(ctx); t != nil {
+		return f(t.data)
+	}
+
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return f(db.data)
+}
+
+// withTx calls f in a transaction, committing it if f returns nil.
+// The transaction is also added to the context passed to f.
+// If ctx already has a transaction of this DB, f is called in a savepoint of it instead.
+func (db *DB) withTx(ctx context.Context, f func(ctx context.Context, t *tx) error) (err error) {
+	if t := db.contextTx(ctx); t != nil {
+		return t.withSavepoint(ctx, f)
+	}
+
+	db.txMu.Lock()
+	defer db.txMu.Unlock()
+
+	db.mu.RLock()
+	t := &tx{
+		db:   db,
+		data: db.data.clone(),
+		now:  db.nextTime(),
+	}
+	db.mu.RUnlock()
+
+	defer func() {
+		// The copy is discarded on error or panic, which rolls back the transaction
+		t.done = true
+	}()
+
+	if err := f(wallet.ContextWithTx(ctx, t), t); err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	db.mu.Lock()
+	db.data = t.data
+	db.mu.Unlock()
+
+	return nil
+}
+
+// withSavepoint calls f in a savepoint of the transaction. If f fails, only
+// the changes made since the savepoint are rolled back.
+func (t *tx) withSavepoint(ctx context.Context, f func(ctx context.Context, t *tx) error) (err error) {
+	savepoint := t.data.clone()
+
+	defer func() {
+		if r := recover(); r != nil {
+			t.data = savepoint
+			panic(r)
+		} else if err != nil {
+			t.data = savepoint
+		}
+	}()
+
+	return f(ctx, t)
+}
+
+// dbTx returns the transaction of a wallet.Tx created by this DB
+func (db *DB) dbTx(wtx wallet.Tx) (*tx, error) {
+	t, ok := wtx.(*tx)
+	if !ok || t.db != db {
+		return nil, errForeignTx
+	}
+	if t.done {
+		return nil, errTxDone
+	}
+	return t, nil
+}
+
+// nextTime returns the current time with the precision of a postgres timestamp.
+// Each transaction gets a later time than the previous one, so that data
+// stored by consecutive transactions has a consistent order.
+// Must be called while holding txMu.
+func (db *DB) nextTime() time.Time {
+	t := time.Now().UTC().Truncate(time.Microsecond)
+	if !t.After(db.lastTime) {
+		t = db.lastTime.Add(time.Microsecond)
+	}
+	db.lastTime = t
+	return t
+}
+
+// copyDecimal returns a copy of d, so that callers can't modify stored decimals
+func copyDecimal(d *apd.Decimal) *apd.Decimal {
+	if d == nil {
+		return nil
+	}
+	return new(apd.Decimal).Set(d)
+}
+
+// round rounds d to exponent decimal places, like the postgres round() function
+func round(d *apd.Decimal, exponent int32) (*apd.Decimal, error) {
+	if d == nil {
+		return nil, nil
+	}
+	c := apd.BaseContext.WithPrecision(64)
+	c.Rounding = apd.RoundHalfUp
+	var r apd.Decimal
+	if _, err := c.Quantize(&r, d, -exponent); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// lessUUID orders UUIDs the same as postgres
+func lessUUID(a, b uuid.UUID) bool {
+	return bytes.Compare(a.Bytes(), b.Bytes()) < 0
+}
+
+// lessTimeID orders by time and then by ID
+func lessTimeID(at time.Time, aID uuid.UUID, bt time.Time, bID uuid.UUID) bool {
+	if !at.Equal(bt) {
+		return at.Before(bt)
+	}
+	return lessUUID(aID, bID)
+}
+
+type accountRepository struct {
+	db *DB
+}
+
+// NewAccountRepository creates a wallet.AccountRepository that stores accounts in db
+func NewAccountRepository(db *DB) wallet.AccountRepository {
+	return &accountRepository{
+		db: db,
+	}
+}
+
+// account returns a copy of an account, with the sum of its active holds
+func (d *data) account(a wallet.Account) (*wallet.Account, error) {
+	a.Balance = copyDecimal(a.Balance)
+	if a.OwnerID != nil {
+		id := *a.OwnerID
+		a.OwnerID = &id
+	}
+	a.Held = new(apd.Decimal)
+	for _, h := range d.holds {
+		if h.Status == wallet.HoldActive && uuid.Equal(h.From, a.ID) {
+			if _, err := apd.BaseContext.Add(a.Held, a.Held, h.Amount); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return &a, nil
+}
+
+func (r *accountRepository) Store(ctx context.Context, account *wallet.Account) error {
+	if uuid.Equal(account.ID, uuid.Nil) {
+		return errEmptyAccountID
+	}
+
+	kind := account.Kind
+	if kind == "" {
+		kind = wallet.AccountKindUser
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		if _, ok := t.data.accounts[account.ID]; ok {
+			return wallet.ErrAccountExists
+		}
+		if account.OwnerID != nil {
+			if kind != wallet.AccountKindUser {
+				return errSystemAccountOwner
+			}
+			if _, ok := t.data.customers[*account.OwnerID]; !ok {
+				return wallet.ErrNoCustomer
+			}
+		}
+
+		a, err := t.newAccount(account.ID, account.Currency, kind)
+		if err != nil {
+			return err
+		}
+		if account.OwnerID != nil {
+			id := *account.OwnerID
+			a.OwnerID = &id
+			t.data.accounts[a.ID] = *a
+		}
+
+		account.Kind = a.Kind
+		account.Status = a.Status
+		account.CreatedAt = a.CreatedAt
+		return nil
+	})
+}
+
+// newAccount stores an account with a zero balance
+func (t *tx) newAccount(id uuid.UUID, currency, kind string) (*wallet.Account, error) {
+	c, ok := t.data.currencies[currency]
+	if !ok {
+		return nil, wallet.ErrNoCurrency
+	}
+
+	a := wallet.Account{
+		ID:        id,
+		Balance:   apd.New(0, -c.Exponent),
+		Currency:  currency,
+		Kind:      kind,
+		Status:    wallet.AccountActive,
+		CreatedAt: t.now,
+	}
+	t.data.accounts[id] = a
+	return &a, nil
+}
+
+func (r *accountRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Account, error) {
+	var a *wallet.Account
+	err := r.db.read(ctx, func(d *data) error {
+		da, ok := d.accounts[id]
+		if !ok {
+			return wallet.ErrNoAccount
+		}
+		var err error
+		a, err = d.account(da)
+		return err
+	})
+	return a, err
+}
+
+func (r *accountRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Account, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// No lock is needed, since transactions are serialized
+	a, ok := t.data.accounts[id]
+	if !ok {
+		return nil, wallet.ErrNoAccount
+	}
+	return t.data.account(a)
+}
+
+func (r *accountRepository) UpdateStatusTx(ctx context.Context, wtx wallet.Tx, account *wallet.Account) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	a, ok := t.data.accounts[account.ID]
+	if !ok {
+		return wallet.ErrNoAccount
+	}
+
+	a.Status = account.Status
+	t.data.accounts[a.ID] = a
+
+	updated, err := t.data.account(a)
+	if err != nil {
+		return err
+	}
+	*account = *updated
+	return nil
+}
+
+func (r *accountRepository) SystemAccountIDTx(ctx context.Context, wtx wallet.Tx, kind, currency string) (uuid.UUID, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return t.systemAccountID(kind, currency)
+}
+
+// systemAccountID returns the ID of the system account of a kind for a currency
+func (t *tx) systemAccountID(kind, currency string) (uuid.UUID, error) {
+	if kind == wallet.AccountKindUser {
+		return uuid.Nil, errNotSystemAccount
+	}
+
+	for _, a := range t.data.accounts {
+		if a.Kind == kind && a.Currency == currency {
+			return a.ID, nil
+		}
+	}
+
+	// The account is created the first time it is needed
+	id, err := uuid.NewV4()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if _, err := t.newAccount(id, currency, kind); err != nil {
+		return uuid.Nil, err
+	}
+	return id, nil
+}
+
+func (r *accountRepository) List(ctx context.Context, page wallet.Page) ([]wallet.Account, string, error) {
+	return r.list(ctx, page, func(a wallet.Account) bool {
+		return a.IsUser()
+	})
+}
+
+func (r *accountRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, page wallet.Page) ([]wallet.Account, string, error) {
+	return r.list(ctx, page, func(a wallet.Account) bool {
+		return a.OwnerID != nil && uuid.Equal(*a.OwnerID, ownerID)
+	})
+}
+
+// list returns a page of the accounts for which match returns true
+func (r *accountRepository) list(ctx context.Context, page wallet.Page, match func(wallet.Account) bool) ([]wallet.Account, string, error) {
+	var after uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		after, err = cursor.DecodeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var accounts []wallet.Account
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, a := range d.accounts {
+			if match(a) && (page.Cursor == "" || lessUUID(after, a.ID)) {
+				ca, err := d.account(a)
+				if err != nil {
+					return err
+				}
+				accounts = append(accounts, *ca)
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(accounts, func(i, j int) bool {
+		return lessUUID(accounts[i].ID, accounts[j].ID)
+	})
+
+	var next string
+	if len(accounts) > page.Limit {
+		accounts = accounts[:page.Limit]
+		next = cursor.EncodeID(accounts[len(accounts)-1].ID)
+	}
+
+	return accounts, next, nil
+}
+
+func (r *accountRepository) BalanceDrifts(ctx context.Context) ([]wallet.BalanceDrift, error) {
+	var drifts []wallet.BalanceDrift
+	if err := r.db.read(ctx, func(d *data) error {
+		ledger := make(map[uuid.UUID]*apd.Decimal, len(d.accounts))
+		add := func(id uuid.UUID, amount *apd.Decimal) error {
+			sum, ok := ledger[id]
+			if !ok {
+				sum = apd.New(0, 0)
+			}
+			next := new(apd.Decimal)
+			if _, err := apd.BaseContext.Add(next, sum, amount); err != nil {
+				return err
+			}
+			ledger[id] = next
+			return nil
+		}
+
+		for _, e := range d.journal {
+			for _, p := range e.Postings {
+				if err := add(p.AccountID, p.Amount); err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, a := range d.accounts {
+			sum, ok := ledger[a.ID]
+			if !ok {
+				sum = apd.New(0, 0)
+			}
+			ledgerBalance, err := round(sum, d.currencies[a.Currency].Exponent)
+			if err != nil {
+				return err
+			}
+			if a.Balance.Cmp(ledgerBalance) != 0 {
+				drifts = append(drifts, wallet.BalanceDrift{
+					AccountID:     a.ID,
+					Balance:       copyDecimal(a.Balance),
+					LedgerBalance: ledgerBalance,
+				})
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(drifts, func(i, j int) bool {
+		return lessUUID(drifts[i].AccountID, drifts[j].AccountID)
+	})
+
+	return drifts, nil
+}
+
+type paymentRepository struct {
+	db *DB
+}
+
+// NewPaymentRepository creates a wallet.PaymentRepository that stores payments in db
+func NewPaymentRepository(db *DB) wallet.PaymentRepository {
+	return &paymentRepository{
+		db: db,
+	}
+}
+
+func copyPayment(p wallet.Payment) *wallet.Payment {
+	p.Amount = copyDecimal(p.Amount)
+	p.ToAmount = copyDecimal(p.ToAmount)
+	p.Rate = copyDecimal(p.Rate)
+	p.RefundedAmount = copyDecimal(p.RefundedAmount)
+	p.Fee = copyDecimal(p.Fee)
+	if p.From != nil {
+		from := *p.From
+		p.From = &from
+	}
+	if p.ReversalOf != nil {
+		reversalOf := *p.ReversalOf
+		p.ReversalOf = &reversalOf
+	}
+	if p.APIKeyID != nil {
+		apiKeyID := *p.APIKeyID
+		p.APIKeyID = &apiKeyID
+	}
+	return &p
+}
+
+func (r *paymentRepository) Store(ctx context.Context, p *wallet.Payment) error {
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		return r.StoreTx(ctx, t, p)
+	})
+}
+
+func (r *paymentRepository) StoreTx(ctx context.Context, wtx wallet.Tx, p *wallet.Payment) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	if p.ID == uuid.Nil {
+		return errEmptyPaymentID
+	}
+
+	// Nothing is modified if the payment can't be stored,
+	// like a failed statement in a postgres transaction
+	return t.withSavepoint(ctx, func(ctx context.Context, t *tx) error {
+		return t.storePayment(p)
+	})
+}
+
+func (t *tx) storePayment(p *wallet.Payment) error {
+	d := t.data
+	if _, ok := d.payments[p.ID]; ok {
+		return errPaymentExists
+	}
+	if p.IdempotencyKey != "" {
+		if _, ok := d.idempotencyKeys[p.IdempotencyKey]; ok {
+			return wallet.ErrIdempotencyKeyReused
+		}
+	}
+
+	to, ok := d.accounts[p.To]
+	if !ok {
+		return wallet.ErrNoAccount
+	}
+	// The amount is in the "From" account's currency, or in the "To" account's
+	// currency for credits from outside the system
+	from := to
+	if p.From != nil {
+		from, ok = d.accounts[*p.From]
+		if !ok {
+			return wallet.ErrNoAccount
+		}
+	}
+	if p.APIKeyID != nil {
+		if _, ok := d.apiKeys[*p.APIKeyID]; !ok {
+			return wallet.ErrNoAPIKey
+		}
+	}
+
+	stored := *copyPayment(*p)
+	stored.Postings = nil
+	var err error
+	if stored.Amount, err = round(p.Amount, d.currencies[from.Currency].Exponent); err != nil {
+		return err
+	}
+	if stored.ToAmount, err = round(p.ToAmount, d.currencies[to.Currency].Exponent); err != nil {
+		return err
+	}
+	if stored.Fee, err = round(p.Fee, d.currencies[from.Currency].Exponent); err != nil {
+		return err
+	}
+	if stored.Fee != nil && stored.Fee.Sign() != 1 {
+		return errInvalidFee
+	}
+	stored.RefundedAmount = apd.New(0, 0)
+	stored.CreatedAt = t.now
+
+	if p.ReversalOf != nil {
+		original, ok := d.payments[*p.ReversalOf]
+		if !ok {
+			return wallet.ErrNoPayment
+		}
+
+		// The refund's amount is in the currency credited by the original payment
+		refunded := new(apd.Decimal)
+		if _, err := apd.BaseContext.Add(refunded, original.RefundedAmount, stored.Amount); err != nil {
+			return err
+		}
+		if refunded.Cmp(original.CreditAmount()) > 0 {
+			return errRefundExceedsPayment
+		}
+		original.RefundedAmount = refunded
+		d.payments[original.ID] = original
+	}
+
+	d.payments[stored.ID] = stored
+	if stored.IdempotencyKey != "" {
+		d.idempotencyKeys[stored.IdempotencyKey] = stored.ID
+	}
+
+	postings := p.Postings
+	if postings == nil {
+		if p.From == nil {
+			// A payment without a "From" account is posted as a debit of the
+			// external account of its currency
+			externalID, err := t.systemAccountID(wallet.AccountKindExternal, to.Currency)
+			if err != nil {
+				return err
+			}
+			from = d.accounts[externalID]
+		}
+
+		postings, err = wallet.PaymentPostings(stored, from, to, t.systemAccountID)
+		if err != nil {
+			return err
+		}
+	}
+
+	postings, err = t.storeJournalEntry(stored.ID, postings)
+	if err != nil {
+		return err
+	}
+
+	p.Amount = copyDecimal(stored.Amount)
+	p.ToAmount = copyDecimal(stored.ToAmount)
+	p.Fee = copyDecimal(stored.Fee)
+	p.RefundedAmount = apd.New(0, 0)
+	p.Postings = copyPostings(postings)
+	p.CreatedAt = stored.CreatedAt
+	return nil
+}
+
+// storeJournalEntry stores the journal entry of a payment and adds the amount of each posting
+// to the balance of its account. It returns the stored postings, whose amounts are rounded to
+// the decimal places of their currencies.
+func (t *tx) storeJournalEntry(paymentID uuid.UUID, postings []wallet.Posting) ([]wallet.Posting, error) {
+	if err := wallet.ValidatePostings(postings); err != nil {
+		return nil, err
+	}
+
+	d := t.data
+	stored := make([]wallet.Posting, len(postings))
+	for i, p := range postings {
+		c, ok := d.currencies[p.Currency]
+		if !ok {
+			return nil, wallet.ErrNoCurrency
+		}
+		a, ok := d.accounts[p.AccountID]
+		if !ok || a.Currency != p.Currency {
+			return nil, wallet.ErrNoAccount
+		}
+
+		amount, err := round(p.Amount, c.Exponent)
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = wallet.Posting{
+			AccountID: p.AccountID,
+			Amount:    amount,
+			Currency:  p.Currency,
+		}
+
+		balance := new(apd.Decimal)
+		if _, err := apd.BaseContext.Add(balance, a.Balance, amount); err != nil {
+			return nil, err
+		}
+		a.Balance = balance
+		d.accounts[a.ID] = a
+	}
+
+	// An account with several postings is checked after all of them are added
+	for _, p := range stored {
+		if a := d.accounts[p.AccountID]; a.IsUser() && a.Balance.Sign() < 0 {
+			return nil, wallet.ErrNegativeBalance
+		}
+	}
+
+	// Rounding could unbalance the postings
+	if err := wallet.ValidatePostings(stored); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+	d.journal[paymentID] = wallet.JournalEntry{
+		ID:        id,
+		PaymentID: paymentID,
+		Postings:  stored,
+		CreatedAt: t.now,
+	}
+
+	return stored, nil
+}
+
+func copyPostings(postings []wallet.Posting) []wallet.Posting {
+	c := make([]wallet.Posting, len(postings))
+	for i, p := range postings {
+		p.Amount = copyDecimal(p.Amount)
+		c[i] = p
+	}
+	return c
+}
+
+func (r *paymentRepository) JournalEntry(ctx context.Context, paymentID uuid.UUID) (*wallet.JournalEntry, error) {
+	var entry *wallet.JournalEntry
+	err := r.db.read(ctx, func(d *data) error {
+		e, ok := d.journal[paymentID]
+		if !ok {
+			return wallet.ErrNoPayment
+		}
+		e.Postings = copyPostings(e.Postings)
+		entry = &e
+		return nil
+	})
+	return entry, err
+}
+
+func (r *paymentRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Payment, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	p, ok := t.data.payments[id]
+	if !ok {
+		return nil, wallet.ErrNoPayment
+	}
+	return copyPayment(p), nil
+}
+
+func (r *paymentRepository) GetByIdempotencyKeyTx(ctx context.Context, wtx wallet.Tx, key string) (*wallet.Payment, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	id, ok := t.data.idempotencyKeys[key]
+	if !ok {
+		return nil, wallet.ErrNoPayment
+	}
+	return copyPayment(t.data.payments[id]), nil
+}
+
+func (r *paymentRepository) List(ctx context.Context, page wallet.Page) ([]wallet.Payment, string, error) {
+	return r.list(ctx, page, func(d *data, p wallet.Payment) bool {
+		return true
+	})
+}
+
+func (r *paymentRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, page wallet.Page) ([]wallet.Payment, string, error) {
+	return r.list(ctx, page, func(d *data, p wallet.Payment) bool {
+		owned := func(id uuid.UUID) bool {
+			a := d.accounts[id]
+			return a.OwnerID != nil && uuid.Equal(*a.OwnerID, ownerID)
+		}
+		return (p.From != nil && owned(*p.From)) || owned(p.To)
+	})
+}
+
+// list returns a page of the payments for which match returns true
+func (r *paymentRepository) list(ctx context.Context, page wallet.Page, match func(*data, wallet.Payment) bool) ([]wallet.Payment, string, error) {
+	var afterTime time.Time
+	var afterID uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		afterTime, afterID, err = cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var payments []wallet.Payment
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, p := range d.payments {
+			if match(d, p) && (page.Cursor == "" || lessTimeID(afterTime, afterID, p.CreatedAt, p.ID)) {
+				payments = append(payments, *copyPayment(p))
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(payments, func(i, j int) bool {
+		return lessTimeID(payments[i].CreatedAt, payments[i].ID, payments[j].CreatedAt, payments[j].ID)
+	})
+
+	var next string
+	if len(payments) > page.Limit {
+		payments = payments[:page.Limit]
+		last := payments[len(payments)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.ID)
+	}
+
+	return payments, next, nil
+}
+
+func (r *paymentRepository) ListAccountPayments(ctx context.Context, accountID uuid.UUID, tr wallet.TimeRange, page wallet.Page) ([]wallet.AccountPayment, string, error) {
+	var beforeTime time.Time
+	var beforeID uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		beforeTime, beforeID, err = cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	// Entries are built from the account's journal postings, so that debits include their fee
+	var entries []wallet.AccountPayment
+	if err := r.db.read(ctx, func(d *data) error {
+		for paymentID, e := range d.journal {
+			var amount *apd.Decimal
+			for _, posting := range e.Postings {
+				if !uuid.Equal(posting.AccountID, accountID) {
+					continue
+				}
+				if amount == nil {
+					amount = apd.New(0, 0)
+				}
+				if _, err := apd.BaseContext.Add(amount, amount, posting.Amount); err != nil {
+					return err
+				}
+			}
+			if amount == nil {
+				continue
+			}
+
+			p := copyPayment(d.payments[paymentID])
+			entry := wallet.AccountPayment{
+				AccountID: accountID,
+				PaymentID: p.ID,
+				Amount:    amount,
+				CreatedAt: p.CreatedAt,
+			}
+			if uuid.Equal(p.To, accountID) {
+				entry.Counterparty = p.From
+			} else {
+				entry.Counterparty = &p.To
+				entry.Fee = p.Fee
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	// The running balance is computed over all of the account's payments,
+	// before the time range and cursor filters are applied
+	sort.Slice(entries, func(i, j int) bool {
+		return lessTimeID(entries[i].CreatedAt, entries[i].PaymentID, entries[j].CreatedAt, entries[j].PaymentID)
+	})
+	balance := apd.New(0, 0)
+	for i := range entries {
+		next := new(apd.Decimal)
+		if _, err := apd.BaseContext.Add(next, balance, entries[i].Amount); err != nil {
+			return nil, "", err
+		}
+		balance = next
+		entries[i].Balance = balance
+	}
+
+	var payments []wallet.AccountPayment
+	for i := len(entries) - 1; i >= 0; i-- {
+		e := entries[i]
+		if !tr.Since.IsZero() && e.CreatedAt.Before(tr.Since) {
+			continue
+		}
+		if !tr.Until.IsZero() && !e.CreatedAt.Before(tr.Until) {
+			continue
+		}
+		if page.Cursor != "" && !lessTimeID(e.CreatedAt, e.PaymentID, beforeTime, beforeID) {
+			continue
+		}
+		payments = append(payments, e)
+	}
+
+	var next string
+	if len(payments) > page.Limit {
+		payments = payments[:page.Limit]
+		last := payments[len(payments)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.PaymentID)
+	}
+
+	return payments, next, nil
+}
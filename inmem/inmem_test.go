@@ -0,0 +1,27 @@
+package inmem
+
+import (
+	"testing"
+
+	"github.com/xsleonard/gokit-example/wallettest"
+)
+
+func TestRepositories(t *testing.T) {
+	wallettest.Run(t, func(t *testing.T) (wallettest.Repositories, func()) {
+		db := NewDB()
+		return wallettest.Repositories{
+			UnitOfWork:         NewUnitOfWork(db),
+			Accounts:           NewAccountRepository(db),
+			Payments:           NewPaymentRepository(db),
+			Rates:              NewRateRepository(db),
+			Currencies:         NewCurrencyRepository(db),
+			Fees:               NewFeeRepository(db),
+			ScheduledTransfers: NewScheduledTransferRepository(db),
+			StandingOrders:     NewStandingOrderRepository(db),
+			Holds:              NewHoldRepository(db),
+			Limits:             NewLimitRepository(db),
+			Customers:          NewCustomerRepository(db),
+			APIKeys:            NewAPIKeyRepository(db),
+		}, func() {}
+	})
+}
@@ -0,0 +1,144 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidSpendingLimit is returned when storing a spending limit that is not more than zero
+var errInvalidSpendingLimit = errors.New("Invalid spending limit")
+
+type limitRepository struct {
+	db *DB
+}
+
+// NewLimitRepository creates a wallet.LimitRepository that stores spending limits in db
+func NewLimitRepository(db *DB) wallet.LimitRepository {
+	return &limitRepository{
+		db: db,
+	}
+}
+
+func copySpendingLimit(l wallet.SpendingLimit) wallet.SpendingLimit {
+	l.MaxAmount = copyDecimal(l.MaxAmount)
+	l.DailyAmount = copyDecimal(l.DailyAmount)
+	if l.AccountID != nil {
+		id := *l.AccountID
+		l.AccountID = &id
+	}
+	if l.HourlyCount != nil {
+		n := *l.HourlyCount
+		l.HourlyCount = &n
+	}
+	return l
+}
+
+func (r *limitRepository) Limit(ctx context.Context, accountID uuid.UUID, currency string) (*wallet.SpendingLimit, error) {
+	var limit *wallet.SpendingLimit
+	err := r.db.read(ctx, func(d *data) error {
+		l, ok := d.accountLimits[accountID]
+		if !ok {
+			l, ok = d.currencyLimits[currency]
+		}
+		if !ok {
+			return wallet.ErrNoSpendingLimit
+		}
+		l = copySpendingLimit(l)
+		limit = &l
+		return nil
+	})
+	return limit, err
+}
+
+func (r *limitRepository) Store(ctx context.Context, limit *wallet.SpendingLimit) error {
+	if !isValidSpendingLimit(limit) {
+		return errInvalidSpendingLimit
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		if limit.AccountID == nil {
+			if _, ok := t.data.currencies[limit.Currency]; !ok {
+				return wallet.ErrNoCurrency
+			}
+		} else {
+			// The limit's currency is the account's currency, and system accounts have no limits
+			a, ok := t.data.accounts[*limit.AccountID]
+			if !ok || !a.IsUser() {
+				return wallet.ErrNoAccount
+			}
+			limit.Currency = a.Currency
+		}
+
+		limit.UpdatedAt = t.now
+		stored := copySpendingLimit(*limit)
+		if stored.AccountID == nil {
+			t.data.currencyLimits[stored.Currency] = stored
+		} else {
+			t.data.accountLimits[*stored.AccountID] = stored
+		}
+		return nil
+	})
+}
+
+func (r *limitRepository) All(ctx context.Context) ([]wallet.SpendingLimit, error) {
+	var currencyLimits, accountLimits []wallet.SpendingLimit
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, l := range d.currencyLimits {
+			currencyLimits = append(currencyLimits, copySpendingLimit(l))
+		}
+		for _, l := range d.accountLimits {
+			accountLimits = append(accountLimits, copySpendingLimit(l))
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(currencyLimits, func(i, j int) bool {
+		return currencyLimits[i].Currency < currencyLimits[j].Currency
+	})
+	sort.Slice(accountLimits, func(i, j int) bool {
+		return lessUUID(*accountLimits[i].AccountID, *accountLimits[j].AccountID)
+	})
+
+	return append(currencyLimits, accountLimits...), nil
+}
+
+func (r *limitRepository) Spending(ctx context.Context, accountID uuid.UUID, since time.Time) (*wallet.Spending, error) {
+	s := wallet.Spending{
+		Amount: apd.New(0, 0),
+	}
+	err := r.db.read(ctx, func(d *data) error {
+		for _, p := range d.payments {
+			if p.From == nil || !uuid.Equal(*p.From, accountID) || p.ReversalOf != nil || p.CreatedAt.Before(since) {
+				continue
+			}
+			if _, err := apd.BaseContext.Add(s.Amount, s.Amount, p.Amount); err != nil {
+				return err
+			}
+			s.Count++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// isValidSpendingLimit returns true if the limits of a spending limit are more than zero
+func isValidSpendingLimit(limit *wallet.SpendingLimit) bool {
+	for _, d := range []*apd.Decimal{limit.MaxAmount, limit.DailyAmount} {
+		if d != nil && (d.Form != apd.Finite || d.Sign() <= 0) {
+			return false
+		}
+	}
+	return limit.HourlyCount == nil || *limit.HourlyCount > 0
+}
@@ -0,0 +1,91 @@
+package inmem
+
+import (
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/cockroachdb/apd"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+var (
+	// errInvalidRate is returned when storing an exchange rate that is not positive
+	errInvalidRate = errors.New("Exchange rate must be greater than 0")
+	// errSameCurrency is returned when storing an exchange rate from a currency to itself
+	errSameCurrency = errors.New("Exchange rates must be between different currencies")
+)
+
+type rateRepository struct {
+	db *DB
+}
+
+// NewRateRepository creates a wallet.RateRepository that stores exchange rates in db
+func NewRateRepository(db *DB) wallet.RateRepository {
+	return &rateRepository{
+		db: db,
+	}
+}
+
+func (r *rateRepository) Rate(ctx context.Context, from, to string) (*apd.Decimal, error) {
+	var rate *apd.Decimal
+	err := r.db.read(ctx, func(d *data) error {
+		er, ok := d.rates[ratePair{from: from, to: to}]
+		if !ok {
+			return wallet.ErrNoExchangeRate
+		}
+		rate = copyDecimal(er.Rate)
+		return nil
+	})
+	return rate, err
+}
+
+func (r *rateRepository) Store(ctx context.Context, rate *wallet.ExchangeRate) error {
+	if rate.Rate == nil || rate.Rate.Sign() != 1 {
+		return errInvalidRate
+	}
+	if rate.From == rate.To {
+		return errSameCurrency
+	}
+
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		if _, ok := t.data.currencies[rate.From]; !ok {
+			return wallet.ErrNoCurrency
+		}
+		if _, ok := t.data.currencies[rate.To]; !ok {
+			return wallet.ErrNoCurrency
+		}
+
+		rate.UpdatedAt = t.now
+		t.data.rates[ratePair{from: rate.From, to: rate.To}] = wallet.ExchangeRate{
+			From:      rate.From,
+			To:        rate.To,
+			Rate:      copyDecimal(rate.Rate),
+			UpdatedAt: rate.UpdatedAt,
+		}
+		return nil
+	})
+}
+
+func (r *rateRepository) All(ctx context.Context) ([]wallet.ExchangeRate, error) {
+	var rates []wallet.ExchangeRate
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, er := range d.rates {
+			er.Rate = copyDecimal(er.Rate)
+			rates = append(rates, er)
+		}
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rates, func(i, j int) bool {
+		if rates[i].From != rates[j].From {
+			return rates[i].From < rates[j].From
+		}
+		return rates[i].To < rates[j].To
+	})
+
+	return rates, nil
+}
@@ -0,0 +1,162 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
+)
+
+type scheduledTransferRepository struct {
+	db *DB
+}
+
+// NewScheduledTransferRepository creates a wallet.ScheduledTransferRepository that stores scheduled transfers in db
+func NewScheduledTransferRepository(db *DB) wallet.ScheduledTransferRepository {
+	return &scheduledTransferRepository{
+		db: db,
+	}
+}
+
+func copyScheduledTransfer(s wallet.ScheduledTransfer) *wallet.ScheduledTransfer {
+	s.Amount = copyDecimal(s.Amount)
+	if s.PaymentID != nil {
+		id := *s.PaymentID
+		s.PaymentID = &id
+	}
+	if s.APIKeyID != nil {
+		apiKeyID := *s.APIKeyID
+		s.APIKeyID = &apiKeyID
+	}
+	return &s
+}
+
+func (r *scheduledTransferRepository) Store(ctx context.Context, transfer *wallet.ScheduledTransfer) error {
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		for _, id := range []uuid.UUID{transfer.To, transfer.From} {
+			if _, ok := t.data.accounts[id]; !ok {
+				return wallet.ErrNoAccount
+			}
+		}
+		if transfer.APIKeyID != nil {
+			if _, ok := t.data.apiKeys[*transfer.APIKeyID]; !ok {
+				return wallet.ErrNoAPIKey
+			}
+		}
+
+		stored := copyScheduledTransfer(*transfer)
+		// Times are stored with the precision of a postgres timestamp
+		stored.ExecuteAt = stored.ExecuteAt.UTC().Truncate(time.Microsecond)
+		stored.Status = wallet.ScheduledTransferPending
+		stored.PaymentID = nil
+		stored.Error = ""
+		stored.CreatedAt = t.now
+		stored.UpdatedAt = t.now
+		t.data.scheduled[stored.ID] = *stored
+
+		*transfer = *copyScheduledTransfer(*stored)
+		return nil
+	})
+}
+
+func (r *scheduledTransferRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.ScheduledTransfer, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// No lock is needed, since transactions are serialized
+	s, ok := t.data.scheduled[id]
+	if !ok {
+		return nil, wallet.ErrNoScheduledTransfer
+	}
+	return copyScheduledTransfer(s), nil
+}
+
+func (r *scheduledTransferRepository) NextDueTx(ctx context.Context, wtx wallet.Tx) (*wallet.ScheduledTransfer, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var next *wallet.ScheduledTransfer
+	for _, s := range t.data.scheduled {
+		if s.Status != wallet.ScheduledTransferPending || s.ExecuteAt.After(t.now) {
+			continue
+		}
+		if next == nil || lessTimeID(s.ExecuteAt, s.ID, next.ExecuteAt, next.ID) {
+			next = copyScheduledTransfer(s)
+		}
+	}
+
+	if next == nil {
+		return nil, wallet.ErrNoScheduledTransfer
+	}
+	return next, nil
+}
+
+func (r *scheduledTransferRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, transfer *wallet.ScheduledTransfer) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	stored, ok := t.data.scheduled[transfer.ID]
+	if !ok {
+		return wallet.ErrNoScheduledTransfer
+	}
+
+	updated := copyScheduledTransfer(*transfer)
+	stored.Status = updated.Status
+	stored.PaymentID = updated.PaymentID
+	stored.Error = updated.Error
+	stored.UpdatedAt = t.now
+	t.data.scheduled[stored.ID] = stored
+
+	transfer.UpdatedAt = t.now
+	return nil
+}
+
+func (r *scheduledTransferRepository) List(ctx context.Context, status string, page wallet.Page) ([]wallet.ScheduledTransfer, string, error) {
+	var afterTime time.Time
+	var afterID uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		afterTime, afterID, err = cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var transfers []wallet.ScheduledTransfer
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, s := range d.scheduled {
+			if status != "" && s.Status != status {
+				continue
+			}
+			if page.Cursor == "" || lessTimeID(afterTime, afterID, s.ExecuteAt, s.ID) {
+				transfers = append(transfers, *copyScheduledTransfer(s))
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(transfers, func(i, j int) bool {
+		return lessTimeID(transfers[i].ExecuteAt, transfers[i].ID, transfers[j].ExecuteAt, transfers[j].ID)
+	})
+
+	var next string
+	if len(transfers) > page.Limit {
+		transfers = transfers[:page.Limit]
+		last := transfers[len(transfers)-1]
+		next = cursor.EncodeTimeID(last.ExecuteAt, last.ID)
+	}
+
+	return transfers, next, nil
+}
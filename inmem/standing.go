@@ -0,0 +1,261 @@
+package inmem
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
+)
+
+type standingOrderRepository struct {
+	db *DB
+}
+
+// NewStandingOrderRepository creates a wallet.StandingOrderRepository that stores standing orders in db
+func NewStandingOrderRepository(db *DB) wallet.StandingOrderRepository {
+	return &standingOrderRepository{
+		db: db,
+	}
+}
+
+func copyStandingOrder(o wallet.StandingOrder) *wallet.StandingOrder {
+	o.Amount = copyDecimal(o.Amount)
+	if o.EndAt != nil {
+		endAt := *o.EndAt
+		o.EndAt = &endAt
+	}
+	if o.APIKeyID != nil {
+		apiKeyID := *o.APIKeyID
+		o.APIKeyID = &apiKeyID
+	}
+	return &o
+}
+
+func copyOccurrence(o wallet.StandingOrderOccurrence) *wallet.StandingOrderOccurrence {
+	if o.PaymentID != nil {
+		id := *o.PaymentID
+		o.PaymentID = &id
+	}
+	return &o
+}
+
+// truncateTime returns t in UTC with the precision of a postgres timestamp
+func truncateTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+func (r *standingOrderRepository) Store(ctx context.Context, order *wallet.StandingOrder) error {
+	return r.db.withTx(ctx, func(ctx context.Context, t *tx) error {
+		for _, id := range []uuid.UUID{order.To, order.From} {
+			if _, ok := t.data.accounts[id]; !ok {
+				return wallet.ErrNoAccount
+			}
+		}
+		if order.APIKeyID != nil {
+			if _, ok := t.data.apiKeys[*order.APIKeyID]; !ok {
+				return wallet.ErrNoAPIKey
+			}
+		}
+
+		stored := copyStandingOrder(*order)
+		stored.StartAt = truncateTime(stored.StartAt)
+		if stored.EndAt != nil {
+			*stored.EndAt = truncateTime(*stored.EndAt)
+		}
+		stored.NextRunAt = truncateTime(stored.NextRunAt)
+		stored.Status = wallet.StandingOrderActive
+		stored.Occurrences = 0
+		stored.FailedAttempts = 0
+		stored.CreatedAt = t.now
+		stored.UpdatedAt = t.now
+		t.data.standingOrders[stored.ID] = *stored
+
+		*order = *copyStandingOrder(*stored)
+		return nil
+	})
+}
+
+func (r *standingOrderRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.StandingOrder, error) {
+	var o *wallet.StandingOrder
+	err := r.db.read(ctx, func(d *data) error {
+		do, ok := d.standingOrders[id]
+		if !ok {
+			return wallet.ErrNoStandingOrder
+		}
+		o = copyStandingOrder(do)
+		return nil
+	})
+	return o, err
+}
+
+func (r *standingOrderRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.StandingOrder, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// No lock is needed, since transactions are serialized
+	o, ok := t.data.standingOrders[id]
+	if !ok {
+		return nil, wallet.ErrNoStandingOrder
+	}
+	return copyStandingOrder(o), nil
+}
+
+func (r *standingOrderRepository) NextDueTx(ctx context.Context, wtx wallet.Tx) (*wallet.StandingOrder, error) {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	var next *wallet.StandingOrder
+	for _, o := range t.data.standingOrders {
+		if o.Status != wallet.StandingOrderActive || o.NextRunAt.After(t.now) {
+			continue
+		}
+		if next == nil || lessTimeID(o.NextRunAt, o.ID, next.NextRunAt, next.ID) {
+			next = copyStandingOrder(o)
+		}
+	}
+
+	if next == nil {
+		return nil, wallet.ErrNoStandingOrder
+	}
+	return next, nil
+}
+
+func (r *standingOrderRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, order *wallet.StandingOrder) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	stored, ok := t.data.standingOrders[order.ID]
+	if !ok {
+		return wallet.ErrNoStandingOrder
+	}
+
+	updated := copyStandingOrder(*order)
+	stored.Amount = updated.Amount
+	stored.EndAt = updated.EndAt
+	if stored.EndAt != nil {
+		*stored.EndAt = truncateTime(*stored.EndAt)
+	}
+	stored.Status = updated.Status
+	stored.Occurrences = updated.Occurrences
+	stored.FailedAttempts = updated.FailedAttempts
+	stored.NextRunAt = truncateTime(updated.NextRunAt)
+	stored.UpdatedAt = t.now
+	t.data.standingOrders[stored.ID] = stored
+
+	*order = *copyStandingOrder(stored)
+	return nil
+}
+
+func (r *standingOrderRepository) List(ctx context.Context, page wallet.Page) ([]wallet.StandingOrder, string, error) {
+	var afterTime time.Time
+	var afterID uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		afterTime, afterID, err = cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var orders []wallet.StandingOrder
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, o := range d.standingOrders {
+			if page.Cursor == "" || lessTimeID(afterTime, afterID, o.CreatedAt, o.ID) {
+				orders = append(orders, *copyStandingOrder(o))
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(orders, func(i, j int) bool {
+		return lessTimeID(orders[i].CreatedAt, orders[i].ID, orders[j].CreatedAt, orders[j].ID)
+	})
+
+	var next string
+	if len(orders) > page.Limit {
+		orders = orders[:page.Limit]
+		last := orders[len(orders)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.ID)
+	}
+
+	return orders, next, nil
+}
+
+func (r *standingOrderRepository) StoreOccurrenceTx(ctx context.Context, wtx wallet.Tx, occurrence *wallet.StandingOrderOccurrence) error {
+	t, err := r.db.dbTx(wtx)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := t.data.standingOrders[occurrence.StandingOrderID]; !ok {
+		return wallet.ErrNoStandingOrder
+	}
+
+	stored := copyOccurrence(*occurrence)
+	stored.ScheduledAt = truncateTime(stored.ScheduledAt)
+	stored.CreatedAt = t.now
+	for _, o := range t.data.occurrences {
+		if uuid.Equal(o.StandingOrderID, stored.StandingOrderID) && o.ScheduledAt.Equal(stored.ScheduledAt) {
+			stored.ID = o.ID
+			stored.CreatedAt = o.CreatedAt
+			break
+		}
+	}
+	stored.UpdatedAt = t.now
+	t.data.occurrences[stored.ID] = *stored
+
+	*occurrence = *copyOccurrence(*stored)
+	return nil
+}
+
+func (r *standingOrderRepository) Occurrences(ctx context.Context, orderID uuid.UUID, page wallet.Page) ([]wallet.StandingOrderOccurrence, string, error) {
+	var afterTime time.Time
+	var afterID uuid.UUID
+	if page.Cursor != "" {
+		var err error
+		afterTime, afterID, err = cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	var occurrences []wallet.StandingOrderOccurrence
+	if err := r.db.read(ctx, func(d *data) error {
+		for _, o := range d.occurrences {
+			if !uuid.Equal(o.StandingOrderID, orderID) {
+				continue
+			}
+			if page.Cursor == "" || lessTimeID(afterTime, afterID, o.ScheduledAt, o.ID) {
+				occurrences = append(occurrences, *copyOccurrence(o))
+			}
+		}
+		return nil
+	}); err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(occurrences, func(i, j int) bool {
+		return lessTimeID(occurrences[i].ScheduledAt, occurrences[i].ID, occurrences[j].ScheduledAt, occurrences[j].ID)
+	})
+
+	var next string
+	if len(occurrences) > page.Limit {
+		occurrences = occurrences[:page.Limit]
+		last := occurrences[len(occurrences)-1]
+		next = cursor.EncodeTimeID(last.ScheduledAt, last.ID)
+	}
+
+	return occurrences, next, nil
+}
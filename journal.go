@@ -0,0 +1,109 @@
+package wallet
+
+import (
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ErrUnbalancedEntry is returned if the postings of a journal entry don't sum to zero in each currency
+var ErrUnbalancedEntry = errors.New("Journal entry postings must sum to zero in each currency")
+
+// Posting is a credit or debit of an account, as part of a journal entry
+type Posting struct {
+	AccountID uuid.UUID
+	// Amount is positive for a credit and negative for a debit
+	Amount   *apd.Decimal
+	Currency string
+}
+
+// JournalEntry records the movement of money between accounts for a payment.
+// The amounts of its postings sum to zero in each currency.
+type JournalEntry struct {
+	ID        uuid.UUID
+	PaymentID uuid.UUID
+	Postings  []Posting
+	CreatedAt time.Time
+}
+
+// ValidatePostings returns ErrUnbalancedEntry if there are fewer than two postings,
+// a posting has a zero amount, or the amounts don't sum to zero in each currency
+func ValidatePostings(postings []Posting) error {
+	if len(postings) < 2 {
+		return ErrUnbalancedEntry
+	}
+
+	sums := make(map[string]*apd.Decimal)
+	for _, p := range postings {
+		if p.Amount == nil || p.Amount.IsZero() || p.Currency == "" {
+			return ErrUnbalancedEntry
+		}
+
+		sum, ok := sums[p.Currency]
+		if !ok {
+			sum = apd.New(0, 0)
+			sums[p.Currency] = sum
+		}
+		if _, err := apd.BaseContext.WithPrecision(64).Add(sum, sum, p.Amount); err != nil {
+			return err
+		}
+	}
+
+	for _, sum := range sums {
+		if !sum.IsZero() {
+			return ErrUnbalancedEntry
+		}
+	}
+
+	return nil
+}
+
+// PaymentPostings returns the postings of a payment from one account to another.
+// If the accounts have different currencies, Amount is exchanged for ToAmount through the
+// exchange account of each currency. A fee is debited from the "From" account and credited
+// to the revenue account of its currency. System accounts are looked up with systemAccountID.
+func PaymentPostings(p Payment, from, to Account, systemAccountID func(kind, currency string) (uuid.UUID, error)) ([]Posting, error) {
+	var postings []Posting
+	if from.Currency == to.Currency {
+		postings = []Posting{
+			{AccountID: from.ID, Amount: negate(p.Amount), Currency: from.Currency},
+			{AccountID: to.ID, Amount: p.Amount, Currency: to.Currency},
+		}
+	} else {
+		fromExchangeID, err := systemAccountID(AccountKindExchange, from.Currency)
+		if err != nil {
+			return nil, err
+		}
+		toExchangeID, err := systemAccountID(AccountKindExchange, to.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		postings = []Posting{
+			{AccountID: from.ID, Amount: negate(p.Amount), Currency: from.Currency},
+			{AccountID: fromExchangeID, Amount: p.Amount, Currency: from.Currency},
+			{AccountID: toExchangeID, Amount: negate(p.ToAmount), Currency: to.Currency},
+			{AccountID: to.ID, Amount: p.ToAmount, Currency: to.Currency},
+		}
+	}
+
+	if p.Fee != nil && !p.Fee.IsZero() {
+		revenueID, err := systemAccountID(AccountKindRevenue, from.Currency)
+		if err != nil {
+			return nil, err
+		}
+
+		postings = append(postings,
+			Posting{AccountID: from.ID, Amount: negate(p.Fee), Currency: from.Currency},
+			Posting{AccountID: revenueID, Amount: p.Fee, Currency: from.Currency},
+		)
+	}
+
+	return postings, nil
+}
+
+func negate(d *apd.Decimal) *apd.Decimal {
+	return new(apd.Decimal).Neg(d)
+}
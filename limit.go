@@ -0,0 +1,51 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ErrNoSpendingLimit is returned when there is no spending limit for an account or currency
+var ErrNoSpendingLimit = errors.New("No spending limit for the account or currency")
+
+// SpendingLimit limits the transfers and withdrawals from an account, or from the accounts of a currency.
+// The limit of an account replaces the limit of its currency. Amounts are in the currency,
+// and a nil field is not limited.
+type SpendingLimit struct {
+	// AccountID is the account that the limit applies to, or nil for all accounts of the currency
+	AccountID *uuid.UUID
+	Currency  string
+	// MaxAmount is the maximum amount of a single transfer or withdrawal
+	MaxAmount *apd.Decimal
+	// DailyAmount is the maximum total amount of transfers and withdrawals in the last 24 hours
+	DailyAmount *apd.Decimal
+	// HourlyCount is the maximum number of transfers and withdrawals in the last hour
+	HourlyCount *int
+	UpdatedAt   time.Time
+}
+
+// Spending is the total amount and number of transfers and withdrawals from an account within a period
+type Spending struct {
+	Amount *apd.Decimal
+	Count  int
+}
+
+// LimitRepository is the storage interface for spending limits.
+// Methods use the transaction of their context, if it has one.
+type LimitRepository interface {
+	// Limit returns the spending limit of an account, or the limit of its currency if the account has none.
+	// ErrNoSpendingLimit is returned if neither has a limit.
+	Limit(ctx context.Context, accountID uuid.UUID, currency string) (*SpendingLimit, error)
+	// Store creates or replaces the spending limit of an account, or of a currency if AccountID is nil.
+	// The currency of an account's limit is set to the account's currency.
+	Store(ctx context.Context, limit *SpendingLimit) error
+	// All returns the limits of all currencies, followed by the limits of all accounts
+	All(ctx context.Context) ([]SpendingLimit, error)
+	// Spending returns the total amount and number of transfers and withdrawals from an account
+	// since a time. Refunds and fees are not included.
+	Spending(ctx context.Context, accountID uuid.UUID, since time.Time) (*Spending, error)
+}
@@ -0,0 +1,27 @@
+DROP TRIGGER IF EXISTS posting_balanced ON posting;
+DROP FUNCTION IF EXISTS check_journal_entry_balanced();
+
+DROP TABLE IF EXISTS posting;
+DROP TABLE IF EXISTS journal_entry;
+
+-- Exchange accounts aren't referenced by payments
+DELETE FROM account WHERE kind = 'exchange';
+
+-- Without postings, the balance of a system account is the sum of its payments
+UPDATE account SET balance = round(COALESCE(payments.balance, 0), currency.exponent)
+    FROM
+        currency,
+        (SELECT account_id, sum(amount) AS balance FROM account_payment GROUP BY account_id) AS payments
+    WHERE
+        account.kind <> 'user'
+        AND currency.code = account.currency
+        AND payments.account_id = account.id;
+
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_id_currency_key;
+
+DROP INDEX IF EXISTS account_system_currency_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS account_external_currency_idx ON account(currency) WHERE kind = 'external';
+
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_kind_check;
+ALTER TABLE account ADD CONSTRAINT account_kind_check
+    CHECK (kind IN ('user', 'external'));
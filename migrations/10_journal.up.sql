@@ -0,0 +1,139 @@
+-- Exchange accounts are system accounts that convert between currencies.
+-- A payment between accounts of different currencies debits the sender and credits
+-- the exchange account of the sender's currency, then debits the exchange account of
+-- the receiver's currency and credits the receiver, so that each currency balances.
+-- There is at most one system account of each kind per currency.
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_kind_check;
+ALTER TABLE account ADD CONSTRAINT account_kind_check
+    CHECK (kind IN ('user', 'external', 'exchange'));
+
+DROP INDEX IF EXISTS account_external_currency_idx;
+CREATE UNIQUE INDEX IF NOT EXISTS account_system_currency_idx ON account(kind, currency) WHERE kind <> 'user';
+
+-- Referenced by posting, so that a posting is in its account's currency
+ALTER TABLE account ADD CONSTRAINT account_id_currency_key UNIQUE (id, currency);
+
+-- Every payment has a journal entry, with postings that sum to zero in each currency.
+-- A posting's amount is positive for a credit and negative for a debit.
+CREATE TABLE IF NOT EXISTS journal_entry (
+    id UUID PRIMARY KEY,
+    payment_id UUID NOT NULL UNIQUE REFERENCES payment(id),
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS posting (
+    id BIGSERIAL PRIMARY KEY,
+    journal_entry_id UUID NOT NULL REFERENCES journal_entry(id),
+    account_id UUID NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount <> 0),
+    currency TEXT NOT NULL REFERENCES currency(code),
+    FOREIGN KEY (account_id, currency) REFERENCES account(id, currency)
+);
+
+CREATE INDEX IF NOT EXISTS posting_journal_entry_idx ON posting(journal_entry_id);
+CREATE INDEX IF NOT EXISTS posting_account_idx ON posting(account_id);
+
+-- Credits without a "from" account are posted as debits of the external account of their currency
+INSERT INTO account (id, currency, kind)
+    SELECT
+        md5(random()::text || clock_timestamp()::text || to_account.currency)::uuid,
+        to_account.currency,
+        'external'
+    FROM
+        payment
+        JOIN account to_account
+        ON to_account.id = payment.to_account_id
+    WHERE
+        payment.from_account_id IS NULL
+    GROUP BY to_account.currency
+ON CONFLICT DO NOTHING;
+
+INSERT INTO account (id, currency, kind)
+    SELECT
+        md5(random()::text || clock_timestamp()::text || currencies.currency)::uuid,
+        currencies.currency,
+        'exchange'
+    FROM (
+        SELECT from_account.currency, to_account.currency AS to_currency
+        FROM
+            payment
+            JOIN account from_account
+            ON from_account.id = payment.from_account_id
+            JOIN account to_account
+            ON to_account.id = payment.to_account_id
+        WHERE from_account.currency <> to_account.currency
+    ) AS pairs,
+    LATERAL (VALUES (pairs.currency), (pairs.to_currency)) AS currencies(currency)
+    GROUP BY currencies.currency
+ON CONFLICT DO NOTHING;
+
+-- Migrate the existing payments. Each journal entry has the ID of its payment.
+INSERT INTO journal_entry (id, payment_id, created_at)
+    SELECT id, id, created_at FROM payment;
+
+INSERT INTO posting (journal_entry_id, account_id, amount, currency)
+    SELECT journal_entry_id, account_id, amount, currency
+    FROM (
+        SELECT
+            payment.id AS journal_entry_id,
+            postings.leg,
+            postings.account_id,
+            postings.amount,
+            postings.currency,
+            payment.created_at
+        FROM
+            payment
+            JOIN account to_account
+            ON to_account.id = payment.to_account_id
+            LEFT OUTER JOIN account from_account
+            ON from_account.id = payment.from_account_id
+            LEFT OUTER JOIN account external
+            ON external.kind = 'external' AND external.currency = to_account.currency
+            LEFT OUTER JOIN account from_exchange
+            ON from_exchange.kind = 'exchange' AND from_exchange.currency = from_account.currency
+            LEFT OUTER JOIN account to_exchange
+            ON to_exchange.kind = 'exchange' AND to_exchange.currency = to_account.currency,
+            LATERAL (VALUES
+                (1, COALESCE(from_account.id, external.id), -1 * payment.amount, COALESCE(from_account.currency, to_account.currency)),
+                (2, from_exchange.id, payment.amount, from_account.currency),
+                (3, to_exchange.id, -1 * payment.to_amount, to_account.currency),
+                (4, to_account.id, COALESCE(payment.to_amount, payment.amount), to_account.currency)
+            ) AS postings(leg, account_id, amount, currency)
+        WHERE
+            postings.leg IN (1, 4)
+            OR from_account.currency <> to_account.currency
+    ) AS legs
+    ORDER BY created_at, journal_entry_id, leg;
+
+-- External accounts are now debited for credits without a "from" account,
+-- and exchange accounts are new, so the balances of system accounts are recomputed
+UPDATE account SET balance = round(COALESCE(postings.balance, 0), currency.exponent)
+    FROM
+        currency,
+        (SELECT account_id, sum(amount) AS balance FROM posting GROUP BY account_id) AS postings
+    WHERE
+        account.kind <> 'user'
+        AND currency.code = account.currency
+        AND postings.account_id = account.id;
+
+-- The postings of a journal entry must sum to zero in each currency.
+-- The check is deferred until the end of the transaction, after all of the entry's postings are inserted.
+CREATE OR REPLACE FUNCTION check_journal_entry_balanced() RETURNS trigger AS $$
+BEGIN
+    IF EXISTS (
+        SELECT 1 FROM posting
+        WHERE journal_entry_id = NEW.journal_entry_id
+        GROUP BY currency
+        HAVING sum(amount) <> 0
+    ) THEN
+        RAISE EXCEPTION 'journal entry % is not balanced', NEW.journal_entry_id
+            USING ERRCODE = 'check_violation', CONSTRAINT = 'posting_balanced';
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE CONSTRAINT TRIGGER posting_balanced
+    AFTER INSERT OR UPDATE ON posting
+    DEFERRABLE INITIALLY DEFERRED
+    FOR EACH ROW EXECUTE PROCEDURE check_journal_entry_balanced();
@@ -0,0 +1,44 @@
+-- Fees are removed from the journal and credited back to the accounts that paid them.
+-- A fee's postings are the last debit of the sender for the fee's amount, and the credit
+-- of the revenue account.
+DELETE FROM posting
+    USING (
+        SELECT max(posting.id) AS id
+        FROM
+            payment
+            JOIN journal_entry
+            ON journal_entry.payment_id = payment.id
+            JOIN posting
+            ON posting.journal_entry_id = journal_entry.id
+        WHERE
+            payment.fee IS NOT NULL
+            AND posting.account_id = payment.from_account_id
+            AND posting.amount = -1 * payment.fee
+        GROUP BY journal_entry.id
+    ) AS fee_debit
+    WHERE posting.id = fee_debit.id;
+
+DELETE FROM posting
+    USING account
+    WHERE
+        account.id = posting.account_id
+        AND account.kind = 'revenue';
+
+UPDATE account SET balance = account.balance + fees.amount
+    FROM (
+        SELECT from_account_id, sum(fee) AS amount
+        FROM payment
+        WHERE fee IS NOT NULL
+        GROUP BY from_account_id
+    ) AS fees
+    WHERE fees.from_account_id = account.id;
+
+DELETE FROM account WHERE kind = 'revenue';
+
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_kind_check;
+ALTER TABLE account ADD CONSTRAINT account_kind_check
+    CHECK (kind IN ('user', 'external', 'exchange'));
+
+ALTER TABLE payment DROP COLUMN IF EXISTS fee;
+
+DROP TABLE IF EXISTS fee_schedule;
@@ -0,0 +1,21 @@
+-- Revenue accounts are system accounts that are credited the fees charged on transfers.
+-- There is at most one revenue account per currency.
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_kind_check;
+ALTER TABLE account ADD CONSTRAINT account_kind_check
+    CHECK (kind IN ('user', 'external', 'exchange', 'revenue'));
+
+-- The fee charged on transfers from accounts of a currency is flat + amount * percent / 100,
+-- raised to min_fee and lowered to max_fee if they are set
+CREATE TABLE IF NOT EXISTS fee_schedule (
+    currency TEXT PRIMARY KEY REFERENCES currency(code),
+    flat NUMERIC NOT NULL DEFAULT 0 CHECK (flat >= 0),
+    percent NUMERIC NOT NULL DEFAULT 0 CHECK (percent >= 0 AND percent <= 100),
+    min_fee NUMERIC CHECK (min_fee >= 0),
+    max_fee NUMERIC CHECK (max_fee >= 0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT fee_schedule_min_max_check CHECK (min_fee <= max_fee)
+);
+
+-- The fee charged to the sender of a payment in addition to its amount, in the sender's currency.
+-- It is null if no fee was charged.
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS fee NUMERIC CHECK (fee > 0);
@@ -0,0 +1,20 @@
+-- A scheduled transfer is made by the scheduled transfer worker once execute_at has passed.
+-- payment_id is the payment made by an executed transfer, and error is the reason a transfer failed.
+CREATE TABLE IF NOT EXISTS scheduled_transfer (
+    id UUID PRIMARY KEY,
+    to_account_id UUID REFERENCES account(id) NOT NULL,
+    from_account_id UUID REFERENCES account(id) NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount > 0),
+    execute_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending'
+        CHECK (status IN ('pending', 'executed', 'failed', 'canceled')),
+    payment_id UUID REFERENCES payment(id),
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT scheduled_transfer_payment_check CHECK ((status = 'executed') = (payment_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS scheduled_transfer_execute_at_idx ON scheduled_transfer(execute_at, id);
+-- The worker claims pending transfers in order of execute_at
+CREATE INDEX IF NOT EXISTS scheduled_transfer_due_idx ON scheduled_transfer(execute_at) WHERE status = 'pending';
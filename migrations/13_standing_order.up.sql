@@ -0,0 +1,42 @@
+-- A standing order is a transfer that recurs every interval_count days, weeks or months from start_at.
+-- The standing order worker makes the occurrence due at next_run_at, which is pushed back when a failed
+-- occurrence is retried. occurrences counts the occurrences that were executed or failed on every attempt,
+-- and failed_attempts counts the failed attempts of the next occurrence.
+CREATE TABLE IF NOT EXISTS standing_order (
+    id UUID PRIMARY KEY,
+    to_account_id UUID REFERENCES account(id) NOT NULL,
+    from_account_id UUID REFERENCES account(id) NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount > 0),
+    frequency TEXT NOT NULL CHECK (frequency IN ('daily', 'weekly', 'monthly')),
+    interval_count INTEGER NOT NULL CHECK (interval_count > 0),
+    start_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    end_at TIMESTAMP WITH TIME ZONE,
+    status TEXT NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'completed', 'canceled')),
+    occurrences INTEGER NOT NULL DEFAULT 0 CHECK (occurrences >= 0),
+    failed_attempts INTEGER NOT NULL DEFAULT 0 CHECK (failed_attempts >= 0),
+    next_run_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT standing_order_end_at_check CHECK (end_at >= start_at)
+);
+
+CREATE INDEX IF NOT EXISTS standing_order_created_at_idx ON standing_order(created_at, id);
+-- The worker claims active orders in order of next_run_at
+CREATE INDEX IF NOT EXISTS standing_order_due_idx ON standing_order(next_run_at) WHERE status = 'active';
+
+-- An occurrence is recorded when the worker attempts it, and updated on each retry.
+-- payment_id is the payment made by an executed occurrence, and error is the reason the last attempt failed.
+CREATE TABLE IF NOT EXISTS standing_order_occurrence (
+    id UUID PRIMARY KEY,
+    standing_order_id UUID REFERENCES standing_order(id) NOT NULL,
+    scheduled_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('executed', 'retrying', 'failed')),
+    attempts INTEGER NOT NULL CHECK (attempts > 0),
+    payment_id UUID REFERENCES payment(id),
+    error TEXT,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT standing_order_occurrence_payment_check CHECK ((status = 'executed') = (payment_id IS NOT NULL)),
+    UNIQUE (standing_order_id, scheduled_at)
+);
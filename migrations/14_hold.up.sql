@@ -0,0 +1,21 @@
+-- A hold reserves an amount of an account's balance until it is captured, released or expires.
+-- The held amount of an account is the sum of its active holds, and is subtracted from its
+-- balance to get the available balance that payments can spend.
+CREATE TABLE IF NOT EXISTS hold (
+    id UUID PRIMARY KEY,
+    from_account_id UUID REFERENCES account(id) NOT NULL,
+    amount NUMERIC NOT NULL CHECK (amount > 0),
+    status TEXT NOT NULL DEFAULT 'active'
+        CHECK (status IN ('active', 'captured', 'released', 'expired')),
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    -- A hold is marked captured before the capture's payment is stored in the same transaction
+    payment_id UUID REFERENCES payment(id) DEFERRABLE INITIALLY DEFERRED,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT hold_payment_check CHECK ((status = 'captured') = (payment_id IS NOT NULL))
+);
+
+-- The held amount of an account is summed from its active holds
+CREATE INDEX IF NOT EXISTS hold_from_account_id_idx ON hold(from_account_id) WHERE status = 'active';
+-- The sweeper expires active holds in order of expires_at
+CREATE INDEX IF NOT EXISTS hold_expires_at_idx ON hold(expires_at) WHERE status = 'active';
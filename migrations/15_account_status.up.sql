@@ -0,0 +1,4 @@
+-- Frozen accounts can receive payments but can't send them. Closed accounts can't send
+-- or receive payments, and are closed with a zero balance. Only user accounts change status.
+ALTER TABLE account ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT 'active'
+    CHECK (status IN ('active', 'frozen', 'closed'));
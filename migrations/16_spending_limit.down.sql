@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS payment_from_account_id_created_at_idx;
+DROP TABLE IF EXISTS spending_limit;
@@ -0,0 +1,18 @@
+-- A spending limit limits the transfers from the accounts of a currency, or from a single account
+-- if account_id is set, which replaces the limit of the account's currency. A null limit is unlimited.
+CREATE TABLE IF NOT EXISTS spending_limit (
+    id SERIAL PRIMARY KEY,
+    account_id UUID REFERENCES account(id),
+    currency TEXT NOT NULL REFERENCES currency(code),
+    max_amount NUMERIC CHECK (max_amount > 0),
+    daily_amount NUMERIC CHECK (daily_amount > 0),
+    hourly_count INTEGER CHECK (hourly_count > 0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+-- There is at most one limit per currency, and one per account
+CREATE UNIQUE INDEX IF NOT EXISTS spending_limit_currency_idx ON spending_limit(currency) WHERE account_id IS NULL;
+CREATE UNIQUE INDEX IF NOT EXISTS spending_limit_account_id_idx ON spending_limit(account_id) WHERE account_id IS NOT NULL;
+
+-- The spending of an account is summed from its recent payments
+CREATE INDEX IF NOT EXISTS payment_from_account_id_created_at_idx ON payment(from_account_id, created_at);
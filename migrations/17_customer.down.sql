@@ -0,0 +1,5 @@
+DROP VIEW IF EXISTS account_balance;
+DROP INDEX IF EXISTS account_owner_id_idx;
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_owner_id_check;
+ALTER TABLE account DROP COLUMN IF EXISTS owner_id;
+DROP TABLE IF EXISTS customer;
@@ -0,0 +1,34 @@
+-- A customer holds user accounts. Accounts created before customers existed have no owner.
+CREATE TABLE IF NOT EXISTS customer (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+ALTER TABLE account ADD COLUMN IF NOT EXISTS owner_id UUID REFERENCES customer(id);
+
+-- System accounts are held by the wallet system, not by a customer
+ALTER TABLE account ADD CONSTRAINT account_owner_id_check
+    CHECK (owner_id IS NULL OR kind = 'user');
+
+CREATE INDEX IF NOT EXISTS account_owner_id_idx ON account(owner_id);
+
+-- account_balance was dropped when balances were stored on the account (9_account_balance).
+-- It is recreated over the stored balances with the owner of each account, so that balances
+-- can be summed by customer.
+CREATE OR REPLACE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at,
+    kind,
+    owner_id
+) AS
+    SELECT
+        account.id,
+        account.balance,
+        account.currency,
+        account.created_at,
+        account.kind,
+        account.owner_id
+    FROM account;
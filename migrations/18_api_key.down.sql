@@ -0,0 +1,4 @@
+ALTER TABLE standing_order DROP COLUMN IF EXISTS api_key_id;
+ALTER TABLE scheduled_transfer DROP COLUMN IF EXISTS api_key_id;
+ALTER TABLE payment DROP COLUMN IF EXISTS api_key_id;
+DROP TABLE IF EXISTS api_key;
@@ -0,0 +1,17 @@
+-- API keys authenticate requests to the API. Only the SHA-256 hash of a key's token is stored.
+CREATE TABLE IF NOT EXISTS api_key (
+    id UUID PRIMARY KEY,
+    name TEXT NOT NULL,
+    key_hash BYTEA NOT NULL UNIQUE,
+    scopes TEXT[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    revoked_at TIMESTAMP WITH TIME ZONE
+);
+
+-- The API key that authenticated the request that created the payment
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS api_key_id UUID REFERENCES api_key(id);
+
+-- The API key that authenticated the request that created a scheduled transfer or standing order,
+-- which is recorded on the payments that the workers make for it
+ALTER TABLE scheduled_transfer ADD COLUMN IF NOT EXISTS api_key_id UUID REFERENCES api_key(id);
+ALTER TABLE standing_order ADD COLUMN IF NOT EXISTS api_key_id UUID REFERENCES api_key(id);
@@ -0,0 +1,28 @@
+DROP VIEW IF EXISTS account_payment;
+
+CREATE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        COALESCE(payment.to_amount, payment.amount),
+        payment.from_account_id,
+        payment.created_at
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount),
+        payment.to_account_id,
+        payment.created_at
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
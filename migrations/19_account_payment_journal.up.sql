@@ -0,0 +1,28 @@
+-- The payment history of an account is built from its journal postings, so that a debit includes
+-- the fee charged to the sender (11_fee) and the running balance matches the account's balance.
+-- amount is the total of the account's postings for the payment, and fee is the part of a debit
+-- that is the payment's fee.
+DROP VIEW IF EXISTS account_payment;
+
+CREATE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    fee,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        posting.account_id,
+        payment.id,
+        sum(posting.amount),
+        CASE WHEN posting.account_id = payment.from_account_id THEN payment.fee END,
+        CASE WHEN posting.account_id = payment.to_account_id THEN payment.from_account_id ELSE payment.to_account_id END,
+        payment.created_at
+    FROM
+        posting
+        JOIN journal_entry
+        ON journal_entry.id = posting.journal_entry_id
+        JOIN payment
+        ON payment.id = journal_entry.payment_id
+    GROUP BY posting.account_id, payment.id;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS payment_idempotency_key_idx;
+ALTER TABLE payment DROP COLUMN IF EXISTS idempotency_key;
@@ -0,0 +1,3 @@
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS idempotency_key TEXT;
+
+CREATE UNIQUE INDEX IF NOT EXISTS payment_idempotency_key_idx ON payment(idempotency_key);
@@ -0,0 +1,40 @@
+-- Columns can't be removed from a view by CREATE OR REPLACE VIEW,
+-- so the views are recreated as they were
+DROP VIEW IF EXISTS account_balance;
+DROP VIEW IF EXISTS account_payment;
+
+CREATE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        payment.amount
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount)
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
+
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency
+) AS
+    SELECT
+        account.id,
+        COALESCE(sum(account_payment.amount), 0.0),
+        account.currency
+    FROM
+        account
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id;
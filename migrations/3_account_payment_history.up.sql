@@ -0,0 +1,28 @@
+-- Add the counterparty and timestamp of each payment to the account_payment view.
+-- Columns can only be appended by CREATE OR REPLACE VIEW.
+CREATE OR REPLACE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        payment.amount,
+        payment.from_account_id,
+        payment.created_at
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount),
+        payment.to_account_id,
+        payment.created_at
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
@@ -0,0 +1,20 @@
+-- Columns can't be removed from a view by CREATE OR REPLACE VIEW,
+-- so the view is recreated as it was
+DROP VIEW IF EXISTS account_balance;
+
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency
+) AS
+    SELECT
+        account.id,
+        COALESCE(sum(account_payment.amount), 0.0),
+        account.currency
+    FROM
+        account
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id;
+
+DROP INDEX IF EXISTS payment_created_at_idx;
@@ -0,0 +1,21 @@
+-- Payments are listed in the order they were created
+CREATE INDEX IF NOT EXISTS payment_created_at_idx ON payment(created_at, id);
+
+-- Add the account creation time to the account_balance view.
+-- Columns can only be appended by CREATE OR REPLACE VIEW.
+CREATE OR REPLACE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at
+) AS
+    SELECT
+        account.id,
+        COALESCE(sum(account_payment.amount), 0.0),
+        account.currency,
+        account.created_at
+    FROM
+        account
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id;
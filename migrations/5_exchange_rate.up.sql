@@ -0,0 +1,42 @@
+CREATE TABLE IF NOT EXISTS exchange_rate (
+    from_currency TEXT NOT NULL,
+    to_currency TEXT NOT NULL,
+    rate NUMERIC NOT NULL CHECK (rate > 0.0),
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (from_currency, to_currency),
+    CHECK (from_currency <> to_currency)
+);
+
+-- Transfers between accounts of different currencies record the amount credited
+-- to the receiving account and the exchange rate applied.
+-- These are null for transfers between accounts of the same currency.
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS to_amount NUMERIC(20, 2) CHECK (to_amount > 0.0);
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS rate NUMERIC CHECK (rate > 0.0);
+
+-- The receiving account is credited the converted amount
+CREATE OR REPLACE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        COALESCE(payment.to_amount, payment.amount),
+        payment.from_account_id,
+        payment.created_at
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount),
+        payment.to_account_id,
+        payment.created_at
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
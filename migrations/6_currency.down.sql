@@ -0,0 +1,55 @@
+DROP VIEW IF EXISTS account_balance;
+DROP VIEW IF EXISTS account_payment;
+
+ALTER TABLE payment ALTER COLUMN to_amount TYPE NUMERIC(20, 2);
+ALTER TABLE payment ALTER COLUMN amount TYPE NUMERIC(20, 2);
+
+CREATE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        COALESCE(payment.to_amount, payment.amount),
+        payment.from_account_id,
+        payment.created_at
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount),
+        payment.to_account_id,
+        payment.created_at
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
+
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at
+) AS
+    SELECT
+        account.id,
+        COALESCE(sum(account_payment.amount), 0.0),
+        account.currency,
+        account.created_at
+    FROM
+        account
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id;
+
+ALTER TABLE exchange_rate DROP CONSTRAINT IF EXISTS exchange_rate_to_currency_fkey;
+ALTER TABLE exchange_rate DROP CONSTRAINT IF EXISTS exchange_rate_from_currency_fkey;
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_currency_fkey;
+
+DROP TABLE IF EXISTS currency;
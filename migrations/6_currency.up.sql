@@ -0,0 +1,82 @@
+-- Currencies are identified by their ISO 4217 code.
+-- The exponent is the number of decimal places of the currency's minor unit,
+-- e.g. 2 for USD (cents) and 0 for JPY.
+-- Only enabled currencies can be used for new accounts and exchange rates.
+CREATE TABLE IF NOT EXISTS currency (
+    code TEXT PRIMARY KEY CHECK (code ~ '^[A-Z]{3}$'),
+    exponent INTEGER NOT NULL CHECK (exponent >= 0 AND exponent <= 4),
+    enabled BOOLEAN NOT NULL DEFAULT TRUE
+);
+
+INSERT INTO currency (code, exponent, enabled) VALUES
+    ('USD', 2, TRUE),
+    ('EUR', 2, TRUE),
+    ('SGD', 2, TRUE),
+    ('GBP', 2, TRUE),
+    ('JPY', 0, FALSE),
+    ('KWD', 3, FALSE)
+ON CONFLICT (code) DO NOTHING;
+
+ALTER TABLE account ADD CONSTRAINT account_currency_fkey
+    FOREIGN KEY (currency) REFERENCES currency(code);
+ALTER TABLE exchange_rate ADD CONSTRAINT exchange_rate_from_currency_fkey
+    FOREIGN KEY (from_currency) REFERENCES currency(code);
+ALTER TABLE exchange_rate ADD CONSTRAINT exchange_rate_to_currency_fkey
+    FOREIGN KEY (to_currency) REFERENCES currency(code);
+
+-- Amounts are stored with the number of decimal places of their currency,
+-- so the columns can't have a fixed scale.
+-- Column types can't be changed while views depend on them,
+-- so the views are dropped and recreated.
+DROP VIEW IF EXISTS account_balance;
+DROP VIEW IF EXISTS account_payment;
+
+ALTER TABLE payment ALTER COLUMN amount TYPE NUMERIC;
+ALTER TABLE payment ALTER COLUMN to_amount TYPE NUMERIC;
+
+CREATE VIEW account_payment(
+    account_id,
+    payment_id,
+    amount,
+    counterparty_id,
+    created_at
+) AS
+    SELECT
+        payment.to_account_id,
+        payment.id,
+        COALESCE(payment.to_amount, payment.amount),
+        payment.from_account_id,
+        payment.created_at
+    FROM
+        payment
+    UNION ALL
+    SELECT
+        payment.from_account_id,
+        payment.id,
+        (-1 * payment.amount),
+        payment.to_account_id,
+        payment.created_at
+    FROM
+        payment
+    WHERE
+        payment.from_account_id IS NOT NULL;
+
+-- Balances are formatted with the number of decimal places of the account's currency
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at
+) AS
+    SELECT
+        account.id,
+        round(COALESCE(sum(account_payment.amount), 0), currency.exponent),
+        account.currency,
+        account.created_at
+    FROM
+        account
+        JOIN currency
+        ON account.currency = currency.code
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id, currency.code;
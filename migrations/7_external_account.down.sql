@@ -0,0 +1,42 @@
+-- Columns can't be removed from a view by CREATE OR REPLACE VIEW,
+-- so the view is recreated as it was
+DROP VIEW IF EXISTS account_balance;
+
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at
+) AS
+    SELECT
+        account.id,
+        round(COALESCE(sum(account_payment.amount), 0), currency.exponent),
+        account.currency,
+        account.created_at
+    FROM
+        account
+        JOIN currency
+        ON account.currency = currency.code
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id, currency.code;
+
+-- Withdrawals can't be represented without external accounts, so they are removed.
+-- Deposits become credits without a "from" account.
+DELETE FROM payment
+    USING account
+    WHERE
+        account.id = payment.to_account_id
+        AND account.kind = 'external';
+
+UPDATE payment SET from_account_id = NULL
+    FROM account
+    WHERE
+        account.id = payment.from_account_id
+        AND account.kind = 'external';
+
+DELETE FROM account WHERE kind = 'external';
+
+DROP INDEX IF EXISTS account_external_currency_idx;
+
+ALTER TABLE account DROP COLUMN IF EXISTS kind;
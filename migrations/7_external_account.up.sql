@@ -0,0 +1,58 @@
+-- User accounts are held by users of the wallet system. External accounts are
+-- system accounts that are the counterparty of deposits into and withdrawals
+-- out of the wallet system, so that every payment is between two accounts.
+-- There is at most one external account per currency. Its balance is the
+-- negative of the amount of the currency held in the wallet system.
+ALTER TABLE account ADD COLUMN IF NOT EXISTS kind TEXT NOT NULL DEFAULT 'user'
+    CHECK (kind IN ('user', 'external'));
+
+CREATE UNIQUE INDEX IF NOT EXISTS account_external_currency_idx ON account(currency) WHERE kind = 'external';
+
+-- Credits made before external accounts existed have no "from" account.
+-- Record them as deposits from the external account of their currency.
+INSERT INTO account (id, currency, kind)
+    SELECT
+        md5(random()::text || clock_timestamp()::text || to_account.currency)::uuid,
+        to_account.currency,
+        'external'
+    FROM
+        payment
+        JOIN account to_account
+        ON to_account.id = payment.to_account_id
+    WHERE
+        payment.from_account_id IS NULL
+    GROUP BY to_account.currency
+ON CONFLICT DO NOTHING;
+
+UPDATE payment SET from_account_id = external.id
+    FROM
+        account to_account,
+        account external
+    WHERE
+        payment.from_account_id IS NULL
+        AND to_account.id = payment.to_account_id
+        AND external.currency = to_account.currency
+        AND external.kind = 'external';
+
+-- Add the account kind to the account_balance view.
+-- Columns can only be appended by CREATE OR REPLACE VIEW.
+CREATE OR REPLACE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at,
+    kind
+) AS
+    SELECT
+        account.id,
+        round(COALESCE(sum(account_payment.amount), 0), currency.exponent),
+        account.currency,
+        account.created_at,
+        account.kind
+    FROM
+        account
+        JOIN currency
+        ON account.currency = currency.code
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id, currency.code;
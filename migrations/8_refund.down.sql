@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS payment_reversal_of_idx;
+
+ALTER TABLE payment DROP CONSTRAINT IF EXISTS payment_refunded_amount_check;
+ALTER TABLE payment DROP COLUMN IF EXISTS refunded_amount;
+ALTER TABLE payment DROP COLUMN IF EXISTS reversal_of;
@@ -0,0 +1,9 @@
+-- A refund is a payment in the opposite direction of an earlier payment, linked by reversal_of.
+-- refunded_amount is the total amount of a payment that has been refunded, in the currency
+-- credited to its receiving account, and can't exceed the amount credited.
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS reversal_of UUID REFERENCES payment(id);
+ALTER TABLE payment ADD COLUMN IF NOT EXISTS refunded_amount NUMERIC NOT NULL DEFAULT 0;
+ALTER TABLE payment ADD CONSTRAINT payment_refunded_amount_check
+    CHECK (refunded_amount >= 0 AND refunded_amount <= COALESCE(to_amount, amount));
+
+CREATE INDEX IF NOT EXISTS payment_reversal_of_idx ON payment(reversal_of);
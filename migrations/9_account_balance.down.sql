@@ -0,0 +1,23 @@
+ALTER TABLE account DROP CONSTRAINT IF EXISTS account_balance_check;
+ALTER TABLE account DROP COLUMN IF EXISTS balance;
+
+CREATE VIEW account_balance(
+    id,
+    balance,
+    currency,
+    created_at,
+    kind
+) AS
+    SELECT
+        account.id,
+        round(COALESCE(sum(account_payment.amount), 0), currency.exponent),
+        account.currency,
+        account.created_at,
+        account.kind
+    FROM
+        account
+        JOIN currency
+        ON account.currency = currency.code
+        LEFT OUTER JOIN account_payment
+        ON account.id = account_payment.account_id
+    GROUP BY account.id, currency.code;
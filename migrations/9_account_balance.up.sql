@@ -0,0 +1,14 @@
+-- Account balances are stored, instead of being summed from all of the account's
+-- payments on every lookup. The balance is updated in the same transaction as each payment.
+ALTER TABLE account ADD COLUMN IF NOT EXISTS balance NUMERIC NOT NULL DEFAULT 0;
+
+UPDATE account SET balance = account_balance.balance
+    FROM account_balance
+    WHERE account_balance.id = account.id;
+
+-- User accounts can't be overdrawn. External accounts have a negative balance,
+-- which is the amount of the currency held in the wallet system.
+ALTER TABLE account ADD CONSTRAINT account_balance_check
+    CHECK (balance >= 0 OR kind <> 'user');
+
+DROP VIEW IF EXISTS account_balance;
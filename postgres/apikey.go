@@ -0,0 +1,121 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+var (
+	// errEmptyAPIKeyID is returned when creating an API key without an ID
+	errEmptyAPIKeyID = errors.New("API key ID must not be empty")
+	// errAPIKeyExists is returned when storing an API key with an ID or hash that is already used
+	errAPIKeyExists = errors.New("API key already exists")
+)
+
+type apiKeyRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewAPIKeyRepository creates a wallet.APIKeyRepository that uses postgres for storage
+func NewAPIKeyRepository(db *sqlx.DB, logger log.Logger) wallet.APIKeyRepository {
+	return &apiKeyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// apiKeyColumns are the columns selected for api_key. The hash is not selected.
+const apiKeyColumns = `id, name, scopes, created_at, revoked_at`
+
+type apiKey struct {
+	ID        uuid.UUID      `db:"id"`
+	Name      string         `db:"name"`
+	Scopes    pq.StringArray `db:"scopes"`
+	CreatedAt time.Time      `db:"created_at"`
+	RevokedAt pq.NullTime    `db:"revoked_at"`
+}
+
+func newWalletAPIKey(k apiKey) wallet.APIKey {
+	wk := wallet.APIKey{
+		ID:        k.ID,
+		Name:      k.Name,
+		Scopes:    []string(k.Scopes),
+		CreatedAt: k.CreatedAt.UTC(),
+	}
+	if k.RevokedAt.Valid {
+		revokedAt := k.RevokedAt.Time.UTC()
+		wk.RevokedAt = &revokedAt
+	}
+	return wk
+}
+
+func (r *apiKeyRepository) Store(ctx context.Context, key *wallet.APIKey, hash []byte) error {
+	if uuid.Equal(key.ID, nullUUID) {
+		return errEmptyAPIKeyID
+	}
+
+	q := `insert into api_key (id, name, key_hash, scopes) values ($1, $2, $3, $4) returning ` + apiKeyColumns
+
+	var k apiKey
+	err := queryer(ctx, r.db).QueryRowxContext(ctx, q, key.ID, key.Name, hash, pq.StringArray(key.Scopes)).StructScan(&k)
+	if isUniqueViolation(err, "api_key_pkey") || isUniqueViolation(err, "api_key_key_hash_key") {
+		return errAPIKeyExists
+	}
+	if err != nil {
+		return err
+	}
+
+	*key = newWalletAPIKey(k)
+	return nil
+}
+
+func (r *apiKeyRepository) GetByHash(ctx context.Context, hash []byte) (*wallet.APIKey, error) {
+	var k apiKey
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, `select `+apiKeyColumns+` from api_key where key_hash=$1`, hash).StructScan(&k); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoAPIKey
+		}
+		return nil, err
+	}
+
+	wk := newWalletAPIKey(k)
+	return &wk, nil
+}
+
+func (r *apiKeyRepository) Revoke(ctx context.Context, id uuid.UUID) (*wallet.APIKey, error) {
+	var wk wallet.APIKey
+	err := withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		var k apiKey
+		if err := tx.QueryRowxContext(ctx, `select `+apiKeyColumns+` from api_key where id=$1 for update`, id).StructScan(&k); err != nil {
+			if err == sql.ErrNoRows {
+				return wallet.ErrNoAPIKey
+			}
+			return err
+		}
+		if k.RevokedAt.Valid {
+			return wallet.ErrAPIKeyRevoked
+		}
+
+		q := `update api_key set revoked_at=CURRENT_TIMESTAMP where id=$1 returning ` + apiKeyColumns
+		if err := tx.QueryRowxContext(ctx, q, id).StructScan(&k); err != nil {
+			return err
+		}
+
+		wk = newWalletAPIKey(k)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &wk, nil
+}
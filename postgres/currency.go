@@ -0,0 +1,109 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidExponent is returned when storing a currency with an exponent out of range
+var errInvalidExponent = errors.New("Currency exponent must be between 0 and 4")
+
+// currencyCodeRegexp matches ISO 4217 currency codes
+var currencyCodeRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
+type currencyRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewCurrencyRepository creates a wallet.CurrencyRepository that uses postgres for storage
+func NewCurrencyRepository(db *sqlx.DB, logger log.Logger) wallet.CurrencyRepository {
+	return &currencyRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+type currency struct {
+	Code     string `db:"code"`
+	Exponent int32  `db:"exponent"`
+	Enabled  bool   `db:"enabled"`
+}
+
+func newWalletCurrency(c currency) wallet.Currency {
+	return wallet.Currency{
+		Code:     c.Code,
+		Exponent: c.Exponent,
+		Enabled:  c.Enabled,
+	}
+}
+
+func (r *currencyRepository) Get(ctx context.Context, code string) (*wallet.Currency, error) {
+	row := queryer(ctx, r.db).QueryRowxContext(ctx, `select code, exponent, enabled from currency where code=$1`, code)
+
+	var c currency
+	if err := row.StructScan(&c); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoCurrency
+		}
+		return nil, err
+	}
+
+	wc := newWalletCurrency(c)
+	return &wc, nil
+}
+
+func (r *currencyRepository) Store(ctx context.Context, c *wallet.Currency) error {
+	if !currencyCodeRegexp.MatchString(c.Code) {
+		return errInvalidCurrency
+	}
+	if c.Exponent < 0 || c.Exponent > 4 {
+		return errInvalidExponent
+	}
+
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		// The exponent of an existing currency is never updated, since that would
+		// change the meaning of amounts already stored in the currency.
+		// No row is returned if the stored exponent is different.
+		q := `insert into currency (code, exponent, enabled) values ($1, $2, $3)
+			on conflict (code)
+			do update set enabled=excluded.enabled where currency.exponent=excluded.exponent
+			returning code`
+
+		var code string
+		if err := tx.QueryRowxContext(ctx, q, c.Code, c.Exponent, c.Enabled).Scan(&code); err != nil {
+			if err == sql.ErrNoRows {
+				return wallet.ErrCurrencyExponentChanged
+			}
+			return err
+		}
+
+		return nil
+	})
+}
+
+func (r *currencyRepository) All(ctx context.Context) ([]wallet.Currency, error) {
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, `select code, exponent, enabled from currency order by code`)
+	if err != nil {
+		return nil, err
+	}
+
+	var currencies []wallet.Currency
+	defer rows.Close()
+	for rows.Next() {
+		var c currency
+		if err := rows.StructScan(&c); err != nil {
+			return nil, err
+		}
+		currencies = append(currencies, newWalletCurrency(c))
+	}
+
+	return currencies, rows.Err()
+}
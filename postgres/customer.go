@@ -0,0 +1,107 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errEmptyCustomerID is returned when creating a customer without an ID
+var errEmptyCustomerID = errors.New("Customer ID must not be empty")
+
+type customerRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewCustomerRepository creates a wallet.CustomerRepository that uses postgres for storage
+func NewCustomerRepository(db *sqlx.DB, logger log.Logger) wallet.CustomerRepository {
+	return &customerRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// customerColumns are the columns selected for customer
+const customerColumns = `id, name, created_at`
+
+type customer struct {
+	ID        uuid.UUID `db:"id"`
+	Name      string    `db:"name"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func newWalletCustomer(c customer) wallet.Customer {
+	return wallet.Customer{
+		ID:        c.ID,
+		Name:      c.Name,
+		CreatedAt: c.CreatedAt.UTC(),
+	}
+}
+
+func (r *customerRepository) Store(ctx context.Context, wc *wallet.Customer) error {
+	if uuid.Equal(wc.ID, nullUUID) {
+		return errEmptyCustomerID
+	}
+
+	q := `insert into customer (id, name) values ($1, $2) returning ` + customerColumns
+
+	var c customer
+	err := queryer(ctx, r.db).QueryRowxContext(ctx, q, wc.ID, wc.Name).StructScan(&c)
+	if isUniqueViolation(err, "customer_pkey") {
+		return wallet.ErrCustomerExists
+	}
+	if err != nil {
+		return err
+	}
+
+	*wc = newWalletCustomer(c)
+	return nil
+}
+
+func (r *customerRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Customer, error) {
+	var c customer
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, `select `+customerColumns+` from customer where id=$1`, id).StructScan(&c); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoCustomer
+		}
+		return nil, err
+	}
+
+	wc := newWalletCustomer(c)
+	return &wc, nil
+}
+
+func (r *customerRepository) Balances(ctx context.Context, id uuid.UUID) ([]wallet.CustomerBalance, error) {
+	q := `select currency, sum(balance), count(*) from account_balance
+		where owner_id=$1
+		group by currency
+		order by currency`
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, id)
+	if err != nil {
+		return nil, err
+	}
+
+	balances := []wallet.CustomerBalance{}
+	defer rows.Close()
+	for rows.Next() {
+		b := wallet.CustomerBalance{
+			Balance: new(apd.Decimal),
+		}
+		if err := rows.Scan(&b.Currency, b.Balance, &b.Accounts); err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+
+	return balances, rows.Err()
+}
@@ -0,0 +1,139 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidFeeSchedule is returned when storing a fee schedule with a negative amount,
+// a percent over 100, or a minimum fee that is more than the maximum fee
+var errInvalidFeeSchedule = errors.New("Invalid fee schedule")
+
+type feeRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewFeeRepository creates a wallet.FeeRepository that uses postgres for storage
+func NewFeeRepository(db *sqlx.DB, logger log.Logger) wallet.FeeRepository {
+	return &feeRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// feeScheduleColumns are the columns selected for fee_schedule
+const feeScheduleColumns = `currency, flat, percent, min_fee, max_fee, updated_at`
+
+type feeSchedule struct {
+	Currency  string       `db:"currency"`
+	Flat      *apd.Decimal `db:"flat"`
+	Percent   *apd.Decimal `db:"percent"`
+	Min       *apd.Decimal `db:"min_fee"`
+	Max       *apd.Decimal `db:"max_fee"`
+	UpdatedAt time.Time    `db:"updated_at"`
+}
+
+func newWalletFeeSchedule(f feeSchedule) wallet.FeeSchedule {
+	return wallet.FeeSchedule{
+		Currency:  f.Currency,
+		Flat:      f.Flat,
+		Percent:   f.Percent,
+		Min:       f.Min,
+		Max:       f.Max,
+		UpdatedAt: f.UpdatedAt.UTC(),
+	}
+}
+
+func (r *feeRepository) Schedule(ctx context.Context, currency string) (*wallet.FeeSchedule, error) {
+	q := `select ` + feeScheduleColumns + ` from fee_schedule where currency=$1`
+
+	var f feeSchedule
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, currency).StructScan(&f); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoFeeSchedule
+		}
+		return nil, err
+	}
+
+	wf := newWalletFeeSchedule(f)
+	return &wf, nil
+}
+
+func (r *feeRepository) Store(ctx context.Context, schedule *wallet.FeeSchedule) error {
+	flat := schedule.Flat
+	if flat == nil {
+		flat = apd.New(0, 0)
+	}
+	percent := schedule.Percent
+	if percent == nil {
+		percent = apd.New(0, 0)
+	}
+	if !isValidFeeSchedule(flat, percent, schedule.Min, schedule.Max) {
+		return errInvalidFeeSchedule
+	}
+
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		q := `insert into fee_schedule (currency, flat, percent, min_fee, max_fee) values ($1, $2, $3, $4, $5)
+			on conflict (currency)
+			do update set flat=excluded.flat, percent=excluded.percent, min_fee=excluded.min_fee,
+				max_fee=excluded.max_fee, updated_at=CURRENT_TIMESTAMP
+			returning updated_at`
+
+		var updatedAt time.Time
+		err := tx.QueryRowxContext(ctx, q, schedule.Currency, flat, percent, schedule.Min, schedule.Max).Scan(&updatedAt)
+		if isForeignKeyViolation(err, "fee_schedule_currency_fkey") {
+			return wallet.ErrNoCurrency
+		}
+		if err != nil {
+			return err
+		}
+
+		schedule.Flat = flat
+		schedule.Percent = percent
+		schedule.UpdatedAt = updatedAt.UTC()
+		return nil
+	})
+}
+
+func (r *feeRepository) All(ctx context.Context) ([]wallet.FeeSchedule, error) {
+	q := `select ` + feeScheduleColumns + ` from fee_schedule order by currency`
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []wallet.FeeSchedule
+	defer rows.Close()
+	for rows.Next() {
+		var f feeSchedule
+		if err := rows.StructScan(&f); err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, newWalletFeeSchedule(f))
+	}
+
+	return schedules, rows.Err()
+}
+
+// isValidFeeSchedule returns true if the amounts of a fee schedule are not negative,
+// percent is at most 100, and min is not more than max
+func isValidFeeSchedule(flat, percent, min, max *apd.Decimal) bool {
+	for _, d := range []*apd.Decimal{flat, percent, min, max} {
+		if d != nil && (d.Form != apd.Finite || d.Sign() < 0) {
+			return false
+		}
+	}
+	if percent.Cmp(apd.New(100, 0)) > 0 {
+		return false
+	}
+	return min == nil || max == nil || min.Cmp(max) <= 0
+}
@@ -0,0 +1,161 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+type holdRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewHoldRepository creates a wallet.HoldRepository that uses postgres for storage
+func NewHoldRepository(db *sqlx.DB, logger log.Logger) wallet.HoldRepository {
+	return &holdRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// holdColumns are the columns selected for hold
+const holdColumns = `id, from_account_id, amount, status, expires_at, payment_id, created_at, updated_at`
+
+type hold struct {
+	ID        uuid.UUID     `db:"id"`
+	From      uuid.UUID     `db:"from_account_id"`
+	Amount    *apd.Decimal  `db:"amount"`
+	Status    string        `db:"status"`
+	ExpiresAt time.Time     `db:"expires_at"`
+	PaymentID uuid.NullUUID `db:"payment_id"`
+	CreatedAt time.Time     `db:"created_at"`
+	UpdatedAt time.Time     `db:"updated_at"`
+}
+
+func newWalletHold(h hold) wallet.Hold {
+	wh := wallet.Hold{
+		ID:        h.ID,
+		From:      h.From,
+		Amount:    h.Amount,
+		Status:    h.Status,
+		ExpiresAt: h.ExpiresAt.UTC(),
+		CreatedAt: h.CreatedAt.UTC(),
+		UpdatedAt: h.UpdatedAt.UTC(),
+	}
+	if h.PaymentID.Valid {
+		wh.PaymentID = &h.PaymentID.UUID
+	}
+	return wh
+}
+
+func (r *holdRepository) StoreTx(ctx context.Context, wtx wallet.Tx, h *wallet.Hold) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	q := `insert into hold (id, from_account_id, amount, expires_at)
+		values ($1, $2, $3, $4)
+		returning ` + holdColumns
+
+	var sh hold
+	err = tx.QueryRowxContext(ctx, q, h.ID, h.From, h.Amount, h.ExpiresAt).StructScan(&sh)
+	if isForeignKeyViolation(err, "hold_from_account_id_fkey") {
+		return wallet.ErrNoAccount
+	}
+	if err != nil {
+		return err
+	}
+
+	*h = newWalletHold(sh)
+	return nil
+}
+
+func (r *holdRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Hold, error) {
+	q := `select ` + holdColumns + ` from hold where id=$1`
+
+	var h hold
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, id).StructScan(&h); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoHold
+		}
+		return nil, err
+	}
+
+	wh := newWalletHold(h)
+	return &wh, nil
+}
+
+func (r *holdRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Hold, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `select ` + holdColumns + ` from hold where id=$1 for update`
+
+	var h hold
+	if err := tx.QueryRowxContext(ctx, q, id).StructScan(&h); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoHold
+		}
+		return nil, err
+	}
+
+	wh := newWalletHold(h)
+	return &wh, nil
+}
+
+func (r *holdRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, h *wallet.Hold) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	var paymentID uuid.NullUUID
+	if h.PaymentID != nil {
+		paymentID = uuid.NullUUID{
+			UUID:  *h.PaymentID,
+			Valid: true,
+		}
+	}
+
+	q := `update hold set status=$2, payment_id=$3, updated_at=CURRENT_TIMESTAMP
+		where id=$1
+		returning ` + holdColumns
+
+	var sh hold
+	if err := tx.QueryRowxContext(ctx, q, h.ID, h.Status, paymentID).StructScan(&sh); err != nil {
+		if err == sql.ErrNoRows {
+			return wallet.ErrNoHold
+		}
+		return err
+	}
+
+	*h = newWalletHold(sh)
+	return nil
+}
+
+func (r *holdRepository) Expire(ctx context.Context) (int, error) {
+	// Holds locked by a capture or release are waited for, and are not expired if they are no longer active
+	q := `with expired as (
+			update hold set status=$1, updated_at=CURRENT_TIMESTAMP
+			where status=$2 and expires_at <= CURRENT_TIMESTAMP
+			returning id
+		)
+		select count(*) from expired`
+
+	var n int
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, wallet.HoldExpired, wallet.HoldActive).Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
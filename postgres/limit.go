@@ -0,0 +1,166 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidSpendingLimit is returned when storing a spending limit that is not more than zero
+var errInvalidSpendingLimit = errors.New("Invalid spending limit")
+
+type limitRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewLimitRepository creates a wallet.LimitRepository that uses postgres for storage
+func NewLimitRepository(db *sqlx.DB, logger log.Logger) wallet.LimitRepository {
+	return &limitRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// spendingLimitColumns are the columns selected for spending_limit
+const spendingLimitColumns = `account_id, currency, max_amount, daily_amount, hourly_count, updated_at`
+
+type spendingLimit struct {
+	AccountID   uuid.NullUUID `db:"account_id"`
+	Currency    string        `db:"currency"`
+	MaxAmount   *apd.Decimal  `db:"max_amount"`
+	DailyAmount *apd.Decimal  `db:"daily_amount"`
+	HourlyCount *int          `db:"hourly_count"`
+	UpdatedAt   time.Time     `db:"updated_at"`
+}
+
+func newWalletSpendingLimit(l spendingLimit) wallet.SpendingLimit {
+	wl := wallet.SpendingLimit{
+		Currency:    l.Currency,
+		MaxAmount:   l.MaxAmount,
+		DailyAmount: l.DailyAmount,
+		HourlyCount: l.HourlyCount,
+		UpdatedAt:   l.UpdatedAt.UTC(),
+	}
+	if l.AccountID.Valid {
+		wl.AccountID = &l.AccountID.UUID
+	}
+	return wl
+}
+
+func (r *limitRepository) Limit(ctx context.Context, accountID uuid.UUID, currency string) (*wallet.SpendingLimit, error) {
+	// The account's limit is ordered before the currency's limit
+	q := `select ` + spendingLimitColumns + ` from spending_limit
+		where account_id=$1 or (account_id is null and currency=$2)
+		order by account_id nulls last
+		limit 1`
+
+	var l spendingLimit
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, accountID, currency).StructScan(&l); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoSpendingLimit
+		}
+		return nil, err
+	}
+
+	wl := newWalletSpendingLimit(l)
+	return &wl, nil
+}
+
+func (r *limitRepository) Store(ctx context.Context, limit *wallet.SpendingLimit) error {
+	if !isValidSpendingLimit(limit) {
+		return errInvalidSpendingLimit
+	}
+
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		var l spendingLimit
+		if limit.AccountID == nil {
+			q := `insert into spending_limit (currency, max_amount, daily_amount, hourly_count) values ($1, $2, $3, $4)
+				on conflict (currency) where account_id is null
+				do update set max_amount=excluded.max_amount, daily_amount=excluded.daily_amount,
+					hourly_count=excluded.hourly_count, updated_at=CURRENT_TIMESTAMP
+				returning ` + spendingLimitColumns
+
+			err := tx.QueryRowxContext(ctx, q, limit.Currency, limit.MaxAmount, limit.DailyAmount, limit.HourlyCount).StructScan(&l)
+			if isForeignKeyViolation(err, "spending_limit_currency_fkey") {
+				return wallet.ErrNoCurrency
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			// The limit's currency is the account's currency, and system accounts have no limits
+			q := `insert into spending_limit (account_id, currency, max_amount, daily_amount, hourly_count)
+				select id, currency, $2, $3, $4 from account where id=$1 and kind=$5
+				on conflict (account_id) where account_id is not null
+				do update set max_amount=excluded.max_amount, daily_amount=excluded.daily_amount,
+					hourly_count=excluded.hourly_count, updated_at=CURRENT_TIMESTAMP
+				returning ` + spendingLimitColumns
+
+			err := tx.QueryRowxContext(ctx, q, *limit.AccountID, limit.MaxAmount, limit.DailyAmount, limit.HourlyCount, wallet.AccountKindUser).StructScan(&l)
+			if err == sql.ErrNoRows {
+				return wallet.ErrNoAccount
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		*limit = newWalletSpendingLimit(l)
+		return nil
+	})
+}
+
+func (r *limitRepository) All(ctx context.Context) ([]wallet.SpendingLimit, error) {
+	q := `select ` + spendingLimitColumns + ` from spending_limit order by account_id nulls first, currency`
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var limits []wallet.SpendingLimit
+	defer rows.Close()
+	for rows.Next() {
+		var l spendingLimit
+		if err := rows.StructScan(&l); err != nil {
+			return nil, err
+		}
+		limits = append(limits, newWalletSpendingLimit(l))
+	}
+
+	return limits, rows.Err()
+}
+
+func (r *limitRepository) Spending(ctx context.Context, accountID uuid.UUID, since time.Time) (*wallet.Spending, error) {
+	q := `select coalesce(sum(amount), 0), count(*)
+		from payment
+		where from_account_id=$1
+			and created_at >= $2
+			and reversal_of is null`
+
+	s := wallet.Spending{
+		Amount: new(apd.Decimal),
+	}
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, accountID, since).Scan(s.Amount, &s.Count); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// isValidSpendingLimit returns true if the limits of a spending limit are more than zero
+func isValidSpendingLimit(limit *wallet.SpendingLimit) bool {
+	for _, d := range []*apd.Decimal{limit.MaxAmount, limit.DailyAmount} {
+		if d != nil && (d.Form != apd.Finite || d.Sign() <= 0) {
+			return false
+		}
+	}
+	return limit.HourlyCount == nil || *limit.HourlyCount > 0
+}
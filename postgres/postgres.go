@@ -2,16 +2,23 @@
 package postgres
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/apd"
 	"github.com/go-kit/kit/log"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 	uuid "github.com/satori/go.uuid"
 
 	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
 )
 
 var (
@@ -21,6 +28,12 @@ var (
 	errEmptyPaymentID = errors.New("Payment ID must not be empty")
 	// errInvalidCurrency is returned for unrecognized currency codes
 	errInvalidCurrency = errors.New("Invalid currency code")
+	// errInvalidFee is returned when storing a payment with a fee that is not positive
+	errInvalidFee = errors.New("Fee must be greater than 0")
+	// errNotSystemAccount is returned when looking up a system account of a user account kind
+	errNotSystemAccount = errors.New("Account kind is not a system account kind")
+	// errSystemAccountOwner is returned when creating a system account with an owner
+	errSystemAccountOwner = errors.New("System accounts cannot have an owner")
 
 	nullUUID uuid.UUID
 )
@@ -42,33 +55,81 @@ func (r *accountRepository) Store(ctx context.Context, account *wallet.Account)
 	if uuid.Equal(account.ID, nullUUID) {
 		return errEmptyAccountID
 	}
-	if !wallet.IsValidCurrency(account.Currency) {
-		return errInvalidCurrency
+
+	kind := account.Kind
+	if kind == "" {
+		kind = wallet.AccountKindUser
 	}
 
-	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *sqlx.Tx) error {
-		q := `insert into account (id, currency) values ($1, $2)`
-		_, err := tx.ExecContext(ctx, q, account.ID, account.Currency)
-		return err
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		q := `insert into account (id, currency, kind, owner_id, balance) values ($1, $2, $3, $4, ` + zeroBalance + `)
+			returning balance, status, created_at`
+		var balance apd.Decimal
+		var status string
+		var createdAt time.Time
+		err := tx.QueryRowxContext(ctx, q, account.ID, account.Currency, kind, account.OwnerID).Scan(&balance, &status, &createdAt)
+		if isUniqueViolation(err, "account_pkey") {
+			return wallet.ErrAccountExists
+		}
+		if isForeignKeyViolation(err, "account_currency_fkey") {
+			return wallet.ErrNoCurrency
+		}
+		if isForeignKeyViolation(err, "account_owner_id_fkey") {
+			return wallet.ErrNoCustomer
+		}
+		if isCheckViolation(err, "account_owner_id_check") {
+			return errSystemAccountOwner
+		}
+		if err != nil {
+			return err
+		}
+
+		account.Kind = kind
+		account.Balance = &balance
+		account.Status = status
+		account.CreatedAt = createdAt.UTC()
+		return nil
 	})
 }
 
+// zeroBalance is the initial balance of an account, with the number of decimal places
+// of its currency, which is the second query parameter. An unknown currency is
+// rejected by the account_currency_fkey constraint.
+const zeroBalance = `round(0::numeric, coalesce((select exponent from currency where code = $2), 0))`
+
+// accountColumns are the columns selected for account, and the sum of its active holds
+const accountColumns = `id, balance, currency, kind, status, owner_id, created_at,
+	(select coalesce(sum(amount), 0) from hold where hold.from_account_id = account.id and hold.status = 'active') as held`
+
 type account struct {
-	ID       uuid.UUID    `db:"id"`
-	Balance  *apd.Decimal `db:"balance"`
-	Currency string       `db:"currency"`
+	ID        uuid.UUID     `db:"id"`
+	Balance   *apd.Decimal  `db:"balance"`
+	Held      *apd.Decimal  `db:"held"`
+	Currency  string        `db:"currency"`
+	Kind      string        `db:"kind"`
+	Status    string        `db:"status"`
+	OwnerID   uuid.NullUUID `db:"owner_id"`
+	CreatedAt time.Time     `db:"created_at"`
 }
 
 func newWalletAccount(a account) wallet.Account {
-	return wallet.Account{
-		ID:       a.ID,
-		Balance:  a.Balance,
-		Currency: a.Currency,
+	wa := wallet.Account{
+		ID:        a.ID,
+		Balance:   a.Balance,
+		Held:      a.Held,
+		Currency:  a.Currency,
+		Kind:      a.Kind,
+		Status:    a.Status,
+		CreatedAt: a.CreatedAt.UTC(),
+	}
+	if a.OwnerID.Valid {
+		wa.OwnerID = &a.OwnerID.UUID
 	}
+	return wa
 }
 
-func (r *accountRepository) GetTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID) (*wallet.Account, error) {
-	row := tx.QueryRowxContext(ctx, `select id, balance, currency from account_balance where id=$1`, id)
+func (r *accountRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.Account, error) {
+	row := queryer(ctx, r.db).QueryRowxContext(ctx, `select `+accountColumns+` from account where id=$1`, id)
 
 	var a account
 	if err := row.StructScan(&a); err != nil {
@@ -82,25 +143,180 @@ func (r *accountRepository) GetTx(ctx context.Context, tx *sqlx.Tx, id uuid.UUID
 	return &wa, nil
 }
 
-func (r *accountRepository) All(ctx context.Context) ([]wallet.Account, error) {
-	rows, err := r.db.QueryxContext(ctx, `select id, balance, currency from account_balance order by id`)
+func (r *accountRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Account, error) {
+	tx, err := txFrom(r.db, wtx)
 	if err != nil {
 		return nil, err
 	}
 
+	// Lock the account row, so that concurrent transactions that debit the
+	// account wait until this one completes. The balance is read after the lock
+	// is acquired, so it includes any payments committed while waiting for the lock.
+	row := tx.QueryRowxContext(ctx, `select `+accountColumns+` from account where id=$1 for update`, id)
+
+	var a account
+	if err := row.StructScan(&a); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoAccount
+		}
+		return nil, err
+	}
+
+	wa := newWalletAccount(a)
+	return &wa, nil
+}
+
+func (r *accountRepository) UpdateStatusTx(ctx context.Context, wtx wallet.Tx, wa *wallet.Account) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	q := `update account set status=$2 where id=$1 returning ` + accountColumns
+
+	var a account
+	if err := tx.QueryRowxContext(ctx, q, wa.ID, wa.Status).StructScan(&a); err != nil {
+		if err == sql.ErrNoRows {
+			return wallet.ErrNoAccount
+		}
+		return err
+	}
+
+	*wa = newWalletAccount(a)
+	return nil
+}
+
+func (r *accountRepository) SystemAccountIDTx(ctx context.Context, wtx wallet.Tx, kind, currency string) (uuid.UUID, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	return systemAccountID(ctx, tx, kind, currency)
+}
+
+// systemAccountID returns the ID of the system account of a kind for a currency
+func systemAccountID(ctx context.Context, tx *transaction, kind, currency string) (uuid.UUID, error) {
+	if kind == wallet.AccountKindUser {
+		return uuid.Nil, errNotSystemAccount
+	}
+
+	// The account is created the first time it is needed.
+	// If it already exists, or a concurrent transaction creates it first, nothing is inserted.
+	newID, err := uuid.NewV4()
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	q := `insert into account (id, currency, kind, balance) values ($1, $2, $3, ` + zeroBalance + `)
+		on conflict (kind, currency) where kind <> 'user' do nothing`
+	_, err = tx.ExecContext(ctx, q, newID, currency, kind)
+	if isForeignKeyViolation(err, "account_currency_fkey") {
+		return uuid.Nil, wallet.ErrNoCurrency
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	var id uuid.UUID
+	if err := tx.GetContext(ctx, &id, `select id from account where currency=$1 and kind=$2`, currency, kind); err != nil {
+		return uuid.Nil, err
+	}
+
+	return id, nil
+}
+
+func (r *accountRepository) List(ctx context.Context, page wallet.Page) ([]wallet.Account, string, error) {
+	return r.list(ctx, `select `+accountColumns+` from account where kind = 'user'`, nil, page)
+}
+
+func (r *accountRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, page wallet.Page) ([]wallet.Account, string, error) {
+	return r.list(ctx, `select `+accountColumns+` from account where owner_id = $1`, []interface{}{ownerID}, page)
+}
+
+// list returns a page of the accounts selected by q, which is filtered by args
+func (r *accountRepository) list(ctx context.Context, q string, args []interface{}, page wallet.Page) ([]wallet.Account, string, error) {
+	if page.Cursor != "" {
+		after, err := cursor.DecodeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, after)
+		q += fmt.Sprintf(` and id > $%d`, len(args))
+	}
+	q += fmt.Sprintf(` order by id limit $%d`, len(args)+1)
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
 	var accounts []wallet.Account
 	defer rows.Close()
 	for rows.Next() {
 		var a account
 		if err := rows.StructScan(&a); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		accounts = append(accounts, newWalletAccount(a))
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(accounts) > page.Limit {
+		accounts = accounts[:page.Limit]
+		next = cursor.EncodeID(accounts[len(accounts)-1].ID)
+	}
+
+	return accounts, next, nil
+}
 
-	return accounts, nil
+type balanceDrift struct {
+	AccountID     uuid.UUID    `db:"id"`
+	Balance       *apd.Decimal `db:"balance"`
+	LedgerBalance *apd.Decimal `db:"ledger_balance"`
+}
+
+func (r *accountRepository) BalanceDrifts(ctx context.Context) ([]wallet.BalanceDrift, error) {
+	q := `select id, balance, ledger_balance from (
+		select account.id, account.balance,
+			round(coalesce(sum(posting.amount), 0), currency.exponent) as ledger_balance
+		from account
+		join currency on currency.code = account.currency
+		left join posting on posting.account_id = account.id
+		group by account.id, currency.exponent
+	) as balances
+	where balance <> ledger_balance
+	order by id`
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifts []wallet.BalanceDrift
+	defer rows.Close()
+	for rows.Next() {
+		var d balanceDrift
+		if err := rows.StructScan(&d); err != nil {
+			return nil, err
+		}
+		drifts = append(drifts, wallet.BalanceDrift{
+			AccountID:     d.AccountID,
+			Balance:       d.Balance,
+			LedgerBalance: d.LedgerBalance,
+		})
+	}
+	return drifts, rows.Err()
 }
 
+// paymentColumns are the columns selected for payment
+const paymentColumns = `id, from_account_id, to_account_id, amount, to_amount, rate, idempotency_key, reversal_of, refunded_amount, fee, api_key_id, created_at`
+
 type paymentRepository struct {
 	db     *sqlx.DB
 	logger log.Logger
@@ -114,31 +330,330 @@ func NewPaymentRepository(db *sqlx.DB, logger log.Logger) wallet.PaymentReposito
 	}
 }
 
-func (r *paymentRepository) WithTx(ctx context.Context, f func(ctx context.Context, tx *sqlx.Tx) error) error {
-	return withTx(ctx, r.logger, r.db, f)
-}
-
 func (r *paymentRepository) Store(ctx context.Context, p *wallet.Payment) error {
-	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *sqlx.Tx) error {
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
 		return r.StoreTx(ctx, tx, p)
 	})
 }
 
-func (r *paymentRepository) StoreTx(ctx context.Context, tx *sqlx.Tx, p *wallet.Payment) error {
+func (r *paymentRepository) StoreTx(ctx context.Context, wtx wallet.Tx, p *wallet.Payment) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
 	if p.ID == uuid.Nil {
 		return errEmptyPaymentID
 	}
 
-	q := `insert into payment (id, from_account_id, to_account_id, amount) values ($1, $2, $3, $4)`
-	_, err := tx.ExecContext(ctx, q, p.ID, p.From, p.To, p.Amount)
+	idempotencyKey := sql.NullString{
+		String: p.IdempotencyKey,
+		Valid:  p.IdempotencyKey != "",
+	}
+
+	// Amounts are stored with the number of decimal places of their currency,
+	// so that e.g. 5 USD is stored as "5.00". The amount is in the "From" account's
+	// currency, or in the "To" account's currency for credits from outside the system.
+	// No row is inserted if the "To" account does not exist.
+	q := `insert into payment (id, from_account_id, to_account_id, amount, to_amount, rate, idempotency_key, reversal_of, fee, api_key_id)
+		select $1::uuid, $2::uuid, $3::uuid,
+			round($4::numeric, from_currency.exponent), round($5::numeric, to_currency.exponent),
+			$6::numeric, $7::text, $8::uuid, round($9::numeric, from_currency.exponent), $10::uuid
+		from account to_account
+		join currency to_currency on to_currency.code = to_account.currency
+		left join account from_account on from_account.id = $2::uuid
+		join currency from_currency on from_currency.code = coalesce(from_account.currency, to_account.currency)
+		where to_account.id = $3::uuid
+		returning amount, to_amount, fee, created_at`
+	var stored struct {
+		Amount    *apd.Decimal `db:"amount"`
+		ToAmount  *apd.Decimal `db:"to_amount"`
+		Fee       *apd.Decimal `db:"fee"`
+		CreatedAt time.Time    `db:"created_at"`
+	}
+	err = tx.QueryRowxContext(ctx, q, p.ID, p.From, p.To, p.Amount, p.ToAmount, p.Rate, idempotencyKey, p.ReversalOf, p.Fee, p.APIKeyID).StructScan(&stored)
+	if err == sql.ErrNoRows {
+		return wallet.ErrNoAccount
+	}
+	if isUniqueViolation(err, "payment_idempotency_key_idx") {
+		// A concurrent transaction stored a payment with the same key
+		return wallet.ErrIdempotencyKeyReused
+	}
+	if isCheckViolation(err, "payment_fee_check") {
+		return errInvalidFee
+	}
+	if isForeignKeyViolation(err, "payment_api_key_id_fkey") {
+		return wallet.ErrNoAPIKey
+	}
+	if err != nil {
+		return err
+	}
+
+	if p.ReversalOf != nil {
+		// The refund's amount is in the currency credited by the original payment.
+		// The stored amount is used, since it is rounded to the currency's decimal places.
+		q := `update payment set refunded_amount = payment.refunded_amount + refund.amount
+			from payment refund
+			where refund.id = $1 and payment.id = refund.reversal_of`
+		res, err := tx.ExecContext(ctx, q, p.ID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return wallet.ErrNoPayment
+		}
+	}
+
+	p.Amount = stored.Amount
+	p.ToAmount = stored.ToAmount
+	p.Fee = stored.Fee
+
+	postings := p.Postings
+	if postings == nil {
+		postings, err = r.paymentPostings(ctx, tx, p)
+		if err != nil {
+			return err
+		}
+	}
+
+	postings, err = storeJournalEntry(ctx, tx, p.ID, postings)
+	if err != nil {
+		return err
+	}
+
+	p.Postings = postings
+	p.RefundedAmount = apd.New(0, 0)
+	p.CreatedAt = stored.CreatedAt.UTC()
+	return nil
+}
+
+// paymentPostings returns the default postings of a stored payment, see wallet.PaymentPostings.
+// A payment without a "From" account is posted as a debit of the external account of its currency.
+func (r *paymentRepository) paymentPostings(ctx context.Context, tx *transaction, p *wallet.Payment) ([]wallet.Posting, error) {
+	var to, from account
+	if err := tx.GetContext(ctx, &to, `select `+accountColumns+` from account where id=$1`, p.To); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoAccount
+		}
+		return nil, err
+	}
+
+	if p.From != nil {
+		if err := tx.GetContext(ctx, &from, `select `+accountColumns+` from account where id=$1`, *p.From); err != nil {
+			if err == sql.ErrNoRows {
+				return nil, wallet.ErrNoAccount
+			}
+			return nil, err
+		}
+	} else {
+		externalID, err := systemAccountID(ctx, tx, wallet.AccountKindExternal, to.Currency)
+		if err != nil {
+			return nil, err
+		}
+		from = account{ID: externalID, Currency: to.Currency}
+	}
+
+	return wallet.PaymentPostings(*p, newWalletAccount(from), newWalletAccount(to), func(kind, currency string) (uuid.UUID, error) {
+		return systemAccountID(ctx, tx, kind, currency)
+	})
+}
+
+// storeJournalEntry stores the journal entry of a payment and adds the amount of each posting
+// to the balance of its account. It returns the postings with their stored amounts, which are
+// rounded to the decimal places of their currencies.
+func storeJournalEntry(ctx context.Context, tx *transaction, paymentID uuid.UUID, postings []wallet.Posting) ([]wallet.Posting, error) {
+	if err := wallet.ValidatePostings(postings); err != nil {
+		return nil, err
+	}
+
+	entryID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.ExecContext(ctx, `insert into journal_entry (id, payment_id) values ($1, $2)`, entryID, paymentID); err != nil {
+		return nil, err
+	}
+
+	// The posting_balanced constraint trigger also checks that the postings
+	// are balanced, when the transaction is committed
+	q := `insert into posting (journal_entry_id, account_id, amount, currency)
+		select $1, $2, round($3::numeric, currency.exponent), currency.code
+		from currency where code = $4
+		returning amount`
+	stored := make([]wallet.Posting, len(postings))
+	balances := make(map[uuid.UUID]*apd.Decimal)
+	for i, p := range postings {
+		var amount apd.Decimal
+		err := tx.QueryRowxContext(ctx, q, entryID, p.AccountID, p.Amount, p.Currency).Scan(&amount)
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoCurrency
+		}
+		if isForeignKeyViolation(err, "posting_account_id_currency_fkey") {
+			return nil, wallet.ErrNoAccount
+		}
+		if isCheckViolation(err, "posting_amount_check") {
+			return nil, wallet.ErrUnbalancedEntry
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		stored[i] = wallet.Posting{
+			AccountID: p.AccountID,
+			Amount:    &amount,
+			Currency:  p.Currency,
+		}
+
+		balance, ok := balances[p.AccountID]
+		if !ok {
+			balance = apd.New(0, 0)
+			balances[p.AccountID] = balance
+		}
+		if _, err := apd.BaseContext.WithPrecision(64).Add(balance, balance, &amount); err != nil {
+			return nil, err
+		}
+	}
+
+	// Rounding could unbalance the postings
+	if err := wallet.ValidatePostings(stored); err != nil {
+		return nil, err
+	}
+
+	// Balances are updated in the same transaction as the payment, in order of account ID,
+	// so that concurrent transactions lock the rows of system accounts in the same order
+	ids := make([]uuid.UUID, 0, len(balances))
+	for id := range balances {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i].Bytes(), ids[j].Bytes()) < 0
+	})
+	for _, id := range ids {
+		if err := updateBalance(ctx, tx, id, balances[id]); err != nil {
+			return nil, err
+		}
+	}
+
+	return stored, nil
+}
+
+// updateBalance adds amount to the balance of an account
+func updateBalance(ctx context.Context, tx *transaction, id uuid.UUID, amount *apd.Decimal) error {
+	_, err := tx.ExecContext(ctx, `update account set balance = balance + $2 where id = $1`, id, amount)
+	if isCheckViolation(err, "account_balance_check") {
+		return wallet.ErrNegativeBalance
+	}
 	return err
 }
 
+func (r *paymentRepository) JournalEntry(ctx context.Context, paymentID uuid.UUID) (*wallet.JournalEntry, error) {
+	q := queryer(ctx, r.db)
+
+	var entry struct {
+		ID        uuid.UUID `db:"id"`
+		PaymentID uuid.UUID `db:"payment_id"`
+		CreatedAt time.Time `db:"created_at"`
+	}
+	row := q.QueryRowxContext(ctx, `select id, payment_id, created_at from journal_entry where payment_id=$1`, paymentID)
+	if err := row.StructScan(&entry); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoPayment
+		}
+		return nil, err
+	}
+
+	rows, err := q.QueryxContext(ctx, `select account_id, amount, currency from posting where journal_entry_id=$1 order by id`, entry.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var postings []wallet.Posting
+	defer rows.Close()
+	for rows.Next() {
+		var p struct {
+			AccountID uuid.UUID    `db:"account_id"`
+			Amount    *apd.Decimal `db:"amount"`
+			Currency  string       `db:"currency"`
+		}
+		if err := rows.StructScan(&p); err != nil {
+			return nil, err
+		}
+		postings = append(postings, wallet.Posting{
+			AccountID: p.AccountID,
+			Amount:    p.Amount,
+			Currency:  p.Currency,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return &wallet.JournalEntry{
+		ID:        entry.ID,
+		PaymentID: entry.PaymentID,
+		Postings:  postings,
+		CreatedAt: entry.CreatedAt.UTC(),
+	}, nil
+}
+
+func (r *paymentRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.Payment, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `select ` + paymentColumns + ` from payment where id=$1 for update`
+	row := tx.QueryRowxContext(ctx, q, id)
+
+	var p payment
+	if err := row.StructScan(&p); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoPayment
+		}
+		return nil, err
+	}
+
+	wp := newWalletPayment(p)
+	return &wp, nil
+}
+
+func (r *paymentRepository) GetByIdempotencyKeyTx(ctx context.Context, wtx wallet.Tx, key string) (*wallet.Payment, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `select ` + paymentColumns + ` from payment where idempotency_key=$1`
+	row := tx.QueryRowxContext(ctx, q, key)
+
+	var p payment
+	if err := row.StructScan(&p); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoPayment
+		}
+		return nil, err
+	}
+
+	wp := newWalletPayment(p)
+	return &wp, nil
+}
+
 type payment struct {
-	ID     uuid.UUID     `db:"id"`
-	To     uuid.UUID     `db:"to_account_id"`
-	From   uuid.NullUUID `db:"from_account_id"`
-	Amount *apd.Decimal  `db:"amount"`
+	ID             uuid.UUID      `db:"id"`
+	To             uuid.UUID      `db:"to_account_id"`
+	From           uuid.NullUUID  `db:"from_account_id"`
+	Amount         *apd.Decimal   `db:"amount"`
+	ToAmount       *apd.Decimal   `db:"to_amount"`
+	Rate           *apd.Decimal   `db:"rate"`
+	IdempotencyKey sql.NullString `db:"idempotency_key"`
+	ReversalOf     uuid.NullUUID  `db:"reversal_of"`
+	RefundedAmount *apd.Decimal   `db:"refunded_amount"`
+	Fee            *apd.Decimal   `db:"fee"`
+	APIKeyID       uuid.NullUUID  `db:"api_key_id"`
+	CreatedAt      time.Time      `db:"created_at"`
 }
 
 func newWalletPayment(p payment) wallet.Payment {
@@ -146,21 +661,63 @@ func newWalletPayment(p payment) wallet.Payment {
 		panic("amount is unexpectedly nil")
 	}
 	pp := wallet.Payment{
-		ID:     p.ID,
-		To:     p.To,
-		Amount: p.Amount,
+		ID:             p.ID,
+		To:             p.To,
+		Amount:         p.Amount,
+		ToAmount:       p.ToAmount,
+		Rate:           p.Rate,
+		IdempotencyKey: p.IdempotencyKey.String,
+		RefundedAmount: p.RefundedAmount,
+		Fee:            p.Fee,
+		CreatedAt:      p.CreatedAt.UTC(),
 	}
 	if p.From.Valid {
 		fromID := p.From.UUID
 		pp.From = &fromID
 	}
+	if p.ReversalOf.Valid {
+		reversalOf := p.ReversalOf.UUID
+		pp.ReversalOf = &reversalOf
+	}
+	if p.APIKeyID.Valid {
+		apiKeyID := p.APIKeyID.UUID
+		pp.APIKeyID = &apiKeyID
+	}
 	return pp
 }
 
-func (r *paymentRepository) All(ctx context.Context) ([]wallet.Payment, error) {
-	rows, err := r.db.QueryxContext(ctx, `select id, from_account_id, to_account_id, amount from payment order by id`)
+func (r *paymentRepository) List(ctx context.Context, page wallet.Page) ([]wallet.Payment, string, error) {
+	return r.list(ctx, nil, nil, page)
+}
+
+func (r *paymentRepository) ListByOwner(ctx context.Context, ownerID uuid.UUID, page wallet.Page) ([]wallet.Payment, string, error) {
+	owned := `(from_account_id in (select id from account where owner_id = $1)
+		or to_account_id in (select id from account where owner_id = $1))`
+	return r.list(ctx, []string{owned}, []interface{}{ownerID}, page)
+}
+
+// list returns a page of the payments that match all of conds, which are filtered by args
+func (r *paymentRepository) list(ctx context.Context, conds []string, args []interface{}, page wallet.Page) ([]wallet.Payment, string, error) {
+	if page.Cursor != "" {
+		createdAt, id, err := cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, createdAt, id)
+		conds = append(conds, fmt.Sprintf(`(created_at, id) > ($%d, $%d)`, len(args)-1, len(args)))
+	}
+
+	q := `select ` + paymentColumns + ` from payment`
+	if len(conds) > 0 {
+		q += ` where ` + strings.Join(conds, ` and `)
+	}
+	q += fmt.Sprintf(` order by created_at, id limit $%d`, len(args)+1)
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
 	var payments []wallet.Payment
@@ -168,41 +725,128 @@ func (r *paymentRepository) All(ctx context.Context) ([]wallet.Payment, error) {
 	for rows.Next() {
 		var p payment
 		if err := rows.StructScan(&p); err != nil {
-			return nil, err
+			return nil, "", err
 		}
 		payments = append(payments, newWalletPayment(p))
 	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
 
-	return payments, nil
+	var next string
+	if len(payments) > page.Limit {
+		payments = payments[:page.Limit]
+		last := payments[len(payments)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.ID)
+	}
+
+	return payments, next, nil
 }
 
-func withTx(ctx context.Context, logger log.Logger, db *sqlx.DB, f func(ctx context.Context, tx *sqlx.Tx) error) (err error) {
-	tx, err := db.BeginTxx(ctx, &sql.TxOptions{
-		Isolation: sql.LevelDefault,
-		ReadOnly:  false,
-	})
+type accountPayment struct {
+	AccountID    uuid.UUID     `db:"account_id"`
+	PaymentID    uuid.UUID     `db:"payment_id"`
+	Counterparty uuid.NullUUID `db:"counterparty_id"`
+	Amount       *apd.Decimal  `db:"amount"`
+	Fee          *apd.Decimal  `db:"fee"`
+	Balance      *apd.Decimal  `db:"balance"`
+	CreatedAt    time.Time     `db:"created_at"`
+}
+
+func newWalletAccountPayment(p accountPayment) wallet.AccountPayment {
+	ap := wallet.AccountPayment{
+		AccountID: p.AccountID,
+		PaymentID: p.PaymentID,
+		Amount:    p.Amount,
+		Fee:       p.Fee,
+		Balance:   p.Balance,
+		CreatedAt: p.CreatedAt.UTC(),
+	}
+	if p.Counterparty.Valid {
+		counterparty := p.Counterparty.UUID
+		ap.Counterparty = &counterparty
+	}
+	return ap
+}
+
+func (r *paymentRepository) ListAccountPayments(ctx context.Context, accountID uuid.UUID, tr wallet.TimeRange, page wallet.Page) ([]wallet.AccountPayment, string, error) {
+	// The running balance is computed over all of the account's payments,
+	// before the time range and cursor filters are applied
+	q := `select account_id, payment_id, counterparty_id, amount, fee, balance, created_at from (
+		select account_id, payment_id, counterparty_id, amount, fee, created_at,
+			sum(amount) over (order by created_at, payment_id) as balance
+		from account_payment
+		where account_id = $1
+	) as entries`
+	args := []interface{}{accountID}
+
+	var conds []string
+	if !tr.Since.IsZero() {
+		args = append(args, tr.Since)
+		conds = append(conds, fmt.Sprintf(`created_at >= $%d`, len(args)))
+	}
+	if !tr.Until.IsZero() {
+		args = append(args, tr.Until)
+		conds = append(conds, fmt.Sprintf(`created_at < $%d`, len(args)))
+	}
+	if page.Cursor != "" {
+		createdAt, paymentID, err := cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		args = append(args, createdAt, paymentID)
+		conds = append(conds, fmt.Sprintf(`(created_at, payment_id) < ($%d, $%d)`, len(args)-1, len(args)))
+	}
+	if len(conds) != 0 {
+		q += ` where ` + strings.Join(conds, ` and `)
+	}
+
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+	q += fmt.Sprintf(` order by created_at desc, payment_id desc limit $%d`, len(args))
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
 	if err != nil {
-		return err
+		return nil, "", err
 	}
 
-	defer func() {
-		if r := recover(); r != nil {
-			// Rollback on panic
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.With(logger, "err", rollbackErr).Log("msg", "Postgres tx rollback failed")
-			}
-			panic(r)
-		} else if err != nil {
-			// Rollback if the function failed
-			if rollbackErr := tx.Rollback(); rollbackErr != nil {
-				log.With(logger, "err", rollbackErr).Log("msg", "Postgres tx rollback failed")
-			}
-			logger.Log("err", err)
-		} else {
-			// Commit, and return any error from that
-			err = tx.Commit()
+	var payments []wallet.AccountPayment
+	defer rows.Close()
+	for rows.Next() {
+		var p accountPayment
+		if err := rows.StructScan(&p); err != nil {
+			return nil, "", err
 		}
-	}()
+		payments = append(payments, newWalletAccountPayment(p))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(payments) > page.Limit {
+		payments = payments[:page.Limit]
+		last := payments[len(payments)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.PaymentID)
+	}
+
+	return payments, next, nil
+}
+
+// isUniqueViolation returns true if err is a violation of the named unique constraint
+func isUniqueViolation(err error, constraint string) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == constraint
+}
+
+// isCheckViolation returns true if err is a violation of the named check constraint
+func isCheckViolation(err error, constraint string) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Name() == "check_violation" && pqErr.Constraint == constraint
+}
 
-	return f(ctx, tx)
+// isForeignKeyViolation returns true if err is a violation of the named foreign key constraint
+func isForeignKeyViolation(err error, constraint string) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code.Name() == "foreign_key_violation" && pqErr.Constraint == constraint
 }
@@ -0,0 +1,149 @@
+package postgres
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/golang-migrate/migrate/v4"
+	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/wallettest"
+
+	_ "github.com/lib/pq" // load postgres driver
+)
+
+var (
+	databaseName = "wallet_test"
+	databaseURL  = fmt.Sprintf("postgresql://postgres@localhost:54320/%s?sslmode=disable", databaseName)
+)
+
+// setupDB sets up a clean test database and returns a teardown function
+func setupDB(t *testing.T) (*sqlx.DB, func()) {
+	m, err := migrate.New("file://../migrations", databaseURL)
+	require.NoError(t, err)
+
+	err = m.Down()
+	if err != migrate.ErrNoChange {
+		require.NoError(t, err)
+	}
+
+	err = m.Up()
+	require.NoError(t, err)
+
+	db, err := sqlx.Connect("postgres", databaseURL)
+	require.NoError(t, err)
+
+	return db, func() {
+		err := m.Down()
+		require.NoError(t, err)
+
+		err = db.Close()
+		require.NoError(t, err)
+	}
+}
+
+func TestRepositories(t *testing.T) {
+	wallettest.Run(t, func(t *testing.T) (wallettest.Repositories, func()) {
+		db, teardown := setupDB(t)
+		logger := log.NewNopLogger()
+		return wallettest.Repositories{
+			UnitOfWork:         NewUnitOfWork(db, logger),
+			Accounts:           NewAccountRepository(db, logger),
+			Payments:           NewPaymentRepository(db, logger),
+			Rates:              NewRateRepository(db, logger),
+			Currencies:         NewCurrencyRepository(db, logger),
+			Fees:               NewFeeRepository(db, logger),
+			ScheduledTransfers: NewScheduledTransferRepository(db, logger),
+			StandingOrders:     NewStandingOrderRepository(db, logger),
+			Holds:              NewHoldRepository(db, logger),
+			Limits:             NewLimitRepository(db, logger),
+			Customers:          NewCustomerRepository(db, logger),
+			APIKeys:            NewAPIKeyRepository(db, logger),
+		}, teardown
+	})
+}
+
+func TestBalanceDrifts(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	accounts := NewAccountRepository(db, logger)
+	payments := NewPaymentRepository(db, logger)
+
+	id := uuid.Must(uuid.NewV4())
+	err := accounts.Store(ctx, &wallet.Account{
+		ID:       id,
+		Currency: wallet.USD,
+	})
+	require.NoError(t, err)
+
+	err = payments.Store(ctx, &wallet.Payment{
+		ID:     uuid.Must(uuid.NewV4()),
+		To:     id,
+		Amount: apd.New(100, 0),
+	})
+	require.NoError(t, err)
+
+	drifts, err := accounts.BalanceDrifts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+
+	// Change the stored balance without a payment
+	_, err = db.ExecContext(ctx, `update account set balance = 90 where id = $1`, id)
+	require.NoError(t, err)
+
+	drifts, err = accounts.BalanceDrifts(ctx)
+	require.NoError(t, err)
+	require.Len(t, drifts, 1)
+	require.Equal(t, id, drifts[0].AccountID)
+	require.Equal(t, "90", drifts[0].Balance.String())
+	require.Equal(t, "100.00", drifts[0].LedgerBalance.String())
+}
+
+func TestJournalEntryBalanced(t *testing.T) {
+	db, teardown := setupDB(t)
+	defer teardown()
+
+	ctx := context.Background()
+	logger := log.NewNopLogger()
+	accounts := NewAccountRepository(db, logger)
+	payments := NewPaymentRepository(db, logger)
+
+	id := uuid.Must(uuid.NewV4())
+	err := accounts.Store(ctx, &wallet.Account{
+		ID:       id,
+		Currency: wallet.USD,
+	})
+	require.NoError(t, err)
+
+	p := &wallet.Payment{
+		ID:     uuid.Must(uuid.NewV4()),
+		To:     id,
+		Amount: apd.New(100, 0),
+	}
+	err = payments.Store(ctx, p)
+	require.NoError(t, err)
+
+	// A posting that unbalances the journal entry is rejected when the transaction commits
+	tx, err := db.BeginTxx(ctx, nil)
+	require.NoError(t, err)
+	_, err = tx.ExecContext(ctx, `insert into posting (journal_entry_id, account_id, amount, currency)
+		select id, $1, 1, 'USD' from journal_entry where payment_id = $2`, id, p.ID)
+	require.NoError(t, err)
+	err = tx.Commit()
+	require.True(t, isCheckViolation(err, "posting_balanced"), "%v", err)
+
+	entry, err := payments.JournalEntry(ctx, p.ID)
+	require.NoError(t, err)
+	require.Len(t, entry.Postings, 2)
+}
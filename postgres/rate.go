@@ -0,0 +1,106 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errInvalidRate is returned when storing an exchange rate that is not positive
+var errInvalidRate = errors.New("Exchange rate must be greater than 0")
+
+type rateRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewRateRepository creates a wallet.RateRepository that uses postgres for storage
+func NewRateRepository(db *sqlx.DB, logger log.Logger) wallet.RateRepository {
+	return &rateRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+type exchangeRate struct {
+	From      string       `db:"from_currency"`
+	To        string       `db:"to_currency"`
+	Rate      *apd.Decimal `db:"rate"`
+	UpdatedAt time.Time    `db:"updated_at"`
+}
+
+func newWalletExchangeRate(r exchangeRate) wallet.ExchangeRate {
+	return wallet.ExchangeRate{
+		From:      r.From,
+		To:        r.To,
+		Rate:      r.Rate,
+		UpdatedAt: r.UpdatedAt.UTC(),
+	}
+}
+
+func (r *rateRepository) Rate(ctx context.Context, from, to string) (*apd.Decimal, error) {
+	q := `select rate from exchange_rate where from_currency=$1 and to_currency=$2`
+
+	var rate apd.Decimal
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, from, to).Scan(&rate); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoExchangeRate
+		}
+		return nil, err
+	}
+
+	return &rate, nil
+}
+
+func (r *rateRepository) Store(ctx context.Context, rate *wallet.ExchangeRate) error {
+	if rate.Rate == nil || rate.Rate.Sign() != 1 {
+		return errInvalidRate
+	}
+
+	return withTx(ctx, r.logger, r.db, func(ctx context.Context, tx *transaction) error {
+		q := `insert into exchange_rate (from_currency, to_currency, rate) values ($1, $2, $3)
+			on conflict (from_currency, to_currency)
+			do update set rate=excluded.rate, updated_at=CURRENT_TIMESTAMP
+			returning updated_at`
+
+		var updatedAt time.Time
+		err := tx.QueryRowxContext(ctx, q, rate.From, rate.To, rate.Rate).Scan(&updatedAt)
+		if isForeignKeyViolation(err, "exchange_rate_from_currency_fkey") ||
+			isForeignKeyViolation(err, "exchange_rate_to_currency_fkey") {
+			return wallet.ErrNoCurrency
+		}
+		if err != nil {
+			return err
+		}
+
+		rate.UpdatedAt = updatedAt.UTC()
+		return nil
+	})
+}
+
+func (r *rateRepository) All(ctx context.Context) ([]wallet.ExchangeRate, error) {
+	q := `select from_currency, to_currency, rate, updated_at from exchange_rate order by from_currency, to_currency`
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	var rates []wallet.ExchangeRate
+	defer rows.Close()
+	for rows.Next() {
+		var er exchangeRate
+		if err := rows.StructScan(&er); err != nil {
+			return nil, err
+		}
+		rates = append(rates, newWalletExchangeRate(er))
+	}
+
+	return rates, rows.Err()
+}
@@ -0,0 +1,214 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
+)
+
+type scheduledTransferRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewScheduledTransferRepository creates a wallet.ScheduledTransferRepository that uses postgres for storage
+func NewScheduledTransferRepository(db *sqlx.DB, logger log.Logger) wallet.ScheduledTransferRepository {
+	return &scheduledTransferRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// scheduledTransferColumns are the columns selected for scheduled_transfer
+const scheduledTransferColumns = `id, to_account_id, from_account_id, amount, execute_at, status, payment_id, error, api_key_id, created_at, updated_at`
+
+type scheduledTransfer struct {
+	ID        uuid.UUID      `db:"id"`
+	To        uuid.UUID      `db:"to_account_id"`
+	From      uuid.UUID      `db:"from_account_id"`
+	Amount    *apd.Decimal   `db:"amount"`
+	ExecuteAt time.Time      `db:"execute_at"`
+	Status    string         `db:"status"`
+	PaymentID uuid.NullUUID  `db:"payment_id"`
+	Error     sql.NullString `db:"error"`
+	APIKeyID  uuid.NullUUID  `db:"api_key_id"`
+	CreatedAt time.Time      `db:"created_at"`
+	UpdatedAt time.Time      `db:"updated_at"`
+}
+
+func newWalletScheduledTransfer(s scheduledTransfer) wallet.ScheduledTransfer {
+	ws := wallet.ScheduledTransfer{
+		ID:        s.ID,
+		To:        s.To,
+		From:      s.From,
+		Amount:    s.Amount,
+		ExecuteAt: s.ExecuteAt.UTC(),
+		Status:    s.Status,
+		Error:     s.Error.String,
+		CreatedAt: s.CreatedAt.UTC(),
+		UpdatedAt: s.UpdatedAt.UTC(),
+	}
+	if s.PaymentID.Valid {
+		ws.PaymentID = &s.PaymentID.UUID
+	}
+	if s.APIKeyID.Valid {
+		ws.APIKeyID = &s.APIKeyID.UUID
+	}
+	return ws
+}
+
+func (r *scheduledTransferRepository) Store(ctx context.Context, transfer *wallet.ScheduledTransfer) error {
+	q := `insert into scheduled_transfer (id, to_account_id, from_account_id, amount, execute_at, api_key_id)
+		values ($1, $2, $3, $4, $5, $6)
+		returning ` + scheduledTransferColumns
+
+	var s scheduledTransfer
+	err := queryer(ctx, r.db).QueryRowxContext(ctx, q, transfer.ID, transfer.To, transfer.From, transfer.Amount, transfer.ExecuteAt, transfer.APIKeyID).StructScan(&s)
+	if isForeignKeyViolation(err, "scheduled_transfer_to_account_id_fkey") ||
+		isForeignKeyViolation(err, "scheduled_transfer_from_account_id_fkey") {
+		return wallet.ErrNoAccount
+	}
+	if isForeignKeyViolation(err, "scheduled_transfer_api_key_id_fkey") {
+		return wallet.ErrNoAPIKey
+	}
+	if err != nil {
+		return err
+	}
+
+	*transfer = newWalletScheduledTransfer(s)
+	return nil
+}
+
+func (r *scheduledTransferRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.ScheduledTransfer, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `select ` + scheduledTransferColumns + ` from scheduled_transfer where id=$1 for update`
+
+	var s scheduledTransfer
+	if err := tx.QueryRowxContext(ctx, q, id).StructScan(&s); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoScheduledTransfer
+		}
+		return nil, err
+	}
+
+	ws := newWalletScheduledTransfer(s)
+	return &ws, nil
+}
+
+func (r *scheduledTransferRepository) NextDueTx(ctx context.Context, wtx wallet.Tx) (*wallet.ScheduledTransfer, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows locked by another worker are skipped instead of waited for
+	q := `select ` + scheduledTransferColumns + ` from scheduled_transfer
+		where status=$1 and execute_at <= CURRENT_TIMESTAMP
+		order by execute_at, id
+		limit 1
+		for update skip locked`
+
+	var s scheduledTransfer
+	if err := tx.QueryRowxContext(ctx, q, wallet.ScheduledTransferPending).StructScan(&s); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoScheduledTransfer
+		}
+		return nil, err
+	}
+
+	ws := newWalletScheduledTransfer(s)
+	return &ws, nil
+}
+
+func (r *scheduledTransferRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, transfer *wallet.ScheduledTransfer) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	var paymentID uuid.NullUUID
+	if transfer.PaymentID != nil {
+		paymentID = uuid.NullUUID{
+			UUID:  *transfer.PaymentID,
+			Valid: true,
+		}
+	}
+	var errMsg sql.NullString
+	if transfer.Error != "" {
+		errMsg = sql.NullString{
+			String: transfer.Error,
+			Valid:  true,
+		}
+	}
+
+	q := `update scheduled_transfer set status=$2, payment_id=$3, error=$4, updated_at=CURRENT_TIMESTAMP
+		where id=$1
+		returning updated_at`
+
+	var updatedAt time.Time
+	if err := tx.QueryRowxContext(ctx, q, transfer.ID, transfer.Status, paymentID, errMsg).Scan(&updatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return wallet.ErrNoScheduledTransfer
+		}
+		return err
+	}
+
+	transfer.UpdatedAt = updatedAt.UTC()
+	return nil
+}
+
+func (r *scheduledTransferRepository) List(ctx context.Context, status string, page wallet.Page) ([]wallet.ScheduledTransfer, string, error) {
+	q := `select ` + scheduledTransferColumns + ` from scheduled_transfer where ($1::text = '' or status = $1)`
+	args := []interface{}{status}
+	if page.Cursor != "" {
+		executeAt, id, err := cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		q += ` and (execute_at, id) > ($2, $3)`
+		args = append(args, executeAt, id)
+	}
+	q += fmt.Sprintf(` order by execute_at, id limit $%d`, len(args)+1)
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var transfers []wallet.ScheduledTransfer
+	defer rows.Close()
+	for rows.Next() {
+		var s scheduledTransfer
+		if err := rows.StructScan(&s); err != nil {
+			return nil, "", err
+		}
+		transfers = append(transfers, newWalletScheduledTransfer(s))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(transfers) > page.Limit {
+		transfers = transfers[:page.Limit]
+		last := transfers[len(transfers)-1]
+		next = cursor.EncodeTimeID(last.ExecuteAt, last.ID)
+	}
+
+	return transfers, next, nil
+}
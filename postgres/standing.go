@@ -0,0 +1,357 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+	"github.com/xsleonard/gokit-example/cursor"
+)
+
+type standingOrderRepository struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewStandingOrderRepository creates a wallet.StandingOrderRepository that uses postgres for storage
+func NewStandingOrderRepository(db *sqlx.DB, logger log.Logger) wallet.StandingOrderRepository {
+	return &standingOrderRepository{
+		db:     db,
+		logger: logger,
+	}
+}
+
+// standingOrderColumns are the columns selected for standing_order
+const standingOrderColumns = `id, to_account_id, from_account_id, amount, frequency, interval_count, start_at, end_at,
+	status, occurrences, failed_attempts, next_run_at, api_key_id, created_at, updated_at`
+
+// occurrenceColumns are the columns selected for standing_order_occurrence
+const occurrenceColumns = `id, standing_order_id, scheduled_at, status, attempts, payment_id, error, created_at, updated_at`
+
+type standingOrder struct {
+	ID             uuid.UUID     `db:"id"`
+	To             uuid.UUID     `db:"to_account_id"`
+	From           uuid.UUID     `db:"from_account_id"`
+	Amount         *apd.Decimal  `db:"amount"`
+	Frequency      string        `db:"frequency"`
+	Interval       int           `db:"interval_count"`
+	StartAt        time.Time     `db:"start_at"`
+	EndAt          pq.NullTime   `db:"end_at"`
+	Status         string        `db:"status"`
+	Occurrences    int           `db:"occurrences"`
+	FailedAttempts int           `db:"failed_attempts"`
+	NextRunAt      time.Time     `db:"next_run_at"`
+	APIKeyID       uuid.NullUUID `db:"api_key_id"`
+	CreatedAt      time.Time     `db:"created_at"`
+	UpdatedAt      time.Time     `db:"updated_at"`
+}
+
+func newWalletStandingOrder(o standingOrder) wallet.StandingOrder {
+	wo := wallet.StandingOrder{
+		ID:             o.ID,
+		To:             o.To,
+		From:           o.From,
+		Amount:         o.Amount,
+		Frequency:      o.Frequency,
+		Interval:       o.Interval,
+		StartAt:        o.StartAt.UTC(),
+		Status:         o.Status,
+		Occurrences:    o.Occurrences,
+		FailedAttempts: o.FailedAttempts,
+		NextRunAt:      o.NextRunAt.UTC(),
+		CreatedAt:      o.CreatedAt.UTC(),
+		UpdatedAt:      o.UpdatedAt.UTC(),
+	}
+	if o.EndAt.Valid {
+		endAt := o.EndAt.Time.UTC()
+		wo.EndAt = &endAt
+	}
+	if o.APIKeyID.Valid {
+		wo.APIKeyID = &o.APIKeyID.UUID
+	}
+	return wo
+}
+
+type occurrence struct {
+	ID              uuid.UUID      `db:"id"`
+	StandingOrderID uuid.UUID      `db:"standing_order_id"`
+	ScheduledAt     time.Time      `db:"scheduled_at"`
+	Status          string         `db:"status"`
+	Attempts        int            `db:"attempts"`
+	PaymentID       uuid.NullUUID  `db:"payment_id"`
+	Error           sql.NullString `db:"error"`
+	CreatedAt       time.Time      `db:"created_at"`
+	UpdatedAt       time.Time      `db:"updated_at"`
+}
+
+func newWalletOccurrence(o occurrence) wallet.StandingOrderOccurrence {
+	wo := wallet.StandingOrderOccurrence{
+		ID:              o.ID,
+		StandingOrderID: o.StandingOrderID,
+		ScheduledAt:     o.ScheduledAt.UTC(),
+		Status:          o.Status,
+		Attempts:        o.Attempts,
+		Error:           o.Error.String,
+		CreatedAt:       o.CreatedAt.UTC(),
+		UpdatedAt:       o.UpdatedAt.UTC(),
+	}
+	if o.PaymentID.Valid {
+		wo.PaymentID = &o.PaymentID.UUID
+	}
+	return wo
+}
+
+// nullTime converts an optional time to a nullable column value
+func nullTime(t *time.Time) pq.NullTime {
+	if t == nil {
+		return pq.NullTime{}
+	}
+	return pq.NullTime{
+		Time:  *t,
+		Valid: true,
+	}
+}
+
+func (r *standingOrderRepository) Store(ctx context.Context, order *wallet.StandingOrder) error {
+	q := `insert into standing_order (id, to_account_id, from_account_id, amount, frequency, interval_count, start_at, end_at, next_run_at, api_key_id)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		returning ` + standingOrderColumns
+
+	var o standingOrder
+	err := queryer(ctx, r.db).QueryRowxContext(ctx, q, order.ID, order.To, order.From, order.Amount, order.Frequency,
+		order.Interval, order.StartAt, nullTime(order.EndAt), order.NextRunAt, order.APIKeyID).StructScan(&o)
+	if isForeignKeyViolation(err, "standing_order_to_account_id_fkey") ||
+		isForeignKeyViolation(err, "standing_order_from_account_id_fkey") {
+		return wallet.ErrNoAccount
+	}
+	if isForeignKeyViolation(err, "standing_order_api_key_id_fkey") {
+		return wallet.ErrNoAPIKey
+	}
+	if err != nil {
+		return err
+	}
+
+	*order = newWalletStandingOrder(o)
+	return nil
+}
+
+func (r *standingOrderRepository) Get(ctx context.Context, id uuid.UUID) (*wallet.StandingOrder, error) {
+	q := `select ` + standingOrderColumns + ` from standing_order where id=$1`
+
+	var o standingOrder
+	if err := queryer(ctx, r.db).QueryRowxContext(ctx, q, id).StructScan(&o); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoStandingOrder
+		}
+		return nil, err
+	}
+
+	wo := newWalletStandingOrder(o)
+	return &wo, nil
+}
+
+func (r *standingOrderRepository) GetTx(ctx context.Context, wtx wallet.Tx, id uuid.UUID) (*wallet.StandingOrder, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	q := `select ` + standingOrderColumns + ` from standing_order where id=$1 for update`
+
+	var o standingOrder
+	if err := tx.QueryRowxContext(ctx, q, id).StructScan(&o); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoStandingOrder
+		}
+		return nil, err
+	}
+
+	wo := newWalletStandingOrder(o)
+	return &wo, nil
+}
+
+func (r *standingOrderRepository) NextDueTx(ctx context.Context, wtx wallet.Tx) (*wallet.StandingOrder, error) {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rows locked by another worker are skipped instead of waited for
+	q := `select ` + standingOrderColumns + ` from standing_order
+		where status=$1 and next_run_at <= CURRENT_TIMESTAMP
+		order by next_run_at, id
+		limit 1
+		for update skip locked`
+
+	var o standingOrder
+	if err := tx.QueryRowxContext(ctx, q, wallet.StandingOrderActive).StructScan(&o); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, wallet.ErrNoStandingOrder
+		}
+		return nil, err
+	}
+
+	wo := newWalletStandingOrder(o)
+	return &wo, nil
+}
+
+func (r *standingOrderRepository) UpdateTx(ctx context.Context, wtx wallet.Tx, order *wallet.StandingOrder) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	q := `update standing_order set amount=$2, end_at=$3, status=$4, occurrences=$5, failed_attempts=$6, next_run_at=$7,
+			updated_at=CURRENT_TIMESTAMP
+		where id=$1
+		returning ` + standingOrderColumns
+
+	var o standingOrder
+	if err := tx.QueryRowxContext(ctx, q, order.ID, order.Amount, nullTime(order.EndAt), order.Status,
+		order.Occurrences, order.FailedAttempts, order.NextRunAt).StructScan(&o); err != nil {
+		if err == sql.ErrNoRows {
+			return wallet.ErrNoStandingOrder
+		}
+		return err
+	}
+
+	*order = newWalletStandingOrder(o)
+	return nil
+}
+
+func (r *standingOrderRepository) List(ctx context.Context, page wallet.Page) ([]wallet.StandingOrder, string, error) {
+	q := `select ` + standingOrderColumns + ` from standing_order`
+	var args []interface{}
+	if page.Cursor != "" {
+		createdAt, id, err := cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		q += ` where (created_at, id) > ($1, $2) order by created_at, id limit $3`
+		args = append(args, createdAt, id)
+	} else {
+		q += ` order by created_at, id limit $1`
+	}
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var orders []wallet.StandingOrder
+	defer rows.Close()
+	for rows.Next() {
+		var o standingOrder
+		if err := rows.StructScan(&o); err != nil {
+			return nil, "", err
+		}
+		orders = append(orders, newWalletStandingOrder(o))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(orders) > page.Limit {
+		orders = orders[:page.Limit]
+		last := orders[len(orders)-1]
+		next = cursor.EncodeTimeID(last.CreatedAt, last.ID)
+	}
+
+	return orders, next, nil
+}
+
+func (r *standingOrderRepository) StoreOccurrenceTx(ctx context.Context, wtx wallet.Tx, o *wallet.StandingOrderOccurrence) error {
+	tx, err := txFrom(r.db, wtx)
+	if err != nil {
+		return err
+	}
+
+	var paymentID uuid.NullUUID
+	if o.PaymentID != nil {
+		paymentID = uuid.NullUUID{
+			UUID:  *o.PaymentID,
+			Valid: true,
+		}
+	}
+	var errMsg sql.NullString
+	if o.Error != "" {
+		errMsg = sql.NullString{
+			String: o.Error,
+			Valid:  true,
+		}
+	}
+
+	// A retried occurrence keeps the ID and creation time of its first attempt
+	q := `insert into standing_order_occurrence (id, standing_order_id, scheduled_at, status, attempts, payment_id, error)
+		values ($1, $2, $3, $4, $5, $6, $7)
+		on conflict (standing_order_id, scheduled_at) do update
+		set status=excluded.status, attempts=excluded.attempts, payment_id=excluded.payment_id, error=excluded.error,
+			updated_at=CURRENT_TIMESTAMP
+		returning ` + occurrenceColumns
+
+	var so occurrence
+	err = tx.QueryRowxContext(ctx, q, o.ID, o.StandingOrderID, o.ScheduledAt, o.Status, o.Attempts, paymentID, errMsg).StructScan(&so)
+	if isForeignKeyViolation(err, "standing_order_occurrence_standing_order_id_fkey") {
+		return wallet.ErrNoStandingOrder
+	}
+	if err != nil {
+		return err
+	}
+
+	*o = newWalletOccurrence(so)
+	return nil
+}
+
+func (r *standingOrderRepository) Occurrences(ctx context.Context, orderID uuid.UUID, page wallet.Page) ([]wallet.StandingOrderOccurrence, string, error) {
+	q := `select ` + occurrenceColumns + ` from standing_order_occurrence where standing_order_id=$1`
+	args := []interface{}{orderID}
+	if page.Cursor != "" {
+		scheduledAt, id, err := cursor.DecodeTimeID(page.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		q += ` and (scheduled_at, id) > ($2, $3) order by scheduled_at, id limit $4`
+		args = append(args, scheduledAt, id)
+	} else {
+		q += ` order by scheduled_at, id limit $2`
+	}
+	// Fetch one more than the limit to know if there is a next page
+	args = append(args, page.Limit+1)
+
+	rows, err := queryer(ctx, r.db).QueryxContext(ctx, q, args...)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var occurrences []wallet.StandingOrderOccurrence
+	defer rows.Close()
+	for rows.Next() {
+		var o occurrence
+		if err := rows.StructScan(&o); err != nil {
+			return nil, "", err
+		}
+		occurrences = append(occurrences, newWalletOccurrence(o))
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	var next string
+	if len(occurrences) > page.Limit {
+		occurrences = occurrences[:page.Limit]
+		last := occurrences[len(occurrences)-1]
+		next = cursor.EncodeTimeID(last.ScheduledAt, last.ID)
+	}
+
+	return occurrences, next, nil
+}
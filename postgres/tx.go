@@ -0,0 +1,144 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/go-kit/kit/log"
+	"github.com/jmoiron/sqlx"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// errForeignTx is returned when a wallet.Tx was not created by this package for the same database
+var errForeignTx = errors.New("Transaction was not created by the postgres repositories")
+
+// transaction is the postgres implementation of wallet.Tx
+type transaction struct {
+	*sqlx.Tx
+	db *sqlx.DB
+	// savepoints is the number of savepoints created in the transaction, which names them
+	savepoints int
+}
+
+type unitOfWork struct {
+	db     *sqlx.DB
+	logger log.Logger
+}
+
+// NewUnitOfWork creates a wallet.UnitOfWork that runs postgres transactions.
+// Repositories created with the same db use its transactions.
+func NewUnitOfWork(db *sqlx.DB, logger log.Logger) wallet.UnitOfWork {
+	return &unitOfWork{
+		db:     db,
+		logger: logger,
+	}
+}
+
+func (u *unitOfWork) Do(ctx context.Context, f func(ctx context.Context, tx wallet.Tx) error) error {
+	return withTx(ctx, u.logger, u.db, func(ctx context.Context, tx *transaction) error {
+		return f(ctx, tx)
+	})
+}
+
+// txFrom returns the postgres transaction of a wallet.Tx created for db
+func txFrom(db *sqlx.DB, wtx wallet.Tx) (*transaction, error) {
+	tx, ok := wtx.(*transaction)
+	if !ok || tx.db != db {
+		return nil, errForeignTx
+	}
+	return tx, nil
+}
+
+// contextTx returns the transaction of ctx if it was created for db, otherwise nil
+func contextTx(ctx context.Context, db *sqlx.DB) *transaction {
+	tx, err := txFrom(db, wallet.TxFromContext(ctx))
+	if err != nil {
+		return nil
+	}
+	return tx
+}
+
+// queryer returns the transaction of ctx if it was created for db, otherwise db,
+// so that reads inside a transaction see its writes
+func queryer(ctx context.Context, db *sqlx.DB) sqlx.QueryerContext {
+	if tx := contextTx(ctx, db); tx != nil {
+		return tx
+	}
+	return db
+}
+
+// withTx calls f in a transaction, which is also added to the context passed to f.
+// If ctx already has a transaction for db, f is called in a savepoint of it instead.
+func withTx(ctx context.Context, logger log.Logger, db *sqlx.DB, f func(ctx context.Context, tx *transaction) error) (err error) {
+	if tx := contextTx(ctx, db); tx != nil {
+		return tx.withSavepoint(ctx, logger, f)
+	}
+
+	sqlTx, err := db.BeginTxx(ctx, &sql.TxOptions{
+		Isolation: sql.LevelDefault,
+		ReadOnly:  false,
+	})
+	if err != nil {
+		return err
+	}
+
+	tx := &transaction{
+		Tx: sqlTx,
+		db: db,
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			// Rollback on panic
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.With(logger, "err", rollbackErr).Log("msg", "Postgres tx rollback failed")
+			}
+			panic(r)
+		} else if err != nil {
+			// Rollback if the function failed
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				log.With(logger, "err", rollbackErr).Log("msg", "Postgres tx rollback failed")
+			}
+			logger.Log("err", err)
+		} else {
+			// Commit, and return any error from that
+			err = tx.Commit()
+		}
+	}()
+
+	return f(wallet.ContextWithTx(ctx, tx), tx)
+}
+
+// withSavepoint calls f in a savepoint of the transaction. If f fails, only
+// the changes made since the savepoint are rolled back, and the transaction can still be used.
+func (tx *transaction) withSavepoint(ctx context.Context, logger log.Logger, f func(ctx context.Context, tx *transaction) error) (err error) {
+	tx.savepoints++
+	name := fmt.Sprintf("savepoint_%d", tx.savepoints)
+
+	if _, err := tx.ExecContext(ctx, "savepoint "+name); err != nil {
+		return err
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			// Rollback on panic
+			if _, rollbackErr := tx.ExecContext(ctx, "rollback to savepoint "+name); rollbackErr != nil {
+				log.With(logger, "err", rollbackErr).Log("msg", "Postgres savepoint rollback failed")
+			}
+			panic(r)
+		} else if err != nil {
+			// Rollback if the function failed
+			if _, rollbackErr := tx.ExecContext(ctx, "rollback to savepoint "+name); rollbackErr != nil {
+				log.With(logger, "err", rollbackErr).Log("msg", "Postgres savepoint rollback failed")
+			}
+		} else {
+			// Release, and return any error from that
+			_, err = tx.ExecContext(ctx, "release savepoint "+name)
+		}
+	}()
+
+	return f(ctx, tx)
+}
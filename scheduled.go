@@ -0,0 +1,69 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Statuses of a scheduled transfer
+const (
+	// ScheduledTransferPending is a scheduled transfer that has not been made yet
+	ScheduledTransferPending = "pending"
+	// ScheduledTransferExecuted is a scheduled transfer that was made
+	ScheduledTransferExecuted = "executed"
+	// ScheduledTransferFailed is a scheduled transfer that could not be made when it was due
+	ScheduledTransferFailed = "failed"
+	// ScheduledTransferCanceled is a scheduled transfer that was canceled before it was due
+	ScheduledTransferCanceled = "canceled"
+)
+
+var (
+	// ErrNoScheduledTransfer is returned if a scheduled transfer does not exist
+	ErrNoScheduledTransfer = errors.New("Scheduled transfer does not exist")
+	// ErrScheduledTransferNotPending is returned when canceling a scheduled transfer that is not pending
+	ErrScheduledTransferNotPending = errors.New("Scheduled transfer is not pending")
+)
+
+// ScheduledTransfer is a transfer from one account to another that is made at a later time
+type ScheduledTransfer struct {
+	ID     uuid.UUID
+	To     uuid.UUID
+	From   uuid.UUID
+	Amount *apd.Decimal
+	// ExecuteAt is the time after which the transfer is made
+	ExecuteAt time.Time
+	Status    string
+	// PaymentID is the payment made by the transfer, if it was executed
+	PaymentID *uuid.UUID
+	// Error is the reason the transfer failed, if it failed
+	Error string
+	// APIKeyID is the ID of the API key that authenticated the request that scheduled the transfer,
+	// which is recorded on the payment made by the transfer, or nil if it was not scheduled by an
+	// authenticated request
+	APIKeyID  *uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// ScheduledTransferRepository is the storage interface for scheduled transfers
+type ScheduledTransferRepository interface {
+	// Store creates a pending scheduled transfer.
+	// ErrNoAccount is returned if either account does not exist, and ErrNoAPIKey if its API key does not exist.
+	Store(ctx context.Context, transfer *ScheduledTransfer) error
+	// GetTx returns a scheduled transfer and locks it until the transaction completes
+	GetTx(ctx context.Context, tx Tx, id uuid.UUID) (*ScheduledTransfer, error)
+	// NextDueTx returns the pending scheduled transfer with the earliest ExecuteAt that is
+	// not after the current time, and locks it until the transaction completes.
+	// Transfers locked by other transactions are skipped, so that concurrent workers
+	// don't wait for each other. ErrNoScheduledTransfer is returned if no transfer is due.
+	NextDueTx(ctx context.Context, tx Tx) (*ScheduledTransfer, error)
+	// UpdateTx updates the status, payment ID and error of a scheduled transfer
+	UpdateTx(ctx context.Context, tx Tx, transfer *ScheduledTransfer) error
+	// List returns a page of scheduled transfers with a status, or with any status if status
+	// is empty, ordered by ExecuteAt, and the cursor for the next page
+	List(ctx context.Context, status string, page Page) ([]ScheduledTransfer, string, error)
+}
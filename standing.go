@@ -0,0 +1,137 @@
+package wallet
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Frequencies of a standing order
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+)
+
+// Statuses of a standing order
+const (
+	// StandingOrderActive is a standing order that has more occurrences to make
+	StandingOrderActive = "active"
+	// StandingOrderCompleted is a standing order whose last occurrence before its end has been made
+	StandingOrderCompleted = "completed"
+	// StandingOrderCanceled is a standing order that was canceled
+	StandingOrderCanceled = "canceled"
+)
+
+// Statuses of an occurrence of a standing order
+const (
+	// OccurrenceExecuted is an occurrence whose transfer was made
+	OccurrenceExecuted = "executed"
+	// OccurrenceRetrying is an occurrence whose transfer failed, and will be tried again
+	OccurrenceRetrying = "retrying"
+	// OccurrenceFailed is an occurrence whose transfer failed on every attempt
+	OccurrenceFailed = "failed"
+)
+
+var (
+	// ErrNoStandingOrder is returned if a standing order does not exist
+	ErrNoStandingOrder = errors.New("Standing order does not exist")
+	// ErrStandingOrderNotActive is returned when changing a standing order that is completed or canceled
+	ErrStandingOrderNotActive = errors.New("Standing order is not active")
+)
+
+// StandingOrder is a transfer from one account to another that recurs every Interval days,
+// weeks or months, depending on Frequency, starting at StartAt
+type StandingOrder struct {
+	ID        uuid.UUID
+	To        uuid.UUID
+	From      uuid.UUID
+	Amount    *apd.Decimal
+	Frequency string
+	Interval  int
+	StartAt   time.Time
+	// EndAt is the time after which there are no more occurrences, or nil if the order doesn't end
+	EndAt  *time.Time
+	Status string
+	// Occurrences is the number of occurrences that have been executed or have failed
+	Occurrences int
+	// FailedAttempts is the number of failed attempts of the next occurrence
+	FailedAttempts int
+	// NextRunAt is the time of the next occurrence, or of the next attempt of
+	// the next occurrence if it has failed
+	NextRunAt time.Time
+	// APIKeyID is the ID of the API key that authenticated the request that created the order,
+	// which is recorded on the payments made by its occurrences, or nil if it was not created by
+	// an authenticated request
+	APIKeyID  *uuid.UUID
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// OccurrenceAt returns the time of the nth occurrence of the order, counting from 0.
+// Monthly occurrences are on the day of the month of StartAt, or on the last day of
+// the month if it is shorter.
+func (o StandingOrder) OccurrenceAt(n int) time.Time {
+	switch o.Frequency {
+	case FrequencyDaily:
+		return o.StartAt.AddDate(0, 0, n*o.Interval)
+	case FrequencyWeekly:
+		return o.StartAt.AddDate(0, 0, 7*n*o.Interval)
+	case FrequencyMonthly:
+		year, month, day := o.StartAt.Date()
+		hour, min, sec := o.StartAt.Clock()
+		month += time.Month(n * o.Interval)
+		// The day after the last day of the month is day 1 of the next month
+		if last := time.Date(year, month+1, 0, 0, 0, 0, 0, o.StartAt.Location()).Day(); day > last {
+			day = last
+		}
+		return time.Date(year, month, day, hour, min, sec, o.StartAt.Nanosecond(), o.StartAt.Location())
+	default:
+		panic("invalid standing order frequency " + o.Frequency)
+	}
+}
+
+// StandingOrderOccurrence is an occurrence of a standing order, which is recorded when it is attempted
+type StandingOrderOccurrence struct {
+	ID              uuid.UUID
+	StandingOrderID uuid.UUID
+	// ScheduledAt is the time of the occurrence, see StandingOrder.OccurrenceAt
+	ScheduledAt time.Time
+	Status      string
+	// Attempts is the number of times the transfer has been tried
+	Attempts int
+	// PaymentID is the payment made by the occurrence, if it was executed
+	PaymentID *uuid.UUID
+	// Error is the reason the last attempt failed, if it wasn't executed
+	Error     string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// StandingOrderRepository is the storage interface for standing orders and their occurrences
+type StandingOrderRepository interface {
+	// Store creates an active standing order.
+	// ErrNoAccount is returned if either account does not exist, and ErrNoAPIKey if its API key does not exist.
+	Store(ctx context.Context, order *StandingOrder) error
+	// Get returns a standing order. ErrNoStandingOrder is returned if it does not exist.
+	Get(ctx context.Context, id uuid.UUID) (*StandingOrder, error)
+	// GetTx returns a standing order and locks it until the transaction completes
+	GetTx(ctx context.Context, tx Tx, id uuid.UUID) (*StandingOrder, error)
+	// NextDueTx returns the active standing order with the earliest NextRunAt that is
+	// not after the current time, and locks it until the transaction completes.
+	// Orders locked by other transactions are skipped. ErrNoStandingOrder is returned if no order is due.
+	NextDueTx(ctx context.Context, tx Tx) (*StandingOrder, error)
+	// UpdateTx updates the amount, end, status, occurrences, failed attempts and next run time of a standing order
+	UpdateTx(ctx context.Context, tx Tx, order *StandingOrder) error
+	// List returns a page of standing orders ordered by creation time, and the cursor for the next page
+	List(ctx context.Context, page Page) ([]StandingOrder, string, error)
+	// StoreOccurrenceTx creates an occurrence, or updates the occurrence of the
+	// same standing order with the same ScheduledAt
+	StoreOccurrenceTx(ctx context.Context, tx Tx, occurrence *StandingOrderOccurrence) error
+	// Occurrences returns a page of the occurrences of a standing order ordered by ScheduledAt,
+	// and the cursor for the next page
+	Occurrences(ctx context.Context, orderID uuid.UUID, page Page) ([]StandingOrderOccurrence, string, error)
+}
@@ -0,0 +1,171 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/endpoint"
+	uuid "github.com/satori/go.uuid"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+const (
+	// apiKeyHeader is the HTTP header that carries the API key token of a request
+	apiKeyHeader = "X-Api-Key"
+	// bearerPrefix is the prefix of the Authorization header of a request that carries a bearer token
+	bearerPrefix = "Bearer "
+)
+
+var (
+	// errAPIKeyRequired is returned if a request has no API key
+	errAPIKeyRequired = fmt.Errorf("API key is required in the %s header", apiKeyHeader)
+	// errInvalidAPIKey is returned if a request's API key does not exist or was revoked
+	errInvalidAPIKey = errors.New("Invalid API key")
+	// errBearerTokenRequired is returned if a request has no bearer token
+	errBearerTokenRequired = errors.New("Bearer token is required in the Authorization header")
+	// errAPIKeyEndpoint is returned if a request authenticated with a bearer token is made to an endpoint
+	// that is only available to API keys
+	errAPIKeyEndpoint = errors.New("Endpoint requires an API key")
+)
+
+// errMissingScope is returned if a request's API key does not have the scope that the endpoint requires
+type errMissingScope struct {
+	Scope string
+}
+
+func (e errMissingScope) Error() string {
+	return fmt.Sprintf("API key does not have the %q scope", e.Scope)
+}
+
+type apiKeyTokenContextKey struct{}
+
+// apiKeyTokenToContext moves the API key token of a request from its header into its context,
+// where it is read by the middleware created by NewAPIKeyMiddleware
+func apiKeyTokenToContext(ctx context.Context, r *http.Request) context.Context {
+	token := r.Header.Get(apiKeyHeader)
+	if token == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, apiKeyTokenContextKey{}, token)
+}
+
+// NewAPIKeyMiddleware creates a middleware that authenticates requests with the API keys of keys,
+// and requires the key to have scope. The ID of the key is added to the context of the endpoint,
+// see wallet.ContextWithAPIKeyID, and is recorded on the payments that the request creates.
+func NewAPIKeyMiddleware(keys wallet.APIKeyRepository, scope string) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, _ := ctx.Value(apiKeyTokenContextKey{}).(string)
+			if token == "" {
+				return nil, errAPIKeyRequired
+			}
+
+			key, err := keys.GetByHash(ctx, wallet.HashAPIKeyToken(token))
+			switch err {
+			case nil:
+			case wallet.ErrNoAPIKey:
+				return nil, errInvalidAPIKey
+			default:
+				return nil, err
+			}
+
+			if key.RevokedAt != nil {
+				return nil, errInvalidAPIKey
+			}
+			if !key.HasScope(scope) {
+				return nil, errMissingScope{
+					Scope: scope,
+				}
+			}
+
+			return next(wallet.ContextWithAPIKeyID(ctx, key.ID), request)
+		}
+	}
+}
+
+type bearerTokenContextKey struct{}
+
+// bearerTokenToContext moves the bearer token of a request from its Authorization header into its context,
+// where it is read by the middleware created by NewJWTMiddleware
+func bearerTokenToContext(ctx context.Context, r *http.Request) context.Context {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, bearerPrefix) {
+		return ctx
+	}
+	return context.WithValue(ctx, bearerTokenContextKey{}, strings.TrimPrefix(auth, bearerPrefix))
+}
+
+type subjectContextKey struct{}
+
+// subjectFromContext returns the customer that a request's bearer token was issued to,
+// and false if the request was not authenticated with a bearer token
+func subjectFromContext(ctx context.Context) (uuid.UUID, bool) {
+	id, ok := ctx.Value(subjectContextKey{}).(uuid.UUID)
+	return id, ok
+}
+
+// NewJWTMiddleware creates a middleware that authenticates requests with bearer tokens verified by v.
+// The subject of a token is the ID of a customer, and endpoints only allow the customer to use their
+// own accounts.
+func NewJWTMiddleware(v *JWTVerifier) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			token, _ := ctx.Value(bearerTokenContextKey{}).(string)
+			if token == "" {
+				return nil, errBearerTokenRequired
+			}
+
+			claims, err := v.Verify(token)
+			if err != nil {
+				return nil, err
+			}
+
+			subject, err := uuid.FromString(claims.Subject)
+			if err != nil {
+				return nil, errInvalidToken{Reason: "subject is not a customer ID"}
+			}
+
+			return next(context.WithValue(ctx, subjectContextKey{}, subject), request)
+		}
+	}
+}
+
+// newAuthMiddleware creates a middleware that authenticates requests that have a bearer token
+// with the JWT middleware of v, and other requests with the API key middleware of keys and scope.
+// If users is false, the endpoint is only available to API keys. Either keys or v may be nil,
+// in which case its type of credential is not accepted.
+func newAuthMiddleware(keys wallet.APIKeyRepository, v *JWTVerifier, scope string, users bool) endpoint.Middleware {
+	return func(next endpoint.Endpoint) endpoint.Endpoint {
+		var apiKeyNext, bearerNext endpoint.Endpoint
+		if keys != nil {
+			apiKeyNext = NewAPIKeyMiddleware(keys, scope)(next)
+		}
+		if v != nil {
+			userNext := next
+			if !users {
+				userNext = func(context.Context, interface{}) (interface{}, error) {
+					return nil, errAPIKeyEndpoint
+				}
+			}
+			bearerNext = NewJWTMiddleware(v)(userNext)
+		}
+
+		return func(ctx context.Context, request interface{}) (interface{}, error) {
+			if _, ok := ctx.Value(bearerTokenContextKey{}).(string); ok {
+				if v == nil {
+					return nil, errInvalidToken{Reason: "bearer tokens are not accepted"}
+				}
+				return bearerNext(ctx, request)
+			}
+
+			if keys == nil {
+				return nil, errBearerTokenRequired
+			}
+			return apiKeyNext(ctx, request)
+		}
+	}
+}
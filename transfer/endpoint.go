@@ -4,7 +4,9 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
+	"github.com/cockroachdb/apd"
 	"github.com/go-kit/kit/endpoint"
 	uuid "github.com/satori/go.uuid"
 
@@ -12,21 +14,53 @@ import (
 	"github.com/xsleonard/gokit-example/decimal"
 )
 
+// formatTime formats a time as an RFC 3339 string in UTC
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
 // Payment is a JSON-representable form of wallet.Payment
 type Payment struct {
-	ID     string `json:"id"`
-	To     string `json:"to"`
-	From   string `json:"from,omitempty"`
-	Amount string `json:"amount"`
+	ID             string `json:"id"`
+	To             string `json:"to"`
+	From           string `json:"from,omitempty"`
+	Amount         string `json:"amount"`
+	ToAmount       string `json:"to_amount,omitempty"`
+	Rate           string `json:"rate,omitempty"`
+	ReversalOf     string `json:"reversal_of,omitempty"`
+	RefundedAmount string `json:"refunded_amount,omitempty"`
+	Fee            string `json:"fee,omitempty"`
+	APIKeyID       string `json:"api_key_id,omitempty"`
+	CreatedAt      string `json:"created_at"`
 }
 
 func newPayment(p wallet.Payment) Payment {
-	return Payment{
-		ID:     p.ID.String(),
-		To:     p.To.String(),
-		From:   p.FromUUIDString(),
-		Amount: p.Amount.Text('f'),
+	pp := Payment{
+		ID:        p.ID.String(),
+		To:        p.To.String(),
+		From:      p.FromUUIDString(),
+		Amount:    p.Amount.Text('f'),
+		CreatedAt: formatTime(p.CreatedAt),
+	}
+	if p.ToAmount != nil {
+		pp.ToAmount = p.ToAmount.Text('f')
+	}
+	if p.Rate != nil {
+		pp.Rate = p.Rate.Text('f')
+	}
+	if p.ReversalOf != nil {
+		pp.ReversalOf = p.ReversalOf.String()
+	}
+	if p.RefundedAmount != nil && p.RefundedAmount.Sign() != 0 {
+		pp.RefundedAmount = p.RefundedAmount.Text('f')
 	}
+	if p.Fee != nil {
+		pp.Fee = p.Fee.Text('f')
+	}
+	if p.APIKeyID != nil {
+		pp.APIKeyID = p.APIKeyID.String()
+	}
+	return pp
 }
 
 func newPayments(payments []wallet.Payment) []Payment {
@@ -45,25 +79,416 @@ func newPayments(payments []wallet.Payment) []Payment {
 type Account struct {
 	ID       string `json:"id"`
 	Currency string `json:"currency"`
-	Balance  string `json:"balance"`
+	// Balance is the ledger balance, which includes held amounts
+	Balance string `json:"balance"`
+	// AvailableBalance is the balance less the amount of active holds
+	AvailableBalance string `json:"available_balance"`
+	Status           string `json:"status"`
+	OwnerID          string `json:"owner_id,omitempty"`
+	CreatedAt        string `json:"created_at"`
 }
 
-func newAccount(a wallet.Account) Account {
-	return Account{
-		ID:       a.ID.String(),
-		Currency: a.Currency,
-		Balance:  a.Balance.Text('f'),
+func newAccount(a wallet.Account) (Account, error) {
+	available, err := a.Available()
+	if err != nil {
+		return Account{}, err
+	}
+
+	aa := Account{
+		ID:               a.ID.String(),
+		Currency:         a.Currency,
+		Balance:          a.Balance.Text('f'),
+		AvailableBalance: available.Text('f'),
+		Status:           a.Status,
+		CreatedAt:        formatTime(a.CreatedAt),
+	}
+	if a.OwnerID != nil {
+		aa.OwnerID = a.OwnerID.String()
 	}
+	return aa, nil
 }
 
-func newAccounts(accounts []wallet.Account) []Account {
+func newAccounts(accounts []wallet.Account) ([]Account, error) {
 	if len(accounts) == 0 {
-		return nil
+		return nil, nil
 	}
 
 	out := make([]Account, len(accounts))
 	for i, a := range accounts {
-		out[i] = newAccount(a)
+		var err error
+		if out[i], err = newAccount(a); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// AccountPayment is a JSON-representable form of wallet.AccountPayment
+type AccountPayment struct {
+	ID           string `json:"id"`
+	Counterparty string `json:"counterparty,omitempty"`
+	Amount       string `json:"amount"`
+	Fee          string `json:"fee,omitempty"`
+	Balance      string `json:"balance"`
+	CreatedAt    string `json:"created_at"`
+}
+
+func newAccountPayment(p wallet.AccountPayment) AccountPayment {
+	ap := AccountPayment{
+		ID:        p.PaymentID.String(),
+		Amount:    p.Amount.Text('f'),
+		Balance:   p.Balance.Text('f'),
+		CreatedAt: formatTime(p.CreatedAt),
+	}
+	if p.Counterparty != nil {
+		ap.Counterparty = p.Counterparty.String()
+	}
+	if p.Fee != nil {
+		ap.Fee = p.Fee.Text('f')
+	}
+	return ap
+}
+
+func newAccountPayments(payments []wallet.AccountPayment) []AccountPayment {
+	if len(payments) == 0 {
+		return nil
+	}
+
+	out := make([]AccountPayment, len(payments))
+	for i, p := range payments {
+		out[i] = newAccountPayment(p)
+	}
+	return out
+}
+
+// ExchangeRate is a JSON-representable form of wallet.ExchangeRate
+type ExchangeRate struct {
+	From      string `json:"from"`
+	To        string `json:"to"`
+	Rate      string `json:"rate"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newExchangeRate(r wallet.ExchangeRate) ExchangeRate {
+	return ExchangeRate{
+		From:      r.From,
+		To:        r.To,
+		Rate:      r.Rate.Text('f'),
+		UpdatedAt: formatTime(r.UpdatedAt),
+	}
+}
+
+func newExchangeRates(rates []wallet.ExchangeRate) []ExchangeRate {
+	if len(rates) == 0 {
+		return nil
+	}
+
+	out := make([]ExchangeRate, len(rates))
+	for i, r := range rates {
+		out[i] = newExchangeRate(r)
+	}
+	return out
+}
+
+// FeeSchedule is a JSON-representable form of wallet.FeeSchedule
+type FeeSchedule struct {
+	Currency  string `json:"currency"`
+	Flat      string `json:"flat"`
+	Percent   string `json:"percent"`
+	Min       string `json:"min,omitempty"`
+	Max       string `json:"max,omitempty"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newFeeSchedule(f wallet.FeeSchedule) FeeSchedule {
+	ff := FeeSchedule{
+		Currency:  f.Currency,
+		Flat:      f.Flat.Text('f'),
+		Percent:   f.Percent.Text('f'),
+		UpdatedAt: formatTime(f.UpdatedAt),
+	}
+	if f.Min != nil {
+		ff.Min = f.Min.Text('f')
+	}
+	if f.Max != nil {
+		ff.Max = f.Max.Text('f')
+	}
+	return ff
+}
+
+func newFeeSchedules(schedules []wallet.FeeSchedule) []FeeSchedule {
+	if len(schedules) == 0 {
+		return nil
+	}
+
+	out := make([]FeeSchedule, len(schedules))
+	for i, f := range schedules {
+		out[i] = newFeeSchedule(f)
+	}
+	return out
+}
+
+// SpendingLimit is a JSON-representable form of wallet.SpendingLimit
+type SpendingLimit struct {
+	AccountID   string `json:"account_id,omitempty"`
+	Currency    string `json:"currency"`
+	MaxAmount   string `json:"max_amount,omitempty"`
+	DailyAmount string `json:"daily_amount,omitempty"`
+	HourlyCount *int   `json:"hourly_count,omitempty"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+func newSpendingLimit(l wallet.SpendingLimit) SpendingLimit {
+	ll := SpendingLimit{
+		Currency:    l.Currency,
+		HourlyCount: l.HourlyCount,
+		UpdatedAt:   formatTime(l.UpdatedAt),
+	}
+	if l.AccountID != nil {
+		ll.AccountID = l.AccountID.String()
+	}
+	if l.MaxAmount != nil {
+		ll.MaxAmount = l.MaxAmount.Text('f')
+	}
+	if l.DailyAmount != nil {
+		ll.DailyAmount = l.DailyAmount.Text('f')
+	}
+	return ll
+}
+
+func newSpendingLimits(limits []wallet.SpendingLimit) []SpendingLimit {
+	if len(limits) == 0 {
+		return nil
+	}
+
+	out := make([]SpendingLimit, len(limits))
+	for i, l := range limits {
+		out[i] = newSpendingLimit(l)
+	}
+	return out
+}
+
+// ScheduledTransfer is a JSON-representable form of wallet.ScheduledTransfer
+type ScheduledTransfer struct {
+	ID        string `json:"id"`
+	To        string `json:"to"`
+	From      string `json:"from"`
+	Amount    string `json:"amount"`
+	ExecuteAt string `json:"execute_at"`
+	Status    string `json:"status"`
+	PaymentID string `json:"payment_id,omitempty"`
+	Error     string `json:"error,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newScheduledTransfer(st wallet.ScheduledTransfer) ScheduledTransfer {
+	sst := ScheduledTransfer{
+		ID:        st.ID.String(),
+		To:        st.To.String(),
+		From:      st.From.String(),
+		Amount:    st.Amount.Text('f'),
+		ExecuteAt: formatTime(st.ExecuteAt),
+		Status:    st.Status,
+		Error:     st.Error,
+		CreatedAt: formatTime(st.CreatedAt),
+		UpdatedAt: formatTime(st.UpdatedAt),
+	}
+	if st.PaymentID != nil {
+		sst.PaymentID = st.PaymentID.String()
+	}
+	return sst
+}
+
+func newScheduledTransfers(transfers []wallet.ScheduledTransfer) []ScheduledTransfer {
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	out := make([]ScheduledTransfer, len(transfers))
+	for i, st := range transfers {
+		out[i] = newScheduledTransfer(st)
+	}
+	return out
+}
+
+// StandingOrder is a JSON-representable form of wallet.StandingOrder
+type StandingOrder struct {
+	ID          string `json:"id"`
+	To          string `json:"to"`
+	From        string `json:"from"`
+	Amount      string `json:"amount"`
+	Frequency   string `json:"frequency"`
+	Interval    int    `json:"interval"`
+	StartAt     string `json:"start_at"`
+	EndAt       string `json:"end_at,omitempty"`
+	Status      string `json:"status"`
+	Occurrences int    `json:"occurrences"`
+	NextRunAt   string `json:"next_run_at,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+func newStandingOrder(o wallet.StandingOrder) StandingOrder {
+	so := StandingOrder{
+		ID:          o.ID.String(),
+		To:          o.To.String(),
+		From:        o.From.String(),
+		Amount:      o.Amount.Text('f'),
+		Frequency:   o.Frequency,
+		Interval:    o.Interval,
+		StartAt:     formatTime(o.StartAt),
+		Status:      o.Status,
+		Occurrences: o.Occurrences,
+		CreatedAt:   formatTime(o.CreatedAt),
+		UpdatedAt:   formatTime(o.UpdatedAt),
+	}
+	if o.EndAt != nil {
+		so.EndAt = formatTime(*o.EndAt)
+	}
+	// Orders that are no longer active don't run again
+	if o.Status == wallet.StandingOrderActive {
+		so.NextRunAt = formatTime(o.NextRunAt)
+	}
+	return so
+}
+
+func newStandingOrders(orders []wallet.StandingOrder) []StandingOrder {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	out := make([]StandingOrder, len(orders))
+	for i, o := range orders {
+		out[i] = newStandingOrder(o)
+	}
+	return out
+}
+
+// StandingOrderOccurrence is a JSON-representable form of wallet.StandingOrderOccurrence
+type StandingOrderOccurrence struct {
+	ID          string `json:"id"`
+	ScheduledAt string `json:"scheduled_at"`
+	Status      string `json:"status"`
+	Attempts    int    `json:"attempts"`
+	PaymentID   string `json:"payment_id,omitempty"`
+	Error       string `json:"error,omitempty"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+func newStandingOrderOccurrences(occurrences []wallet.StandingOrderOccurrence) []StandingOrderOccurrence {
+	if len(occurrences) == 0 {
+		return nil
+	}
+
+	out := make([]StandingOrderOccurrence, len(occurrences))
+	for i, o := range occurrences {
+		out[i] = StandingOrderOccurrence{
+			ID:          o.ID.String(),
+			ScheduledAt: formatTime(o.ScheduledAt),
+			Status:      o.Status,
+			Attempts:    o.Attempts,
+			Error:       o.Error,
+			CreatedAt:   formatTime(o.CreatedAt),
+			UpdatedAt:   formatTime(o.UpdatedAt),
+		}
+		if o.PaymentID != nil {
+			out[i].PaymentID = o.PaymentID.String()
+		}
+	}
+	return out
+}
+
+// Hold is a JSON-representable form of wallet.Hold
+type Hold struct {
+	ID        string `json:"id"`
+	From      string `json:"from"`
+	Amount    string `json:"amount"`
+	Status    string `json:"status"`
+	ExpiresAt string `json:"expires_at"`
+	PaymentID string `json:"payment_id,omitempty"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func newHold(h wallet.Hold) Hold {
+	hh := Hold{
+		ID:        h.ID.String(),
+		From:      h.From.String(),
+		Amount:    h.Amount.Text('f'),
+		Status:    h.Status,
+		ExpiresAt: formatTime(h.ExpiresAt),
+		CreatedAt: formatTime(h.CreatedAt),
+		UpdatedAt: formatTime(h.UpdatedAt),
+	}
+	if h.PaymentID != nil {
+		hh.PaymentID = h.PaymentID.String()
+	}
+	return hh
+}
+
+// Customer is a JSON-representable form of wallet.Customer
+type Customer struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+func newCustomer(c wallet.Customer) Customer {
+	return Customer{
+		ID:        c.ID.String(),
+		Name:      c.Name,
+		CreatedAt: formatTime(c.CreatedAt),
+	}
+}
+
+// CustomerBalance is a JSON-representable form of wallet.CustomerBalance
+type CustomerBalance struct {
+	Currency string `json:"currency"`
+	Balance  string `json:"balance"`
+	Accounts int    `json:"accounts"`
+}
+
+func newCustomerBalances(balances []wallet.CustomerBalance) []CustomerBalance {
+	if len(balances) == 0 {
+		return nil
+	}
+
+	out := make([]CustomerBalance, len(balances))
+	for i, b := range balances {
+		out[i] = CustomerBalance{
+			Currency: b.Currency,
+			Balance:  b.Balance.Text('f'),
+			Accounts: b.Accounts,
+		}
+	}
+	return out
+}
+
+// Currency is a JSON-representable form of wallet.Currency
+type Currency struct {
+	Code     string `json:"code"`
+	Exponent int32  `json:"exponent"`
+	Enabled  bool   `json:"enabled"`
+}
+
+func newCurrency(c wallet.Currency) Currency {
+	return Currency{
+		Code:     c.Code,
+		Exponent: c.Exponent,
+		Enabled:  c.Enabled,
+	}
+}
+
+func newCurrencies(currencies []wallet.Currency) []Currency {
+	if len(currencies) == 0 {
+		return nil
+	}
+
+	out := make([]Currency, len(currencies))
+	for i, c := range currencies {
+		out[i] = newCurrency(c)
 	}
 	return out
 }
@@ -72,6 +497,8 @@ type transferRequest struct {
 	To     string `json:"to"`
 	From   string `json:"from"`
 	Amount string `json:"amount"`
+	// IdempotencyKey is read from the Idempotency-Key header
+	IdempotencyKey string `json:"-"`
 }
 
 type transferResponse struct {
@@ -84,60 +511,91 @@ func (r transferResponse) error() error {
 }
 
 var (
-	errFromRequired   = errors.New("from is required")
-	errToRequired     = errors.New("to is required")
-	errAmountRequired = errors.New("amount is required")
+	errFromRequired      = errors.New("from is required")
+	errToRequired        = errors.New("to is required")
+	errAmountRequired    = errors.New("amount is required")
+	errCurrencyRequired  = errors.New("currency is required")
+	errRateRequired      = errors.New("rate is required")
+	errCodeRequired      = errors.New("code is required")
+	errExponentRequired  = errors.New("exponent is required")
+	errEnabledRequired   = errors.New("enabled is required")
+	errExecuteAtRequired = errors.New("execute_at is required")
+	errFrequencyRequired = errors.New("frequency is required")
+	errStartAtRequired   = errors.New("start_at is required")
+	errNameRequired      = errors.New("name is required")
+	// errAccountOrCurrency is returned unless exactly one of a spending limit's account or currency is given
+	errAccountOrCurrency = errors.New("Exactly one of account_id or currency is required")
+	// errNotSubject is returned if a request authenticated with a bearer token reads another customer
+	errNotSubject = errors.New("Customer is not the authenticated customer")
 )
 
-type errInvalidAccountID struct {
+// authorizeAccount returns wallet.ErrNoAccount if a request is authenticated with a bearer token
+// and the token's subject does not own the account. Accounts of other customers are reported
+// the same as accounts that do not exist, so that end users can't find out which IDs exist.
+// API keys may use any account.
+func authorizeAccount(ctx context.Context, s wallet.Service, id uuid.UUID) error {
+	subject, ok := subjectFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	a, err := s.Account(ctx, id)
+	if err != nil {
+		return err
+	}
+	if a.OwnerID == nil || !uuid.Equal(*a.OwnerID, subject) {
+		return wallet.ErrNoAccount
+	}
+	return nil
+}
+
+// authorizeCustomer returns errNotSubject if a request is authenticated with a bearer token
+// whose subject is not the customer. API keys may read any customer.
+func authorizeCustomer(ctx context.Context, id uuid.UUID) error {
+	if subject, ok := subjectFromContext(ctx); ok && !uuid.Equal(subject, id) {
+		return errNotSubject
+	}
+	return nil
+}
+
+type errInvalidTime struct {
 	Err   error
 	Field string
 }
 
-func (e errInvalidAccountID) Error() string {
-	return fmt.Sprintf("Invalid account ID for field %q: %v", e.Field, e.Err)
+func (e errInvalidTime) Error() string {
+	return fmt.Sprintf("Invalid RFC 3339 time for field %q: %v", e.Field, e.Err)
+}
+
+// errInvalidID is returned if the ID of a resource in a request is not a valid UUID
+type errInvalidID struct {
+	// Resource is the kind of resource that the ID refers to, e.g. "account"
+	Resource string
+	Err      error
+	Field    string
+}
+
+func (e errInvalidID) Error() string {
+	return fmt.Sprintf("Invalid %s ID for field %q: %v", e.Resource, e.Field, e.Err)
 }
 
 func makeTransferEndpoint(s wallet.Service) endpoint.Endpoint {
 	return func(ctx context.Context, request interface{}) (interface{}, error) {
 		req := request.(transferRequest)
 
-		// Parse and validate request fields
-		// Note: we could use the parsed types (uuid.UUID, apd.Decimal)
-		// in the request struct, but then we would lose control over the
-		// response error handling
-		if req.From == "" {
-			return nil, errFromRequired
-		}
-		if req.To == "" {
-			return nil, errToRequired
-		}
-		if req.Amount == "" {
-			return nil, errAmountRequired
-		}
-
-		from, err := uuid.FromString(req.From)
-		if err != nil {
-			return nil, errInvalidAccountID{
-				Err:   err,
-				Field: "from",
-			}
-		}
-
-		to, err := uuid.FromString(req.To)
+		t, err := parseTransferRequest(req)
 		if err != nil {
-			return nil, errInvalidAccountID{
-				Err:   err,
-				Field: "to",
-			}
+			return nil, err
 		}
 
-		amount, err := decimal.ParseCurrency(req.Amount)
-		if err != nil {
-			return nil, err
+		// End users can only move money from their own accounts
+		if err := authorizeAccount(ctx, s, t.From); err != nil {
+			return transferResponse{
+				Err: err,
+			}, nil
 		}
 
-		p, err := s.Transfer(ctx, to, from, amount)
+		p, err := s.Transfer(ctx, t.To, t.From, t.Amount, req.IdempotencyKey)
 		if err != nil {
 			return transferResponse{
 				Err: err,
@@ -151,40 +609,1350 @@ func makeTransferEndpoint(s wallet.Service) endpoint.Endpoint {
 	}
 }
 
-type paymentsResponse struct {
-	Payments []Payment `json:"payments,omitempty"`
-	Err      error     `json:"error,omitempty"`
+// parseTransferRequest parses and validates the fields of a transfer request
+func parseTransferRequest(req transferRequest) (wallet.TransferRequest, error) {
+	// Note: we could use the parsed types (uuid.UUID, apd.Decimal)
+	// in the request struct, but then we would lose control over the
+	// response error handling
+	if req.From == "" {
+		return wallet.TransferRequest{}, errFromRequired
+	}
+	if req.To == "" {
+		return wallet.TransferRequest{}, errToRequired
+	}
+	if req.Amount == "" {
+		return wallet.TransferRequest{}, errAmountRequired
+	}
+
+	from, err := uuid.FromString(req.From)
+	if err != nil {
+		return wallet.TransferRequest{}, errInvalidID{
+			Resource: "account",
+			Err:      err,
+			Field:    "from",
+		}
+	}
+
+	to, err := uuid.FromString(req.To)
+	if err != nil {
+		return wallet.TransferRequest{}, errInvalidID{
+			Resource: "account",
+			Err:      err,
+			Field:    "to",
+		}
+	}
+
+	// The number of decimal places depends on the account's currency,
+	// which is checked by the service
+	amount, err := decimal.ParseAmount(req.Amount)
+	if err != nil {
+		return wallet.TransferRequest{}, err
+	}
+
+	return wallet.TransferRequest{
+		To:     to,
+		From:   from,
+		Amount: amount,
+	}, nil
 }
 
-func (r paymentsResponse) error() error {
+type transferBatchRequest struct {
+	// Mode is wallet.BatchModeAtomic or wallet.BatchModeBestEffort, and defaults to wallet.BatchModeAtomic
+	Mode      string            `json:"mode"`
+	Transfers []transferRequest `json:"transfers"`
+}
+
+// TransferResult is a JSON-representable form of wallet.TransferResult.
+// Status is the HTTP status code of the error, the same as if the transfer was made alone.
+type TransferResult struct {
+	Payment *Payment `json:"payment,omitempty"`
+	Error   string   `json:"error,omitempty"`
+	Status  int      `json:"status,omitempty"`
+}
+
+func newTransferResults(results []wallet.TransferResult) []TransferResult {
+	out := make([]TransferResult, len(results))
+	for i, r := range results {
+		if r.Err != nil {
+			out[i] = TransferResult{
+				Error:  r.Err.Error(),
+				Status: errorStatusCode(r.Err),
+			}
+			continue
+		}
+
+		p := newPayment(*r.Payment)
+		out[i] = TransferResult{
+			Payment: &p,
+		}
+	}
+	return out
+}
+
+type transferBatchResponse struct {
+	Results []TransferResult `json:"results,omitempty"`
+	Err     error            `json:"error,omitempty"`
+}
+
+func (r transferBatchResponse) error() error {
 	return r.Err
 }
 
-func makePaymentsEndpoint(s wallet.Service) endpoint.Endpoint {
-	return func(ctx context.Context, _ interface{}) (interface{}, error) {
-		p, err := s.Payments(ctx)
-		return paymentsResponse{
-			Payments: newPayments(p),
-			Err:      err,
+func makeTransferBatchEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(transferBatchRequest)
+
+		mode := req.Mode
+		if mode == "" {
+			mode = wallet.BatchModeAtomic
+		}
+
+		// A malformed transfer rejects the whole batch, in either mode
+		transfers := make([]wallet.TransferRequest, len(req.Transfers))
+		for i, r := range req.Transfers {
+			t, err := parseTransferRequest(r)
+			if err != nil {
+				return nil, errBatchTransfer{
+					Index: i,
+					Err:   err,
+				}
+			}
+			transfers[i] = t
+		}
+
+		results, err := s.TransferBatch(ctx, transfers, mode)
+		if err != nil {
+			return transferBatchResponse{
+				Err: err,
+			}, nil
+		}
+
+		return transferBatchResponse{
+			Results: newTransferResults(results),
 		}, nil
 	}
 }
 
-type accountsResponse struct {
-	Accounts []Account `json:"accounts,omitempty"`
-	Err      error     `json:"error,omitempty"`
+type scheduleTransferRequest struct {
+	transferRequest
+	// ExecuteAt is an RFC 3339 time
+	ExecuteAt string `json:"execute_at"`
 }
 
-func (r accountsResponse) error() error {
+type scheduledTransferResponse struct {
+	ScheduledTransfer *ScheduledTransfer `json:"scheduled_transfer,omitempty"`
+	Err               error              `json:"error,omitempty"`
+}
+
+func (r scheduledTransferResponse) error() error {
 	return r.Err
 }
 
-func makeAccountsEndpoint(s wallet.Service) endpoint.Endpoint {
-	return func(ctx context.Context, _ interface{}) (interface{}, error) {
-		a, err := s.Accounts(ctx)
-		return accountsResponse{
-			Accounts: newAccounts(a),
-			Err:      err,
+func makeScheduleTransferEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(scheduleTransferRequest)
+
+		t, err := parseTransferRequest(req.transferRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.ExecuteAt == "" {
+			return nil, errExecuteAtRequired
+		}
+
+		executeAt, err := time.Parse(time.RFC3339, req.ExecuteAt)
+		if err != nil {
+			return nil, errInvalidTime{
+				Err:   err,
+				Field: "execute_at",
+			}
+		}
+
+		st, err := s.ScheduleTransfer(ctx, t.To, t.From, t.Amount, executeAt)
+		if err != nil {
+			return scheduledTransferResponse{
+				Err: err,
+			}, nil
+		}
+
+		sst := newScheduledTransfer(*st)
+		return scheduledTransferResponse{
+			ScheduledTransfer: &sst,
+		}, nil
+	}
+}
+
+type scheduledTransfersRequest struct {
+	// Status is optional, scheduled transfers with any status are listed if it is empty
+	Status string
+	Page   wallet.Page
+}
+
+type scheduledTransfersResponse struct {
+	ScheduledTransfers []ScheduledTransfer `json:"scheduled_transfers,omitempty"`
+	NextCursor         string              `json:"next_cursor,omitempty"`
+	Err                error               `json:"error,omitempty"`
+}
+
+func (r scheduledTransfersResponse) error() error {
+	return r.Err
+}
+
+func makeScheduledTransfersEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(scheduledTransfersRequest)
+		st, next, err := s.ScheduledTransfers(ctx, req.Status, req.Page)
+		return scheduledTransfersResponse{
+			ScheduledTransfers: newScheduledTransfers(st),
+			NextCursor:         next,
+			Err:                err,
+		}, nil
+	}
+}
+
+type cancelScheduledTransferRequest struct {
+	ID uuid.UUID
+}
+
+func makeCancelScheduledTransferEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(cancelScheduledTransferRequest)
+
+		st, err := s.CancelScheduledTransfer(ctx, req.ID)
+		if err != nil {
+			return scheduledTransferResponse{
+				Err: err,
+			}, nil
+		}
+
+		sst := newScheduledTransfer(*st)
+		return scheduledTransferResponse{
+			ScheduledTransfer: &sst,
+		}, nil
+	}
+}
+
+// parseOptionalTime parses an RFC 3339 time of a request field, or returns nil if the field is empty
+func parseOptionalTime(value, field string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, errInvalidTime{
+			Err:   err,
+			Field: field,
+		}
+	}
+	return &t, nil
+}
+
+type createStandingOrderRequest struct {
+	transferRequest
+	Frequency string `json:"frequency"`
+	// Interval is optional, and defaults to 1
+	Interval *int `json:"interval"`
+	// StartAt is an RFC 3339 time
+	StartAt string `json:"start_at"`
+	// EndAt is an optional RFC 3339 time
+	EndAt string `json:"end_at"`
+}
+
+type standingOrderResponse struct {
+	StandingOrder *StandingOrder `json:"standing_order,omitempty"`
+	Err           error          `json:"error,omitempty"`
+}
+
+func (r standingOrderResponse) error() error {
+	return r.Err
+}
+
+// newStandingOrderResponse creates the response of an endpoint that returns a standing order
+func newStandingOrderResponse(o *wallet.StandingOrder, err error) standingOrderResponse {
+	if err != nil {
+		return standingOrderResponse{
+			Err: err,
+		}
+	}
+
+	so := newStandingOrder(*o)
+	return standingOrderResponse{
+		StandingOrder: &so,
+	}
+}
+
+func makeCreateStandingOrderEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createStandingOrderRequest)
+
+		t, err := parseTransferRequest(req.transferRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		if req.Frequency == "" {
+			return nil, errFrequencyRequired
+		}
+
+		interval := 1
+		if req.Interval != nil {
+			interval = *req.Interval
+		}
+
+		if req.StartAt == "" {
+			return nil, errStartAtRequired
+		}
+
+		startAt, err := parseOptionalTime(req.StartAt, "start_at")
+		if err != nil {
+			return nil, err
+		}
+
+		endAt, err := parseOptionalTime(req.EndAt, "end_at")
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := s.CreateStandingOrder(ctx, wallet.StandingOrder{
+			To:        t.To,
+			From:      t.From,
+			Amount:    t.Amount,
+			Frequency: req.Frequency,
+			Interval:  interval,
+			StartAt:   *startAt,
+			EndAt:     endAt,
+		})
+		return newStandingOrderResponse(o, err), nil
+	}
+}
+
+type standingOrdersResponse struct {
+	StandingOrders []StandingOrder `json:"standing_orders,omitempty"`
+	NextCursor     string          `json:"next_cursor,omitempty"`
+	Err            error           `json:"error,omitempty"`
+}
+
+func (r standingOrdersResponse) error() error {
+	return r.Err
+}
+
+func makeStandingOrdersEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		page := request.(wallet.Page)
+		o, next, err := s.StandingOrders(ctx, page)
+		return standingOrdersResponse{
+			StandingOrders: newStandingOrders(o),
+			NextCursor:     next,
+			Err:            err,
+		}, nil
+	}
+}
+
+type standingOrderRequest struct {
+	ID uuid.UUID
+}
+
+func makeStandingOrderEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(standingOrderRequest)
+		o, err := s.StandingOrder(ctx, req.ID)
+		return newStandingOrderResponse(o, err), nil
+	}
+}
+
+type updateStandingOrderRequest struct {
+	// ID is read from the URL path
+	ID     uuid.UUID `json:"-"`
+	Amount string    `json:"amount"`
+	// EndAt is an optional RFC 3339 time. The order has no end if it is empty.
+	EndAt string `json:"end_at"`
+}
+
+func makeUpdateStandingOrderEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(updateStandingOrderRequest)
+
+		if req.Amount == "" {
+			return nil, errAmountRequired
+		}
+
+		amount, err := decimal.ParseAmount(req.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		endAt, err := parseOptionalTime(req.EndAt, "end_at")
+		if err != nil {
+			return nil, err
+		}
+
+		o, err := s.UpdateStandingOrder(ctx, req.ID, amount, endAt)
+		return newStandingOrderResponse(o, err), nil
+	}
+}
+
+func makeCancelStandingOrderEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(standingOrderRequest)
+		o, err := s.CancelStandingOrder(ctx, req.ID)
+		return newStandingOrderResponse(o, err), nil
+	}
+}
+
+type standingOrderOccurrencesRequest struct {
+	ID   uuid.UUID
+	Page wallet.Page
+}
+
+type standingOrderOccurrencesResponse struct {
+	Occurrences []StandingOrderOccurrence `json:"occurrences,omitempty"`
+	NextCursor  string                    `json:"next_cursor,omitempty"`
+	Err         error                     `json:"error,omitempty"`
+}
+
+func (r standingOrderOccurrencesResponse) error() error {
+	return r.Err
+}
+
+func makeStandingOrderOccurrencesEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(standingOrderOccurrencesRequest)
+		o, next, err := s.StandingOrderOccurrences(ctx, req.ID, req.Page)
+		return standingOrderOccurrencesResponse{
+			Occurrences: newStandingOrderOccurrences(o),
+			NextCursor:  next,
+			Err:         err,
+		}, nil
+	}
+}
+
+type createHoldRequest struct {
+	From   string `json:"from"`
+	Amount string `json:"amount"`
+	// ExpiresAt is an optional RFC 3339 time, the hold expires after a default time if it is empty
+	ExpiresAt string `json:"expires_at"`
+}
+
+type holdResponse struct {
+	Hold *Hold `json:"hold,omitempty"`
+	Err  error `json:"error,omitempty"`
+}
+
+func (r holdResponse) error() error {
+	return r.Err
+}
+
+// newHoldResponse creates the response of an endpoint that returns a hold
+func newHoldResponse(h *wallet.Hold, err error) holdResponse {
+	if err != nil {
+		return holdResponse{
+			Err: err,
+		}
+	}
+
+	hh := newHold(*h)
+	return holdResponse{
+		Hold: &hh,
+	}
+}
+
+func makeCreateHoldEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createHoldRequest)
+
+		if req.From == "" {
+			return nil, errFromRequired
+		}
+		if req.Amount == "" {
+			return nil, errAmountRequired
+		}
+
+		from, err := uuid.FromString(req.From)
+		if err != nil {
+			return nil, errInvalidID{
+				Resource: "account",
+				Err:      err,
+				Field:    "from",
+			}
+		}
+
+		amount, err := decimal.ParseAmount(req.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		expiresAt, err := parseOptionalTime(req.ExpiresAt, "expires_at")
+		if err != nil {
+			return nil, err
+		}
+
+		// A zero time uses the default expiry
+		var at time.Time
+		if expiresAt != nil {
+			at = *expiresAt
+		}
+
+		h, err := s.Hold(ctx, from, amount, at)
+		return newHoldResponse(h, err), nil
+	}
+}
+
+type holdRequest struct {
+	ID uuid.UUID
+}
+
+func makeHoldEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(holdRequest)
+		h, err := s.GetHold(ctx, req.ID)
+		return newHoldResponse(h, err), nil
+	}
+}
+
+type captureRequest struct {
+	// HoldID is read from the URL path
+	HoldID uuid.UUID `json:"-"`
+	To     string    `json:"to"`
+	// Amount is optional, the whole hold is captured if not provided
+	Amount string `json:"amount"`
+}
+
+func makeCaptureEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(captureRequest)
+
+		if req.To == "" {
+			return nil, errToRequired
+		}
+
+		to, err := uuid.FromString(req.To)
+		if err != nil {
+			return nil, errInvalidID{
+				Resource: "account",
+				Err:      err,
+				Field:    "to",
+			}
+		}
+
+		var amount *apd.Decimal
+		if req.Amount != "" {
+			amount, err = decimal.ParseAmount(req.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p, err := s.Capture(ctx, req.HoldID, to, amount)
+		if err != nil {
+			return transferResponse{
+				Err: err,
+			}, nil
+		}
+
+		pp := newPayment(*p)
+		return transferResponse{
+			Payment: &pp,
+		}, nil
+	}
+}
+
+func makeReleaseEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(holdRequest)
+		h, err := s.Release(ctx, req.ID)
+		return newHoldResponse(h, err), nil
+	}
+}
+
+type depositRequest struct {
+	To     string `json:"to"`
+	Amount string `json:"amount"`
+	// IdempotencyKey is read from the Idempotency-Key header
+	IdempotencyKey string `json:"-"`
+}
+
+func makeDepositEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(depositRequest)
+
+		if req.To == "" {
+			return nil, errToRequired
+		}
+		if req.Amount == "" {
+			return nil, errAmountRequired
+		}
+
+		to, err := uuid.FromString(req.To)
+		if err != nil {
+			return nil, errInvalidID{
+				Resource: "account",
+				Err:      err,
+				Field:    "to",
+			}
+		}
+
+		amount, err := decimal.ParseAmount(req.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := s.Deposit(ctx, to, amount, req.IdempotencyKey)
+		if err != nil {
+			return transferResponse{
+				Err: err,
+			}, nil
+		}
+
+		pp := newPayment(*p)
+		return transferResponse{
+			Payment: &pp,
+		}, nil
+	}
+}
+
+type withdrawRequest struct {
+	From   string `json:"from"`
+	Amount string `json:"amount"`
+	// IdempotencyKey is read from the Idempotency-Key header
+	IdempotencyKey string `json:"-"`
+}
+
+func makeWithdrawEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(withdrawRequest)
+
+		if req.From == "" {
+			return nil, errFromRequired
+		}
+		if req.Amount == "" {
+			return nil, errAmountRequired
+		}
+
+		from, err := uuid.FromString(req.From)
+		if err != nil {
+			return nil, errInvalidID{
+				Resource: "account",
+				Err:      err,
+				Field:    "from",
+			}
+		}
+
+		amount, err := decimal.ParseAmount(req.Amount)
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := s.Withdraw(ctx, from, amount, req.IdempotencyKey)
+		if err != nil {
+			return transferResponse{
+				Err: err,
+			}, nil
+		}
+
+		pp := newPayment(*p)
+		return transferResponse{
+			Payment: &pp,
+		}, nil
+	}
+}
+
+type refundRequest struct {
+	PaymentID uuid.UUID `json:"-"`
+	// Amount is optional, the remaining amount of the payment is refunded if not provided
+	Amount string `json:"amount"`
+	// IdempotencyKey is read from the Idempotency-Key header
+	IdempotencyKey string `json:"-"`
+}
+
+func makeRefundEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(refundRequest)
+
+		var amount *apd.Decimal
+		if req.Amount != "" {
+			var err error
+			amount, err = decimal.ParseAmount(req.Amount)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		p, err := s.Refund(ctx, req.PaymentID, amount, req.IdempotencyKey)
+		if err != nil {
+			return transferResponse{
+				Err: err,
+			}, nil
+		}
+
+		pp := newPayment(*p)
+		return transferResponse{
+			Payment: &pp,
+		}, nil
+	}
+}
+
+type paymentsResponse struct {
+	Payments   []Payment `json:"payments,omitempty"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Err        error     `json:"error,omitempty"`
+}
+
+func (r paymentsResponse) error() error {
+	return r.Err
+}
+
+func makePaymentsEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		page := request.(wallet.Page)
+
+		var p []wallet.Payment
+		var next string
+		var err error
+		if subject, ok := subjectFromContext(ctx); ok {
+			// End users only see the payments of their own accounts
+			p, next, err = s.CustomerPayments(ctx, subject, page)
+		} else {
+			p, next, err = s.Payments(ctx, page)
+		}
+		return paymentsResponse{
+			Payments:   newPayments(p),
+			NextCursor: next,
+			Err:        err,
+		}, nil
+	}
+}
+
+type accountsResponse struct {
+	Accounts   []Account `json:"accounts,omitempty"`
+	NextCursor string    `json:"next_cursor,omitempty"`
+	Err        error     `json:"error,omitempty"`
+}
+
+func (r accountsResponse) error() error {
+	return r.Err
+}
+
+func makeAccountsEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		page := request.(wallet.Page)
+
+		var a []wallet.Account
+		var next string
+		var err error
+		if subject, ok := subjectFromContext(ctx); ok {
+			// End users only see their own accounts
+			a, next, err = s.CustomerAccounts(ctx, subject, page)
+		} else {
+			a, next, err = s.Accounts(ctx, page)
+		}
+		if err != nil {
+			return accountsResponse{
+				Err: err,
+			}, nil
+		}
+
+		accounts, err := newAccounts(a)
+		return accountsResponse{
+			Accounts:   accounts,
+			NextCursor: next,
+			Err:        err,
+		}, nil
+	}
+}
+
+// newAccountResponse creates the response of an account, or of the error formatting it
+func newAccountResponse(a wallet.Account) accountResponse {
+	aa, err := newAccount(a)
+	if err != nil {
+		return accountResponse{
+			Err: err,
+		}
+	}
+
+	return accountResponse{
+		Account: &aa,
+	}
+}
+
+type accountRequest struct {
+	ID uuid.UUID
+}
+
+type accountResponse struct {
+	Account *Account `json:"account,omitempty"`
+	Err     error    `json:"error,omitempty"`
+}
+
+func (r accountResponse) error() error {
+	return r.Err
+}
+
+func makeAccountEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountRequest)
+
+		if err := authorizeAccount(ctx, s, req.ID); err != nil {
+			return accountResponse{
+				Err: err,
+			}, nil
+		}
+
+		a, err := s.Account(ctx, req.ID)
+		if err != nil {
+			return accountResponse{
+				Err: err,
+			}, nil
+		}
+
+		return newAccountResponse(*a), nil
+	}
+}
+
+// makeSetAccountStatusEndpoint creates an endpoint that changes an account's status to status
+func makeSetAccountStatusEndpoint(s wallet.Service, status string) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountRequest)
+
+		a, err := s.SetAccountStatus(ctx, req.ID, status)
+		if err != nil {
+			return accountResponse{
+				Err: err,
+			}, nil
+		}
+
+		return newAccountResponse(*a), nil
+	}
+}
+
+type accountPaymentsRequest struct {
+	ID        uuid.UUID
+	TimeRange wallet.TimeRange
+	Page      wallet.Page
+}
+
+type accountPaymentsResponse struct {
+	Payments   []AccountPayment `json:"payments,omitempty"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+	Err        error            `json:"error,omitempty"`
+}
+
+func (r accountPaymentsResponse) error() error {
+	return r.Err
+}
+
+func makeAccountPaymentsEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(accountPaymentsRequest)
+
+		if err := authorizeAccount(ctx, s, req.ID); err != nil {
+			return accountPaymentsResponse{
+				Err: err,
+			}, nil
+		}
+
+		p, next, err := s.AccountPayments(ctx, req.ID, req.TimeRange, req.Page)
+		return accountPaymentsResponse{
+			Payments:   newAccountPayments(p),
+			NextCursor: next,
+			Err:        err,
+		}, nil
+	}
+}
+
+type createAccountRequest struct {
+	ID       string `json:"id,omitempty"`
+	Currency string `json:"currency"`
+	OwnerID  string `json:"owner_id,omitempty"`
+}
+
+func makeCreateAccountEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createAccountRequest)
+
+		if req.Currency == "" {
+			return nil, errCurrencyRequired
+		}
+
+		// The ID is optional, a new ID is generated if not provided
+		id := uuid.Nil
+		if req.ID != "" {
+			var err error
+			id, err = uuid.FromString(req.ID)
+			if err != nil {
+				return nil, errInvalidID{
+					Resource: "account",
+					Err:      err,
+					Field:    "id",
+				}
+			}
+		}
+
+		// The owner is optional, an account without an owner is not held by a customer
+		var ownerID *uuid.UUID
+		if req.OwnerID != "" {
+			id, err := uuid.FromString(req.OwnerID)
+			if err != nil {
+				return nil, errInvalidID{
+					Resource: "customer",
+					Err:      err,
+					Field:    "owner_id",
+				}
+			}
+			ownerID = &id
+		}
+
+		a, err := s.CreateAccount(ctx, id, req.Currency, ownerID)
+		if err != nil {
+			return accountResponse{
+				Err: err,
+			}, nil
+		}
+
+		return newAccountResponse(*a), nil
+	}
+}
+
+type createCustomerRequest struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name"`
+}
+
+type customerRequest struct {
+	ID uuid.UUID
+}
+
+type customerResponse struct {
+	Customer *Customer `json:"customer,omitempty"`
+	Err      error     `json:"error,omitempty"`
+}
+
+func (r customerResponse) error() error {
+	return r.Err
+}
+
+func makeCreateCustomerEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(createCustomerRequest)
+
+		if req.Name == "" {
+			return nil, errNameRequired
+		}
+
+		// The ID is optional, a new ID is generated if not provided
+		id := uuid.Nil
+		if req.ID != "" {
+			var err error
+			id, err = uuid.FromString(req.ID)
+			if err != nil {
+				return nil, errInvalidID{
+					Resource: "customer",
+					Err:      err,
+					Field:    "id",
+				}
+			}
+		}
+
+		c, err := s.CreateCustomer(ctx, id, req.Name)
+		if err != nil {
+			return customerResponse{
+				Err: err,
+			}, nil
+		}
+
+		cc := newCustomer(*c)
+		return customerResponse{
+			Customer: &cc,
+		}, nil
+	}
+}
+
+func makeCustomerEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(customerRequest)
+
+		if err := authorizeCustomer(ctx, req.ID); err != nil {
+			return customerResponse{
+				Err: err,
+			}, nil
+		}
+
+		c, err := s.Customer(ctx, req.ID)
+		if err != nil {
+			return customerResponse{
+				Err: err,
+			}, nil
+		}
+
+		cc := newCustomer(*c)
+		return customerResponse{
+			Customer: &cc,
+		}, nil
+	}
+}
+
+type customerAccountsRequest struct {
+	ID   uuid.UUID
+	Page wallet.Page
+}
+
+func makeCustomerAccountsEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(customerAccountsRequest)
+
+		if err := authorizeCustomer(ctx, req.ID); err != nil {
+			return accountsResponse{
+				Err: err,
+			}, nil
+		}
+
+		a, next, err := s.CustomerAccounts(ctx, req.ID, req.Page)
+		if err != nil {
+			return accountsResponse{
+				Err: err,
+			}, nil
+		}
+
+		accounts, err := newAccounts(a)
+		return accountsResponse{
+			Accounts:   accounts,
+			NextCursor: next,
+			Err:        err,
+		}, nil
+	}
+}
+
+type customerBalancesResponse struct {
+	Balances []CustomerBalance `json:"balances,omitempty"`
+	Err      error             `json:"error,omitempty"`
+}
+
+func (r customerBalancesResponse) error() error {
+	return r.Err
+}
+
+func makeCustomerBalancesEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(customerRequest)
+
+		if err := authorizeCustomer(ctx, req.ID); err != nil {
+			return customerBalancesResponse{
+				Err: err,
+			}, nil
+		}
+
+		b, err := s.CustomerBalances(ctx, req.ID)
+		return customerBalancesResponse{
+			Balances: newCustomerBalances(b),
+			Err:      err,
+		}, nil
+	}
+}
+
+type exchangeRatesResponse struct {
+	Rates []ExchangeRate `json:"rates,omitempty"`
+	Err   error          `json:"error,omitempty"`
+}
+
+func (r exchangeRatesResponse) error() error {
+	return r.Err
+}
+
+func makeExchangeRatesEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		r, err := s.ExchangeRates(ctx)
+		return exchangeRatesResponse{
+			Rates: newExchangeRates(r),
+			Err:   err,
+		}, nil
+	}
+}
+
+type setExchangeRateRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	Rate string `json:"rate"`
+}
+
+type exchangeRateResponse struct {
+	Rate *ExchangeRate `json:"rate,omitempty"`
+	Err  error         `json:"error,omitempty"`
+}
+
+func (r exchangeRateResponse) error() error {
+	return r.Err
+}
+
+func makeSetExchangeRateEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setExchangeRateRequest)
+
+		if req.From == "" {
+			return nil, errFromRequired
+		}
+		if req.To == "" {
+			return nil, errToRequired
+		}
+		if req.Rate == "" {
+			return nil, errRateRequired
+		}
+
+		rate, err := decimal.ParseRate(req.Rate)
+		if err != nil {
+			return nil, err
+		}
+
+		r, err := s.SetExchangeRate(ctx, req.From, req.To, rate)
+		if err != nil {
+			return exchangeRateResponse{
+				Err: err,
+			}, nil
+		}
+
+		rr := newExchangeRate(*r)
+		return exchangeRateResponse{
+			Rate: &rr,
+		}, nil
+	}
+}
+
+type feeSchedulesResponse struct {
+	Fees []FeeSchedule `json:"fees,omitempty"`
+	Err  error         `json:"error,omitempty"`
+}
+
+func (r feeSchedulesResponse) error() error {
+	return r.Err
+}
+
+func makeFeeSchedulesEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		f, err := s.FeeSchedules(ctx)
+		return feeSchedulesResponse{
+			Fees: newFeeSchedules(f),
+			Err:  err,
+		}, nil
+	}
+}
+
+// setFeeScheduleRequest sets the fee schedule of a currency.
+// All fields except the currency are optional. Flat and Percent default to 0.
+type setFeeScheduleRequest struct {
+	Currency string `json:"currency"`
+	Flat     string `json:"flat"`
+	Percent  string `json:"percent"`
+	Min      string `json:"min"`
+	Max      string `json:"max"`
+}
+
+type feeScheduleResponse struct {
+	Fee *FeeSchedule `json:"fee,omitempty"`
+	Err error        `json:"error,omitempty"`
+}
+
+func (r feeScheduleResponse) error() error {
+	return r.Err
+}
+
+// parseOptionalAmount parses an amount, or returns nil if it is empty
+func parseOptionalAmount(amount string) (*apd.Decimal, error) {
+	if amount == "" {
+		return nil, nil
+	}
+	return decimal.ParseAmount(amount)
+}
+
+func makeSetFeeScheduleEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setFeeScheduleRequest)
+
+		if req.Currency == "" {
+			return nil, errCurrencyRequired
+		}
+
+		flat, err := parseOptionalAmount(req.Flat)
+		if err != nil {
+			return nil, err
+		}
+		percent, err := parseOptionalAmount(req.Percent)
+		if err != nil {
+			return nil, err
+		}
+		min, err := parseOptionalAmount(req.Min)
+		if err != nil {
+			return nil, err
+		}
+		max, err := parseOptionalAmount(req.Max)
+		if err != nil {
+			return nil, err
+		}
+
+		schedule := wallet.FeeSchedule{
+			Currency: req.Currency,
+			Flat:     flat,
+			Percent:  percent,
+			Min:      min,
+			Max:      max,
+		}
+
+		f, err := s.SetFeeSchedule(ctx, schedule)
+		if err != nil {
+			return feeScheduleResponse{
+				Err: err,
+			}, nil
+		}
+
+		ff := newFeeSchedule(*f)
+		return feeScheduleResponse{
+			Fee: &ff,
+		}, nil
+	}
+}
+
+type spendingLimitsResponse struct {
+	Limits []SpendingLimit `json:"limits,omitempty"`
+	Err    error           `json:"error,omitempty"`
+}
+
+func (r spendingLimitsResponse) error() error {
+	return r.Err
+}
+
+func makeSpendingLimitsEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		l, err := s.SpendingLimits(ctx)
+		return spendingLimitsResponse{
+			Limits: newSpendingLimits(l),
+			Err:    err,
+		}, nil
+	}
+}
+
+// setSpendingLimitRequest sets the spending limit of an account, or of a currency.
+// Exactly one of AccountID and Currency is required. Limits that are not given are unlimited.
+type setSpendingLimitRequest struct {
+	AccountID   string `json:"account_id"`
+	Currency    string `json:"currency"`
+	MaxAmount   string `json:"max_amount"`
+	DailyAmount string `json:"daily_amount"`
+	HourlyCount *int   `json:"hourly_count"`
+}
+
+type spendingLimitResponse struct {
+	Limit *SpendingLimit `json:"limit,omitempty"`
+	Err   error          `json:"error,omitempty"`
+}
+
+func (r spendingLimitResponse) error() error {
+	return r.Err
+}
+
+func makeSetSpendingLimitEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setSpendingLimitRequest)
+
+		if (req.AccountID == "") == (req.Currency == "") {
+			return nil, errAccountOrCurrency
+		}
+
+		limit := wallet.SpendingLimit{
+			Currency:    req.Currency,
+			HourlyCount: req.HourlyCount,
+		}
+
+		if req.AccountID != "" {
+			id, err := uuid.FromString(req.AccountID)
+			if err != nil {
+				return nil, errInvalidID{
+					Resource: "account",
+					Err:      err,
+					Field:    "account_id",
+				}
+			}
+			limit.AccountID = &id
+		}
+
+		var err error
+		if limit.MaxAmount, err = parseOptionalAmount(req.MaxAmount); err != nil {
+			return nil, err
+		}
+		if limit.DailyAmount, err = parseOptionalAmount(req.DailyAmount); err != nil {
+			return nil, err
+		}
+
+		l, err := s.SetSpendingLimit(ctx, limit)
+		if err != nil {
+			return spendingLimitResponse{
+				Err: err,
+			}, nil
+		}
+
+		ll := newSpendingLimit(*l)
+		return spendingLimitResponse{
+			Limit: &ll,
+		}, nil
+	}
+}
+
+type currenciesResponse struct {
+	Currencies []Currency `json:"currencies,omitempty"`
+	Err        error      `json:"error,omitempty"`
+}
+
+func (r currenciesResponse) error() error {
+	return r.Err
+}
+
+func makeCurrenciesEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, _ interface{}) (interface{}, error) {
+		c, err := s.Currencies(ctx)
+		return currenciesResponse{
+			Currencies: newCurrencies(c),
+			Err:        err,
+		}, nil
+	}
+}
+
+type setCurrencyRequest struct {
+	Code     string `json:"code"`
+	Exponent *int32 `json:"exponent"`
+	Enabled  *bool  `json:"enabled"`
+}
+
+type currencyResponse struct {
+	Currency *Currency `json:"currency,omitempty"`
+	Err      error     `json:"error,omitempty"`
+}
+
+func (r currencyResponse) error() error {
+	return r.Err
+}
+
+func makeSetCurrencyEndpoint(s wallet.Service) endpoint.Endpoint {
+	return func(ctx context.Context, request interface{}) (interface{}, error) {
+		req := request.(setCurrencyRequest)
+
+		if req.Code == "" {
+			return nil, errCodeRequired
+		}
+		if req.Exponent == nil {
+			return nil, errExponentRequired
+		}
+		if req.Enabled == nil {
+			return nil, errEnabledRequired
+		}
+
+		c, err := s.SetCurrency(ctx, req.Code, *req.Exponent, *req.Enabled)
+		if err != nil {
+			return currencyResponse{
+				Err: err,
+			}, nil
+		}
+
+		cc := newCurrency(*c)
+		return currencyResponse{
+			Currency: &cc,
 		}, nil
 	}
 }
@@ -0,0 +1,214 @@
+package transfer
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	// Register the hashes used by JWT signatures
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+// jwtAlgorithms are the hashes of the supported JWT signature algorithms,
+// HMAC (HS256, HS384, HS512) and RSA PKCS #1 v1.5 (RS256, RS384, RS512)
+var jwtAlgorithms = map[string]crypto.Hash{
+	"HS256": crypto.SHA256,
+	"HS384": crypto.SHA384,
+	"HS512": crypto.SHA512,
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+}
+
+var (
+	// errEmptyHMACKey is returned when creating a JWTVerifier with an empty HMAC key
+	errEmptyHMACKey = errors.New("HMAC key must not be empty")
+	// errNoPEMBlock is returned when loading an RSA public key from a file that is not PEM encoded
+	errNoPEMBlock = errors.New("No PEM block found")
+	// errNotRSAKey is returned when loading an RSA public key from a file that has another type of key
+	errNotRSAKey = errors.New("Public key is not an RSA key")
+)
+
+// errInvalidToken is returned if a request's bearer token is malformed, has an invalid signature,
+// or its claims are not valid at the current time
+type errInvalidToken struct {
+	Reason string
+}
+
+func (e errInvalidToken) Error() string {
+	return fmt.Sprintf("Invalid bearer token: %s", e.Reason)
+}
+
+// Claims are the claims of a JWT that are used by the service.
+// Times are seconds since the Unix epoch, and nil if the token does not have the claim.
+type Claims struct {
+	// Subject is the customer that the token was issued to
+	Subject   string   `json:"sub"`
+	ExpiresAt *float64 `json:"exp"`
+	NotBefore *float64 `json:"nbf"`
+}
+
+// JWTVerifier verifies the signatures and claims of JWTs issued to end users.
+// It is keyed with either an HMAC secret or an RSA public key, and only accepts tokens
+// signed with an algorithm of its type of key.
+type JWTVerifier struct {
+	hmacKey []byte
+	rsaKey  *rsa.PublicKey
+	now     func() time.Time
+}
+
+// NewHMACVerifier creates a JWTVerifier of tokens signed with HS256, HS384 or HS512 and key
+func NewHMACVerifier(key []byte) (*JWTVerifier, error) {
+	if len(key) == 0 {
+		return nil, errEmptyHMACKey
+	}
+	return &JWTVerifier{
+		hmacKey: key,
+		now:     time.Now,
+	}, nil
+}
+
+// NewRSAVerifier creates a JWTVerifier of tokens signed with RS256, RS384 or RS512 and verified with the public key key
+func NewRSAVerifier(key *rsa.PublicKey) *JWTVerifier {
+	return &JWTVerifier{
+		rsaKey: key,
+		now:    time.Now,
+	}
+}
+
+// LoadHMACVerifier creates a JWTVerifier with the HMAC key in a file.
+// Trailing newlines are not part of the key.
+func LoadHMACVerifier(path string) (*JWTVerifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewHMACVerifier(bytes.TrimRight(b, "\r\n"))
+}
+
+// LoadRSAVerifier creates a JWTVerifier with the PEM encoded RSA public key in a file,
+// either a PKIX "PUBLIC KEY" or a PKCS #1 "RSA PUBLIC KEY"
+func LoadRSAVerifier(path string) (*JWTVerifier, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errNoPEMBlock
+	}
+
+	if block.Type == "RSA PUBLIC KEY" {
+		key, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return NewRSAVerifier(key), nil
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errNotRSAKey
+	}
+	return NewRSAVerifier(rsaKey), nil
+}
+
+// Verify verifies the signature of a token in the JWS compact serialization and returns its claims.
+// Tokens must have a subject and an expiry time, and must not be used before their not before time.
+func (v *JWTVerifier) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errInvalidToken{Reason: "malformed token"}
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, errInvalidToken{Reason: "malformed header"}
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, errInvalidToken{Reason: "malformed signature"}
+	}
+
+	if err := v.verifySignature(header.Alg, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, errInvalidToken{Reason: "malformed claims"}
+	}
+
+	now := float64(v.now().Unix())
+	switch {
+	case claims.Subject == "":
+		return nil, errInvalidToken{Reason: "token has no subject"}
+	case claims.ExpiresAt == nil:
+		return nil, errInvalidToken{Reason: "token has no expiry time"}
+	case now >= *claims.ExpiresAt:
+		return nil, errInvalidToken{Reason: "token has expired"}
+	case claims.NotBefore != nil && now < *claims.NotBefore:
+		return nil, errInvalidToken{Reason: "token is not valid yet"}
+	}
+
+	return &claims, nil
+}
+
+// verifySignature verifies the signature of the signed part of a token with alg.
+// The algorithm must match the verifier's type of key, so that an RSA public key
+// can't be used as an HMAC secret.
+func (v *JWTVerifier) verifySignature(alg, signed string, sig []byte) error {
+	hash, ok := jwtAlgorithms[alg]
+	if !ok {
+		return errInvalidToken{Reason: fmt.Sprintf("unsupported algorithm %q", alg)}
+	}
+
+	switch {
+	case v.hmacKey != nil && strings.HasPrefix(alg, "HS"):
+		mac := hmac.New(hash.New, v.hmacKey)
+		mac.Write([]byte(signed)) //nolint:errcheck
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return errInvalidToken{Reason: "invalid signature"}
+		}
+		return nil
+
+	case v.rsaKey != nil && strings.HasPrefix(alg, "RS"):
+		h := hash.New()
+		h.Write([]byte(signed)) //nolint:errcheck
+		if err := rsa.VerifyPKCS1v15(v.rsaKey, hash, h.Sum(nil), sig); err != nil {
+			return errInvalidToken{Reason: "invalid signature"}
+		}
+		return nil
+
+	default:
+		return errInvalidToken{Reason: fmt.Sprintf("unexpected algorithm %q", alg)}
+	}
+}
+
+// decodeJWTPart decodes a base64url encoded JSON part of a token into v
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
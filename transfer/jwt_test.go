@@ -0,0 +1,261 @@
+package transfer
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// signJWT creates a token with claims, signed with alg and key, which is an HMAC key or *rsa.PrivateKey
+func signJWT(t *testing.T, alg string, key interface{}, claims map[string]interface{}) string {
+	header, err := json.Marshal(map[string]string{
+		"alg": alg,
+		"typ": "JWT",
+	})
+	require.NoError(t, err)
+	payload, err := json.Marshal(claims)
+	require.NoError(t, err)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(signed)) //nolint:errcheck
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := sha256.Sum256([]byte(signed))
+		sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, h[:])
+		require.NoError(t, err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+// writeTempFile writes b to a temporary file and returns its path
+func writeTempFile(t *testing.T, b []byte) string {
+	f, err := ioutil.TempFile("", "jwt-key")
+	require.NoError(t, err)
+	defer f.Close()
+	_, err = f.Write(b)
+	require.NoError(t, err)
+	return f.Name()
+}
+
+func TestJWTVerifier(t *testing.T) {
+	now := time.Date(2019, 6, 1, 12, 0, 0, 0, time.UTC)
+	hmacKey := []byte("secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	hmacVerifier, err := NewHMACVerifier(hmacKey)
+	require.NoError(t, err)
+	rsaVerifier := NewRSAVerifier(&rsaKey.PublicKey)
+
+	claims := func(exp, nbf time.Time) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": "c2f0a7d4-3b6e-4e8a-9f1d-5a7c3e9b2d4f",
+			"exp": exp.Unix(),
+		}
+		if !nbf.IsZero() {
+			c["nbf"] = nbf.Unix()
+		}
+		return c
+	}
+	valid := claims(now.Add(time.Hour), time.Time{})
+
+	cases := []struct {
+		name     string
+		verifier *JWTVerifier
+		token    string
+		err      error
+	}{
+		{
+			name:     "hmac",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "HS256", hmacKey, valid),
+		},
+
+		{
+			name:     "rsa",
+			verifier: rsaVerifier,
+			token:    signJWT(t, "RS256", rsaKey, valid),
+		},
+
+		{
+			name:     "not before",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "HS256", hmacKey, claims(now.Add(time.Hour), now.Add(-time.Minute))),
+		},
+
+		{
+			name:     "wrong hmac key",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "HS256", []byte("other"), valid),
+			err:      errInvalidToken{Reason: "invalid signature"},
+		},
+
+		{
+			name:     "hmac token for rsa key",
+			verifier: rsaVerifier,
+			token:    signJWT(t, "HS256", hmacKey, valid),
+			err:      errInvalidToken{Reason: `unexpected algorithm "HS256"`},
+		},
+
+		{
+			name:     "unsigned",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "none", nil, valid),
+			err:      errInvalidToken{Reason: `unsupported algorithm "none"`},
+		},
+
+		{
+			name:     "malformed",
+			verifier: hmacVerifier,
+			token:    "not-a-token",
+			err:      errInvalidToken{Reason: "malformed token"},
+		},
+
+		{
+			name:     "expired",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "HS256", hmacKey, claims(now, time.Time{})),
+			err:      errInvalidToken{Reason: "token has expired"},
+		},
+
+		{
+			name:     "not valid yet",
+			verifier: hmacVerifier,
+			token:    signJWT(t, "HS256", hmacKey, claims(now.Add(time.Hour), now.Add(time.Minute))),
+			err:      errInvalidToken{Reason: "token is not valid yet"},
+		},
+
+		{
+			name:     "no expiry time",
+			verifier: hmacVerifier,
+			token: signJWT(t, "HS256", hmacKey, map[string]interface{}{
+				"sub": "c2f0a7d4-3b6e-4e8a-9f1d-5a7c3e9b2d4f",
+			}),
+			err: errInvalidToken{Reason: "token has no expiry time"},
+		},
+
+		{
+			name:     "no subject",
+			verifier: hmacVerifier,
+			token: signJWT(t, "HS256", hmacKey, map[string]interface{}{
+				"exp": now.Add(time.Hour).Unix(),
+			}),
+			err: errInvalidToken{Reason: "token has no subject"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			v := *tc.verifier
+			v.now = func() time.Time {
+				return now
+			}
+
+			c, err := v.Verify(tc.token)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.Equal(t, "c2f0a7d4-3b6e-4e8a-9f1d-5a7c3e9b2d4f", c.Subject)
+		})
+	}
+}
+
+func TestLoadJWTVerifier(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	pkix, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	claims := map[string]interface{}{
+		"sub": "c2f0a7d4-3b6e-4e8a-9f1d-5a7c3e9b2d4f",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+
+	cases := []struct {
+		name  string
+		load  func(string) (*JWTVerifier, error)
+		file  []byte
+		token string
+		err   error
+	}{
+		{
+			name:  "hmac key with newline",
+			load:  LoadHMACVerifier,
+			file:  []byte("secret\n"),
+			token: signJWT(t, "HS256", []byte("secret"), claims),
+		},
+
+		{
+			name: "empty hmac key",
+			load: LoadHMACVerifier,
+			file: []byte("\n"),
+			err:  errEmptyHMACKey,
+		},
+
+		{
+			name: "pkix rsa key",
+			load: LoadRSAVerifier,
+			file: pem.EncodeToMemory(&pem.Block{
+				Type:  "PUBLIC KEY",
+				Bytes: pkix,
+			}),
+			token: signJWT(t, "RS256", rsaKey, claims),
+		},
+
+		{
+			name: "pkcs1 rsa key",
+			load: LoadRSAVerifier,
+			file: pem.EncodeToMemory(&pem.Block{
+				Type:  "RSA PUBLIC KEY",
+				Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey),
+			}),
+			token: signJWT(t, "RS256", rsaKey, claims),
+		},
+
+		{
+			name: "not pem",
+			load: LoadRSAVerifier,
+			file: []byte("secret"),
+			err:  errNoPEMBlock,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTempFile(t, tc.file)
+			defer os.Remove(path)
+
+			v, err := tc.load(path)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+
+			_, err = v.Verify(tc.token)
+			require.NoError(t, err)
+		})
+	}
+}
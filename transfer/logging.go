@@ -24,38 +24,515 @@ func NewLoggingService(logger log.Logger, s wallet.Service) wallet.Service {
 	}
 }
 
-func (s loggingService) Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal) (p *wallet.Payment, err error) {
+func (s loggingService) Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (p *wallet.Payment, err error) {
 	defer func(begin time.Time) {
 		logger := s.logger
 		if err != nil {
 			logger = log.With(logger, "err", err)
 		}
-		logger.Log("operation", "transfer", "to", to, "from", from, "amount", amount, "took", time.Since(begin))
+		logger.Log("operation", "transfer", "to", to, "from", from, "amount", amount, "idempotency_key", idempotencyKey, "took", time.Since(begin))
 	}(time.Now())
 
-	return s.Service.Transfer(ctx, to, from, amount)
+	return s.Service.Transfer(ctx, to, from, amount, idempotencyKey)
 }
 
-func (s loggingService) Payments(ctx context.Context) (p []wallet.Payment, err error) {
+func (s loggingService) TransferBatch(ctx context.Context, transfers []wallet.TransferRequest, mode string) (results []wallet.TransferResult, err error) {
 	defer func(begin time.Time) {
 		logger := s.logger
 		if err != nil {
 			logger = log.With(logger, "err", err)
 		}
-		logger.Log("operation", "payments", "took", time.Since(begin))
+		failed := 0
+		for _, r := range results {
+			if r.Err != nil {
+				failed++
+			}
+		}
+		logger.Log("operation", "transfer_batch", "transfers", len(transfers), "mode", mode, "failed", failed, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.TransferBatch(ctx, transfers, mode)
+}
+
+func (s loggingService) ScheduleTransfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, executeAt time.Time) (st *wallet.ScheduledTransfer, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "schedule_transfer", "to", to, "from", from, "amount", amount, "execute_at", executeAt, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ScheduleTransfer(ctx, to, from, amount, executeAt)
+}
+
+func (s loggingService) ScheduledTransfers(ctx context.Context, status string, page wallet.Page) (st []wallet.ScheduledTransfer, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "scheduled_transfers", "status", status, "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ScheduledTransfers(ctx, status, page)
+}
+
+func (s loggingService) CancelScheduledTransfer(ctx context.Context, id uuid.UUID) (st *wallet.ScheduledTransfer, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "cancel_scheduled_transfer", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CancelScheduledTransfer(ctx, id)
+}
+
+func (s loggingService) ExecuteScheduledTransfers(ctx context.Context) (n int, err error) {
+	defer func(begin time.Time) {
+		// Nothing is logged if no transfers were due, since this is called periodically
+		if n == 0 && err == nil {
+			return
+		}
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "execute_scheduled_transfers", "processed", n, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ExecuteScheduledTransfers(ctx)
+}
+
+func (s loggingService) CreateStandingOrder(ctx context.Context, order wallet.StandingOrder) (o *wallet.StandingOrder, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "create_standing_order", "to", order.To, "from", order.From, "amount", order.Amount,
+			"frequency", order.Frequency, "interval", order.Interval, "start_at", order.StartAt, "end_at", order.EndAt, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CreateStandingOrder(ctx, order)
+}
+
+func (s loggingService) StandingOrders(ctx context.Context, page wallet.Page) (o []wallet.StandingOrder, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "standing_orders", "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.StandingOrders(ctx, page)
+}
+
+func (s loggingService) StandingOrder(ctx context.Context, id uuid.UUID) (o *wallet.StandingOrder, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "standing_order", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.StandingOrder(ctx, id)
+}
+
+func (s loggingService) UpdateStandingOrder(ctx context.Context, id uuid.UUID, amount *apd.Decimal, endAt *time.Time) (o *wallet.StandingOrder, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "update_standing_order", "id", id, "amount", amount, "end_at", endAt, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.UpdateStandingOrder(ctx, id, amount, endAt)
+}
+
+func (s loggingService) CancelStandingOrder(ctx context.Context, id uuid.UUID) (o *wallet.StandingOrder, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "cancel_standing_order", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CancelStandingOrder(ctx, id)
+}
+
+func (s loggingService) StandingOrderOccurrences(ctx context.Context, id uuid.UUID, page wallet.Page) (o []wallet.StandingOrderOccurrence, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "standing_order_occurrences", "id", id, "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.StandingOrderOccurrences(ctx, id, page)
+}
+
+func (s loggingService) ExecuteStandingOrders(ctx context.Context) (occurrences []wallet.StandingOrderOccurrence, err error) {
+	defer func(begin time.Time) {
+		// Nothing is logged if no occurrences were due, since this is called periodically
+		if len(occurrences) == 0 && err == nil {
+			return
+		}
+
+		// Each failed attempt is reported, so that the owner of the order can be told
+		for _, o := range occurrences {
+			if o.Status == wallet.OccurrenceExecuted {
+				continue
+			}
+			s.logger.Log("operation", "standing_order_occurrence", "standing_order_id", o.StandingOrderID, "scheduled_at", o.ScheduledAt,
+				"status", o.Status, "attempts", o.Attempts, "err", o.Error)
+		}
+
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "execute_standing_orders", "processed", len(occurrences), "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ExecuteStandingOrders(ctx)
+}
+
+func (s loggingService) Hold(ctx context.Context, from uuid.UUID, amount *apd.Decimal, expiresAt time.Time) (h *wallet.Hold, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "hold", "from", from, "amount", amount, "expires_at", expiresAt, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Hold(ctx, from, amount, expiresAt)
+}
+
+func (s loggingService) GetHold(ctx context.Context, id uuid.UUID) (h *wallet.Hold, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "get_hold", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.GetHold(ctx, id)
+}
+
+func (s loggingService) Capture(ctx context.Context, holdID, to uuid.UUID, amount *apd.Decimal) (p *wallet.Payment, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "capture", "hold_id", holdID, "to", to, "amount", amount, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Capture(ctx, holdID, to, amount)
+}
+
+func (s loggingService) Release(ctx context.Context, holdID uuid.UUID) (h *wallet.Hold, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "release", "hold_id", holdID, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Release(ctx, holdID)
+}
+
+func (s loggingService) ExpireHolds(ctx context.Context) (n int, err error) {
+	defer func(begin time.Time) {
+		// Nothing is logged if no holds expired, since this is called periodically
+		if n == 0 && err == nil {
+			return
+		}
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "expire_holds", "expired", n, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ExpireHolds(ctx)
+}
+
+func (s loggingService) Deposit(ctx context.Context, to uuid.UUID, amount *apd.Decimal, idempotencyKey string) (p *wallet.Payment, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "deposit", "to", to, "amount", amount, "idempotency_key", idempotencyKey, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Deposit(ctx, to, amount, idempotencyKey)
+}
+
+func (s loggingService) Withdraw(ctx context.Context, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (p *wallet.Payment, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "withdraw", "from", from, "amount", amount, "idempotency_key", idempotencyKey, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Withdraw(ctx, from, amount, idempotencyKey)
+}
+
+func (s loggingService) Refund(ctx context.Context, paymentID uuid.UUID, amount *apd.Decimal, idempotencyKey string) (p *wallet.Payment, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "refund", "payment_id", paymentID, "amount", amount, "idempotency_key", idempotencyKey, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Refund(ctx, paymentID, amount, idempotencyKey)
+}
+
+func (s loggingService) Payments(ctx context.Context, page wallet.Page) (p []wallet.Payment, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "payments", "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Payments(ctx, page)
+}
+
+func (s loggingService) Accounts(ctx context.Context, page wallet.Page) (a []wallet.Account, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "accounts", "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Accounts(ctx, page)
+}
+
+func (s loggingService) Account(ctx context.Context, id uuid.UUID) (a *wallet.Account, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "account", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Account(ctx, id)
+}
+
+func (s loggingService) AccountPayments(ctx context.Context, id uuid.UUID, r wallet.TimeRange, page wallet.Page) (p []wallet.AccountPayment, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "account_payments", "id", id, "since", r.Since, "until", r.Until, "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.AccountPayments(ctx, id, r, page)
+}
+
+func (s loggingService) ExchangeRates(ctx context.Context) (r []wallet.ExchangeRate, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "exchange_rates", "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.ExchangeRates(ctx)
+}
+
+func (s loggingService) SetExchangeRate(ctx context.Context, from, to string, rate *apd.Decimal) (r *wallet.ExchangeRate, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "set_exchange_rate", "from", from, "to", to, "rate", rate, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.SetExchangeRate(ctx, from, to, rate)
+}
+
+func (s loggingService) FeeSchedules(ctx context.Context) (f []wallet.FeeSchedule, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "fee_schedules", "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.FeeSchedules(ctx)
+}
+
+func (s loggingService) SetFeeSchedule(ctx context.Context, schedule wallet.FeeSchedule) (f *wallet.FeeSchedule, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "set_fee_schedule", "currency", schedule.Currency, "flat", schedule.Flat, "percent", schedule.Percent, "min", schedule.Min, "max", schedule.Max, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.SetFeeSchedule(ctx, schedule)
+}
+
+func (s loggingService) SpendingLimits(ctx context.Context) (l []wallet.SpendingLimit, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "spending_limits", "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.SpendingLimits(ctx)
+}
+
+func (s loggingService) SetSpendingLimit(ctx context.Context, limit wallet.SpendingLimit) (l *wallet.SpendingLimit, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		var hourlyCount interface{}
+		if limit.HourlyCount != nil {
+			hourlyCount = *limit.HourlyCount
+		}
+		logger.Log("operation", "set_spending_limit", "account_id", limit.AccountID, "currency", limit.Currency, "max_amount", limit.MaxAmount, "daily_amount", limit.DailyAmount, "hourly_count", hourlyCount, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.SetSpendingLimit(ctx, limit)
+}
+
+func (s loggingService) Currencies(ctx context.Context) (c []wallet.Currency, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "currencies", "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Currencies(ctx)
+}
+
+func (s loggingService) SetCurrency(ctx context.Context, code string, exponent int32, enabled bool) (c *wallet.Currency, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "set_currency", "code", code, "exponent", exponent, "enabled", enabled, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.SetCurrency(ctx, code, exponent, enabled)
+}
+
+func (s loggingService) CreateAccount(ctx context.Context, id uuid.UUID, currency string, ownerID *uuid.UUID) (a *wallet.Account, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "create_account", "id", id, "currency", currency, "owner_id", ownerID, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CreateAccount(ctx, id, currency, ownerID)
+}
+
+func (s loggingService) CreateCustomer(ctx context.Context, id uuid.UUID, name string) (c *wallet.Customer, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "create_customer", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CreateCustomer(ctx, id, name)
+}
+
+func (s loggingService) Customer(ctx context.Context, id uuid.UUID) (c *wallet.Customer, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "customer", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.Customer(ctx, id)
+}
+
+func (s loggingService) CustomerAccounts(ctx context.Context, id uuid.UUID, page wallet.Page) (a []wallet.Account, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "customer_accounts", "id", id, "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CustomerAccounts(ctx, id, page)
+}
+
+func (s loggingService) CustomerBalances(ctx context.Context, id uuid.UUID) (b []wallet.CustomerBalance, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "customer_balances", "id", id, "took", time.Since(begin))
+	}(time.Now())
+
+	return s.Service.CustomerBalances(ctx, id)
+}
+
+func (s loggingService) CustomerPayments(ctx context.Context, id uuid.UUID, page wallet.Page) (p []wallet.Payment, next string, err error) {
+	defer func(begin time.Time) {
+		logger := s.logger
+		if err != nil {
+			logger = log.With(logger, "err", err)
+		}
+		logger.Log("operation", "customer_payments", "id", id, "cursor", page.Cursor, "limit", page.Limit, "took", time.Since(begin))
 	}(time.Now())
 
-	return s.Service.Payments(ctx)
+	return s.Service.CustomerPayments(ctx, id, page)
 }
 
-func (s loggingService) Accounts(ctx context.Context) (a []wallet.Account, err error) {
+func (s loggingService) SetAccountStatus(ctx context.Context, id uuid.UUID, status string) (a *wallet.Account, err error) {
 	defer func(begin time.Time) {
 		logger := s.logger
 		if err != nil {
 			logger = log.With(logger, "err", err)
 		}
-		logger.Log("operation", "accounts", "took", time.Since(begin))
+		logger.Log("operation", "set_account_status", "id", id, "status", status, "took", time.Since(begin))
 	}(time.Now())
 
-	return s.Service.Accounts(ctx)
+	return s.Service.SetAccountStatus(ctx, id, status)
 }
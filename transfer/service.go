@@ -2,11 +2,16 @@
 package transfer
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/cockroachdb/apd"
-	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
 
 	wallet "github.com/xsleonard/gokit-example"
@@ -16,49 +21,724 @@ import (
 var (
 	// errSameAccount is returned if a transfer's sender and receiver are the same account
 	errSameAccount = errors.New("Transfers must be between different accounts")
-	// errInsufficientBalance is returned if an account's balance is less than
-	// an amount requested to be transferred
+	// errInsufficientBalance is returned if an account's available balance is less than
+	// an amount requested to be transferred or held
 	errInsufficientBalance = errors.New("Account has an insufficient balance")
-	// errDifferentCurrency is returned if a transfer is requested between accounts
-	// that have different currencies
-	errDifferentCurrency = errors.New("Transfers must use the same currency")
+	// errConvertedAmountTooSmall is returned if a transfer between accounts of
+	// different currencies would credit nothing after conversion
+	errConvertedAmountTooSmall = errors.New("Amount is too small to convert to the receiving account's currency")
+	// errNotRefundable is returned when refunding a refund, or a credit made without a sender
+	errNotRefundable = errors.New("Payment cannot be refunded")
+	// errAlreadyRefunded is returned when refunding a payment that has been fully refunded
+	errAlreadyRefunded = errors.New("Payment has already been fully refunded")
+	// errRefundExceedsPayment is returned if a refund's amount is more than the
+	// amount of the payment that has not been refunded
+	errRefundExceedsPayment = errors.New("Refund amount exceeds the unrefunded amount of the payment")
+	// errSameCurrency is returned if an exchange rate is set from a currency to itself
+	errSameCurrency = errors.New("Exchange rates must be between different currencies")
+	// errInvalidCurrency is returned for unrecognized currency codes
+	errInvalidCurrency = errors.New("Invalid currency code")
+	// errCurrencyDisabled is returned if a disabled currency is used for a new account or exchange rate
+	errCurrencyDisabled = errors.New("Currency is not enabled")
+	// errInvalidExponent is returned if a currency's exponent is out of range
+	errInvalidExponent = fmt.Errorf("Currency exponent must be between 0 and %d", maxCurrencyExponent)
+	// errInvalidLimit is returned if a page limit is out of range
+	errInvalidLimit = fmt.Errorf("Limit must be between 1 and %d", maxPageLimit)
+	// errInvalidTimeRange is returned if the start of a time range is after its end
+	errInvalidTimeRange = errors.New("since must not be after until")
+	// errInvalidPercent is returned if a fee schedule's percent is out of range
+	errInvalidPercent = errors.New("Percent must be between 0 and 100")
+	// errFeeMinExceedsMax is returned if a fee schedule's minimum fee is more than its maximum fee
+	errFeeMinExceedsMax = errors.New("Minimum fee must not be more than the maximum fee")
+	// errInvalidBatchSize is returned if a batch of transfers is empty or has more than maxBatchSize transfers
+	errInvalidBatchSize = fmt.Errorf("A batch must have between 1 and %d transfers", maxBatchSize)
+	// errInvalidBatchMode is returned for an unrecognized batch mode
+	errInvalidBatchMode = fmt.Errorf("Mode must be %q or %q", wallet.BatchModeAtomic, wallet.BatchModeBestEffort)
+	// errExecuteAtNotInFuture is returned when scheduling a transfer at a time that has passed
+	errExecuteAtNotInFuture = errors.New("execute_at must be in the future")
+	// errInvalidScheduledTransferStatus is returned for an unrecognized scheduled transfer status
+	errInvalidScheduledTransferStatus = errors.New("Invalid scheduled transfer status")
+	// errStartAtNotInFuture is returned when creating a standing order that starts at a time that has passed
+	errStartAtNotInFuture = errors.New("start_at must be in the future")
+	// errEndAtBeforeStartAt is returned if a standing order would end before it starts
+	errEndAtBeforeStartAt = errors.New("end_at must not be before start_at")
+	// errInvalidFrequency is returned for an unrecognized standing order frequency
+	errInvalidFrequency = fmt.Errorf("Frequency must be %q, %q or %q", wallet.FrequencyDaily, wallet.FrequencyWeekly, wallet.FrequencyMonthly)
+	// errInvalidInterval is returned if a standing order's interval is out of range
+	errInvalidInterval = fmt.Errorf("Interval must be between 1 and %d", maxStandingOrderInterval)
+	// errInvalidHoldExpiry is returned if a hold would expire at a time that has passed, or too far in the future
+	errInvalidHoldExpiry = fmt.Errorf("expires_at must be in the future, and within %d days", maxHoldExpiry/(24*time.Hour))
+	// errHoldExpired is returned when capturing a hold that has passed its expiry, but has not been expired yet
+	errHoldExpired = errors.New("Hold has expired")
+	// errCaptureExceedsHold is returned if a capture's amount is more than the amount of the hold
+	errCaptureExceedsHold = errors.New("Capture amount exceeds the amount of the hold")
+	// errAccountFrozen is returned when debiting a frozen account
+	errAccountFrozen = errors.New("Account is frozen")
+	// errAccountClosed is returned when debiting or crediting a closed account, or changing its status
+	errAccountClosed = errors.New("Account is closed")
+	// errAccountBalanceNotZero is returned when closing an account whose balance is not zero
+	errAccountBalanceNotZero = errors.New("Account balance must be zero to close the account")
+	// errInvalidAccountStatus is returned for an unrecognized account status
+	errInvalidAccountStatus = fmt.Errorf("Status must be %q, %q or %q", wallet.AccountActive, wallet.AccountFrozen, wallet.AccountClosed)
+	// errInvalidHourlyCount is returned if a spending limit's hourly count is not more than zero
+	errInvalidHourlyCount = errors.New("hourly_count must be more than 0")
+	// errInvalidCustomerName is returned if a customer's name is blank or longer than maxCustomerNameLength
+	errInvalidCustomerName = fmt.Errorf("Customer name must not be blank or longer than %d characters", maxCustomerNameLength)
+	// errIdempotencyKeyTooLong is returned if an idempotency key is longer than maxIdempotencyKeyLength
+	errIdempotencyKeyTooLong = fmt.Errorf("Idempotency key must not be longer than %d characters", maxIdempotencyKeyLength)
 )
 
+const (
+	// maxIdempotencyKeyLength is the maximum length of an idempotency key
+	maxIdempotencyKeyLength = 255
+	// maxCustomerNameLength is the maximum length of a customer's name
+	maxCustomerNameLength = 255
+	// maxPageLimit is the maximum number of results that can be requested in a page
+	maxPageLimit = 1000
+	// maxBatchSize is the maximum number of transfers in a batch
+	maxBatchSize = 1000
+	// maxCurrencyExponent is the maximum number of decimal places of a currency's minor unit
+	maxCurrencyExponent = 4
+	// maxStandingOrderInterval is the maximum number of days, weeks or months between occurrences of a standing order
+	maxStandingOrderInterval = 365
+	// maxOccurrenceAttempts is the number of times an occurrence of a standing order is
+	// attempted before it is recorded as failed
+	maxOccurrenceAttempts = 3
+	// occurrenceRetryDelay is the time after which a failed occurrence of a standing order is attempted again
+	occurrenceRetryDelay = time.Hour
+	// defaultHoldExpiry is the time after which a hold expires, if its expiry is not given
+	defaultHoldExpiry = 7 * 24 * time.Hour
+	// maxHoldExpiry is the maximum time after which a hold can expire
+	maxHoldExpiry = 30 * 24 * time.Hour
+	// dailySpendingPeriod and hourlySpendingPeriod are the periods of the spending limits,
+	// which end at the time of the transfer
+	dailySpendingPeriod  = 24 * time.Hour
+	hourlySpendingPeriod = time.Hour
+)
+
+// Names of the spending limits, as they are named in the API
+const (
+	limitMaxAmount   = "max_amount"
+	limitDailyAmount = "daily_amount"
+	limitHourlyCount = "hourly_count"
+)
+
+// errBatchTransfer is returned if a transfer of an atomic batch fails.
+// Index is the position of the transfer in the batch.
+type errBatchTransfer struct {
+	Index int
+	Err   error
+}
+
+func (e errBatchTransfer) Error() string {
+	return fmt.Sprintf("transfers[%d]: %v", e.Index, e.Err)
+}
+
+// errLimitExceeded is returned if a transfer would exceed a spending limit of the sending account.
+// Remaining is what is left of the limit: an amount for the amount limits, and a number of transfers
+// for the hourly count.
+type errLimitExceeded struct {
+	Limit     string
+	Remaining string
+}
+
+func (e errLimitExceeded) Error() string {
+	return fmt.Sprintf("Spending limit %s exceeded, the remaining allowance is %s", e.Limit, e.Remaining)
+}
+
+// currencyCodeRegexp matches ISO 4217 currency codes
+var currencyCodeRegexp = regexp.MustCompile(`^[A-Z]{3}$`)
+
 type service struct {
-	accounts wallet.AccountRepository
-	payments wallet.PaymentRepository
+	uow        wallet.UnitOfWork
+	accounts   wallet.AccountRepository
+	payments   wallet.PaymentRepository
+	rates      wallet.RateRepository
+	currencies wallet.CurrencyRepository
+	fees       wallet.FeeRepository
+	scheduled  wallet.ScheduledTransferRepository
+	standing   wallet.StandingOrderRepository
+	holds      wallet.HoldRepository
+	limits     wallet.LimitRepository
+	customers  wallet.CustomerRepository
+	rounding   string
 }
 
-// NewService creates a wallet.Service
-func NewService(accounts wallet.AccountRepository, payments wallet.PaymentRepository) wallet.Service {
+// NewService creates a wallet.Service.
+// rounding is the apd rounding mode used when converting amounts between currencies
+// and calculating fees, see decimal.IsValidRounding.
+func NewService(uow wallet.UnitOfWork, accounts wallet.AccountRepository, payments wallet.PaymentRepository, rates wallet.RateRepository, currencies wallet.CurrencyRepository, fees wallet.FeeRepository, scheduled wallet.ScheduledTransferRepository, standing wallet.StandingOrderRepository, holds wallet.HoldRepository, limits wallet.LimitRepository, customers wallet.CustomerRepository, rounding string) wallet.Service {
 	return service{
-		accounts: accounts,
-		payments: payments,
+		uow:        uow,
+		accounts:   accounts,
+		payments:   payments,
+		rates:      rates,
+		currencies: currencies,
+		fees:       fees,
+		scheduled:  scheduled,
+		standing:   standing,
+		holds:      holds,
+		limits:     limits,
+		customers:  customers,
+		rounding:   rounding,
+	}
+}
+
+func (s service) Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	p, err := makeTransfer(to, from, amount, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return s.transferTx(ctx, tx, p)
+	}); err != nil {
+		return nil, err
 	}
+
+	return p, nil
 }
 
-func (s service) Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal) (*wallet.Payment, error) {
-	if err := decimal.ValidateTransferAmount(amount); err != nil {
+func (s service) TransferBatch(ctx context.Context, transfers []wallet.TransferRequest, mode string) ([]wallet.TransferResult, error) {
+	if len(transfers) == 0 || len(transfers) > maxBatchSize {
+		return nil, errInvalidBatchSize
+	}
+
+	atomic := mode == wallet.BatchModeAtomic
+	if !atomic && mode != wallet.BatchModeBestEffort {
+		return nil, errInvalidBatchMode
+	}
+
+	results := make([]wallet.TransferResult, len(transfers))
+	payments := make([]*wallet.Payment, len(transfers))
+	for i, t := range transfers {
+		p, err := makeTransfer(t.To, t.From, t.Amount, "")
+		if err != nil {
+			if atomic {
+				return nil, errBatchTransfer{
+					Index: i,
+					Err:   err,
+				}
+			}
+			results[i].Err = err
+			continue
+		}
+		payments[i] = p
+	}
+
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		if err := s.lockBatchAccountsTx(ctx, tx, payments); err != nil {
+			return err
+		}
+
+		for i, p := range payments {
+			if p == nil {
+				continue
+			}
+
+			if atomic {
+				if err := s.transferTx(ctx, tx, p); err != nil {
+					return errBatchTransfer{
+						Index: i,
+						Err:   err,
+					}
+				}
+				continue
+			}
+
+			// Each transfer is made in a savepoint, so that a failed transfer
+			// is rolled back without rolling back the others
+			if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+				return s.transferTx(ctx, tx, p)
+			}); err != nil {
+				results[i].Err = err
+			}
+		}
+
+		return nil
+	}); err != nil {
 		return nil, err
 	}
 
+	for i, p := range payments {
+		if p != nil && results[i].Err == nil {
+			results[i].Payment = p
+		}
+	}
+
+	return results, nil
+}
+
+func (s service) ScheduleTransfer(ctx context.Context, to, from uuid.UUID, amount *apd.Decimal, executeAt time.Time) (*wallet.ScheduledTransfer, error) {
 	if uuid.Equal(to, from) {
 		return nil, errSameAccount
 	}
 
-	paymentID, err := uuid.NewV4()
+	if err := decimal.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+
+	if !executeAt.After(time.Now()) {
+		return nil, errExecuteAtNotInFuture
+	}
+
+	// The accounts are checked now, so that a transfer that can never be made isn't scheduled.
+	// The balance, exchange rate and fee are checked when the transfer is made.
+	accounts := make([]*wallet.Account, 2)
+	for i, id := range []uuid.UUID{to, from} {
+		a, err := s.accounts.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !a.IsUser() {
+			return nil, wallet.ErrNoAccount
+		}
+		accounts[i] = a
+	}
+
+	if _, err := s.validateAmount(ctx, amount, accounts[1].Currency); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
 	if err != nil {
 		return nil, err
 	}
 
-	p := &wallet.Payment{
-		ID:     paymentID,
-		To:     to,
-		From:   &from,
-		Amount: amount,
+	st := &wallet.ScheduledTransfer{
+		ID:        id,
+		To:        to,
+		From:      from,
+		Amount:    amount,
+		ExecuteAt: executeAt,
+		APIKeyID:  apiKeyIDFromContext(ctx),
+	}
+	if err := s.scheduled.Store(ctx, st); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (s service) ScheduledTransfers(ctx context.Context, status string, page wallet.Page) ([]wallet.ScheduledTransfer, string, error) {
+	switch status {
+	case "",
+		wallet.ScheduledTransferPending,
+		wallet.ScheduledTransferExecuted,
+		wallet.ScheduledTransferFailed,
+		wallet.ScheduledTransferCanceled:
+	default:
+		return nil, "", errInvalidScheduledTransferStatus
+	}
+
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	return s.scheduled.List(ctx, status, page)
+}
+
+func (s service) CancelScheduledTransfer(ctx context.Context, id uuid.UUID) (*wallet.ScheduledTransfer, error) {
+	var st *wallet.ScheduledTransfer
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The scheduled transfer is locked, so that it can't be executed while it is canceled
+		var err error
+		st, err = s.scheduled.GetTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if st.Status != wallet.ScheduledTransferPending {
+			return wallet.ErrScheduledTransferNotPending
+		}
+
+		st.Status = wallet.ScheduledTransferCanceled
+		return s.scheduled.UpdateTx(ctx, tx, st)
+	}); err != nil {
+		return nil, err
+	}
+
+	return st, nil
+}
+
+func (s service) ExecuteScheduledTransfers(ctx context.Context) (int, error) {
+	n := 0
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+
+		done := false
+		if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			st, err := s.scheduled.NextDueTx(ctx, tx)
+			if err == wallet.ErrNoScheduledTransfer {
+				done = true
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			// The transfer is made in a savepoint of this transaction, so that a failed
+			// transfer is rolled back and the failure is recorded instead.
+			// Failed transfers are not retried. The payment records the API key that
+			// scheduled the transfer.
+			p, err := s.Transfer(contextWithAPIKeyID(ctx, st.APIKeyID), st.To, st.From, st.Amount, "")
+			if err != nil {
+				st.Status = wallet.ScheduledTransferFailed
+				st.Error = err.Error()
+			} else {
+				st.Status = wallet.ScheduledTransferExecuted
+				st.PaymentID = &p.ID
+			}
+
+			return s.scheduled.UpdateTx(ctx, tx, st)
+		}); err != nil {
+			return n, err
+		}
+
+		if done {
+			return n, nil
+		}
+		n++
+	}
+}
+
+func (s service) CreateStandingOrder(ctx context.Context, order wallet.StandingOrder) (*wallet.StandingOrder, error) {
+	if uuid.Equal(order.To, order.From) {
+		return nil, errSameAccount
+	}
+
+	if err := decimal.ValidateAmount(order.Amount); err != nil {
+		return nil, err
+	}
+
+	switch order.Frequency {
+	case wallet.FrequencyDaily, wallet.FrequencyWeekly, wallet.FrequencyMonthly:
+	default:
+		return nil, errInvalidFrequency
+	}
+
+	if order.Interval < 1 || order.Interval > maxStandingOrderInterval {
+		return nil, errInvalidInterval
+	}
+
+	if !order.StartAt.After(time.Now()) {
+		return nil, errStartAtNotInFuture
+	}
+
+	if order.EndAt != nil && order.EndAt.Before(order.StartAt) {
+		return nil, errEndAtBeforeStartAt
+	}
+
+	// The accounts are checked now, so that an order that can never be made isn't created.
+	// The balance, exchange rate and fee are checked when each occurrence is made.
+	accounts := make([]*wallet.Account, 2)
+	for i, id := range []uuid.UUID{order.To, order.From} {
+		a, err := s.accounts.Get(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !a.IsUser() {
+			return nil, wallet.ErrNoAccount
+		}
+		accounts[i] = a
+	}
+
+	if _, err := s.validateAmount(ctx, order.Amount, accounts[1].Currency); err != nil {
+		return nil, err
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	o := &wallet.StandingOrder{
+		ID:        id,
+		To:        order.To,
+		From:      order.From,
+		Amount:    order.Amount,
+		Frequency: order.Frequency,
+		Interval:  order.Interval,
+		StartAt:   order.StartAt,
+		EndAt:     order.EndAt,
+		NextRunAt: order.StartAt,
+		APIKeyID:  apiKeyIDFromContext(ctx),
+	}
+	if err := s.standing.Store(ctx, o); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (s service) StandingOrders(ctx context.Context, page wallet.Page) ([]wallet.StandingOrder, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	return s.standing.List(ctx, page)
+}
+
+func (s service) StandingOrder(ctx context.Context, id uuid.UUID) (*wallet.StandingOrder, error) {
+	return s.standing.Get(ctx, id)
+}
+
+func (s service) UpdateStandingOrder(ctx context.Context, id uuid.UUID, amount *apd.Decimal, endAt *time.Time) (*wallet.StandingOrder, error) {
+	if err := decimal.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+
+	var o *wallet.StandingOrder
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The order is locked, so that it can't be executed while it is updated
+		var err error
+		o, err = s.standing.GetTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if o.Status != wallet.StandingOrderActive {
+			return wallet.ErrStandingOrderNotActive
+		}
+
+		if endAt != nil && endAt.Before(o.StartAt) {
+			return errEndAtBeforeStartAt
+		}
+
+		from, err := s.accounts.Get(ctx, o.From)
+		if err != nil {
+			return err
+		}
+		if _, err := s.validateAmount(ctx, amount, from.Currency); err != nil {
+			return err
+		}
+
+		o.Amount = amount
+		o.EndAt = endAt
+		completeIfEnded(o)
+		return s.standing.UpdateTx(ctx, tx, o)
+	}); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (s service) CancelStandingOrder(ctx context.Context, id uuid.UUID) (*wallet.StandingOrder, error) {
+	var o *wallet.StandingOrder
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The order is locked, so that it can't be executed while it is canceled
+		var err error
+		o, err = s.standing.GetTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if o.Status != wallet.StandingOrderActive {
+			return wallet.ErrStandingOrderNotActive
+		}
+
+		o.Status = wallet.StandingOrderCanceled
+		return s.standing.UpdateTx(ctx, tx, o)
+	}); err != nil {
+		return nil, err
+	}
+
+	return o, nil
+}
+
+func (s service) StandingOrderOccurrences(ctx context.Context, id uuid.UUID, page wallet.Page) ([]wallet.StandingOrderOccurrence, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	if _, err := s.standing.Get(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	return s.standing.Occurrences(ctx, id, page)
+}
+
+func (s service) ExecuteStandingOrders(ctx context.Context) ([]wallet.StandingOrderOccurrence, error) {
+	var attempted []wallet.StandingOrderOccurrence
+	for {
+		if err := ctx.Err(); err != nil {
+			return attempted, err
+		}
+
+		var occurrence *wallet.StandingOrderOccurrence
+		if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			o, err := s.standing.NextDueTx(ctx, tx)
+			if err == wallet.ErrNoStandingOrder {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+
+			id, err := uuid.NewV4()
+			if err != nil {
+				return err
+			}
+
+			occurrence = &wallet.StandingOrderOccurrence{
+				ID:              id,
+				StandingOrderID: o.ID,
+				ScheduledAt:     o.OccurrenceAt(o.Occurrences),
+				Attempts:        o.FailedAttempts + 1,
+			}
+
+			// The transfer is made in a savepoint of this transaction, so that a failed
+			// transfer is rolled back and the failure is recorded instead.
+			// The payment records the API key that created the order.
+			p, err := s.Transfer(contextWithAPIKeyID(ctx, o.APIKeyID), o.To, o.From, o.Amount, "")
+			switch {
+			case err == nil:
+				occurrence.Status = wallet.OccurrenceExecuted
+				occurrence.PaymentID = &p.ID
+				nextOccurrence(o)
+			case occurrence.Attempts < maxOccurrenceAttempts:
+				occurrence.Status = wallet.OccurrenceRetrying
+				occurrence.Error = err.Error()
+				o.FailedAttempts = occurrence.Attempts
+				o.NextRunAt = time.Now().Add(occurrenceRetryDelay)
+			default:
+				occurrence.Status = wallet.OccurrenceFailed
+				occurrence.Error = err.Error()
+				nextOccurrence(o)
+			}
+
+			if err := s.standing.StoreOccurrenceTx(ctx, tx, occurrence); err != nil {
+				return err
+			}
+			return s.standing.UpdateTx(ctx, tx, o)
+		}); err != nil {
+			return attempted, err
+		}
+
+		if occurrence == nil {
+			return attempted, nil
+		}
+		attempted = append(attempted, *occurrence)
+	}
+}
+
+// nextOccurrence moves a standing order on to its next occurrence, after the
+// current one was executed or failed on every attempt.
+// Occurrences that were missed are made one after another, until the order catches up.
+func nextOccurrence(o *wallet.StandingOrder) {
+	o.Occurrences++
+	o.FailedAttempts = 0
+	o.NextRunAt = o.OccurrenceAt(o.Occurrences)
+	completeIfEnded(o)
+}
+
+// completeIfEnded completes a standing order if its next occurrence is after its end
+func completeIfEnded(o *wallet.StandingOrder) {
+	if o.EndAt != nil && o.OccurrenceAt(o.Occurrences).After(*o.EndAt) {
+		o.Status = wallet.StandingOrderCompleted
+	}
+}
+
+func (s service) Hold(ctx context.Context, from uuid.UUID, amount *apd.Decimal, expiresAt time.Time) (*wallet.Hold, error) {
+	if err := decimal.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	if expiresAt.IsZero() {
+		expiresAt = now.Add(defaultHoldExpiry)
+	} else if !expiresAt.After(now) || expiresAt.After(now.Add(maxHoldExpiry)) {
+		return nil, errInvalidHoldExpiry
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	h := &wallet.Hold{
+		ID:        id,
+		From:      from,
+		Amount:    amount,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The account is locked, so that its available balance can't change before the hold is stored
+		fromAccount, err := s.lockUserAccountTx(ctx, tx, from)
+		if err != nil {
+			return err
+		}
+
+		if err := checkAccountStatus(fromAccount, nil); err != nil {
+			return err
+		}
+
+		if _, err := s.validateAmount(ctx, amount, fromAccount.Currency); err != nil {
+			return err
+		}
+
+		if err := checkAvailable(fromAccount, amount); err != nil {
+			return err
+		}
+
+		return s.holds.StoreTx(ctx, tx, h)
+	}); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (s service) GetHold(ctx context.Context, id uuid.UUID) (*wallet.Hold, error) {
+	return s.holds.Get(ctx, id)
+}
+
+func (s service) Capture(ctx context.Context, holdID, to uuid.UUID, amount *apd.Decimal) (*wallet.Payment, error) {
+	// A nil amount captures the whole hold, which is known once the hold is locked
+	if amount != nil {
+		if err := decimal.ValidateAmount(amount); err != nil {
+			return nil, err
+		}
 	}
 
-	if err := s.payments.WithTx(ctx, func(ctx context.Context, tx *sqlx.Tx) error {
+	var p *wallet.Payment
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The hold is locked, so that it can't be captured or released twice
+		h, err := s.holds.GetTx(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+
+		if h.Status != wallet.HoldActive {
+			return wallet.ErrHoldNotActive
+		}
+		if !h.ExpiresAt.After(time.Now()) {
+			return errHoldExpired
+		}
+
+		captured := amount
+		if captured == nil {
+			captured = h.Amount
+		} else if captured.Cmp(h.Amount) > 0 {
+			return errCaptureExceedsHold
+		}
+
+		p, err = makeTransfer(to, h.From, captured, "")
+		if err != nil {
+			return err
+		}
+
+		// The hold is captured before the transfer, so that its amount is available to the transfer.
+		// The payment is stored later in the transaction.
+		h.Status = wallet.HoldCaptured
+		h.PaymentID = &p.ID
+		if err := s.holds.UpdateTx(ctx, tx, h); err != nil {
+			return err
+		}
+
 		return s.transferTx(ctx, tx, p)
 	}); err != nil {
 		return nil, err
@@ -67,35 +747,1013 @@ func (s service) Transfer(ctx context.Context, to, from uuid.UUID, amount *apd.D
 	return p, nil
 }
 
-func (s service) transferTx(ctx context.Context, tx *sqlx.Tx, p *wallet.Payment) error {
-	// Fetch the accounts, checking that they exist
-	toAccount, err := s.accounts.GetTx(ctx, tx, p.To)
+func (s service) Release(ctx context.Context, holdID uuid.UUID) (*wallet.Hold, error) {
+	var h *wallet.Hold
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The hold is locked, so that it can't be captured while it is released
+		var err error
+		h, err = s.holds.GetTx(ctx, tx, holdID)
+		if err != nil {
+			return err
+		}
+
+		if h.Status != wallet.HoldActive {
+			return wallet.ErrHoldNotActive
+		}
+
+		h.Status = wallet.HoldReleased
+		return s.holds.UpdateTx(ctx, tx, h)
+	}); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+func (s service) ExpireHolds(ctx context.Context) (int, error) {
+	return s.holds.Expire(ctx)
+}
+
+// makeTransfer validates the fields of a transfer request and creates its payment
+func makeTransfer(to, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	if uuid.Equal(to, from) {
+		return nil, errSameAccount
+	}
+
+	return makePayment(to, &from, amount, idempotencyKey)
+}
+
+func (s service) transferTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) error {
+	// Fetch and lock the accounts, checking that they exist
+	accounts, err := s.lockAccountsTx(ctx, tx, p.To, *p.From)
 	if err != nil {
 		return err
 	}
+	toAccount := accounts[0]
+	fromAccount := accounts[1]
+
+	// System accounts are only used by deposits and withdrawals
+	if !toAccount.IsUser() || !fromAccount.IsUser() {
+		return wallet.ErrNoAccount
+	}
+
+	if replayed, err := s.replayTx(ctx, tx, p); err != nil || replayed {
+		return err
+	}
+
+	if err := checkAccountStatus(fromAccount, toAccount); err != nil {
+		return err
+	}
 
-	fromAccount, err := s.accounts.GetTx(ctx, tx, *p.From)
+	// The amount is in the sending account's currency
+	fromCurrency, err := s.validateAmount(ctx, p.Amount, fromAccount.Currency)
 	if err != nil {
 		return err
 	}
 
-	// Transfers between accounts of different currencies is not allowed
+	// The sending account is locked, so its spending can't change until the payment is stored
+	if err := s.checkLimits(ctx, fromAccount, p.Amount); err != nil {
+		return err
+	}
+
+	// Transfers between accounts of different currencies are converted
+	// to the receiving account's currency
 	if toAccount.Currency != fromAccount.Currency {
-		return errDifferentCurrency
+		toCurrency, err := s.currencies.Get(ctx, toAccount.Currency)
+		if err != nil {
+			return err
+		}
+		if err := s.convert(ctx, p, fromCurrency, toCurrency); err != nil {
+			return err
+		}
 	}
 
-	// The account must have sufficient balance
-	if fromAccount.Balance.Cmp(p.Amount) < 0 {
-		return errInsufficientBalance
+	// The fee is read in the transaction, so that it can't change before the payment is stored
+	if p.Fee, err = s.fee(ctx, p.Amount, fromCurrency); err != nil {
+		return err
 	}
 
-	return s.payments.StoreTx(ctx, tx, p)
+	// The account must have sufficient balance for the amount and the fee
+	debit := p.Amount
+	if p.Fee != nil {
+		debit = new(apd.Decimal)
+		if _, err := apd.BaseContext.Add(debit, p.Amount, p.Fee); err != nil {
+			return err
+		}
+	}
+	if err := checkAvailable(fromAccount, debit); err != nil {
+		return err
+	}
+
+	if err := s.postingsTx(ctx, tx, p, fromAccount, toAccount); err != nil {
+		return err
+	}
+
+	return s.storePaymentTx(ctx, tx, p)
+}
+
+// checkLimits returns errLimitExceeded if transferring or withdrawing an amount from an account would exceed
+// its spending limit, or the limit of its currency, see wallet.SpendingLimit
+func (s service) checkLimits(ctx context.Context, a *wallet.Account, amount *apd.Decimal) error {
+	limit, err := s.limits.Limit(ctx, a.ID, a.Currency)
+	switch err {
+	case nil:
+	case wallet.ErrNoSpendingLimit:
+		return nil
+	default:
+		return err
+	}
+
+	if limit.MaxAmount != nil && amount.Cmp(limit.MaxAmount) > 0 {
+		return errLimitExceeded{
+			Limit:     limitMaxAmount,
+			Remaining: limit.MaxAmount.Text('f'),
+		}
+	}
+
+	now := time.Now()
+
+	if limit.DailyAmount != nil {
+		spending, err := s.limits.Spending(ctx, a.ID, now.Add(-dailySpendingPeriod))
+		if err != nil {
+			return err
+		}
+
+		remaining := new(apd.Decimal)
+		if _, err := apd.BaseContext.Sub(remaining, limit.DailyAmount, spending.Amount); err != nil {
+			return err
+		}
+		if amount.Cmp(remaining) > 0 {
+			// The limit may have been lowered below the amount already spent
+			if remaining.Sign() < 0 {
+				remaining = apd.New(0, remaining.Exponent)
+			}
+			return errLimitExceeded{
+				Limit:     limitDailyAmount,
+				Remaining: remaining.Text('f'),
+			}
+		}
+	}
+
+	if limit.HourlyCount != nil {
+		spending, err := s.limits.Spending(ctx, a.ID, now.Add(-hourlySpendingPeriod))
+		if err != nil {
+			return err
+		}
+
+		if spending.Count >= *limit.HourlyCount {
+			return errLimitExceeded{
+				Limit:     limitHourlyCount,
+				Remaining: "0",
+			}
+		}
+	}
+
+	return nil
+}
+
+// fee returns the fee for transferring an amount from an account in a currency,
+// or nil if there is no fee, see wallet.FeeSchedule
+func (s service) fee(ctx context.Context, amount *apd.Decimal, c *wallet.Currency) (*apd.Decimal, error) {
+	schedule, err := s.fees.Schedule(ctx, c.Code)
+	switch err {
+	case nil:
+	case wallet.ErrNoFeeSchedule:
+		return nil, nil
+	default:
+		return nil, err
+	}
+
+	// The percentage is converted to a rate, e.g. 2.5% is 0.025
+	var rate apd.Decimal
+	rate.Set(schedule.Percent)
+	rate.Exponent -= 2
+
+	fee, err := decimal.Convert(amount, &rate, c.Exponent, s.rounding)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := apd.BaseContext.Add(fee, fee, schedule.Flat); err != nil {
+		return nil, err
+	}
+
+	if schedule.Min != nil && fee.Cmp(schedule.Min) < 0 {
+		fee = schedule.Min
+	}
+	if schedule.Max != nil && fee.Cmp(schedule.Max) > 0 {
+		fee = schedule.Max
+	}
+
+	if fee.Sign() == 0 {
+		return nil, nil
+	}
+	return fee, nil
 }
 
-func (s service) Payments(ctx context.Context) ([]wallet.Payment, error) {
-	return s.payments.All(ctx)
+// postingsTx sets the postings of a payment from one account to another, see wallet.PaymentPostings.
+// Payments between accounts of different currencies are posted through the exchange account of each
+// currency, and fees are credited to the revenue account of the sender's currency.
+func (s service) postingsTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment, from, to *wallet.Account) error {
+	postings, err := wallet.PaymentPostings(*p, *from, *to, func(kind, currency string) (uuid.UUID, error) {
+		return s.accounts.SystemAccountIDTx(ctx, tx, kind, currency)
+	})
+	if err != nil {
+		return err
+	}
+
+	p.Postings = postings
+	return nil
 }
 
-func (s service) Accounts(ctx context.Context) ([]wallet.Account, error) {
-	return s.accounts.All(ctx)
+func (s service) Deposit(ctx context.Context, to uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	p, err := makePayment(to, nil, amount, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return s.depositTx(ctx, tx, p)
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s service) depositTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) error {
+	// The account is locked so that a replay that is concurrent with
+	// the original request waits for it to complete
+	toAccount, err := s.lockUserAccountTx(ctx, tx, p.To)
+	if err != nil {
+		return err
+	}
+
+	externalID, err := s.accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, toAccount.Currency)
+	if err != nil {
+		return err
+	}
+	p.From = &externalID
+
+	if replayed, err := s.replayTx(ctx, tx, p); err != nil || replayed {
+		return err
+	}
+
+	if err := checkAccountStatus(nil, toAccount); err != nil {
+		return err
+	}
+
+	if _, err := s.validateAmount(ctx, p.Amount, toAccount.Currency); err != nil {
+		return err
+	}
+
+	return s.storePaymentTx(ctx, tx, p)
+}
+
+func (s service) Withdraw(ctx context.Context, from uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	// The "To" account is set to the external account once the currency is known
+	p, err := makePayment(uuid.Nil, &from, amount, idempotencyKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return s.withdrawTx(ctx, tx, p)
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s service) withdrawTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) error {
+	fromAccount, err := s.lockUserAccountTx(ctx, tx, *p.From)
+	if err != nil {
+		return err
+	}
+
+	externalID, err := s.accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, fromAccount.Currency)
+	if err != nil {
+		return err
+	}
+	p.To = externalID
+
+	if replayed, err := s.replayTx(ctx, tx, p); err != nil || replayed {
+		return err
+	}
+
+	if err := checkAccountStatus(fromAccount, nil); err != nil {
+		return err
+	}
+
+	if _, err := s.validateAmount(ctx, p.Amount, fromAccount.Currency); err != nil {
+		return err
+	}
+
+	// Withdrawals count against the same limits as transfers, so that the limits
+	// cap all of the money leaving the account
+	if err := s.checkLimits(ctx, fromAccount, p.Amount); err != nil {
+		return err
+	}
+
+	// The account must have sufficient balance
+	if err := checkAvailable(fromAccount, p.Amount); err != nil {
+		return err
+	}
+
+	return s.storePaymentTx(ctx, tx, p)
+}
+
+func (s service) Refund(ctx context.Context, paymentID uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	// A nil amount refunds the remaining amount, which is known once the payment is locked
+	if amount != nil {
+		if err := decimal.ValidateAmount(amount); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, errIdempotencyKeyTooLong
+	}
+
+	refundID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &wallet.Payment{
+		ID:             refundID,
+		Amount:         amount,
+		IdempotencyKey: idempotencyKey,
+		ReversalOf:     &paymentID,
+	}
+
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return s.refundTx(ctx, tx, p)
+	}); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (s service) refundTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) error {
+	// Lock the original payment, so that concurrent refunds of it
+	// can't refund more than its amount in total
+	original, err := s.payments.GetTx(ctx, tx, *p.ReversalOf)
+	if err != nil {
+		return err
+	}
+
+	if original.ReversalOf != nil || original.From == nil {
+		return errNotRefundable
+	}
+
+	// The refund is paid by the original receiver back to the original sender
+	p.From = &original.To
+	p.To = *original.From
+
+	accounts, err := s.lockAccountsTx(ctx, tx, p.To, *p.From)
+	if err != nil {
+		return err
+	}
+	toAccount := accounts[0]
+	fromAccount := accounts[1]
+
+	if replayed, err := s.replayTx(ctx, tx, p); err != nil || replayed {
+		return err
+	}
+
+	if err := checkAccountStatus(fromAccount, toAccount); err != nil {
+		return err
+	}
+
+	// The refund's amount is in the currency credited by the original payment
+	credited := original.CreditAmount()
+	var remaining apd.Decimal
+	if _, err := apd.BaseContext.Sub(&remaining, credited, original.RefundedAmount); err != nil {
+		return err
+	}
+
+	if p.Amount == nil {
+		if remaining.Sign() == 0 {
+			return errAlreadyRefunded
+		}
+		p.Amount = &remaining
+	}
+
+	if _, err := s.validateAmount(ctx, p.Amount, fromAccount.Currency); err != nil {
+		return err
+	}
+
+	if p.Amount.Cmp(&remaining) > 0 {
+		return errRefundExceedsPayment
+	}
+
+	// Refunds of payments between accounts of different currencies are converted
+	// back in proportion to the original payment, rather than at the current rate,
+	// so that a full refund returns the original amount
+	if original.ToAmount != nil {
+		toCurrency, err := s.currencies.Get(ctx, toAccount.Currency)
+		if err != nil {
+			return err
+		}
+
+		toAmount, err := decimal.Prorate(original.Amount, p.Amount, credited, toCurrency.Exponent, s.rounding)
+		if err != nil {
+			return err
+		}
+		if toAmount.Sign() != 1 {
+			return errConvertedAmountTooSmall
+		}
+		p.ToAmount = toAmount
+	}
+
+	// External accounts pay refunds of withdrawals without a balance check,
+	// the same as for deposits
+	if fromAccount.IsUser() {
+		if err := checkAvailable(fromAccount, p.Amount); err != nil {
+			return err
+		}
+	}
+
+	return s.storePaymentTx(ctx, tx, p)
+}
+
+// storePaymentTx stores a payment, recording the API key that authenticated the request, if any
+func (s service) storePaymentTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) error {
+	p.APIKeyID = apiKeyIDFromContext(ctx)
+	return s.payments.StoreTx(ctx, tx, p)
+}
+
+// apiKeyIDFromContext returns the ID of the API key that authenticated the request, or nil if there is none
+func apiKeyIDFromContext(ctx context.Context) *uuid.UUID {
+	id, ok := wallet.APIKeyIDFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return &id
+}
+
+// contextWithAPIKeyID returns a copy of ctx that carries the ID of the API key stored with a scheduled
+// transfer or standing order, so that the payments made for it by a worker record the key.
+// ctx is returned if id is nil.
+func contextWithAPIKeyID(ctx context.Context, id *uuid.UUID) context.Context {
+	if id == nil {
+		return ctx
+	}
+	return wallet.ContextWithAPIKeyID(ctx, *id)
+}
+
+// checkAccountStatus returns an error if a payment can't be debited from an account, or credited
+// to another, because of their statuses. Frozen accounts can receive payments but can't send them,
+// and closed accounts can't do either. Either account can be nil if it is not checked.
+func checkAccountStatus(from, to *wallet.Account) error {
+	if from != nil {
+		switch from.Status {
+		case wallet.AccountFrozen:
+			return errAccountFrozen
+		case wallet.AccountClosed:
+			return errAccountClosed
+		}
+	}
+	if to != nil && to.Status == wallet.AccountClosed {
+		return errAccountClosed
+	}
+	return nil
+}
+
+// checkAvailable returns errInsufficientBalance if an account's available balance,
+// its balance less the amount of its active holds, is less than amount
+func checkAvailable(a *wallet.Account, amount *apd.Decimal) error {
+	available, err := a.Available()
+	if err != nil {
+		return err
+	}
+	if available.Cmp(amount) < 0 {
+		return errInsufficientBalance
+	}
+	return nil
+}
+
+// makePayment validates the fields of a payment request and creates a payment with a new ID
+func makePayment(to uuid.UUID, from *uuid.UUID, amount *apd.Decimal, idempotencyKey string) (*wallet.Payment, error) {
+	// The amount's precision is validated once the account's currency is known
+	if err := decimal.ValidateAmount(amount); err != nil {
+		return nil, err
+	}
+
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return nil, errIdempotencyKeyTooLong
+	}
+
+	paymentID, err := uuid.NewV4()
+	if err != nil {
+		return nil, err
+	}
+
+	return &wallet.Payment{
+		ID:             paymentID,
+		To:             to,
+		From:           from,
+		Amount:         amount,
+		IdempotencyKey: idempotencyKey,
+	}, nil
+}
+
+// replayTx checks if a payment request is a replay of a previous request with the same
+// idempotency key. If it is, p is replaced by the original payment and replayed is true.
+// This must be called after the payment's accounts are locked, so that a replay
+// that is concurrent with the original request waits for it to complete.
+func (s service) replayTx(ctx context.Context, tx wallet.Tx, p *wallet.Payment) (replayed bool, err error) {
+	if p.IdempotencyKey == "" {
+		return false, nil
+	}
+
+	original, err := s.payments.GetByIdempotencyKeyTx(ctx, tx, p.IdempotencyKey)
+	switch err {
+	case nil:
+		if !isSamePayment(original, p) {
+			return false, wallet.ErrIdempotencyKeyReused
+		}
+		*p = *original
+		return true, nil
+	case wallet.ErrNoPayment:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// validateAmount checks that an amount does not have more decimal places
+// than the minor unit of its currency, and returns the currency
+func (s service) validateAmount(ctx context.Context, amount *apd.Decimal, currency string) (*wallet.Currency, error) {
+	c, err := s.currencies.Get(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := decimal.ValidateTransferAmount(amount, c.Exponent); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// convert sets the payment's converted amount and exchange rate
+func (s service) convert(ctx context.Context, p *wallet.Payment, from, to *wallet.Currency) error {
+	rate, err := s.rates.Rate(ctx, from.Code, to.Code)
+	if err != nil {
+		return err
+	}
+
+	toAmount, err := decimal.Convert(p.Amount, rate, to.Exponent, s.rounding)
+	if err != nil {
+		return err
+	}
+
+	if toAmount.Sign() != 1 {
+		return errConvertedAmountTooSmall
+	}
+
+	p.ToAmount = toAmount
+	p.Rate = rate
+	return nil
+}
+
+// isSamePayment returns true if a stored payment and a payment request are between
+// the same accounts for the same amount, and refund the same payment.
+// A request without an amount is a refund of the remaining amount, which matches any amount.
+func isSamePayment(stored, req *wallet.Payment) bool {
+	sameFrom := (stored.From == nil && req.From == nil) ||
+		(stored.From != nil && req.From != nil && uuid.Equal(*stored.From, *req.From))
+	sameReversalOf := (stored.ReversalOf == nil && req.ReversalOf == nil) ||
+		(stored.ReversalOf != nil && req.ReversalOf != nil && uuid.Equal(*stored.ReversalOf, *req.ReversalOf))
+	sameAmount := req.Amount == nil || stored.Amount.Cmp(req.Amount) == 0
+	return sameFrom && sameReversalOf && uuid.Equal(stored.To, req.To) && sameAmount
+}
+
+// lockAccountsTx fetches and locks accounts for the remainder of the transaction.
+// The accounts are locked in order of their IDs, so that concurrent transactions
+// that lock the same accounts cannot deadlock. The accounts are returned in the
+// same order as the ids argument.
+func (s service) lockAccountsTx(ctx context.Context, tx wallet.Tx, ids ...uuid.UUID) ([]*wallet.Account, error) {
+	sorted := make([]uuid.UUID, len(ids))
+	copy(sorted, ids)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].Bytes(), sorted[j].Bytes()) < 0
+	})
+
+	locked := make(map[uuid.UUID]*wallet.Account, len(ids))
+	for _, id := range sorted {
+		if _, ok := locked[id]; ok {
+			continue
+		}
+
+		a, err := s.accounts.GetTx(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		locked[id] = a
+	}
+
+	accounts := make([]*wallet.Account, len(ids))
+	for i, id := range ids {
+		accounts[i] = locked[id]
+	}
+	return accounts, nil
+}
+
+// lockBatchAccountsTx locks the accounts of a batch of payments in order of their IDs,
+// the same as lockAccountsTx, so that concurrent batches cannot deadlock.
+// Nil payments are skipped. Accounts that don't exist are skipped, and fail their
+// payments when they are made.
+func (s service) lockBatchAccountsTx(ctx context.Context, tx wallet.Tx, payments []*wallet.Payment) error {
+	var ids []uuid.UUID
+	for _, p := range payments {
+		if p != nil {
+			ids = append(ids, p.To, *p.From)
+		}
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i].Bytes(), ids[j].Bytes()) < 0
+	})
+
+	for i, id := range ids {
+		if i > 0 && uuid.Equal(id, ids[i-1]) {
+			continue
+		}
+
+		if _, err := s.accounts.GetTx(ctx, tx, id); err != nil && err != wallet.ErrNoAccount {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// lockUserAccountTx fetches and locks a user account for the remainder of the transaction
+func (s service) lockUserAccountTx(ctx context.Context, tx wallet.Tx, id uuid.UUID) (*wallet.Account, error) {
+	accounts, err := s.lockAccountsTx(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if !accounts[0].IsUser() {
+		return nil, wallet.ErrNoAccount
+	}
+
+	return accounts[0], nil
+}
+
+func (s service) SetAccountStatus(ctx context.Context, id uuid.UUID, status string) (*wallet.Account, error) {
+	switch status {
+	case wallet.AccountActive, wallet.AccountFrozen, wallet.AccountClosed:
+	default:
+		return nil, errInvalidAccountStatus
+	}
+
+	var a *wallet.Account
+	if err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		// The account is locked, so that its balance can't change before it is closed
+		var err error
+		a, err = s.lockUserAccountTx(ctx, tx, id)
+		if err != nil {
+			return err
+		}
+
+		if a.Status == wallet.AccountClosed {
+			return errAccountClosed
+		}
+
+		if status == wallet.AccountClosed && a.Balance.Sign() != 0 {
+			return errAccountBalanceNotZero
+		}
+
+		a.Status = status
+		return s.accounts.UpdateStatusTx(ctx, tx, a)
+	}); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (s service) Payments(ctx context.Context, page wallet.Page) ([]wallet.Payment, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+	return s.payments.List(ctx, page)
+}
+
+func (s service) Accounts(ctx context.Context, page wallet.Page) ([]wallet.Account, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+	return s.accounts.List(ctx, page)
+}
+
+func validatePage(page wallet.Page) error {
+	if page.Limit < 1 || page.Limit > maxPageLimit {
+		return errInvalidLimit
+	}
+	return nil
+}
+
+func (s service) Account(ctx context.Context, id uuid.UUID) (*wallet.Account, error) {
+	a, err := s.accounts.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// System accounts are not visible to users
+	if !a.IsUser() {
+		return nil, wallet.ErrNoAccount
+	}
+
+	return a, nil
+}
+
+func (s service) AccountPayments(ctx context.Context, id uuid.UUID, r wallet.TimeRange, page wallet.Page) ([]wallet.AccountPayment, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	if !r.Since.IsZero() && !r.Until.IsZero() && r.Since.After(r.Until) {
+		return nil, "", errInvalidTimeRange
+	}
+
+	// Check that the account exists, otherwise an empty list would be returned
+	if _, err := s.Account(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	return s.payments.ListAccountPayments(ctx, id, r, page)
+}
+
+func (s service) ExchangeRates(ctx context.Context) ([]wallet.ExchangeRate, error) {
+	return s.rates.All(ctx)
+}
+
+func (s service) SetExchangeRate(ctx context.Context, from, to string, rate *apd.Decimal) (*wallet.ExchangeRate, error) {
+	if from == to {
+		return nil, errSameCurrency
+	}
+
+	if rate == nil || rate.Form != apd.Finite || rate.Sign() != 1 {
+		return nil, decimal.ErrRateNotMoreThanZero
+	}
+
+	for _, code := range []string{from, to} {
+		if _, err := s.enabledCurrency(ctx, code); err != nil {
+			return nil, err
+		}
+	}
+
+	r := &wallet.ExchangeRate{
+		From: from,
+		To:   to,
+		Rate: rate,
+	}
+
+	if err := s.rates.Store(ctx, r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+func (s service) FeeSchedules(ctx context.Context) ([]wallet.FeeSchedule, error) {
+	return s.fees.All(ctx)
+}
+
+func (s service) SetFeeSchedule(ctx context.Context, schedule wallet.FeeSchedule) (*wallet.FeeSchedule, error) {
+	c, err := s.enabledCurrency(ctx, schedule.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if schedule.Flat == nil {
+		schedule.Flat = apd.New(0, 0)
+	}
+	if schedule.Percent == nil {
+		schedule.Percent = apd.New(0, 0)
+	}
+
+	// Fee amounts are in the currency, so they must not have more decimal places than it allows
+	for _, amount := range []*apd.Decimal{schedule.Flat, schedule.Min, schedule.Max} {
+		if err := validateFeeAmount(amount, c.Exponent); err != nil {
+			return nil, err
+		}
+	}
+
+	if schedule.Percent.Form != apd.Finite || schedule.Percent.Sign() < 0 || schedule.Percent.Cmp(apd.New(100, 0)) > 0 {
+		return nil, errInvalidPercent
+	}
+
+	if schedule.Min != nil && schedule.Max != nil && schedule.Min.Cmp(schedule.Max) > 0 {
+		return nil, errFeeMinExceedsMax
+	}
+
+	if err := s.fees.Store(ctx, &schedule); err != nil {
+		return nil, err
+	}
+
+	return &schedule, nil
+}
+
+// validateFeeAmount checks that an optional fee amount is not negative, and does not have
+// more decimal places than the minor unit of its currency
+func validateFeeAmount(amount *apd.Decimal, exponent int32) error {
+	if amount == nil {
+		return nil
+	}
+	if amount.Form != apd.Finite {
+		return decimal.ErrNotFinite
+	}
+	switch amount.Sign() {
+	case -1:
+		return decimal.ErrNegative
+	case 0:
+		return nil
+	default:
+		return decimal.ValidateTransferAmount(amount, exponent)
+	}
+}
+
+func (s service) SpendingLimits(ctx context.Context) ([]wallet.SpendingLimit, error) {
+	return s.limits.All(ctx)
+}
+
+func (s service) SetSpendingLimit(ctx context.Context, limit wallet.SpendingLimit) (*wallet.SpendingLimit, error) {
+	// The limit of an account is in the account's currency
+	if limit.AccountID != nil {
+		a, err := s.accounts.Get(ctx, *limit.AccountID)
+		if err != nil {
+			return nil, err
+		}
+		if !a.IsUser() {
+			return nil, wallet.ErrNoAccount
+		}
+		limit.Currency = a.Currency
+	}
+
+	c, err := s.enabledCurrency(ctx, limit.Currency)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, amount := range []*apd.Decimal{limit.MaxAmount, limit.DailyAmount} {
+		if amount == nil {
+			continue
+		}
+		if err := decimal.ValidateTransferAmount(amount, c.Exponent); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit.HourlyCount != nil && *limit.HourlyCount <= 0 {
+		return nil, errInvalidHourlyCount
+	}
+
+	if err := s.limits.Store(ctx, &limit); err != nil {
+		return nil, err
+	}
+
+	return &limit, nil
+}
+
+func (s service) Currencies(ctx context.Context) ([]wallet.Currency, error) {
+	return s.currencies.All(ctx)
+}
+
+func (s service) SetCurrency(ctx context.Context, code string, exponent int32, enabled bool) (*wallet.Currency, error) {
+	if !currencyCodeRegexp.MatchString(code) {
+		return nil, errInvalidCurrency
+	}
+
+	if exponent < 0 || exponent > maxCurrencyExponent {
+		return nil, errInvalidExponent
+	}
+
+	c := &wallet.Currency{
+		Code:     code,
+		Exponent: exponent,
+		Enabled:  enabled,
+	}
+
+	if err := s.currencies.Store(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// enabledCurrency returns a currency, checking that it exists and is enabled
+func (s service) enabledCurrency(ctx context.Context, code string) (*wallet.Currency, error) {
+	c, err := s.currencies.Get(ctx, code)
+	switch err {
+	case nil:
+	case wallet.ErrNoCurrency:
+		return nil, errInvalidCurrency
+	default:
+		return nil, err
+	}
+
+	if !c.Enabled {
+		return nil, errCurrencyDisabled
+	}
+
+	return c, nil
+}
+
+func (s service) CreateAccount(ctx context.Context, id uuid.UUID, currency string, ownerID *uuid.UUID) (*wallet.Account, error) {
+	c, err := s.enabledCurrency(ctx, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	if uuid.Equal(id, uuid.Nil) {
+		id, err = uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a := &wallet.Account{
+		ID:       id,
+		Balance:  apd.New(0, -c.Exponent),
+		Currency: currency,
+		Kind:     wallet.AccountKindUser,
+		OwnerID:  ownerID,
+	}
+
+	if err := s.accounts.Store(ctx, a); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+func (s service) CreateCustomer(ctx context.Context, id uuid.UUID, name string) (*wallet.Customer, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxCustomerNameLength {
+		return nil, errInvalidCustomerName
+	}
+
+	if uuid.Equal(id, uuid.Nil) {
+		var err error
+		id, err = uuid.NewV4()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &wallet.Customer{
+		ID:   id,
+		Name: name,
+	}
+
+	if err := s.customers.Store(ctx, c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (s service) Customer(ctx context.Context, id uuid.UUID) (*wallet.Customer, error) {
+	return s.customers.Get(ctx, id)
+}
+
+func (s service) CustomerAccounts(ctx context.Context, id uuid.UUID, page wallet.Page) ([]wallet.Account, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	// An unknown customer is an error, not an empty list of accounts
+	if _, err := s.customers.Get(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	return s.accounts.ListByOwner(ctx, id, page)
+}
+
+func (s service) CustomerBalances(ctx context.Context, id uuid.UUID) ([]wallet.CustomerBalance, error) {
+	if _, err := s.customers.Get(ctx, id); err != nil {
+		return nil, err
+	}
+
+	return s.customers.Balances(ctx, id)
+}
+
+func (s service) CustomerPayments(ctx context.Context, id uuid.UUID, page wallet.Page) ([]wallet.Payment, string, error) {
+	if err := validatePage(page); err != nil {
+		return nil, "", err
+	}
+
+	if _, err := s.customers.Get(ctx, id); err != nil {
+		return nil, "", err
+	}
+
+	return s.payments.ListByOwner(ctx, id, page)
 }
@@ -4,7 +4,10 @@ import (
 	"context"
 	// "errors"
 	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/apd"
 	"github.com/go-kit/kit/log"
@@ -13,10 +16,12 @@ import (
 	_ "github.com/golang-migrate/migrate/v4/source/file"
 	"github.com/jmoiron/sqlx"
 	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
 	wallet "github.com/xsleonard/gokit-example"
 	"github.com/xsleonard/gokit-example/decimal"
+	"github.com/xsleonard/gokit-example/inmem"
 	"github.com/xsleonard/gokit-example/postgres"
 
 	_ "github.com/lib/pq" // load postgres driver
@@ -52,17 +57,80 @@ func setupDB(t *testing.T) (*sqlx.DB, func()) {
 	}
 }
 
+// testRepositories are the repositories of a test service, for tests that seed or inspect data directly
+type testRepositories struct {
+	uow        wallet.UnitOfWork
+	accounts   wallet.AccountRepository
+	payments   wallet.PaymentRepository
+	rates      wallet.RateRepository
+	currencies wallet.CurrencyRepository
+	fees       wallet.FeeRepository
+	scheduled  wallet.ScheduledTransferRepository
+	standing   wallet.StandingOrderRepository
+	holds      wallet.HoldRepository
+	limits     wallet.LimitRepository
+	customers  wallet.CustomerRepository
+	apiKeys    wallet.APIKeyRepository
+}
+
+// newService creates a service with the repositories
+func (r testRepositories) newService() wallet.Service {
+	return NewService(r.uow, r.accounts, r.payments, r.rates, r.currencies, r.fees, r.scheduled, r.standing, r.holds, r.limits, r.customers, apd.RoundHalfEven)
+}
+
+// newPostgresRepositories creates test repositories that use postgres for storage
+func newPostgresRepositories(db *sqlx.DB) testRepositories {
+	logger := log.NewNopLogger()
+	return testRepositories{
+		uow:        postgres.NewUnitOfWork(db, logger),
+		accounts:   postgres.NewAccountRepository(db, logger),
+		payments:   postgres.NewPaymentRepository(db, logger),
+		rates:      postgres.NewRateRepository(db, logger),
+		currencies: postgres.NewCurrencyRepository(db, logger),
+		fees:       postgres.NewFeeRepository(db, logger),
+		scheduled:  postgres.NewScheduledTransferRepository(db, logger),
+		standing:   postgres.NewStandingOrderRepository(db, logger),
+		holds:      postgres.NewHoldRepository(db, logger),
+		limits:     postgres.NewLimitRepository(db, logger),
+		customers:  postgres.NewCustomerRepository(db, logger),
+		apiKeys:    postgres.NewAPIKeyRepository(db, logger),
+	}
+}
+
+// newTestService creates a service with in-memory repositories, and returns the repositories
+func newTestService(t *testing.T) (wallet.Service, testRepositories) {
+	t.Helper()
+
+	db := inmem.NewDB()
+	repos := testRepositories{
+		uow:        inmem.NewUnitOfWork(db),
+		accounts:   inmem.NewAccountRepository(db),
+		payments:   inmem.NewPaymentRepository(db),
+		rates:      inmem.NewRateRepository(db),
+		currencies: inmem.NewCurrencyRepository(db),
+		fees:       inmem.NewFeeRepository(db),
+		scheduled:  inmem.NewScheduledTransferRepository(db),
+		standing:   inmem.NewStandingOrderRepository(db),
+		holds:      inmem.NewHoldRepository(db),
+		limits:     inmem.NewLimitRepository(db),
+		customers:  inmem.NewCustomerRepository(db),
+		apiKeys:    inmem.NewAPIKeyRepository(db),
+	}
+	return repos.newService(), repos
+}
+
 func TestServiceTransfer(t *testing.T) {
 	toID := uuid.Must(uuid.NewV4())
 	fromID := uuid.Must(uuid.NewV4())
 
 	cases := []struct {
-		name   string
-		to     uuid.UUID
-		from   uuid.UUID
-		amount *apd.Decimal
-		setup  func(*testing.T, context.Context, service)
-		err    error
+		name     string
+		to       uuid.UUID
+		from     uuid.UUID
+		amount   *apd.Decimal
+		toAmount *apd.Decimal
+		setup    func(*testing.T, context.Context, service)
+		err      error
 	}{
 		{
 			name:   "nil amount",
@@ -112,11 +180,76 @@ func TestServiceTransfer(t *testing.T) {
 		},
 
 		{
-			name:   "account currencies do not match",
+			name:   "account currencies do not match, no exchange rate",
 			to:     toID,
 			from:   fromID,
 			amount: apd.New(123, -2),
-			err:    errDifferentCurrency,
+			err:    wallet.ErrNoExchangeRate,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.SGD,
+				})
+				require.NoError(t, err)
+
+				// The rate in the other direction is not used
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.USD,
+					To:   wallet.SGD,
+					Rate: apd.New(136, -2),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:   "account currencies do not match, converted amount too small",
+			to:     toID,
+			from:   fromID,
+			amount: apd.New(1, -2),
+			err:    errConvertedAmountTooSmall,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.SGD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     uuid.Must(uuid.NewV4()),
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.SGD,
+					To:   wallet.USD,
+					Rate: apd.New(1, -1),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:     "valid, account currencies do not match",
+			to:       toID,
+			from:     fromID,
+			amount:   apd.New(1000, -2),
+			toAmount: apd.New(734, -2),
 			setup: func(t *testing.T, ctx context.Context, s service) {
 				err := s.accounts.Store(ctx, &wallet.Account{
 					ID:       toID,
@@ -129,6 +262,95 @@ func TestServiceTransfer(t *testing.T) {
 					Currency: wallet.SGD,
 				})
 				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     uuid.Must(uuid.NewV4()),
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				// 10.00 * 0.7345 = 7.345, which rounds half-even to 7.34
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.SGD,
+					To:   wallet.USD,
+					Rate: apd.New(7345, -4),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:   "amount has more decimal places than the currency",
+			to:     toID,
+			from:   fromID,
+			amount: apd.New(1234, -3),
+			err:    decimal.ErrInvalidPrecision,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     uuid.Must(uuid.NewV4()),
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:     "valid, converted to a currency without a minor unit",
+			to:       toID,
+			from:     fromID,
+			amount:   apd.New(1000, -2),
+			toAmount: apd.New(1575, 0),
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.currencies.Store(ctx, &wallet.Currency{
+					Code:     wallet.JPY,
+					Exponent: 0,
+					Enabled:  true,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.JPY,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     uuid.Must(uuid.NewV4()),
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				// 10.00 * 157.46 = 1574.6, which rounds to 1575
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.USD,
+					To:   wallet.JPY,
+					Rate: apd.New(15746, -2),
+				})
+				require.NoError(t, err)
 			},
 		},
 
@@ -220,14 +442,7 @@ func TestServiceTransfer(t *testing.T) {
 
 	for _, tc := range cases {
 		t.Run(tc.name, func(t *testing.T) {
-			db, shutdown := setupDB(t)
-			defer shutdown()
-
-			logger := log.NewNopLogger()
-			accountsRepo := postgres.NewAccountRepository(db, logger)
-			paymentsRepo := postgres.NewPaymentRepository(db, logger)
-
-			s := NewService(accountsRepo, paymentsRepo)
+			s, _ := newTestService(t)
 
 			ctx := context.Background()
 
@@ -235,7 +450,7 @@ func TestServiceTransfer(t *testing.T) {
 				tc.setup(t, ctx, s.(service))
 			}
 
-			p, err := s.Transfer(ctx, tc.to, tc.from, tc.amount)
+			p, err := s.Transfer(ctx, tc.to, tc.from, tc.amount, "")
 			if tc.err != nil {
 				require.Error(t, err)
 				require.Equal(t, tc.err, err, "%v != %v", tc.err, err)
@@ -248,6 +463,2626 @@ func TestServiceTransfer(t *testing.T) {
 			require.True(t, uuid.Equal(tc.to, p.To))
 			require.True(t, uuid.Equal(tc.from, *p.From))
 			require.Equal(t, 0, tc.amount.Cmp(p.Amount))
+			require.False(t, p.CreatedAt.IsZero())
+
+			if tc.toAmount != nil {
+				require.NotNil(t, p.ToAmount)
+				require.NotNil(t, p.Rate)
+				require.Equal(t, 0, tc.toAmount.Cmp(p.ToAmount), "%s != %s", tc.toAmount, p.ToAmount)
+				// The amounts are exchanged through the exchange account of each currency
+				require.Len(t, p.Postings, 4)
+			} else {
+				require.Nil(t, p.ToAmount)
+				require.Nil(t, p.Rate)
+				require.Len(t, p.Postings, 2)
+			}
+
+			// The sender is debited and the receiver is credited
+			debit := p.Postings[0]
+			require.True(t, uuid.Equal(tc.from, debit.AccountID))
+			require.Equal(t, 0, new(apd.Decimal).Neg(p.Amount).Cmp(debit.Amount))
+			credit := p.Postings[len(p.Postings)-1]
+			require.True(t, uuid.Equal(tc.to, credit.AccountID))
+			require.Equal(t, 0, p.CreditAmount().Cmp(credit.Amount))
+
+			// Check the new balance of the receiving account
+			to, err := s.Account(ctx, tc.to)
+			require.NoError(t, err)
+			require.Equal(t, 0, p.CreditAmount().Cmp(to.Balance))
+		})
+	}
+}
+
+func TestServiceCreateAccount(t *testing.T) {
+	id := uuid.Must(uuid.NewV4())
+
+	cases := []struct {
+		name     string
+		id       uuid.UUID
+		currency string
+		setup    func(*testing.T, context.Context, service)
+		err      error
+	}{
+		{
+			name:     "invalid currency",
+			id:       id,
+			currency: "XYZ",
+			err:      errInvalidCurrency,
+		},
+
+		{
+			name:     "disabled currency",
+			id:       id,
+			currency: wallet.JPY,
+			err:      errCurrencyDisabled,
+		},
+
+		{
+			name:     "duplicate id",
+			id:       id,
+			currency: wallet.USD,
+			err:      wallet.ErrAccountExists,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.EUR,
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:     "valid, client-supplied id",
+			id:       id,
+			currency: wallet.USD,
+		},
+
+		{
+			name:     "valid, generated id",
+			id:       uuid.Nil,
+			currency: wallet.GBP,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			if tc.setup != nil {
+				tc.setup(t, ctx, s.(service))
+			}
+
+			a, err := s.CreateAccount(ctx, tc.id, tc.currency, nil)
+			if tc.err != nil {
+				require.Error(t, err)
+				require.Equal(t, tc.err, err, "%v != %v", tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, a)
+			require.NotEqual(t, uuid.Nil, a.ID)
+			if !uuid.Equal(tc.id, uuid.Nil) {
+				require.Equal(t, tc.id, a.ID)
+			}
+			require.Equal(t, tc.currency, a.Currency)
+			require.Equal(t, 0, a.Balance.Sign())
+			require.False(t, a.CreatedAt.IsZero())
+
+			accounts, _, err := repos.accounts.List(ctx, wallet.Page{Limit: 100})
+			require.NoError(t, err)
+			require.Len(t, accounts, 1)
+			require.Equal(t, a.ID, accounts[0].ID)
+		})
+	}
+}
+
+func TestServiceDeposit(t *testing.T) {
+	toID := uuid.Must(uuid.NewV4())
+
+	cases := []struct {
+		name   string
+		to     uuid.UUID
+		amount *apd.Decimal
+		setup  func(*testing.T, context.Context, service)
+		err    error
+	}{
+		{
+			name:   "nil amount",
+			to:     toID,
+			amount: nil,
+			err:    decimal.ErrAmountNil,
+		},
+
+		{
+			name:   "account does not exist",
+			to:     toID,
+			amount: apd.New(100, 0),
+			err:    wallet.ErrNoAccount,
+		},
+
+		{
+			name:   "amount has more decimal places than the currency",
+			to:     toID,
+			amount: apd.New(1234, -3),
+			err:    decimal.ErrInvalidPrecision,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:   "valid",
+			to:     toID,
+			amount: apd.New(12345, -2),
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			if tc.setup != nil {
+				tc.setup(t, ctx, s.(service))
+			}
+
+			p, err := s.Deposit(ctx, tc.to, tc.amount, "")
+			if tc.err != nil {
+				require.Error(t, err)
+				require.Equal(t, tc.err, err, "%v != %v", tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, p)
+			require.True(t, uuid.Equal(tc.to, p.To))
+			require.NotNil(t, p.From)
+			require.Equal(t, 0, tc.amount.Cmp(p.Amount))
+
+			to, err := s.Account(ctx, tc.to)
+			require.NoError(t, err)
+			require.Equal(t, 0, tc.amount.Cmp(to.Balance))
+
+			// The deposit is balanced by the external account
+			external, err := repos.accounts.Get(ctx, *p.From)
+			require.NoError(t, err)
+			require.Equal(t, wallet.AccountKindExternal, external.Kind)
+			require.Equal(t, to.Currency, external.Currency)
+			require.Equal(t, 0, external.Balance.Cmp(apd.New(-12345, -2)))
+
+			// The external account is not visible to users and can't be used for transfers
+			_, err = s.Account(ctx, *p.From)
+			require.Equal(t, wallet.ErrNoAccount, err)
+
+			_, err = s.Transfer(ctx, tc.to, *p.From, apd.New(1, 0), "")
+			require.Equal(t, wallet.ErrNoAccount, err)
+		})
+	}
+}
+
+func TestServiceWithdraw(t *testing.T) {
+	fromID := uuid.Must(uuid.NewV4())
+
+	// deposit creates the account and deposits 100.00 to it
+	deposit := func(t *testing.T, ctx context.Context, s service) {
+		err := s.accounts.Store(ctx, &wallet.Account{
+			ID:       fromID,
+			Currency: wallet.EUR,
+		})
+		require.NoError(t, err)
+
+		_, err = s.Deposit(ctx, fromID, apd.New(100, 0), "")
+		require.NoError(t, err)
+	}
+
+	cases := []struct {
+		name    string
+		from    uuid.UUID
+		amount  *apd.Decimal
+		balance *apd.Decimal
+		setup   func(*testing.T, context.Context, service)
+		err     error
+	}{
+		{
+			name:   "negative amount",
+			from:   fromID,
+			amount: apd.New(-1, 0),
+			err:    decimal.ErrAmountNotMoreThanZero,
+		},
+
+		{
+			name:   "account does not exist",
+			from:   fromID,
+			amount: apd.New(1, 0),
+			err:    wallet.ErrNoAccount,
+		},
+
+		{
+			name:   "insufficient balance",
+			from:   fromID,
+			amount: apd.New(10001, -2),
+			setup:  deposit,
+			err:    errInsufficientBalance,
+		},
+
+		{
+			name:    "valid, partial balance",
+			from:    fromID,
+			amount:  apd.New(2550, -2),
+			balance: apd.New(7450, -2),
+			setup:   deposit,
+		},
+
+		{
+			name:    "valid, full balance",
+			from:    fromID,
+			amount:  apd.New(100, 0),
+			balance: apd.New(0, 0),
+			setup:   deposit,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			if tc.setup != nil {
+				tc.setup(t, ctx, s.(service))
+			}
+
+			p, err := s.Withdraw(ctx, tc.from, tc.amount, "")
+			if tc.err != nil {
+				require.Error(t, err)
+				require.Equal(t, tc.err, err, "%v != %v", tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, p)
+			require.True(t, uuid.Equal(tc.from, *p.From))
+			require.Equal(t, 0, tc.amount.Cmp(p.Amount))
+
+			from, err := s.Account(ctx, tc.from)
+			require.NoError(t, err)
+			require.Equal(t, 0, tc.balance.Cmp(from.Balance))
+
+			// The withdrawal is paid to the same external account as the deposit,
+			// whose balance is the negative of the amount held by the account
+			external, err := repos.accounts.Get(ctx, p.To)
+			require.NoError(t, err)
+			require.Equal(t, wallet.AccountKindExternal, external.Kind)
+			require.Equal(t, 0, external.Balance.Neg(external.Balance).Cmp(from.Balance))
+		})
+	}
+}
+
+func TestServiceRefund(t *testing.T) {
+	toID := uuid.Must(uuid.NewV4())
+	fromID := uuid.Must(uuid.NewV4())
+	paymentID := uuid.Must(uuid.NewV4())
+
+	// setupPayment creates a payment of 30.00 between two accounts in the currencies.
+	// If the currencies are different, 30.00 is converted to 22.04.
+	setupPayment := func(toCurrency, fromCurrency string) func(*testing.T, context.Context, service) {
+		return func(t *testing.T, ctx context.Context, s service) {
+			err := s.accounts.Store(ctx, &wallet.Account{
+				ID:       toID,
+				Currency: toCurrency,
+			})
+			require.NoError(t, err)
+
+			err = s.accounts.Store(ctx, &wallet.Account{
+				ID:       fromID,
+				Currency: fromCurrency,
+			})
+			require.NoError(t, err)
+
+			err = s.payments.Store(ctx, &wallet.Payment{
+				ID:     uuid.Must(uuid.NewV4()),
+				To:     fromID,
+				From:   nil,
+				Amount: apd.New(100, 0),
+			})
+			require.NoError(t, err)
+
+			p := &wallet.Payment{
+				ID:     paymentID,
+				To:     toID,
+				From:   &fromID,
+				Amount: apd.New(3000, -2),
+			}
+			if toCurrency != fromCurrency {
+				p.ToAmount = apd.New(2204, -2)
+				p.Rate = apd.New(7345, -4)
+			}
+			err = s.payments.Store(ctx, p)
+			require.NoError(t, err)
+		}
+	}
+
+	// refundPayment refunds part of the payment made by setupPayment
+	refundPayment := func(amount *apd.Decimal) func(*testing.T, context.Context, service) {
+		return func(t *testing.T, ctx context.Context, s service) {
+			setupPayment(wallet.USD, wallet.USD)(t, ctx, s)
+			_, err := s.Refund(ctx, paymentID, amount, "")
+			require.NoError(t, err)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		paymentID uuid.UUID
+		amount    *apd.Decimal
+		setup     func(*testing.T, context.Context, service)
+		// expAmount is the amount of the refund, in the original receiver's currency
+		expAmount *apd.Decimal
+		// expToAmount is the amount credited to the original sender, if the currencies differ
+		expToAmount *apd.Decimal
+		// expRefunded is the original payment's refunded amount after the refund
+		expRefunded *apd.Decimal
+		err         error
+	}{
+		{
+			name:      "negative amount",
+			paymentID: paymentID,
+			amount:    apd.New(-1, 0),
+			err:       decimal.ErrAmountNotMoreThanZero,
+		},
+
+		{
+			name:      "payment does not exist",
+			paymentID: paymentID,
+			err:       wallet.ErrNoPayment,
+		},
+
+		{
+			name:      "credit without a sender",
+			paymentID: paymentID,
+			err:       errNotRefundable,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentID,
+					To:     toID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:      "amount exceeds payment",
+			paymentID: paymentID,
+			amount:    apd.New(3001, -2),
+			setup:     setupPayment(wallet.USD, wallet.USD),
+			err:       errRefundExceedsPayment,
+		},
+
+		{
+			name:      "amount exceeds unrefunded amount",
+			paymentID: paymentID,
+			amount:    apd.New(2001, -2),
+			setup:     refundPayment(apd.New(1000, -2)),
+			err:       errRefundExceedsPayment,
+		},
+
+		{
+			name:      "already fully refunded",
+			paymentID: paymentID,
+			setup:     refundPayment(nil),
+			err:       errAlreadyRefunded,
+		},
+
+		{
+			name:      "amount has more decimal places than the currency",
+			paymentID: paymentID,
+			amount:    apd.New(1001, -3),
+			setup:     setupPayment(wallet.USD, wallet.USD),
+			err:       decimal.ErrInvalidPrecision,
+		},
+
+		{
+			name:        "valid, partial refund",
+			paymentID:   paymentID,
+			amount:      apd.New(1000, -2),
+			setup:       setupPayment(wallet.USD, wallet.USD),
+			expAmount:   apd.New(1000, -2),
+			expRefunded: apd.New(1000, -2),
+		},
+
+		{
+			name:        "valid, remaining amount",
+			paymentID:   paymentID,
+			setup:       refundPayment(apd.New(1000, -2)),
+			expAmount:   apd.New(2000, -2),
+			expRefunded: apd.New(3000, -2),
+		},
+
+		{
+			name:        "valid, different currencies",
+			paymentID:   paymentID,
+			setup:       setupPayment(wallet.USD, wallet.SGD),
+			expAmount:   apd.New(2204, -2),
+			expToAmount: apd.New(3000, -2),
+			expRefunded: apd.New(2204, -2),
+		},
+
+		{
+			name:        "valid, different currencies, partial refund",
+			paymentID:   paymentID,
+			amount:      apd.New(1102, -2),
+			setup:       setupPayment(wallet.USD, wallet.SGD),
+			expAmount:   apd.New(1102, -2),
+			expToAmount: apd.New(1500, -2),
+			expRefunded: apd.New(1102, -2),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			if tc.setup != nil {
+				tc.setup(t, ctx, s.(service))
+			}
+
+			p, err := s.Refund(ctx, tc.paymentID, tc.amount, "")
+			if tc.err != nil {
+				require.Error(t, err)
+				require.Equal(t, tc.err, err, "%v != %v", tc.err, err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NotNil(t, p)
+			require.NotNil(t, p.ReversalOf)
+			require.Equal(t, tc.paymentID, *p.ReversalOf)
+			require.True(t, uuid.Equal(toID, *p.From))
+			require.True(t, uuid.Equal(fromID, p.To))
+			require.Equal(t, 0, tc.expAmount.Cmp(p.Amount), "%s != %s", tc.expAmount, p.Amount)
+			if tc.expToAmount != nil {
+				require.NotNil(t, p.ToAmount)
+				require.Equal(t, 0, tc.expToAmount.Cmp(p.ToAmount), "%s != %s", tc.expToAmount, p.ToAmount)
+			} else {
+				require.Nil(t, p.ToAmount)
+			}
+
+			// A refund can't be refunded
+			_, err = s.Refund(ctx, p.ID, nil, "")
+			require.Equal(t, errNotRefundable, err)
+
+			var original *wallet.Payment
+			err = repos.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+				var err error
+				original, err = repos.payments.GetTx(ctx, tx, tc.paymentID)
+				return err
+			})
+			require.NoError(t, err)
+			require.Equal(t, 0, tc.expRefunded.Cmp(original.RefundedAmount), "%s != %s", tc.expRefunded, original.RefundedAmount)
+		})
+	}
+}
+
+func TestServiceTransferFee(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule *wallet.FeeSchedule
+		amount   *apd.Decimal
+		fee      *apd.Decimal
+		err      error
+	}{
+		{
+			name:   "no fee schedule",
+			amount: apd.New(10, 0),
+		},
+
+		{
+			name: "fee schedule of another currency",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.EUR,
+				Flat:     apd.New(1, 0),
+			},
+			amount: apd.New(10, 0),
+		},
+
+		{
+			name: "flat",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Flat:     apd.New(30, -2),
+			},
+			amount: apd.New(10, 0),
+			fee:    apd.New(30, -2),
+		},
+
+		{
+			name: "flat and percent",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Flat:     apd.New(30, -2),
+				Percent:  apd.New(29, -1),
+			},
+			amount: apd.New(10, 0),
+			fee:    apd.New(59, -2),
+		},
+
+		{
+			name: "percent is rounded",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Percent:  apd.New(15, -1),
+			},
+			amount: apd.New(1, 0),
+			fee:    apd.New(2, -2),
+		},
+
+		{
+			name: "min",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Percent:  apd.New(1, 0),
+				Min:      apd.New(50, -2),
+			},
+			amount: apd.New(10, 0),
+			fee:    apd.New(50, -2),
+		},
+
+		{
+			name: "max",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Percent:  apd.New(10, 0),
+				Max:      apd.New(2, 0),
+			},
+			amount: apd.New(50, 0),
+			fee:    apd.New(2, 0),
+		},
+
+		{
+			name: "zero fee",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Percent:  apd.New(1, -1),
+			},
+			amount: apd.New(1, -2),
+		},
+
+		{
+			name: "insufficient balance for the fee",
+			schedule: &wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Flat:     apd.New(1, -2),
+			},
+			amount: apd.New(100, 0),
+			err:    errInsufficientBalance,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			toID := uuid.Must(uuid.NewV4())
+			fromID := uuid.Must(uuid.NewV4())
+			for _, id := range []uuid.UUID{toID, fromID} {
+				err := repos.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			}
+
+			err := repos.payments.Store(ctx, &wallet.Payment{
+				ID:     uuid.Must(uuid.NewV4()),
+				To:     fromID,
+				Amount: apd.New(100, 0),
+			})
+			require.NoError(t, err)
+
+			if tc.schedule != nil {
+				err := repos.fees.Store(ctx, tc.schedule)
+				require.NoError(t, err)
+			}
+
+			p, err := s.Transfer(ctx, toID, fromID, tc.amount, "")
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+
+			from, err := s.Account(ctx, fromID)
+			require.NoError(t, err)
+			var balance apd.Decimal
+			_, err = apd.BaseContext.Sub(&balance, apd.New(100, 0), tc.amount)
+			require.NoError(t, err)
+
+			if tc.fee == nil {
+				require.Nil(t, p.Fee)
+				require.Len(t, p.Postings, 2)
+			} else {
+				require.NotNil(t, p.Fee)
+				require.Equal(t, 0, tc.fee.Cmp(p.Fee), "%s != %s", tc.fee, p.Fee)
+				require.Len(t, p.Postings, 4)
+
+				// The fee is credited to the revenue account
+				revenue := p.Postings[3]
+				a, err := repos.accounts.Get(ctx, revenue.AccountID)
+				require.NoError(t, err)
+				require.Equal(t, wallet.AccountKindRevenue, a.Kind)
+				require.Equal(t, 0, tc.fee.Cmp(a.Balance))
+
+				_, err = apd.BaseContext.Sub(&balance, &balance, tc.fee)
+				require.NoError(t, err)
+			}
+
+			// The sender is debited the amount and the fee
+			require.Equal(t, 0, balance.Cmp(from.Balance), "%s != %s", &balance, from.Balance)
+
+			// The sender's payment history includes the fee, so its balance is the account's balance
+			history, _, err := s.AccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 10})
+			require.NoError(t, err)
+			require.Len(t, history, 2)
+			require.Equal(t, p.ID, history[0].PaymentID)
+			require.Equal(t, 0, from.Balance.Cmp(history[0].Balance), "%s != %s", from.Balance, history[0].Balance)
+			if tc.fee == nil {
+				require.Nil(t, history[0].Fee)
+			} else {
+				require.NotNil(t, history[0].Fee)
+				require.Equal(t, 0, tc.fee.Cmp(history[0].Fee), "%s != %s", tc.fee, history[0].Fee)
+			}
+		})
+	}
+}
+
+func TestServiceSetFeeSchedule(t *testing.T) {
+	cases := []struct {
+		name     string
+		schedule wallet.FeeSchedule
+		err      error
+	}{
+		{
+			name: "valid",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Flat:     apd.New(30, -2),
+				Percent:  apd.New(29, -1),
+				Min:      apd.New(50, -2),
+				Max:      apd.New(10, 0),
+			},
+		},
+
+		{
+			name: "valid, zero fee",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+			},
+		},
+
+		{
+			name: "unknown currency",
+			schedule: wallet.FeeSchedule{
+				Currency: "XYZ",
+			},
+			err: errInvalidCurrency,
+		},
+
+		{
+			name: "disabled currency",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.JPY,
+			},
+			err: errCurrencyDisabled,
+		},
+
+		{
+			name: "flat has too many decimal places",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Flat:     apd.New(1, -3),
+			},
+			err: decimal.ErrInvalidPrecision,
+		},
+
+		{
+			name: "negative min",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Min:      apd.New(-1, 0),
+			},
+			err: decimal.ErrNegative,
+		},
+
+		{
+			name: "percent over 100",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Percent:  apd.New(101, 0),
+			},
+			err: errInvalidPercent,
+		},
+
+		{
+			name: "min more than max",
+			schedule: wallet.FeeSchedule{
+				Currency: wallet.USD,
+				Min:      apd.New(2, 0),
+				Max:      apd.New(1, 0),
+			},
+			err: errFeeMinExceedsMax,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+
+			ctx := context.Background()
+
+			f, err := s.SetFeeSchedule(ctx, tc.schedule)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, f.Flat)
+			require.NotNil(t, f.Percent)
+
+			schedules, err := s.FeeSchedules(ctx)
+			require.NoError(t, err)
+			require.Len(t, schedules, 1)
+			require.Equal(t, tc.schedule.Currency, schedules[0].Currency)
+		})
+	}
+}
+
+func TestServiceSetSpendingLimit(t *testing.T) {
+	accountID := uuid.Must(uuid.FromString("5136843a-0948-432d-8ce6-060362edb538"))
+	unknownID := uuid.Must(uuid.FromString("b0505aa0-b927-4667-a484-906b4e2a410b"))
+	hourlyCount := 10
+	zero := 0
+
+	cases := []struct {
+		name     string
+		limit    wallet.SpendingLimit
+		currency string
+		err      error
+	}{
+		{
+			name: "valid currency limit",
+			limit: wallet.SpendingLimit{
+				Currency:    wallet.USD,
+				MaxAmount:   apd.New(10000, -2),
+				DailyAmount: apd.New(500, 0),
+				HourlyCount: &hourlyCount,
+			},
+			currency: wallet.USD,
+		},
+
+		{
+			name: "valid account limit",
+			limit: wallet.SpendingLimit{
+				AccountID: &accountID,
+				MaxAmount: apd.New(10000, -2),
+			},
+			currency: wallet.EUR,
+		},
+
+		{
+			name: "unknown account",
+			limit: wallet.SpendingLimit{
+				AccountID: &unknownID,
+				MaxAmount: apd.New(1, 0),
+			},
+			err: wallet.ErrNoAccount,
+		},
+
+		{
+			name: "unknown currency",
+			limit: wallet.SpendingLimit{
+				Currency: "XYZ",
+			},
+			err: errInvalidCurrency,
+		},
+
+		{
+			name: "disabled currency",
+			limit: wallet.SpendingLimit{
+				Currency: wallet.JPY,
+			},
+			err: errCurrencyDisabled,
+		},
+
+		{
+			name: "max amount has too many decimal places",
+			limit: wallet.SpendingLimit{
+				Currency:  wallet.USD,
+				MaxAmount: apd.New(1, -3),
+			},
+			err: decimal.ErrInvalidPrecision,
+		},
+
+		{
+			name: "zero daily amount",
+			limit: wallet.SpendingLimit{
+				Currency:    wallet.USD,
+				DailyAmount: apd.New(0, 0),
+			},
+			err: decimal.ErrAmountNotMoreThanZero,
+		},
+
+		{
+			name: "zero hourly count",
+			limit: wallet.SpendingLimit{
+				Currency:    wallet.USD,
+				HourlyCount: &zero,
+			},
+			err: errInvalidHourlyCount,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+
+			ctx := context.Background()
+
+			_, err := s.CreateAccount(ctx, accountID, wallet.EUR, nil)
+			require.NoError(t, err)
+
+			l, err := s.SetSpendingLimit(ctx, tc.limit)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+			// The currency of an account's limit is the account's currency
+			require.Equal(t, tc.currency, l.Currency)
+
+			limits, err := s.SpendingLimits(ctx)
+			require.NoError(t, err)
+			require.Len(t, limits, 1)
+			require.Equal(t, tc.currency, limits[0].Currency)
+		})
+	}
+}
+
+func TestServiceTransferLimits(t *testing.T) {
+	toID := uuid.Must(uuid.FromString("b0505aa0-b927-4667-a484-906b4e2a410b"))
+	fromID := uuid.Must(uuid.FromString("5136843a-0948-432d-8ce6-060362edb538"))
+	two := 2
+
+	cases := []struct {
+		name string
+		// limits are set before the transfers
+		limits []wallet.SpendingLimit
+		// transfers and withdrawals are made from fromID before the tested payment
+		transfers   []*apd.Decimal
+		withdrawals []*apd.Decimal
+		// withdraw makes the tested payment a withdrawal instead of a transfer
+		withdraw bool
+		amount   *apd.Decimal
+		err      error
+	}{
+		{
+			name:   "no limits",
+			amount: apd.New(100, 0),
+		},
+
+		{
+			name: "max amount",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, MaxAmount: apd.New(5000, -2)},
+			},
+			amount: apd.New(5000, -2),
+		},
+
+		{
+			name: "max amount exceeded",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, MaxAmount: apd.New(5000, -2)},
+			},
+			amount: apd.New(5001, -2),
+			err: errLimitExceeded{
+				Limit:     limitMaxAmount,
+				Remaining: "50.00",
+			},
+		},
+
+		{
+			name: "daily amount",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, DailyAmount: apd.New(10000, -2)},
+			},
+			transfers: []*apd.Decimal{apd.New(4000, -2), apd.New(3500, -2)},
+			amount:    apd.New(2500, -2),
+		},
+
+		{
+			name: "daily amount exceeded",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, DailyAmount: apd.New(10000, -2)},
+			},
+			transfers: []*apd.Decimal{apd.New(4000, -2), apd.New(3500, -2)},
+			amount:    apd.New(2501, -2),
+			err: errLimitExceeded{
+				Limit:     limitDailyAmount,
+				Remaining: "25.00",
+			},
+		},
+
+		{
+			name: "hourly count exceeded",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, HourlyCount: &two},
+			},
+			transfers: []*apd.Decimal{apd.New(1, 0), apd.New(1, 0)},
+			amount:    apd.New(1, 0),
+			err: errLimitExceeded{
+				Limit:     limitHourlyCount,
+				Remaining: "0",
+			},
+		},
+
+		{
+			name: "account limit replaces currency limit",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, MaxAmount: apd.New(10, 0)},
+				{AccountID: &fromID, MaxAmount: apd.New(200, 0)},
+			},
+			amount: apd.New(200, 0),
+		},
+
+		{
+			name: "account limit lowered below spending",
+			limits: []wallet.SpendingLimit{
+				{AccountID: &fromID, DailyAmount: apd.New(1000, -2)},
+			},
+			transfers: []*apd.Decimal{apd.New(2000, -2)},
+			amount:    apd.New(1, 0),
+			err: errLimitExceeded{
+				Limit:     limitDailyAmount,
+				Remaining: "0.00",
+			},
+		},
+
+		{
+			name: "withdrawal max amount exceeded",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, MaxAmount: apd.New(5000, -2)},
+			},
+			withdraw: true,
+			amount:   apd.New(5001, -2),
+			err: errLimitExceeded{
+				Limit:     limitMaxAmount,
+				Remaining: "50.00",
+			},
+		},
+
+		{
+			name: "withdrawals count toward daily amount",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, DailyAmount: apd.New(10000, -2)},
+			},
+			withdrawals: []*apd.Decimal{apd.New(4000, -2), apd.New(3500, -2)},
+			amount:      apd.New(2501, -2),
+			err: errLimitExceeded{
+				Limit:     limitDailyAmount,
+				Remaining: "25.00",
+			},
+		},
+
+		{
+			name: "hourly count exceeded by withdrawal",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.USD, HourlyCount: &two},
+			},
+			transfers: []*apd.Decimal{apd.New(1, 0), apd.New(1, 0)},
+			withdraw:  true,
+			amount:    apd.New(1, 0),
+			err: errLimitExceeded{
+				Limit:     limitHourlyCount,
+				Remaining: "0",
+			},
+		},
+
+		{
+			name: "limit of another currency",
+			limits: []wallet.SpendingLimit{
+				{Currency: wallet.EUR, MaxAmount: apd.New(10, 0)},
+			},
+			amount: apd.New(100, 0),
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{toID, fromID} {
+				_, err := s.CreateAccount(ctx, id, wallet.USD, nil)
+				require.NoError(t, err)
+			}
+			_, err := s.Deposit(ctx, fromID, apd.New(1000, 0), "")
+			require.NoError(t, err)
+
+			// The limits are set after the earlier transfers, which may exceed them
+			for _, amount := range tc.transfers {
+				_, err := s.Transfer(ctx, toID, fromID, amount, "")
+				require.NoError(t, err)
+			}
+			for _, amount := range tc.withdrawals {
+				_, err := s.Withdraw(ctx, fromID, amount, "")
+				require.NoError(t, err)
+			}
+			for _, l := range tc.limits {
+				_, err := s.SetSpendingLimit(ctx, l)
+				require.NoError(t, err)
+			}
+
+			if tc.withdraw {
+				_, err = s.Withdraw(ctx, fromID, tc.amount, "")
+			} else {
+				_, err = s.Transfer(ctx, toID, fromID, tc.amount, "")
+			}
+			require.Equal(t, tc.err, err)
+
+			// Deposits are not limited
+			_, err = s.Deposit(ctx, fromID, apd.New(1, 0), "")
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestServiceTransferIdempotent(t *testing.T) {
+	s, repos := newTestService(t)
+
+	ctx := context.Background()
+
+	toID := uuid.Must(uuid.NewV4())
+	fromID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{toID, fromID} {
+		err := repos.accounts.Store(ctx, &wallet.Account{
+			ID:       id,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+	}
+
+	err := repos.payments.Store(ctx, &wallet.Payment{
+		ID:     uuid.Must(uuid.NewV4()),
+		To:     fromID,
+		From:   nil,
+		Amount: apd.New(100, 0),
+	})
+	require.NoError(t, err)
+
+	p, err := s.Transfer(ctx, toID, fromID, apd.New(6000, -2), "key-1")
+	require.NoError(t, err)
+	require.Equal(t, "key-1", p.IdempotencyKey)
+
+	// A replay of the same request returns the original payment,
+	// even though the account no longer has enough balance to repeat it
+	replay, err := s.Transfer(ctx, toID, fromID, apd.New(6000, -2), "key-1")
+	require.NoError(t, err)
+	require.Equal(t, p.ID, replay.ID)
+	require.Equal(t, 0, p.Amount.Cmp(replay.Amount))
+
+	// Reusing the key for a different request is rejected
+	_, err = s.Transfer(ctx, toID, fromID, apd.New(1, 0), "key-1")
+	require.Equal(t, wallet.ErrIdempotencyKeyReused, err)
+
+	_, err = s.Transfer(ctx, fromID, toID, apd.New(6000, -2), "key-1")
+	require.Equal(t, wallet.ErrIdempotencyKeyReused, err)
+
+	// A different key makes a new payment
+	p2, err := s.Transfer(ctx, toID, fromID, apd.New(1, 0), "key-2")
+	require.NoError(t, err)
+	require.NotEqual(t, p.ID, p2.ID)
+
+	payments, _, err := repos.payments.List(ctx, wallet.Page{Limit: 100})
+	require.NoError(t, err)
+	require.Len(t, payments, 3)
+}
+
+func TestServiceTransferBatch(t *testing.T) {
+	aID := uuid.Must(uuid.FromString("1fa7c4b2-3c1e-4b8f-9b6e-0d1a2b3c4d5e"))
+	bID := uuid.Must(uuid.FromString("6c2d8e0f-4a5b-4c6d-8e7f-9a0b1c2d3e4f"))
+	cID := uuid.Must(uuid.FromString("b3e4f5a6-7b8c-4d9e-0f1a-2b3c4d5e6f70"))
+	missingID := uuid.Must(uuid.FromString("e9f0a1b2-c3d4-4e5f-a6b7-c8d9e0f1a2b3"))
+
+	cases := []struct {
+		name      string
+		mode      string
+		transfers []wallet.TransferRequest
+		errs      []error
+		err       error
+		balances  map[uuid.UUID]*apd.Decimal
+	}{
+		{
+			name: "atomic",
+			mode: wallet.BatchModeAtomic,
+			transfers: []wallet.TransferRequest{
+				{To: bID, From: aID, Amount: apd.New(10, 0)},
+				// Spends the balance credited by the previous transfer
+				{To: cID, From: bID, Amount: apd.New(5, 0)},
+			},
+			errs: []error{nil, nil},
+			balances: map[uuid.UUID]*apd.Decimal{
+				aID: apd.New(90, 0),
+				bID: apd.New(5, 0),
+				cID: apd.New(5, 0),
+			},
+		},
+
+		{
+			name: "atomic, a transfer fails",
+			mode: wallet.BatchModeAtomic,
+			transfers: []wallet.TransferRequest{
+				{To: bID, From: aID, Amount: apd.New(10, 0)},
+				{To: cID, From: bID, Amount: apd.New(20, 0)},
+			},
+			err: errBatchTransfer{
+				Index: 1,
+				Err:   errInsufficientBalance,
+			},
+			balances: map[uuid.UUID]*apd.Decimal{
+				aID: apd.New(100, 0),
+				bID: apd.New(0, 0),
+				cID: apd.New(0, 0),
+			},
+		},
+
+		{
+			name: "atomic, an invalid transfer",
+			mode: wallet.BatchModeAtomic,
+			transfers: []wallet.TransferRequest{
+				{To: bID, From: aID, Amount: apd.New(10, 0)},
+				{To: aID, From: aID, Amount: apd.New(1, 0)},
+			},
+			err: errBatchTransfer{
+				Index: 1,
+				Err:   errSameAccount,
+			},
+			balances: map[uuid.UUID]*apd.Decimal{
+				aID: apd.New(100, 0),
+				bID: apd.New(0, 0),
+				cID: apd.New(0, 0),
+			},
+		},
+
+		{
+			name: "best effort",
+			mode: wallet.BatchModeBestEffort,
+			transfers: []wallet.TransferRequest{
+				{To: bID, From: aID, Amount: apd.New(10, 0)},
+				{To: cID, From: bID, Amount: apd.New(20, 0)},
+				{To: aID, From: aID, Amount: apd.New(1, 0)},
+				{To: missingID, From: aID, Amount: apd.New(1, 0)},
+				{To: cID, From: aID, Amount: apd.New(1234, -3)},
+				{To: cID, From: bID, Amount: apd.New(4, 0)},
+			},
+			errs: []error{
+				nil,
+				errInsufficientBalance,
+				errSameAccount,
+				wallet.ErrNoAccount,
+				decimal.ErrInvalidPrecision,
+				nil,
+			},
+			balances: map[uuid.UUID]*apd.Decimal{
+				aID: apd.New(90, 0),
+				bID: apd.New(6, 0),
+				cID: apd.New(4, 0),
+			},
+		},
+
+		{
+			name: "empty batch",
+			mode: wallet.BatchModeAtomic,
+			err:  errInvalidBatchSize,
+		},
+
+		{
+			name: "invalid mode",
+			mode: "all",
+			transfers: []wallet.TransferRequest{
+				{To: bID, From: aID, Amount: apd.New(10, 0)},
+			},
+			err: errInvalidBatchMode,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{aID, bID, cID} {
+				err := repos.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			}
+
+			err := repos.payments.Store(ctx, &wallet.Payment{
+				ID:     uuid.Must(uuid.NewV4()),
+				To:     aID,
+				Amount: apd.New(100, 0),
+			})
+			require.NoError(t, err)
+
+			results, err := s.TransferBatch(ctx, tc.transfers, tc.mode)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				require.Nil(t, results)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, results, len(tc.errs))
+
+				for i, r := range results {
+					require.Equal(t, tc.errs[i], r.Err)
+					if r.Err != nil {
+						require.Nil(t, r.Payment)
+						continue
+					}
+
+					require.NotNil(t, r.Payment)
+					require.True(t, uuid.Equal(tc.transfers[i].To, r.Payment.To))
+					require.True(t, uuid.Equal(tc.transfers[i].From, *r.Payment.From))
+					require.Equal(t, 0, tc.transfers[i].Amount.Cmp(r.Payment.Amount))
+
+					_, err := repos.payments.JournalEntry(ctx, r.Payment.ID)
+					require.NoError(t, err)
+				}
+			}
+
+			for id, balance := range tc.balances {
+				a, err := s.Account(ctx, id)
+				require.NoError(t, err)
+				require.Equal(t, 0, balance.Cmp(a.Balance), "%s != %s", balance, a.Balance)
+			}
+		})
+	}
+}
+
+func TestServiceScheduleTransfer(t *testing.T) {
+	toID := uuid.Must(uuid.FromString("0a8f5c3e-2b7d-4e1f-9c6a-5d4b3a2f1e0d"))
+	fromID := uuid.Must(uuid.FromString("7c1e9b2a-4f3d-4a8e-b6c5-1d0e9f8a7b6c"))
+
+	cases := []struct {
+		name      string
+		to        uuid.UUID
+		from      uuid.UUID
+		amount    *apd.Decimal
+		executeAt time.Time
+		err       error
+	}{
+		{
+			name:      "valid",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(1250, -2),
+			executeAt: time.Now().Add(time.Hour),
+		},
+
+		{
+			name:      "more than the balance",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(1000, 0),
+			executeAt: time.Now().Add(time.Hour),
+		},
+
+		{
+			name:      "execute at in the past",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			executeAt: time.Now().Add(-time.Minute),
+			err:       errExecuteAtNotInFuture,
+		},
+
+		{
+			name:      "same account",
+			to:        fromID,
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			executeAt: time.Now().Add(time.Hour),
+			err:       errSameAccount,
+		},
+
+		{
+			name:      "account does not exist",
+			to:        uuid.Must(uuid.NewV4()),
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			executeAt: time.Now().Add(time.Hour),
+			err:       wallet.ErrNoAccount,
+		},
+
+		{
+			name:      "too many decimal places",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(1001, -3),
+			executeAt: time.Now().Add(time.Hour),
+			err:       decimal.ErrInvalidPrecision,
+		},
+
+		{
+			name:      "negative amount",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(-1, 0),
+			executeAt: time.Now().Add(time.Hour),
+			err:       decimal.ErrAmountNotMoreThanZero,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{toID, fromID} {
+				err := repos.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			}
+
+			st, err := s.ScheduleTransfer(ctx, tc.to, tc.from, tc.amount, tc.executeAt)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, wallet.ScheduledTransferPending, st.Status)
+			require.Equal(t, tc.to, st.To)
+			require.Equal(t, tc.from, st.From)
+			require.Equal(t, 0, tc.amount.Cmp(st.Amount))
+			require.WithinDuration(t, tc.executeAt, st.ExecuteAt, time.Microsecond)
+
+			// Nothing is transferred until the transfer is due
+			n, err := s.ExecuteScheduledTransfers(ctx)
+			require.NoError(t, err)
+			require.Equal(t, 0, n)
+
+			transfers, _, err := s.ScheduledTransfers(ctx, wallet.ScheduledTransferPending, wallet.Page{Limit: 10})
+			require.NoError(t, err)
+			require.Len(t, transfers, 1)
+			require.Equal(t, st.ID, transfers[0].ID)
+		})
+	}
+}
+
+func TestServiceExecuteScheduledTransfers(t *testing.T) {
+	s, repos := newTestService(t)
+
+	ctx := context.Background()
+
+	aID := uuid.Must(uuid.NewV4())
+	bID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{aID, bID} {
+		err := repos.accounts.Store(ctx, &wallet.Account{
+			ID:       id,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+	}
+
+	err := repos.payments.Store(ctx, &wallet.Payment{
+		ID:     uuid.Must(uuid.NewV4()),
+		To:     aID,
+		Amount: apd.New(100, 0),
+	})
+	require.NoError(t, err)
+
+	// Transfers that are already due are stored directly, since the service
+	// only schedules transfers in the future
+	schedule := func(to, from uuid.UUID, amount *apd.Decimal, executeAt time.Time) *wallet.ScheduledTransfer {
+		st := &wallet.ScheduledTransfer{
+			ID:        uuid.Must(uuid.NewV4()),
+			To:        to,
+			From:      from,
+			Amount:    amount,
+			ExecuteAt: executeAt,
+		}
+		require.NoError(t, repos.scheduled.Store(ctx, st))
+		return st
+	}
+
+	now := time.Now()
+	executed := schedule(bID, aID, apd.New(30, 0), now.Add(-time.Hour))
+	// Executed after the first transfer, which doesn't credit enough for it
+	failed := schedule(aID, bID, apd.New(50, 0), now.Add(-time.Minute))
+	pending := schedule(bID, aID, apd.New(1, 0), now.Add(time.Hour))
+
+	n, err := s.ExecuteScheduledTransfers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+
+	a, err := s.Account(ctx, aID)
+	require.NoError(t, err)
+	require.Equal(t, 0, apd.New(70, 0).Cmp(a.Balance))
+	b, err := s.Account(ctx, bID)
+	require.NoError(t, err)
+	require.Equal(t, 0, apd.New(30, 0).Cmp(b.Balance))
+
+	transfers, _, err := s.ScheduledTransfers(ctx, "", wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, transfers, 3)
+
+	require.Equal(t, executed.ID, transfers[0].ID)
+	require.Equal(t, wallet.ScheduledTransferExecuted, transfers[0].Status)
+	require.NotNil(t, transfers[0].PaymentID)
+	require.Empty(t, transfers[0].Error)
+
+	require.Equal(t, failed.ID, transfers[1].ID)
+	require.Equal(t, wallet.ScheduledTransferFailed, transfers[1].Status)
+	require.Nil(t, transfers[1].PaymentID)
+	require.Equal(t, errInsufficientBalance.Error(), transfers[1].Error)
+
+	require.Equal(t, pending.ID, transfers[2].ID)
+	require.Equal(t, wallet.ScheduledTransferPending, transfers[2].Status)
+
+	// Executed and failed transfers are not executed again
+	n, err = s.ExecuteScheduledTransfers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 0, n)
+
+	payments, _, err := repos.payments.List(ctx, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	require.Equal(t, *transfers[0].PaymentID, payments[1].ID)
+
+	// Only pending transfers can be canceled
+	canceled, err := s.CancelScheduledTransfer(ctx, pending.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.ScheduledTransferCanceled, canceled.Status)
+
+	for _, id := range []uuid.UUID{executed.ID, failed.ID, pending.ID} {
+		_, err = s.CancelScheduledTransfer(ctx, id)
+		require.Equal(t, wallet.ErrScheduledTransferNotPending, err)
+	}
+
+	_, err = s.CancelScheduledTransfer(ctx, uuid.Must(uuid.NewV4()))
+	require.Equal(t, wallet.ErrNoScheduledTransfer, err)
+
+	transfers, _, err = s.ScheduledTransfers(ctx, wallet.ScheduledTransferCanceled, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	require.Equal(t, pending.ID, transfers[0].ID)
+
+	_, _, err = s.ScheduledTransfers(ctx, "done", wallet.Page{Limit: 10})
+	require.Equal(t, errInvalidScheduledTransferStatus, err)
+}
+
+func TestStandingOrderOccurrenceAt(t *testing.T) {
+	cases := []struct {
+		name      string
+		frequency string
+		interval  int
+		startAt   time.Time
+		expected  []time.Time
+	}{
+		{
+			name:      "daily",
+			frequency: wallet.FrequencyDaily,
+			interval:  2,
+			startAt:   time.Date(2024, 2, 27, 9, 30, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 2, 27, 9, 30, 0, 0, time.UTC),
+				time.Date(2024, 2, 29, 9, 30, 0, 0, time.UTC),
+				time.Date(2024, 3, 2, 9, 30, 0, 0, time.UTC),
+			},
+		},
+
+		{
+			name:      "weekly",
+			frequency: wallet.FrequencyWeekly,
+			interval:  1,
+			startAt:   time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 12, 25, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 1, 8, 0, 0, 0, 0, time.UTC),
+			},
+		},
+
+		{
+			name:      "monthly at the end of the month",
+			frequency: wallet.FrequencyMonthly,
+			interval:  1,
+			startAt:   time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 2, 29, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 3, 31, 12, 0, 0, 0, time.UTC),
+				time.Date(2024, 4, 30, 12, 0, 0, 0, time.UTC),
+			},
+		},
+
+		{
+			name:      "yearly on a leap day",
+			frequency: wallet.FrequencyMonthly,
+			interval:  12,
+			startAt:   time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+			expected: []time.Time{
+				time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC),
+				time.Date(2025, 2, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2026, 2, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2027, 2, 28, 0, 0, 0, 0, time.UTC),
+				time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC),
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := wallet.StandingOrder{
+				Frequency: tc.frequency,
+				Interval:  tc.interval,
+				StartAt:   tc.startAt,
+			}
+			for i, expected := range tc.expected {
+				require.Equal(t, expected, o.OccurrenceAt(i), "occurrence %d", i)
+			}
+		})
+	}
+}
+
+func TestServiceCreateStandingOrder(t *testing.T) {
+	toID := uuid.Must(uuid.FromString("3e5d7c9b-1a2f-4b6e-8d0c-9f7a5b3c1e2d"))
+	fromID := uuid.Must(uuid.FromString("b4c6e8a0-2d1f-4e3a-9b5c-7d9e1f3a5c7b"))
+
+	startAt := time.Now().Add(time.Hour)
+	endAt := startAt.Add(90 * 24 * time.Hour)
+	beforeStart := startAt.Add(-time.Minute)
+
+	cases := []struct {
+		name      string
+		to        uuid.UUID
+		from      uuid.UUID
+		amount    *apd.Decimal
+		frequency string
+		interval  int
+		startAt   time.Time
+		endAt     *time.Time
+		err       error
+	}{
+		{
+			name:      "valid",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(2500, -2),
+			frequency: wallet.FrequencyMonthly,
+			interval:  1,
+			startAt:   startAt,
+		},
+
+		{
+			name:      "with an end",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyWeekly,
+			interval:  2,
+			startAt:   startAt,
+			endAt:     &endAt,
+		},
+
+		{
+			name:      "invalid frequency",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: "yearly",
+			interval:  1,
+			startAt:   startAt,
+			err:       errInvalidFrequency,
+		},
+
+		{
+			name:      "zero interval",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  0,
+			startAt:   startAt,
+			err:       errInvalidInterval,
+		},
+
+		{
+			name:      "interval too large",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  maxStandingOrderInterval + 1,
+			startAt:   startAt,
+			err:       errInvalidInterval,
+		},
+
+		{
+			name:      "start at in the past",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  1,
+			startAt:   time.Now().Add(-time.Minute),
+			err:       errStartAtNotInFuture,
+		},
+
+		{
+			name:      "end at before start at",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  1,
+			startAt:   startAt,
+			endAt:     &beforeStart,
+			err:       errEndAtBeforeStartAt,
+		},
+
+		{
+			name:      "same account",
+			to:        fromID,
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  1,
+			startAt:   startAt,
+			err:       errSameAccount,
+		},
+
+		{
+			name:      "account does not exist",
+			to:        uuid.Must(uuid.NewV4()),
+			from:      fromID,
+			amount:    apd.New(10, 0),
+			frequency: wallet.FrequencyDaily,
+			interval:  1,
+			startAt:   startAt,
+			err:       wallet.ErrNoAccount,
+		},
+
+		{
+			name:      "too many decimal places",
+			to:        toID,
+			from:      fromID,
+			amount:    apd.New(1001, -3),
+			frequency: wallet.FrequencyDaily,
+			interval:  1,
+			startAt:   startAt,
+			err:       decimal.ErrInvalidPrecision,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{toID, fromID} {
+				err := repos.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			}
+
+			o, err := s.CreateStandingOrder(ctx, wallet.StandingOrder{
+				To:        tc.to,
+				From:      tc.from,
+				Amount:    tc.amount,
+				Frequency: tc.frequency,
+				Interval:  tc.interval,
+				StartAt:   tc.startAt,
+				EndAt:     tc.endAt,
+			})
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, wallet.StandingOrderActive, o.Status)
+			require.Equal(t, tc.to, o.To)
+			require.Equal(t, tc.from, o.From)
+			require.Equal(t, 0, tc.amount.Cmp(o.Amount))
+			require.Equal(t, tc.frequency, o.Frequency)
+			require.Equal(t, tc.interval, o.Interval)
+			require.WithinDuration(t, tc.startAt, o.StartAt, time.Microsecond)
+			require.True(t, o.StartAt.Equal(o.NextRunAt))
+			if tc.endAt == nil {
+				require.Nil(t, o.EndAt)
+			} else {
+				require.NotNil(t, o.EndAt)
+				require.WithinDuration(t, *tc.endAt, *o.EndAt, time.Microsecond)
+			}
+
+			// Nothing is transferred until the order starts
+			occurrences, err := s.ExecuteStandingOrders(ctx)
+			require.NoError(t, err)
+			require.Empty(t, occurrences)
+
+			stored, err := s.StandingOrder(ctx, o.ID)
+			require.NoError(t, err)
+			require.Equal(t, o.ID, stored.ID)
+			require.Zero(t, stored.Occurrences)
+		})
+	}
+}
+
+func TestServiceExecuteStandingOrders(t *testing.T) {
+	s, repos := newTestService(t)
+
+	ctx := context.Background()
+
+	aID := uuid.Must(uuid.NewV4())
+	bID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{aID, bID} {
+		err := repos.accounts.Store(ctx, &wallet.Account{
+			ID:       id,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+	}
+
+	deposit := func(to uuid.UUID, amount *apd.Decimal) {
+		err := repos.payments.Store(ctx, &wallet.Payment{
+			ID:     uuid.Must(uuid.NewV4()),
+			To:     to,
+			Amount: amount,
+		})
+		require.NoError(t, err)
+	}
+	deposit(aID, apd.New(100, 0))
+
+	// Orders that have already started are stored directly, since the service
+	// only creates orders that start in the future
+	store := func(to, from uuid.UUID, amount *apd.Decimal, frequency string, startAt time.Time, endAt *time.Time) *wallet.StandingOrder {
+		o := &wallet.StandingOrder{
+			ID:        uuid.Must(uuid.NewV4()),
+			To:        to,
+			From:      from,
+			Amount:    amount,
+			Frequency: frequency,
+			Interval:  1,
+			StartAt:   startAt,
+			EndAt:     endAt,
+			NextRunAt: startAt,
+		}
+		require.NoError(t, repos.standing.Store(ctx, o))
+		return o
+	}
+
+	// retryNow makes a retrying occurrence due, instead of waiting for occurrenceRetryDelay
+	retryNow := func(id uuid.UUID) {
+		err := repos.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			o, err := repos.standing.GetTx(ctx, tx, id)
+			require.NoError(t, err)
+			require.NotZero(t, o.FailedAttempts)
+			o.NextRunAt = time.Now().Add(-time.Second)
+			return repos.standing.UpdateTx(ctx, tx, o)
+		})
+		require.NoError(t, err)
+	}
+
+	now := time.Now()
+	// The three missed occurrences of the daily order are made one after another,
+	// after which the order ends
+	dailyStart := now.Add(-49 * time.Hour)
+	dailyEnd := dailyStart.Add(48 * time.Hour)
+	daily := store(bID, aID, apd.New(30, 0), wallet.FrequencyDaily, dailyStart, &dailyEnd)
+	// Due after the daily order's occurrences, which leave too little to pay it
+	weekly := store(bID, aID, apd.New(50, 0), wallet.FrequencyWeekly, now.Add(-time.Minute), nil)
+	// Never has enough to pay it
+	monthly := store(aID, bID, apd.New(500, 0), wallet.FrequencyMonthly, now.Add(-time.Minute), nil)
+
+	occurrences, err := s.ExecuteStandingOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, occurrences, 5)
+	for i := 0; i < 3; i++ {
+		require.Equal(t, daily.ID, occurrences[i].StandingOrderID)
+		require.Equal(t, wallet.OccurrenceExecuted, occurrences[i].Status)
+		require.Equal(t, 1, occurrences[i].Attempts)
+		require.NotNil(t, occurrences[i].PaymentID)
+		require.WithinDuration(t, daily.OccurrenceAt(i), occurrences[i].ScheduledAt, time.Microsecond)
+	}
+	for _, o := range occurrences[3:] {
+		require.Equal(t, wallet.OccurrenceRetrying, o.Status)
+		require.Equal(t, 1, o.Attempts)
+		require.Nil(t, o.PaymentID)
+		require.Equal(t, errInsufficientBalance.Error(), o.Error)
+	}
+
+	a, err := s.Account(ctx, aID)
+	require.NoError(t, err)
+	require.Equal(t, 0, apd.New(10, 0).Cmp(a.Balance))
+
+	o, err := s.StandingOrder(ctx, daily.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderCompleted, o.Status)
+	require.Equal(t, 3, o.Occurrences)
+
+	o, err = s.StandingOrder(ctx, weekly.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderActive, o.Status)
+	require.Zero(t, o.Occurrences)
+	require.Equal(t, 1, o.FailedAttempts)
+	require.WithinDuration(t, time.Now().Add(occurrenceRetryDelay), o.NextRunAt, time.Minute)
+
+	// Failed occurrences are not retried until the retry delay has passed
+	occurrences, err = s.ExecuteStandingOrders(ctx)
+	require.NoError(t, err)
+	require.Empty(t, occurrences)
+
+	// A retried occurrence is executed once there is enough balance
+	deposit(aID, apd.New(40, 0))
+	retryNow(weekly.ID)
+	occurrences, err = s.ExecuteStandingOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1)
+	require.Equal(t, wallet.OccurrenceExecuted, occurrences[0].Status)
+	require.Equal(t, 2, occurrences[0].Attempts)
+
+	o, err = s.StandingOrder(ctx, weekly.ID)
+	require.NoError(t, err)
+	require.Equal(t, 1, o.Occurrences)
+	require.Zero(t, o.FailedAttempts)
+	require.WithinDuration(t, weekly.OccurrenceAt(1), o.NextRunAt, time.Microsecond)
+
+	// An occurrence fails after it has been attempted maxOccurrenceAttempts times
+	for attempts := 2; attempts <= maxOccurrenceAttempts; attempts++ {
+		retryNow(monthly.ID)
+		occurrences, err = s.ExecuteStandingOrders(ctx)
+		require.NoError(t, err)
+		require.Len(t, occurrences, 1)
+		require.Equal(t, attempts, occurrences[0].Attempts)
+	}
+	require.Equal(t, wallet.OccurrenceFailed, occurrences[0].Status)
+
+	o, err = s.StandingOrder(ctx, monthly.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderActive, o.Status)
+	require.Equal(t, 1, o.Occurrences)
+	require.Zero(t, o.FailedAttempts)
+	require.WithinDuration(t, monthly.OccurrenceAt(1), o.NextRunAt, time.Microsecond)
+
+	// Each occurrence is recorded once, with its last attempt
+	history, _, err := s.StandingOrderOccurrences(ctx, weekly.ID, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, wallet.OccurrenceExecuted, history[0].Status)
+	require.Equal(t, 2, history[0].Attempts)
+	require.Empty(t, history[0].Error)
+
+	history, _, err = s.StandingOrderOccurrences(ctx, monthly.ID, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, history, 1)
+	require.Equal(t, wallet.OccurrenceFailed, history[0].Status)
+	require.Equal(t, maxOccurrenceAttempts, history[0].Attempts)
+	require.Equal(t, errInsufficientBalance.Error(), history[0].Error)
+
+	_, _, err = s.StandingOrderOccurrences(ctx, uuid.Must(uuid.NewV4()), wallet.Page{Limit: 10})
+	require.Equal(t, wallet.ErrNoStandingOrder, err)
+
+	// The order is completed if its next occurrence is after the new end
+	o, err = s.UpdateStandingOrder(ctx, monthly.ID, apd.New(20, 0), &monthly.StartAt)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderCompleted, o.Status)
+	require.Equal(t, 0, apd.New(20, 0).Cmp(o.Amount))
+
+	weeklyEnd := now.Add(365 * 24 * time.Hour)
+	o, err = s.UpdateStandingOrder(ctx, weekly.ID, apd.New(20, 0), &weeklyEnd)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderActive, o.Status)
+	require.NotNil(t, o.EndAt)
+
+	beforeStart := weekly.StartAt.Add(-time.Hour)
+	_, err = s.UpdateStandingOrder(ctx, weekly.ID, apd.New(20, 0), &beforeStart)
+	require.Equal(t, errEndAtBeforeStartAt, err)
+
+	_, err = s.UpdateStandingOrder(ctx, weekly.ID, apd.New(1001, -3), nil)
+	require.Equal(t, decimal.ErrInvalidPrecision, err)
+
+	// Only active orders can be changed
+	canceled, err := s.CancelStandingOrder(ctx, weekly.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderCanceled, canceled.Status)
+
+	for _, id := range []uuid.UUID{daily.ID, weekly.ID, monthly.ID} {
+		_, err = s.CancelStandingOrder(ctx, id)
+		require.Equal(t, wallet.ErrStandingOrderNotActive, err)
+
+		_, err = s.UpdateStandingOrder(ctx, id, apd.New(1, 0), nil)
+		require.Equal(t, wallet.ErrStandingOrderNotActive, err)
+	}
+
+	_, err = s.CancelStandingOrder(ctx, uuid.Must(uuid.NewV4()))
+	require.Equal(t, wallet.ErrNoStandingOrder, err)
+
+	orders, _, err := s.StandingOrders(ctx, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, orders, 3)
+	require.Equal(t, daily.ID, orders[0].ID)
+	require.Equal(t, weekly.ID, orders[1].ID)
+	require.Equal(t, monthly.ID, orders[2].ID)
+}
+
+func TestServiceWorkerAPIKey(t *testing.T) {
+	s, repos := newTestService(t)
+
+	ctx := context.Background()
+
+	key := &wallet.APIKey{
+		ID:     uuid.Must(uuid.NewV4()),
+		Name:   "test",
+		Scopes: []string{wallet.ScopeTransferWrite},
+	}
+	require.NoError(t, repos.apiKeys.Store(ctx, key, wallet.HashAPIKeyToken("wk_test")))
+	keyCtx := wallet.ContextWithAPIKeyID(ctx, key.ID)
+
+	aID := uuid.Must(uuid.NewV4())
+	bID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{aID, bID} {
+		err := repos.accounts.Store(ctx, &wallet.Account{
+			ID:       id,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+	}
+	_, err := s.Deposit(ctx, aID, apd.New(100, 0), "")
+	require.NoError(t, err)
+
+	// The key that authenticated the request is stored with scheduled transfers and standing orders
+	st, err := s.ScheduleTransfer(keyCtx, bID, aID, apd.New(10, 0), time.Now().Add(time.Hour))
+	require.NoError(t, err)
+	require.NotNil(t, st.APIKeyID)
+	require.Equal(t, key.ID, *st.APIKeyID)
+
+	o, err := s.CreateStandingOrder(keyCtx, wallet.StandingOrder{
+		To:        bID,
+		From:      aID,
+		Amount:    apd.New(10, 0),
+		Frequency: wallet.FrequencyDaily,
+		Interval:  1,
+		StartAt:   time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.NotNil(t, o.APIKeyID)
+	require.Equal(t, key.ID, *o.APIKeyID)
+
+	// Make them due, since the service only schedules them in the future
+	require.NoError(t, repos.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		o, err := repos.standing.GetTx(ctx, tx, o.ID)
+		require.NoError(t, err)
+		o.NextRunAt = time.Now().Add(-time.Second)
+		return repos.standing.UpdateTx(ctx, tx, o)
+	}))
+	due := &wallet.ScheduledTransfer{
+		ID:        uuid.Must(uuid.NewV4()),
+		To:        bID,
+		From:      aID,
+		Amount:    apd.New(10, 0),
+		ExecuteAt: time.Now().Add(-time.Second),
+		APIKeyID:  &key.ID,
+	}
+	require.NoError(t, repos.scheduled.Store(ctx, due))
+
+	// The workers run without a request, and record the stored key on the payments
+	n, err := s.ExecuteScheduledTransfers(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+
+	occurrences, err := s.ExecuteStandingOrders(ctx)
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1)
+	require.Equal(t, wallet.OccurrenceExecuted, occurrences[0].Status)
+
+	transfers, _, err := s.ScheduledTransfers(ctx, wallet.ScheduledTransferExecuted, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	require.Equal(t, due.ID, transfers[0].ID)
+
+	for _, id := range []uuid.UUID{*transfers[0].PaymentID, *occurrences[0].PaymentID} {
+		require.NoError(t, repos.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			p, err := repos.payments.GetTx(ctx, tx, id)
+			require.NoError(t, err)
+			require.NotNil(t, p.APIKeyID)
+			require.Equal(t, key.ID, *p.APIKeyID)
+			return nil
+		}))
+	}
+}
+
+func TestServiceHold(t *testing.T) {
+	fromID := uuid.Must(uuid.NewV4())
+	now := time.Now()
+
+	cases := []struct {
+		name      string
+		from      uuid.UUID
+		amount    *apd.Decimal
+		expiresAt time.Time
+		// expectedExpiresAt is the expected expiry, if it is not expiresAt
+		expectedExpiresAt time.Time
+		err               error
+	}{
+		{
+			name:              "default expiry",
+			from:              fromID,
+			amount:            apd.New(1000, -2),
+			expectedExpiresAt: now.Add(defaultHoldExpiry),
+		},
+
+		{
+			name:      "expires at",
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			expiresAt: now.Add(time.Hour),
+		},
+
+		{
+			name:      "more than the balance",
+			from:      fromID,
+			amount:    apd.New(1001, -2),
+			expiresAt: now.Add(time.Hour),
+			err:       errInsufficientBalance,
+		},
+
+		{
+			name:      "expires in the past",
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			expiresAt: now.Add(-time.Minute),
+			err:       errInvalidHoldExpiry,
+		},
+
+		{
+			name:      "expires after the maximum",
+			from:      fromID,
+			amount:    apd.New(1, 0),
+			expiresAt: now.Add(maxHoldExpiry + time.Hour),
+			err:       errInvalidHoldExpiry,
+		},
+
+		{
+			name:   "negative amount",
+			from:   fromID,
+			amount: apd.New(-1, 0),
+			err:    decimal.ErrAmountNotMoreThanZero,
+		},
+
+		{
+			name:   "too many decimal places",
+			from:   fromID,
+			amount: apd.New(1001, -3),
+			err:    decimal.ErrInvalidPrecision,
+		},
+
+		{
+			name:   "account does not exist",
+			from:   uuid.Must(uuid.NewV4()),
+			amount: apd.New(1, 0),
+			err:    wallet.ErrNoAccount,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			s, _ := newTestService(t)
+
+			ctx := context.Background()
+
+			_, err := s.CreateAccount(ctx, fromID, wallet.USD, nil)
+			require.NoError(t, err)
+			_, err = s.Deposit(ctx, fromID, apd.New(10, 0), "")
+			require.NoError(t, err)
+
+			h, err := s.Hold(ctx, tc.from, tc.amount, tc.expiresAt)
+			if tc.err != nil {
+				require.Equal(t, tc.err, err)
+				return
+			}
+			require.NoError(t, err)
+
+			require.Equal(t, wallet.HoldActive, h.Status)
+			require.Equal(t, tc.from, h.From)
+			require.Equal(t, 0, tc.amount.Cmp(h.Amount))
+			require.Nil(t, h.PaymentID)
+			expectedExpiresAt := tc.expiresAt
+			if !tc.expectedExpiresAt.IsZero() {
+				expectedExpiresAt = tc.expectedExpiresAt
+			}
+			require.WithinDuration(t, expectedExpiresAt, h.ExpiresAt, time.Minute)
+
+			// The hold reduces the available balance, but not the balance
+			a, err := s.Account(ctx, tc.from)
+			require.NoError(t, err)
+			require.Equal(t, 0, apd.New(10, 0).Cmp(a.Balance))
+			require.Equal(t, 0, tc.amount.Cmp(a.Held))
+
+			stored, err := s.GetHold(ctx, h.ID)
+			require.NoError(t, err)
+			require.Equal(t, h.ID, stored.ID)
+		})
+	}
+}
+
+func TestServiceCaptureRelease(t *testing.T) {
+	s, repos := newTestService(t)
+
+	ctx := context.Background()
+
+	aID := uuid.Must(uuid.NewV4())
+	bID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{aID, bID} {
+		_, err := s.CreateAccount(ctx, id, wallet.USD, nil)
+		require.NoError(t, err)
+	}
+	_, err := s.Deposit(ctx, aID, apd.New(100, 0), "")
+	require.NoError(t, err)
+
+	requireBalances := func(balance, available *apd.Decimal) {
+		a, err := s.Account(ctx, aID)
+		require.NoError(t, err)
+		require.Equal(t, 0, balance.Cmp(a.Balance))
+		d, err := a.Available()
+		require.NoError(t, err)
+		require.Equal(t, 0, available.Cmp(d))
+	}
+
+	h, err := s.Hold(ctx, aID, apd.New(60, 0), time.Time{})
+	require.NoError(t, err)
+	requireBalances(apd.New(100, 0), apd.New(40, 0))
+
+	// Held amounts can't be spent by other payments
+	_, err = s.Transfer(ctx, bID, aID, apd.New(50, 0), "")
+	require.Equal(t, errInsufficientBalance, err)
+	_, err = s.Withdraw(ctx, aID, apd.New(41, 0), "")
+	require.Equal(t, errInsufficientBalance, err)
+	_, err = s.Hold(ctx, aID, apd.New(41, 0), time.Time{})
+	require.Equal(t, errInsufficientBalance, err)
+
+	_, err = s.Capture(ctx, h.ID, bID, apd.New(61, 0))
+	require.Equal(t, errCaptureExceedsHold, err)
+	_, err = s.Capture(ctx, h.ID, aID, apd.New(10, 0))
+	require.Equal(t, errSameAccount, err)
+	_, err = s.Capture(ctx, uuid.Must(uuid.NewV4()), bID, apd.New(10, 0))
+	require.Equal(t, wallet.ErrNoHold, err)
+
+	// Capturing part of a hold releases the rest
+	p, err := s.Capture(ctx, h.ID, bID, apd.New(45, 0))
+	require.NoError(t, err)
+	require.Equal(t, bID, p.To)
+	require.Equal(t, aID, *p.From)
+	require.Equal(t, 0, apd.New(45, 0).Cmp(p.Amount))
+	requireBalances(apd.New(55, 0), apd.New(55, 0))
+
+	captured, err := s.GetHold(ctx, h.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldCaptured, captured.Status)
+	require.NotNil(t, captured.PaymentID)
+	require.Equal(t, p.ID, *captured.PaymentID)
+
+	_, err = s.Capture(ctx, h.ID, bID, nil)
+	require.Equal(t, wallet.ErrHoldNotActive, err)
+	_, err = s.Release(ctx, h.ID)
+	require.Equal(t, wallet.ErrHoldNotActive, err)
+
+	// A nil amount captures the whole hold
+	h, err = s.Hold(ctx, aID, apd.New(5, 0), time.Time{})
+	require.NoError(t, err)
+	p, err = s.Capture(ctx, h.ID, bID, nil)
+	require.NoError(t, err)
+	require.Equal(t, 0, apd.New(5, 0).Cmp(p.Amount))
+	requireBalances(apd.New(50, 0), apd.New(50, 0))
+
+	// Released holds are available again
+	h, err = s.Hold(ctx, aID, apd.New(20, 0), time.Time{})
+	require.NoError(t, err)
+	requireBalances(apd.New(50, 0), apd.New(30, 0))
+	released, err := s.Release(ctx, h.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldReleased, released.Status)
+	requireBalances(apd.New(50, 0), apd.New(50, 0))
+
+	_, err = s.Release(ctx, uuid.Must(uuid.NewV4()))
+	require.Equal(t, wallet.ErrNoHold, err)
+
+	// Holds that have passed their expiry can't be captured, and are expired by ExpireHolds.
+	// The hold is stored directly, since the service only creates holds that expire in the future.
+	expired := &wallet.Hold{
+		ID:        uuid.Must(uuid.NewV4()),
+		From:      aID,
+		Amount:    apd.New(10, 0),
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}
+	err = repos.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return repos.holds.StoreTx(ctx, tx, expired)
+	})
+	require.NoError(t, err)
+	requireBalances(apd.New(50, 0), apd.New(40, 0))
+
+	_, err = s.Capture(ctx, expired.ID, bID, nil)
+	require.Equal(t, errHoldExpired, err)
+
+	n, err := s.ExpireHolds(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	requireBalances(apd.New(50, 0), apd.New(50, 0))
+
+	h, err = s.GetHold(ctx, expired.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldExpired, h.Status)
+
+	n, err = s.ExpireHolds(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func TestServiceSetAccountStatus(t *testing.T) {
+	s, _ := newTestService(t)
+
+	ctx := context.Background()
+
+	aID := uuid.Must(uuid.NewV4())
+	bID := uuid.Must(uuid.NewV4())
+	for _, id := range []uuid.UUID{aID, bID} {
+		a, err := s.CreateAccount(ctx, id, wallet.USD, nil)
+		require.NoError(t, err)
+		require.Equal(t, wallet.AccountActive, a.Status)
+	}
+	_, err := s.Deposit(ctx, aID, apd.New(100, 0), "")
+	require.NoError(t, err)
+	p, err := s.Transfer(ctx, bID, aID, apd.New(10, 0), "")
+	require.NoError(t, err)
+
+	_, err = s.SetAccountStatus(ctx, aID, "suspended")
+	require.Equal(t, errInvalidAccountStatus, err)
+	_, err = s.SetAccountStatus(ctx, uuid.Must(uuid.NewV4()), wallet.AccountFrozen)
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	a, err := s.SetAccountStatus(ctx, aID, wallet.AccountFrozen)
+	require.NoError(t, err)
+	require.Equal(t, wallet.AccountFrozen, a.Status)
+	require.Equal(t, 0, apd.New(90, 0).Cmp(a.Balance))
+
+	// A frozen account can't send money, but can receive it
+	_, err = s.Transfer(ctx, bID, aID, apd.New(1, 0), "")
+	require.Equal(t, errAccountFrozen, err)
+	_, err = s.Withdraw(ctx, aID, apd.New(1, 0), "")
+	require.Equal(t, errAccountFrozen, err)
+	_, err = s.Hold(ctx, aID, apd.New(1, 0), time.Time{})
+	require.Equal(t, errAccountFrozen, err)
+	_, err = s.Deposit(ctx, aID, apd.New(1, 0), "")
+	require.NoError(t, err)
+	_, err = s.Transfer(ctx, aID, bID, apd.New(1, 0), "")
+	require.NoError(t, err)
+	_, err = s.Refund(ctx, p.ID, apd.New(1, 0), "")
+	require.NoError(t, err)
+
+	a, err = s.SetAccountStatus(ctx, aID, wallet.AccountActive)
+	require.NoError(t, err)
+	require.Equal(t, wallet.AccountActive, a.Status)
+	_, err = s.Transfer(ctx, bID, aID, apd.New(1, 0), "")
+	require.NoError(t, err)
+
+	// An account can only be closed with a zero balance
+	_, err = s.SetAccountStatus(ctx, bID, wallet.AccountClosed)
+	require.Equal(t, errAccountBalanceNotZero, err)
+	b, err := s.Account(ctx, bID)
+	require.NoError(t, err)
+	_, err = s.Withdraw(ctx, bID, b.Balance, "")
+	require.NoError(t, err)
+	b, err = s.SetAccountStatus(ctx, bID, wallet.AccountClosed)
+	require.NoError(t, err)
+	require.Equal(t, wallet.AccountClosed, b.Status)
+
+	// A closed account can't send or receive money, or be reopened
+	_, err = s.Transfer(ctx, bID, aID, apd.New(1, 0), "")
+	require.Equal(t, errAccountClosed, err)
+	_, err = s.Deposit(ctx, bID, apd.New(1, 0), "")
+	require.Equal(t, errAccountClosed, err)
+	_, err = s.Refund(ctx, p.ID, apd.New(1, 0), "")
+	require.Equal(t, errAccountClosed, err)
+	_, err = s.SetAccountStatus(ctx, bID, wallet.AccountActive)
+	require.Equal(t, errAccountClosed, err)
+}
+
+func TestServiceCustomers(t *testing.T) {
+	s, _ := newTestService(t)
+
+	ctx := context.Background()
+
+	for _, name := range []string{"", "  ", strings.Repeat("a", maxCustomerNameLength+1)} {
+		_, err := s.CreateCustomer(ctx, uuid.Nil, name)
+		require.Equal(t, errInvalidCustomerName, err)
+	}
+
+	// The ID is generated and the name is trimmed
+	c, err := s.CreateCustomer(ctx, uuid.Nil, " Alice ")
+	require.NoError(t, err)
+	require.NotEqual(t, uuid.Nil, c.ID)
+	require.Equal(t, "Alice", c.Name)
+
+	_, err = s.CreateCustomer(ctx, c.ID, "Bob")
+	require.Equal(t, wallet.ErrCustomerExists, err)
+
+	got, err := s.Customer(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, c.ID, got.ID)
+
+	missingID := uuid.Must(uuid.NewV4())
+	_, err = s.Customer(ctx, missingID)
+	require.Equal(t, wallet.ErrNoCustomer, err)
+	_, _, err = s.CustomerAccounts(ctx, missingID, wallet.Page{Limit: 10})
+	require.Equal(t, wallet.ErrNoCustomer, err)
+	_, err = s.CustomerBalances(ctx, missingID)
+	require.Equal(t, wallet.ErrNoCustomer, err)
+	_, _, err = s.CustomerPayments(ctx, missingID, wallet.Page{Limit: 10})
+	require.Equal(t, wallet.ErrNoCustomer, err)
+	_, err = s.CreateAccount(ctx, uuid.Nil, wallet.USD, &missingID)
+	require.Equal(t, wallet.ErrNoCustomer, err)
+
+	accounts, next, err := s.CustomerAccounts(ctx, c.ID, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+	require.Empty(t, next)
+
+	usd, err := s.CreateAccount(ctx, uuid.Nil, wallet.USD, &c.ID)
+	require.NoError(t, err)
+	require.Equal(t, c.ID, *usd.OwnerID)
+	eur, err := s.CreateAccount(ctx, uuid.Nil, wallet.EUR, &c.ID)
+	require.NoError(t, err)
+	unowned, err := s.CreateAccount(ctx, uuid.Nil, wallet.USD, nil)
+	require.NoError(t, err)
+
+	_, err = s.Deposit(ctx, usd.ID, apd.New(1050, -2), "")
+	require.NoError(t, err)
+	_, err = s.Deposit(ctx, eur.ID, apd.New(3, 0), "")
+	require.NoError(t, err)
+	_, err = s.Deposit(ctx, unowned.ID, apd.New(1, 0), "")
+	require.NoError(t, err)
+
+	_, _, err = s.CustomerAccounts(ctx, c.ID, wallet.Page{Limit: 0})
+	require.Equal(t, errInvalidLimit, err)
+
+	accounts, _, err = s.CustomerAccounts(ctx, c.ID, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, accounts, 2)
+	for _, a := range accounts {
+		require.True(t, uuid.Equal(a.ID, usd.ID) || uuid.Equal(a.ID, eur.ID))
+	}
+
+	balances, err := s.CustomerBalances(ctx, c.ID)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	require.Equal(t, wallet.EUR, balances[0].Currency)
+	require.Equal(t, "3.00", balances[0].Balance.String())
+	require.Equal(t, wallet.USD, balances[1].Currency)
+	require.Equal(t, "10.50", balances[1].Balance.String())
+	require.Equal(t, 1, balances[1].Accounts)
+
+	// The deposit to the account without an owner is not one of the customer's payments
+	payments, _, err := s.CustomerPayments(ctx, c.ID, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	for _, p := range payments {
+		require.True(t, uuid.Equal(p.To, usd.ID) || uuid.Equal(p.To, eur.ID))
+	}
+}
+
+func TestServiceTransferConcurrent(t *testing.T) {
+	db, shutdown := setupDB(t)
+	defer shutdown()
+
+	repos := newPostgresRepositories(db)
+	s := repos.newService()
+
+	ctx := context.Background()
+
+	// Create accounts, each credited with 5.00
+	nAccounts := 4
+	accountIDs := make([]uuid.UUID, nAccounts)
+	for i := range accountIDs {
+		accountIDs[i] = uuid.Must(uuid.NewV4())
+
+		err := repos.accounts.Store(ctx, &wallet.Account{
+			ID:       accountIDs[i],
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+
+		err = repos.payments.Store(ctx, &wallet.Payment{
+			ID:     uuid.Must(uuid.NewV4()),
+			To:     accountIDs[i],
+			From:   nil,
+			Amount: apd.New(500, -2),
+		})
+		require.NoError(t, err)
+	}
+
+	// Many concurrent transfers of 1.00 between every pair of accounts,
+	// in both directions. Transfers may fail if an account is drained by the
+	// transfers before it is credited, but balances must stay consistent.
+	// See TestServiceTransferDoubleSpend for overdrawing an account.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	succeeded := 0
+	rounds := 4
+	for r := 0; r < rounds; r++ {
+		for i := range accountIDs {
+			for j := range accountIDs {
+				if i == j {
+					continue
+				}
+
+				wg.Add(1)
+				go func(to, from uuid.UUID) {
+					defer wg.Done()
+					_, err := s.Transfer(ctx, to, from, apd.New(100, -2), "")
+					if err != nil {
+						// The only acceptable failure is an insufficient balance
+						assert.Equal(t, errInsufficientBalance, err)
+						return
+					}
+
+					mu.Lock()
+					defer mu.Unlock()
+					succeeded++
+				}(accountIDs[i], accountIDs[j])
+			}
+		}
+	}
+	wg.Wait()
+
+	require.NotZero(t, succeeded)
+
+	// No balance may be negative, and the total balance must be unchanged
+	accounts, _, err := repos.accounts.List(ctx, wallet.Page{Limit: 100})
+	require.NoError(t, err)
+	require.Len(t, accounts, nAccounts)
+
+	total := apd.New(0, 0)
+	for _, a := range accounts {
+		require.True(t, a.Balance.Sign() >= 0, "account %s has a negative balance %s", a.ID, a.Balance)
+		_, err := apd.BaseContext.Add(total, total, a.Balance)
+		require.NoError(t, err)
+	}
+	require.Equal(t, 0, total.Cmp(apd.New(2000, -2)), "total balance changed: %s", total)
+
+	// Every successful transfer must have been stored
+	payments, _, err := repos.payments.List(ctx, wallet.Page{Limit: 100})
+	require.NoError(t, err)
+	require.Len(t, payments, nAccounts+succeeded)
+}
+
+func TestServiceTransferDoubleSpend(t *testing.T) {
+	cases := []struct {
+		name  string
+		setup func(t *testing.T) (testRepositories, func())
+	}{
+		{
+			name: "inmem",
+			setup: func(t *testing.T) (testRepositories, func()) {
+				_, repos := newTestService(t)
+				return repos, func() {}
+			},
+		},
+
+		{
+			name: "postgres",
+			setup: func(t *testing.T) (testRepositories, func()) {
+				db, shutdown := setupDB(t)
+				return newPostgresRepositories(db), shutdown
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			repos, shutdown := tc.setup(t)
+			defer shutdown()
+			s := repos.newService()
+
+			ctx := context.Background()
+
+			fromID := uuid.Must(uuid.NewV4())
+			toID := uuid.Must(uuid.NewV4())
+			for _, id := range []uuid.UUID{fromID, toID} {
+				err := repos.accounts.Store(ctx, &wallet.Account{
+					ID:       id,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			}
+
+			_, err := s.Deposit(ctx, fromID, apd.New(500, -2), "")
+			require.NoError(t, err)
+
+			// Concurrent transfers of 1.00 from an account with a balance of 5.00, for a total of 20.00.
+			// Exactly 5 of them can be made, and the rest must fail without overdrawing the account.
+			nTransfers := 20
+			start := make(chan struct{})
+			errs := make(chan error, nTransfers)
+			var wg sync.WaitGroup
+			for i := 0; i < nTransfers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					<-start
+					_, err := s.Transfer(ctx, toID, fromID, apd.New(100, -2), "")
+					errs <- err
+				}()
+			}
+			close(start)
+			wg.Wait()
+			close(errs)
+
+			succeeded := 0
+			for err := range errs {
+				if err == nil {
+					succeeded++
+					continue
+				}
+				require.Equal(t, errInsufficientBalance, err)
+			}
+			require.Equal(t, 5, succeeded)
+
+			from, err := s.Account(ctx, fromID)
+			require.NoError(t, err)
+			require.Equal(t, 0, from.Balance.Sign(), "balance is %s", from.Balance)
+
+			to, err := s.Account(ctx, toID)
+			require.NoError(t, err)
+			require.Equal(t, 0, apd.New(500, -2).Cmp(to.Balance), "balance is %s", to.Balance)
+
+			payments, _, err := repos.payments.List(ctx, wallet.Page{Limit: 100})
+			require.NoError(t, err)
+			require.Len(t, payments, 1+succeeded)
 		})
 	}
 }
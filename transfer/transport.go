@@ -4,70 +4,617 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/go-kit/kit/endpoint"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/transport"
 	kithttp "github.com/go-kit/kit/transport/http"
+	uuid "github.com/satori/go.uuid"
 
 	wallet "github.com/xsleonard/gokit-example"
 	"github.com/xsleonard/gokit-example/decimal"
 )
 
-var errMethodNotAllowed = errors.New(http.StatusText(http.StatusMethodNotAllowed))
+var (
+	errMethodNotAllowed = errors.New(http.StatusText(http.StatusMethodNotAllowed))
+	errNotFound         = errors.New(http.StatusText(http.StatusNotFound))
+)
+
+const (
+	// accountPathPrefix is the URL path prefix for a single account, /v1/accounts/{id}
+	accountPathPrefix = "/v1/accounts/"
+	// paymentPathPrefix is the URL path prefix for a single payment, /v1/payments/{id}
+	paymentPathPrefix = "/v1/payments/"
+	// scheduledTransferPathPrefix is the URL path prefix for a single scheduled transfer, /v1/transfers/scheduled/{id}
+	scheduledTransferPathPrefix = "/v1/transfers/scheduled/"
+	// standingOrderPathPrefix is the URL path prefix for a single standing order, /v1/standing-orders/{id}
+	standingOrderPathPrefix = "/v1/standing-orders/"
+	// holdPathPrefix is the URL path prefix for a single hold, /v1/holds/{id}
+	holdPathPrefix = "/v1/holds/"
+	// customerPathPrefix is the URL path prefix for a single customer, /v1/customers/{id}
+	customerPathPrefix = "/v1/customers/"
+	// adminAccountPathPrefix is the URL path prefix for administering a single account, /v1/admin/accounts/{id}
+	adminAccountPathPrefix = "/v1/admin/accounts/"
+	// defaultPageLimit is the number of results in a page if a limit is not requested
+	defaultPageLimit = 100
+)
 
 // MakeHandler returns a handler for the tracking service.
-func MakeHandler(s wallet.Service, logger log.Logger) http.Handler {
+// Requests are authenticated with the API keys of keys, and each endpoint requires a scope,
+// see wallet.Scopes. End users authenticate with bearer tokens verified by verifier instead,
+// and can only use the transfer and read endpoints, limited to their own accounts.
+// If both keys and verifier are nil, requests are not authenticated.
+func MakeHandler(s wallet.Service, keys wallet.APIKeyRepository, verifier *JWTVerifier, logger log.Logger) http.Handler {
 	r := http.NewServeMux()
 
 	opts := []kithttp.ServerOption{
+		kithttp.ServerBefore(apiKeyTokenToContext, bearerTokenToContext),
 		kithttp.ServerErrorHandler(transport.NewLogErrorHandler(logger)),
 		kithttp.ServerErrorEncoder(encodeError),
 	}
 
+	middleware := func(scope string, users bool) endpoint.Middleware {
+		if keys == nil && verifier == nil {
+			return func(next endpoint.Endpoint) endpoint.Endpoint {
+				return next
+			}
+		}
+		return newAuthMiddleware(keys, verifier, scope, users)
+	}
+	// authorize requires an API key with scope
+	authorize := func(scope string) endpoint.Middleware {
+		return middleware(scope, false)
+	}
+	// authorizeUser requires an API key with scope, or a bearer token
+	authorizeUser := func(scope string) endpoint.Middleware {
+		return middleware(scope, true)
+	}
+
 	transferHandler := kithttp.NewServer(
-		makeTransferEndpoint(s),
+		authorizeUser(wallet.ScopeTransferWrite)(makeTransferEndpoint(s)),
 		decodeTransferRequest,
 		encodeResponse,
 		opts...,
 	)
 
+	transferBatchHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeTransferBatchEndpoint(s)),
+		decodeTransferBatchRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	scheduleTransferHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeScheduleTransferEndpoint(s)),
+		decodeScheduleTransferRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	scheduledTransfersHandler := kithttp.NewServer(
+		authorize(wallet.ScopePaymentsRead)(makeScheduledTransfersEndpoint(s)),
+		decodeScheduledTransfersRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	cancelScheduledTransferHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeCancelScheduledTransferEndpoint(s)),
+		decodeCancelScheduledTransferRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	createStandingOrderHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeCreateStandingOrderEndpoint(s)),
+		decodeCreateStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	standingOrdersHandler := kithttp.NewServer(
+		authorize(wallet.ScopePaymentsRead)(makeStandingOrdersEndpoint(s)),
+		decodePageRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	standingOrderHandler := kithttp.NewServer(
+		authorize(wallet.ScopePaymentsRead)(makeStandingOrderEndpoint(s)),
+		decodeStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	updateStandingOrderHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeUpdateStandingOrderEndpoint(s)),
+		decodeUpdateStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	cancelStandingOrderHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeCancelStandingOrderEndpoint(s)),
+		decodeStandingOrderRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	standingOrderOccurrencesHandler := kithttp.NewServer(
+		authorize(wallet.ScopePaymentsRead)(makeStandingOrderOccurrencesEndpoint(s)),
+		decodeStandingOrderOccurrencesRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	createHoldHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeCreateHoldEndpoint(s)),
+		decodeCreateHoldRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	holdHandler := kithttp.NewServer(
+		authorize(wallet.ScopePaymentsRead)(makeHoldEndpoint(s)),
+		decodeHoldRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	captureHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeCaptureEndpoint(s)),
+		decodeCaptureRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	releaseHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeReleaseEndpoint(s)),
+		decodeReleaseRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	depositHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeDepositEndpoint(s)),
+		decodeDepositRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	withdrawHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeWithdrawEndpoint(s)),
+		decodeWithdrawRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	refundHandler := kithttp.NewServer(
+		authorize(wallet.ScopeTransferWrite)(makeRefundEndpoint(s)),
+		decodeRefundRequest,
+		encodeResponse,
+		opts...,
+	)
+
 	paymentsHandler := kithttp.NewServer(
-		makePaymentsEndpoint(s),
-		decodeEmptyRequest([]string{http.MethodGet}),
+		authorizeUser(wallet.ScopePaymentsRead)(makePaymentsEndpoint(s)),
+		decodePageRequest,
 		encodeResponse,
 		opts...,
 	)
 
 	accountsHandler := kithttp.NewServer(
-		makeAccountsEndpoint(s),
-		decodeEmptyRequest([]string{http.MethodGet}),
+		authorizeUser(wallet.ScopeAccountsRead)(makeAccountsEndpoint(s)),
+		decodePageRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	createAccountHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAccountsWrite)(makeCreateAccountEndpoint(s)),
+		decodeCreateAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	accountHandler := kithttp.NewServer(
+		authorizeUser(wallet.ScopeAccountsRead)(makeAccountEndpoint(s)),
+		decodeAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	accountPaymentsHandler := kithttp.NewServer(
+		authorizeUser(wallet.ScopePaymentsRead)(makeAccountPaymentsEndpoint(s)),
+		decodeAccountPaymentsRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	freezeAccountHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetAccountStatusEndpoint(s, wallet.AccountFrozen)),
+		decodeAdminAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	unfreezeAccountHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetAccountStatusEndpoint(s, wallet.AccountActive)),
+		decodeAdminAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	closeAccountHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetAccountStatusEndpoint(s, wallet.AccountClosed)),
+		decodeAdminAccountRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	createCustomerHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAccountsWrite)(makeCreateCustomerEndpoint(s)),
+		decodeCreateCustomerRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	customerHandler := kithttp.NewServer(
+		authorizeUser(wallet.ScopeAccountsRead)(makeCustomerEndpoint(s)),
+		decodeCustomerRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	customerAccountsHandler := kithttp.NewServer(
+		authorizeUser(wallet.ScopeAccountsRead)(makeCustomerAccountsEndpoint(s)),
+		decodeCustomerAccountsRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	customerBalancesHandler := kithttp.NewServer(
+		authorizeUser(wallet.ScopeAccountsRead)(makeCustomerBalancesEndpoint(s)),
+		decodeCustomerRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	exchangeRatesHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeExchangeRatesEndpoint(s)),
+		decodeEmptyRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	setExchangeRateHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetExchangeRateEndpoint(s)),
+		decodeSetExchangeRateRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	feeSchedulesHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeFeeSchedulesEndpoint(s)),
+		decodeEmptyRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	setFeeScheduleHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetFeeScheduleEndpoint(s)),
+		decodeSetFeeScheduleRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	spendingLimitsHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSpendingLimitsEndpoint(s)),
+		decodeEmptyRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	setSpendingLimitHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetSpendingLimitEndpoint(s)),
+		decodeSetSpendingLimitRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	currenciesHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeCurrenciesEndpoint(s)),
+		decodeEmptyRequest,
+		encodeResponse,
+		opts...,
+	)
+
+	setCurrencyHandler := kithttp.NewServer(
+		authorize(wallet.ScopeAdmin)(makeSetCurrencyEndpoint(s)),
+		decodeSetCurrencyRequest,
 		encodeResponse,
 		opts...,
 	)
 
 	r.Handle("/v1/transfer", transferHandler)
+	r.Handle("/v1/transfers/batch", transferBatchHandler)
+	r.Handle("/v1/transfers/scheduled", methodHandler{
+		http.MethodGet:  scheduledTransfersHandler,
+		http.MethodPost: scheduleTransferHandler,
+	})
+	r.Handle(scheduledTransferPathPrefix, resourceHandler{
+		prefix: scheduledTransferPathPrefix,
+		subresources: map[string]http.Handler{
+			"cancel": cancelScheduledTransferHandler,
+		},
+	})
+	r.Handle("/v1/standing-orders", methodHandler{
+		http.MethodGet:  standingOrdersHandler,
+		http.MethodPost: createStandingOrderHandler,
+	})
+	r.Handle(standingOrderPathPrefix, resourceHandler{
+		prefix: standingOrderPathPrefix,
+		subresources: map[string]http.Handler{
+			"": methodHandler{
+				http.MethodGet:    standingOrderHandler,
+				http.MethodPut:    updateStandingOrderHandler,
+				http.MethodDelete: cancelStandingOrderHandler,
+			},
+			"occurrences": standingOrderOccurrencesHandler,
+		},
+	})
+	r.Handle("/v1/holds", createHoldHandler)
+	r.Handle(holdPathPrefix, resourceHandler{
+		prefix: holdPathPrefix,
+		subresources: map[string]http.Handler{
+			"": methodHandler{
+				http.MethodGet: holdHandler,
+			},
+			"capture": captureHandler,
+			"release": releaseHandler,
+		},
+	})
+	r.Handle("/v1/deposit", depositHandler)
+	r.Handle("/v1/withdraw", withdrawHandler)
 	r.Handle("/v1/payments", paymentsHandler)
-	r.Handle("/v1/accounts", accountsHandler)
+	r.Handle(paymentPathPrefix, resourceHandler{
+		prefix: paymentPathPrefix,
+		subresources: map[string]http.Handler{
+			"refund": refundHandler,
+		},
+	})
+	r.Handle("/v1/accounts", methodHandler{
+		http.MethodGet:  accountsHandler,
+		http.MethodPost: createAccountHandler,
+	})
+	r.Handle("/v1/admin/rates", methodHandler{
+		http.MethodGet: exchangeRatesHandler,
+		http.MethodPut: setExchangeRateHandler,
+	})
+	r.Handle("/v1/admin/fees", methodHandler{
+		http.MethodGet: feeSchedulesHandler,
+		http.MethodPut: setFeeScheduleHandler,
+	})
+	r.Handle("/v1/admin/limits", methodHandler{
+		http.MethodGet: spendingLimitsHandler,
+		http.MethodPut: setSpendingLimitHandler,
+	})
+	r.Handle("/v1/admin/currencies", methodHandler{
+		http.MethodGet: currenciesHandler,
+		http.MethodPut: setCurrencyHandler,
+	})
+	r.Handle(adminAccountPathPrefix, resourceHandler{
+		prefix: adminAccountPathPrefix,
+		subresources: map[string]http.Handler{
+			"freeze":   freezeAccountHandler,
+			"unfreeze": unfreezeAccountHandler,
+			"close":    closeAccountHandler,
+		},
+	})
+	r.Handle(accountPathPrefix, resourceHandler{
+		prefix: accountPathPrefix,
+		subresources: map[string]http.Handler{
+			"":         accountHandler,
+			"payments": accountPaymentsHandler,
+		},
+	})
+	r.Handle("/v1/customers", createCustomerHandler)
+	r.Handle(customerPathPrefix, resourceHandler{
+		prefix: customerPathPrefix,
+		subresources: map[string]http.Handler{
+			"":         customerHandler,
+			"accounts": customerAccountsHandler,
+			"balances": customerBalancesHandler,
+		},
+	})
 
 	return r
 }
 
-func decodeEmptyRequest(allowedMethods []string) kithttp.DecodeRequestFunc {
-	return func(_ context.Context, r *http.Request) (interface{}, error) {
-		allowed := false
-		for _, m := range allowedMethods {
-			if r.Method == m {
-				allowed = true
-				break
-			}
+// methodHandler dispatches requests to a handler by the request's HTTP method
+type methodHandler map[string]http.Handler
+
+func (h methodHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	handler, ok := h[r.Method]
+	if !ok {
+		encodeError(r.Context(), errMethodNotAllowed, w)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// resourceHandler routes requests for a resource identified by an ID in the URL path.
+// Paths of the form prefix + "{id}" + "/" + subresource are dispatched to the handler
+// registered for the subresource. The empty subresource is the resource itself.
+type resourceHandler struct {
+	prefix       string
+	subresources map[string]http.Handler
+}
+
+func (h resourceHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id, subresource := splitResourcePath(r.URL.Path, h.prefix)
+	handler, ok := h.subresources[subresource]
+	if id == "" || !ok {
+		encodeError(r.Context(), errNotFound, w)
+		return
+	}
+	handler.ServeHTTP(w, r)
+}
+
+// splitResourcePath splits a URL path of the form prefix + "{id}" + "/" + subresource
+func splitResourcePath(path, prefix string) (id, subresource string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 2)
+	id = parts[0]
+	if len(parts) == 2 {
+		subresource = parts[1]
+	}
+	return id, subresource
+}
+
+// parseAccountPath parses the account ID from a URL path under /v1/accounts/{id}
+func parseAccountPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, accountPathPrefix)
+	accountID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "account",
+			Err:      err,
+			Field:    "id",
+		}
+	}
+	return accountID, nil
+}
+
+// parseCustomerPath parses the customer ID from a URL path under /v1/customers/{id}
+func parseCustomerPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, customerPathPrefix)
+	customerID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "customer",
+			Err:      err,
+			Field:    "id",
 		}
-		if !allowed {
-			return nil, errMethodNotAllowed
+	}
+	return customerID, nil
+}
+
+// parsePaymentPath parses the payment ID from a URL path under /v1/payments/{id}
+func parsePaymentPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, paymentPathPrefix)
+	paymentID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "payment",
+			Err:      err,
+			Field:    "id",
+		}
+	}
+	return paymentID, nil
+}
+
+// parseScheduledTransferPath parses the scheduled transfer ID from a URL path under /v1/transfers/scheduled/{id}
+func parseScheduledTransferPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, scheduledTransferPathPrefix)
+	scheduledTransferID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "scheduled transfer",
+			Err:      err,
+			Field:    "id",
+		}
+	}
+	return scheduledTransferID, nil
+}
+
+// parseStandingOrderPath parses the standing order ID from a URL path under /v1/standing-orders/{id}
+func parseStandingOrderPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, standingOrderPathPrefix)
+	standingOrderID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "standing order",
+			Err:      err,
+			Field:    "id",
+		}
+	}
+	return standingOrderID, nil
+}
+
+// parseHoldPath parses the hold ID from a URL path under /v1/holds/{id}
+func parseHoldPath(r *http.Request) (uuid.UUID, error) {
+	id, _ := splitResourcePath(r.URL.Path, holdPathPrefix)
+	holdID, err := uuid.FromString(id)
+	if err != nil {
+		return uuid.Nil, errInvalidID{
+			Resource: "hold",
+			Err:      err,
+			Field:    "id",
 		}
+	}
+	return holdID, nil
+}
+
+// decodeAdminAccountRequest decodes a request for /v1/admin/accounts/{id}/{action}
+func decodeAdminAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	id, _ := splitResourcePath(r.URL.Path, adminAccountPathPrefix)
+	accountID, err := uuid.FromString(id)
+	if err != nil {
+		return nil, errInvalidID{
+			Resource: "account",
+			Err:      err,
+			Field:    "id",
+		}
+	}
+
+	return accountRequest{
+		ID: accountID,
+	}, nil
+}
+
+func decodeEmptyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	return struct{}{}, nil
+}
 
-		return struct{}{}, nil
+func decodePageRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
 	}
+
+	return parsePage(r)
+}
+
+// parsePage parses the "limit" and "cursor" query parameters of a list request
+func parsePage(r *http.Request) (wallet.Page, error) {
+	q := r.URL.Query()
+
+	page := wallet.Page{
+		Cursor: q.Get("cursor"),
+		Limit:  defaultPageLimit,
+	}
+
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil {
+			return wallet.Page{}, errInvalidLimit
+		}
+		page.Limit = n
+	}
+
+	return page, nil
 }
 
 type decodeError struct {
@@ -84,6 +631,425 @@ func decodeTransferRequest(_ context.Context, r *http.Request) (interface{}, err
 		return nil, decodeError{err}
 	}
 
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	return req, nil
+}
+
+func decodeTransferBatchRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req transferBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeScheduleTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req scheduleTransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeScheduledTransfersRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return scheduledTransfersRequest{
+		Status: r.URL.Query().Get("status"),
+		Page:   page,
+	}, nil
+}
+
+func decodeCancelScheduledTransferRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseScheduledTransferPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return cancelScheduledTransferRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeCreateStandingOrderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req createStandingOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+// decodeStandingOrderRequest decodes a request for /v1/standing-orders/{id},
+// whose method is checked by the methodHandler that routes it
+func decodeStandingOrderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseStandingOrderPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return standingOrderRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeUpdateStandingOrderRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseStandingOrderPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req updateStandingOrderRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+	req.ID = id
+
+	return req, nil
+}
+
+func decodeStandingOrderOccurrencesRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseStandingOrderPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return standingOrderOccurrencesRequest{
+		ID:   id,
+		Page: page,
+	}, nil
+}
+
+func decodeCreateHoldRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req createHoldRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+// decodeHoldRequest decodes a request for /v1/holds/{id},
+// whose method is checked by the methodHandler that routes it
+func decodeHoldRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	id, err := parseHoldPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return holdRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeCaptureRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseHoldPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var req captureRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+	req.HoldID = id
+
+	return req, nil
+}
+
+func decodeReleaseRequest(ctx context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	return decodeHoldRequest(ctx, r)
+}
+
+func decodeDepositRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req depositRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	return req, nil
+}
+
+func decodeWithdrawRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req withdrawRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	return req, nil
+}
+
+func decodeRefundRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parsePaymentPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	// The body is optional, since all of its fields are optional
+	var req refundRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && err != io.EOF {
+		return nil, decodeError{err}
+	}
+
+	req.PaymentID = id
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+
+	return req, nil
+}
+
+// parseTimeRange parses the "since" and "until" query parameters of a request
+func parseTimeRange(r *http.Request) (wallet.TimeRange, error) {
+	since, err := parseTimeParam(r, "since")
+	if err != nil {
+		return wallet.TimeRange{}, err
+	}
+
+	until, err := parseTimeParam(r, "until")
+	if err != nil {
+		return wallet.TimeRange{}, err
+	}
+
+	return wallet.TimeRange{
+		Since: since,
+		Until: until,
+	}, nil
+}
+
+// parseTimeParam parses an optional RFC 3339 time query parameter.
+// The zero time is returned if the parameter is not set.
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errInvalidTime{
+			Err:   err,
+			Field: name,
+		}
+	}
+
+	return t, nil
+}
+
+func decodeAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseAccountPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return accountRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeAccountPaymentsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseAccountPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tr, err := parseTimeRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return accountPaymentsRequest{
+		ID:        id,
+		TimeRange: tr,
+		Page:      page,
+	}, nil
+}
+
+func decodeCreateAccountRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req createAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeCreateCustomerRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPost {
+		return nil, errMethodNotAllowed
+	}
+
+	var req createCustomerRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeCustomerRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseCustomerPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return customerRequest{
+		ID: id,
+	}, nil
+}
+
+func decodeCustomerAccountsRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodGet {
+		return nil, errMethodNotAllowed
+	}
+
+	id, err := parseCustomerPath(r)
+	if err != nil {
+		return nil, err
+	}
+
+	page, err := parsePage(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return customerAccountsRequest{
+		ID:   id,
+		Page: page,
+	}, nil
+}
+
+func decodeSetExchangeRateRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPut {
+		return nil, errMethodNotAllowed
+	}
+
+	var req setExchangeRateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeSetFeeScheduleRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPut {
+		return nil, errMethodNotAllowed
+	}
+
+	var req setFeeScheduleRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeSetSpendingLimitRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPut {
+		return nil, errMethodNotAllowed
+	}
+
+	var req setSpendingLimitRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
+	return req, nil
+}
+
+func decodeSetCurrencyRequest(_ context.Context, r *http.Request) (interface{}, error) {
+	if r.Method != http.MethodPut {
+		return nil, errMethodNotAllowed
+	}
+
+	var req setCurrencyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return nil, decodeError{err}
+	}
+
 	return req, nil
 }
 
@@ -104,15 +1070,54 @@ type errorer interface {
 func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 	// Note: charset=utf-8 mitigates some old browser vulnerabilities
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	switch err.(type) {
-	case decodeError, errInvalidAccountID:
-		w.WriteHeader(http.StatusBadRequest)
+	w.WriteHeader(errorStatusCode(err))
+	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
+		"error": err.Error(),
+	})
+}
+
+// errorStatusCode returns the HTTP status code of an error
+func errorStatusCode(err error) int {
+	switch e := err.(type) {
+	case errMissingScope:
+		return http.StatusForbidden
+	case errInvalidToken:
+		return http.StatusUnauthorized
+	case decodeError, errInvalidID, errInvalidTime:
+		return http.StatusBadRequest
+	case errBatchTransfer:
+		return errorStatusCode(e.Err)
+	case errLimitExceeded:
+		return http.StatusForbidden
 	default:
 		switch err {
 		case errMethodNotAllowed:
-			w.WriteHeader(http.StatusMethodNotAllowed)
-		case wallet.ErrNoAccount:
-			w.WriteHeader(http.StatusNotFound)
+			return http.StatusMethodNotAllowed
+		case errAPIKeyRequired, errInvalidAPIKey, errBearerTokenRequired:
+			return http.StatusUnauthorized
+		case errAPIKeyEndpoint, errNotSubject:
+			return http.StatusForbidden
+		case wallet.ErrNoAccount,
+			wallet.ErrNoPayment,
+			wallet.ErrNoScheduledTransfer,
+			wallet.ErrNoStandingOrder,
+			wallet.ErrNoHold,
+			wallet.ErrNoCustomer,
+			errNotFound:
+			return http.StatusNotFound
+		case wallet.ErrIdempotencyKeyReused,
+			wallet.ErrAccountExists,
+			wallet.ErrCustomerExists,
+			wallet.ErrCurrencyExponentChanged,
+			wallet.ErrScheduledTransferNotPending,
+			wallet.ErrStandingOrderNotActive,
+			wallet.ErrHoldNotActive,
+			errHoldExpired,
+			errAccountClosed,
+			errAccountBalanceNotZero:
+			return http.StatusConflict
+		case errAccountFrozen:
+			return http.StatusForbidden
 		case decimal.ErrInvalidPrecision,
 			decimal.ErrNegative,
 			decimal.ErrInvalid,
@@ -120,17 +1125,52 @@ func encodeError(_ context.Context, err error, w http.ResponseWriter) {
 			decimal.ErrAmountNotMoreThanZero,
 			decimal.ErrAmountNil,
 			errInsufficientBalance,
-			errDifferentCurrency,
+			errInvalidAccountStatus,
+			errInvalidCustomerName,
+			errNameRequired,
+			errInvalidHourlyCount,
+			errAccountOrCurrency,
+			errNotRefundable,
+			errAlreadyRefunded,
+			errRefundExceedsPayment,
+			decimal.ErrRateNotMoreThanZero,
+			wallet.ErrNoExchangeRate,
+			errConvertedAmountTooSmall,
+			errSameCurrency,
+			errRateRequired,
+			errInvalidPercent,
+			errFeeMinExceedsMax,
 			errSameAccount,
+			errIdempotencyKeyTooLong,
+			errInvalidCurrency,
+			errCurrencyDisabled,
+			errInvalidExponent,
+			errCodeRequired,
+			errExponentRequired,
+			errEnabledRequired,
+			errInvalidLimit,
+			errInvalidTimeRange,
+			wallet.ErrInvalidCursor,
+			errCurrencyRequired,
 			errToRequired,
 			errFromRequired,
-			errAmountRequired:
-			w.WriteHeader(http.StatusBadRequest)
+			errAmountRequired,
+			errInvalidBatchSize,
+			errInvalidBatchMode,
+			errExecuteAtNotInFuture,
+			errInvalidScheduledTransferStatus,
+			errExecuteAtRequired,
+			errStartAtNotInFuture,
+			errEndAtBeforeStartAt,
+			errInvalidFrequency,
+			errInvalidInterval,
+			errFrequencyRequired,
+			errStartAtRequired,
+			errInvalidHoldExpiry,
+			errCaptureExceedsHold:
+			return http.StatusBadRequest
 		default:
-			w.WriteHeader(http.StatusInternalServerError)
+			return http.StatusInternalServerError
 		}
 	}
-	json.NewEncoder(w).Encode(map[string]interface{}{ //nolint:errcheck
-		"error": err.Error(),
-	})
 }
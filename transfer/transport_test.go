@@ -7,8 +7,10 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/cockroachdb/apd"
 	"github.com/go-kit/kit/log"
@@ -16,9 +18,21 @@ import (
 	"github.com/stretchr/testify/require"
 
 	wallet "github.com/xsleonard/gokit-example"
-	"github.com/xsleonard/gokit-example/postgres"
 )
 
+var timestampRegexp = regexp.MustCompile(`"(created_at|updated_at)":"([^"]*)"`)
+
+// normalizeTimestamps checks that the timestamps in a JSON response are RFC 3339 times,
+// and replaces them with "*" so that the response can be compared to a fixed string
+func normalizeTimestamps(t *testing.T, resp string) string {
+	return timestampRegexp.ReplaceAllStringFunc(resp, func(m string) string {
+		match := timestampRegexp.FindStringSubmatch(m)
+		_, err := time.Parse(time.RFC3339Nano, match[2])
+		require.NoError(t, err)
+		return fmt.Sprintf(`"%s":"*"`, match[1])
+	})
+}
+
 func TestEndpoints(t *testing.T) {
 	toID := uuid.Must(uuid.FromString("b0505aa0-b927-4667-a484-906b4e2a410b"))
 	fromID := uuid.Must(uuid.FromString("5136843a-0948-432d-8ce6-060362edb538"))
@@ -28,11 +42,118 @@ func TestEndpoints(t *testing.T) {
 	paymentIDs[1] = uuid.Must(uuid.FromString("1024abad-6de0-466f-9022-4499a97c3f87"))
 	paymentIDs[2] = uuid.Must(uuid.FromString("e76c0e9d-499f-4759-ae40-895fec818035"))
 
+	// setupPayments creates two accounts and three payments between them
+	setupPayments := func(t *testing.T, ctx context.Context, s service) {
+		err := s.accounts.Store(ctx, &wallet.Account{
+			ID:       toID,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+
+		err = s.accounts.Store(ctx, &wallet.Account{
+			ID:       fromID,
+			Currency: wallet.USD,
+		})
+		require.NoError(t, err)
+
+		err = s.payments.Store(ctx, &wallet.Payment{
+			ID:     paymentIDs[0],
+			To:     fromID,
+			From:   nil,
+			Amount: apd.New(100, 0),
+		})
+		require.NoError(t, err)
+
+		err = s.payments.Store(ctx, &wallet.Payment{
+			ID:     paymentIDs[1],
+			To:     toID,
+			From:   &fromID,
+			Amount: apd.New(3033, -2),
+		})
+		require.NoError(t, err)
+
+		err = s.payments.Store(ctx, &wallet.Payment{
+			ID:     paymentIDs[2],
+			To:     fromID,
+			From:   &toID,
+			Amount: apd.New(1011, -2),
+		})
+		require.NoError(t, err)
+	}
+
+	// setupStandingOrder creates the accounts and payments of setupPayments, and a weekly standing order
+	setupStandingOrder := func(t *testing.T, ctx context.Context, s service) {
+		setupPayments(t, ctx, s)
+
+		startAt := time.Date(2100, 1, 4, 9, 0, 0, 0, time.UTC)
+		err := s.standing.Store(ctx, &wallet.StandingOrder{
+			ID:        paymentIDs[1],
+			To:        toID,
+			From:      fromID,
+			Amount:    apd.New(500, -2),
+			Frequency: wallet.FrequencyWeekly,
+			Interval:  1,
+			StartAt:   startAt,
+			NextRunAt: startAt,
+		})
+		require.NoError(t, err)
+	}
+
+	customerID := uuid.Must(uuid.FromString("0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d"))
+
+	// setupCustomer creates a customer who holds a USD account toID with 10.50 and an EUR account fromID with 3.00
+	setupCustomer := func(t *testing.T, ctx context.Context, s service) {
+		err := s.customers.Store(ctx, &wallet.Customer{
+			ID:   customerID,
+			Name: "Alice",
+		})
+		require.NoError(t, err)
+
+		for _, a := range []struct {
+			id       uuid.UUID
+			currency string
+			amount   *apd.Decimal
+		}{
+			{toID, wallet.USD, apd.New(1050, -2)},
+			{fromID, wallet.EUR, apd.New(3, 0)},
+		} {
+			err = s.accounts.Store(ctx, &wallet.Account{
+				ID:       a.id,
+				Currency: a.currency,
+				OwnerID:  &customerID,
+			})
+			require.NoError(t, err)
+
+			err = s.payments.Store(ctx, &wallet.Payment{
+				ID:     uuid.Must(uuid.NewV4()),
+				To:     a.id,
+				Amount: a.amount,
+			})
+			require.NoError(t, err)
+		}
+	}
+
+	// setupHold creates the accounts and payments of setupPayments, and a hold of 25.00 from fromID
+	setupHold := func(t *testing.T, ctx context.Context, s service) {
+		setupPayments(t, ctx, s)
+
+		err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			return s.holds.StoreTx(ctx, tx, &wallet.Hold{
+				ID:        paymentIDs[1],
+				From:      fromID,
+				Amount:    apd.New(2500, -2),
+				ExpiresAt: time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+			})
+		})
+		require.NoError(t, err)
+	}
+
 	cases := []struct {
 		name          string
 		url           string
 		method        string
 		body          string
+		headers       map[string]string
 		statusCode    int
 		checkResponse func(*testing.T, string)
 		response      string
@@ -52,7 +173,7 @@ func TestEndpoints(t *testing.T) {
 			url:        "/v1/accounts",
 			method:     http.MethodGet,
 			statusCode: http.StatusOK,
-			response:   `{"accounts":[{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"69.67"},{"id":"b0505aa0-b927-4667-a484-906b4e2a410b","currency":"USD","balance":"30.33"}]}`,
+			response:   `{"accounts":[{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"69.67","available_balance":"69.67","status":"active","created_at":"*"},{"id":"b0505aa0-b927-4667-a484-906b4e2a410b","currency":"USD","balance":"30.33","available_balance":"30.33","status":"active","created_at":"*"}]}`,
 			setup: func(t *testing.T, ctx context.Context, s service) {
 				err := s.accounts.Store(ctx, &wallet.Account{
 					ID:       toID,
@@ -87,7 +208,7 @@ func TestEndpoints(t *testing.T) {
 		{
 			name:       "list all accounts, bad method",
 			url:        "/v1/accounts",
-			method:     http.MethodPost,
+			method:     http.MethodDelete,
 			statusCode: http.StatusMethodNotAllowed,
 			response:   `{"error":"Method Not Allowed"}`,
 		},
@@ -105,7 +226,7 @@ func TestEndpoints(t *testing.T) {
 			url:        "/v1/payments",
 			method:     http.MethodGet,
 			statusCode: http.StatusOK,
-			response:   `{"payments":[{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"30.33"},{"id":"7e09ef65-1203-4d10-849a-9e56b9368166","to":"5136843a-0948-432d-8ce6-060362edb538","amount":"100.00"},{"id":"e76c0e9d-499f-4759-ae40-895fec818035","to":"5136843a-0948-432d-8ce6-060362edb538","from":"b0505aa0-b927-4667-a484-906b4e2a410b","amount":"10.11"}]}`,
+			response:   `{"payments":[{"id":"7e09ef65-1203-4d10-849a-9e56b9368166","to":"5136843a-0948-432d-8ce6-060362edb538","amount":"100.00","created_at":"*"},{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"30.33","created_at":"*"},{"id":"e76c0e9d-499f-4759-ae40-895fec818035","to":"5136843a-0948-432d-8ce6-060362edb538","from":"b0505aa0-b927-4667-a484-906b4e2a410b","amount":"10.11","created_at":"*"}]}`,
 			setup: func(t *testing.T, ctx context.Context, s service) {
 				err := s.accounts.Store(ctx, &wallet.Account{
 					ID:       toID,
@@ -147,7 +268,7 @@ func TestEndpoints(t *testing.T) {
 
 		{
 			name:       "list all payments, bad method",
-			url:        "/v1/accounts",
+			url:        "/v1/payments",
 			method:     http.MethodPost,
 			statusCode: http.StatusMethodNotAllowed,
 			response:   `{"error":"Method Not Allowed"}`,
@@ -176,7 +297,16 @@ func TestEndpoints(t *testing.T) {
 			method:     http.MethodPost,
 			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"123.456"}`, toID, fromID),
 			statusCode: http.StatusBadRequest,
-			response:   `{"error":"Amount strings must have exactly two digits of precision"}`,
+			response:   `{"error":"Amount has more decimal places than the currency allows"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				for _, id := range []uuid.UUID{toID, fromID} {
+					err := s.accounts.Store(ctx, &wallet.Account{
+						ID:       id,
+						Currency: wallet.USD,
+					})
+					require.NoError(t, err)
+				}
+			},
 		},
 
 		{
@@ -257,7 +387,7 @@ func TestEndpoints(t *testing.T) {
 			method:     http.MethodPost,
 			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.23"}`, toID, fromID),
 			statusCode: http.StatusBadRequest,
-			response:   `{"error":"Transfers must use the same currency"}`,
+			response:   `{"error":"No exchange rate between the currencies"}`,
 			setup: func(t *testing.T, ctx context.Context, s service) {
 				err := s.accounts.Store(ctx, &wallet.Account{
 					ID:       toID,
@@ -306,6 +436,9 @@ func TestEndpoints(t *testing.T) {
 				require.NotEmpty(t, r.Payment.ID)
 				_, err = uuid.FromString(r.Payment.ID)
 				require.NoError(t, err)
+
+				_, err = time.Parse(time.RFC3339Nano, r.Payment.CreatedAt)
+				require.NoError(t, err)
 			},
 			setup: func(t *testing.T, ctx context.Context, s service) {
 				err := s.accounts.Store(ctx, &wallet.Account{
@@ -330,12 +463,12 @@ func TestEndpoints(t *testing.T) {
 			},
 			checkDB: func(t *testing.T, ctx context.Context, s service) {
 				// Check that a payment was added to the DB
-				payments, err := s.payments.All(ctx)
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 100})
 				require.NoError(t, err)
 				require.Equal(t, 2, len(payments))
 
 				// Check the new balances of accounts
-				accounts, err := s.accounts.All(ctx)
+				accounts, _, err := s.accounts.List(ctx, wallet.Page{Limit: 100})
 				require.NoError(t, err)
 
 				for _, a := range accounts {
@@ -349,49 +482,2619 @@ func TestEndpoints(t *testing.T) {
 				}
 			},
 		},
-	}
 
-	for _, tc := range cases {
-		t.Run(tc.name, func(t *testing.T) {
-			db, shutdown := setupDB(t)
-			defer shutdown()
+		{
+			name:       "transfer, idempotent replay",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.23"}`, toID, fromID),
+			headers:    map[string]string{"Idempotency-Key": "abc"},
+			statusCode: http.StatusOK,
+			response:   `{"payment":{"id":"e76c0e9d-499f-4759-ae40-895fec818035","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"1.23","created_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
 
-			logger := log.NewNopLogger()
-			accountsRepo := postgres.NewAccountRepository(db, logger)
-			paymentsRepo := postgres.NewPaymentRepository(db, logger)
-			s := NewService(accountsRepo, paymentsRepo)
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
 
-			ctx := context.Background()
-			if tc.setup != nil {
-				tc.setup(t, ctx, s.(service))
-			}
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[0],
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
 
-			handler := MakeHandler(s, logger)
-			w := httptest.NewRecorder()
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:             paymentIDs[2],
+					To:             toID,
+					From:           &fromID,
+					Amount:         apd.New(123, -2),
+					IdempotencyKey: "abc",
+				})
+				require.NoError(t, err)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				// Check that no payment was added to the DB
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 100})
+				require.NoError(t, err)
+				require.Equal(t, 2, len(payments))
+			},
+		},
 
-			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
-			require.NoError(t, err)
+		{
+			name:       "transfer, idempotency key reused",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"2.00"}`, toID, fromID),
+			headers:    map[string]string{"Idempotency-Key": "abc"},
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Idempotency key was already used for a different request"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
 
-			handler.ServeHTTP(w, req)
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
 
-			resp := w.Result()
-			defer resp.Body.Close()
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[0],
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
 
-			body, err := ioutil.ReadAll(resp.Body)
-			require.NoError(t, err)
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:             paymentIDs[2],
+					To:             toID,
+					From:           &fromID,
+					Amount:         apd.New(123, -2),
+					IdempotencyKey: "abc",
+				})
+				require.NoError(t, err)
+			},
+		},
 
-			if tc.checkResponse != nil {
-				require.Empty(t, tc.response, "response should not be set when using checkResponse")
-				tc.checkResponse(t, string(body))
-			} else {
-				require.Equal(t, tc.response+"\n", string(body))
-			}
+		{
+			name:       "deposit, valid",
+			url:        "/v1/deposit",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"amount":"25.5"}`, toID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
 
-			require.Equal(t, tc.statusCode, resp.StatusCode)
-			require.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, toID.String(), r.Payment.To)
+				require.Equal(t, "25.50", r.Payment.Amount)
 
-			if tc.checkDB != nil {
-				tc.checkDB(t, ctx, s.(service))
+				// The payment is from the external account
+				_, err = uuid.FromString(r.Payment.From)
+				require.NoError(t, err)
+				require.NotEqual(t, fromID.String(), r.Payment.From)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				a, err := s.accounts.Get(ctx, toID)
+				require.NoError(t, err)
+				require.Equal(t, 0, a.Balance.Cmp(apd.New(4572, -2)))
+			},
+		},
+
+		{
+			name:       "deposit, missing to",
+			url:        "/v1/deposit",
+			method:     http.MethodPost,
+			body:       `{"amount":"25.50"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"to is required"}`,
+		},
+
+		{
+			name:       "deposit, account does not exist",
+			url:        "/v1/deposit",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"amount":"25.50"}`, toID),
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "deposit, bad method",
+			url:        "/v1/deposit",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "withdraw, valid",
+			url:        "/v1/withdraw",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"20.22"}`, toID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, toID.String(), r.Payment.From)
+				require.Equal(t, "20.22", r.Payment.Amount)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				a, err := s.accounts.Get(ctx, toID)
+				require.NoError(t, err)
+				require.Equal(t, 0, a.Balance.Sign())
+			},
+		},
+
+		{
+			name:       "withdraw, insufficient balance",
+			url:        "/v1/withdraw",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"20.23"}`, toID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Account has an insufficient balance"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "withdraw, missing from",
+			url:        "/v1/withdraw",
+			method:     http.MethodPost,
+			body:       `{"amount":"1.00"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"from is required"}`,
+		},
+
+		{
+			name:       "refund, valid",
+			url:        fmt.Sprintf("/v1/payments/%s/refund", paymentIDs[1]),
+			method:     http.MethodPost,
+			body:       `{"amount":"10"}`,
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, fromID.String(), r.Payment.To)
+				require.Equal(t, toID.String(), r.Payment.From)
+				require.Equal(t, "10.00", r.Payment.Amount)
+				require.Equal(t, paymentIDs[1].String(), r.Payment.ReversalOf)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 100})
+				require.NoError(t, err)
+				require.Len(t, payments, 4)
+
+				for _, p := range payments {
+					if p.ID == paymentIDs[1] {
+						require.Equal(t, 0, p.RefundedAmount.Cmp(apd.New(10, 0)))
+					}
+				}
+			},
+		},
+
+		{
+			name:       "refund, remaining amount without a body",
+			url:        fmt.Sprintf("/v1/payments/%s/refund", paymentIDs[2]),
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, "10.11", r.Payment.Amount)
+				require.Equal(t, paymentIDs[2].String(), r.Payment.ReversalOf)
+			},
+			setup: setupPayments,
+		},
+
+		{
+			name:       "refund, amount exceeds payment",
+			url:        fmt.Sprintf("/v1/payments/%s/refund", paymentIDs[1]),
+			method:     http.MethodPost,
+			body:       `{"amount":"30.34"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Refund amount exceeds the unrefunded amount of the payment"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "refund, payment does not exist",
+			url:        fmt.Sprintf("/v1/payments/%s/refund", paymentIDs[1]),
+			method:     http.MethodPost,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Payment does not exist"}`,
+		},
+
+		{
+			name:       "refund, invalid id",
+			url:        "/v1/payments/foo/refund",
+			method:     http.MethodPost,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid payment ID for field \"id\": uuid: incorrect UUID length: foo"}`,
+		},
+
+		{
+			name:       "refund, bad method",
+			url:        fmt.Sprintf("/v1/payments/%s/refund", paymentIDs[1]),
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "create account",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":%q,"currency":"SGD"}`, toID),
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"b0505aa0-b927-4667-a484-906b4e2a410b","currency":"SGD","balance":"0.00","available_balance":"0.00","status":"active","created_at":"*"}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				accounts, _, err := s.accounts.List(ctx, wallet.Page{Limit: 100})
+				require.NoError(t, err)
+				require.Equal(t, 1, len(accounts))
+				require.Equal(t, toID, accounts[0].ID)
+				require.Equal(t, wallet.SGD, accounts[0].Currency)
+			},
+		},
+
+		{
+			name:       "create account, generated id",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{"currency":"USD"}`,
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r accountResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Account)
+				require.Equal(t, wallet.USD, r.Account.Currency)
+				require.Equal(t, "0.00", r.Account.Balance)
+
+				_, err = uuid.FromString(r.Account.ID)
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "create account, duplicate id",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":%q,"currency":"USD"}`, toID),
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Account already exists"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "create account, invalid currency",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{"currency":"XYZ"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid currency code"}`,
+		},
+
+		{
+			name:       "create account, missing currency",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"currency is required"}`,
+		},
+
+		{
+			name:       "create account, invalid id",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{"id":"abc","currency":"USD"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid account ID for field \"id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "get account",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"69.67","available_balance":"69.67","status":"active","created_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[0],
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[1],
+					To:     toID,
+					From:   &fromID,
+					Amount: apd.New(3033, -2),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "get account, does not exist",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "get account, invalid id",
+			url:        "/v1/accounts/abc",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid account ID for field \"id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "get account, bad method",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "get account, unknown path",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/foo",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Not Found"}`,
+		},
+
+		{
+			name:       "list accounts, first page",
+			url:        "/v1/accounts?limit=1",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"accounts":[{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"79.78","available_balance":"79.78","status":"active","created_at":"*"}],"next_cursor":"NTEzNjg0M2EtMDk0OC00MzJkLThjZTYtMDYwMzYyZWRiNTM4"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "list accounts, last page",
+			url:        "/v1/accounts?limit=1&cursor=NTEzNjg0M2EtMDk0OC00MzJkLThjZTYtMDYwMzYyZWRiNTM4",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"accounts":[{"id":"b0505aa0-b927-4667-a484-906b4e2a410b","currency":"USD","balance":"20.22","available_balance":"20.22","status":"active","created_at":"*"}]}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "list accounts, invalid cursor",
+			url:        "/v1/accounts?cursor=abc",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid page cursor"}`,
+		},
+
+		{
+			name:       "list accounts, invalid limit",
+			url:        "/v1/accounts?limit=abc",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Limit must be between 1 and 1000"}`,
+		},
+
+		{
+			name:       "list accounts, limit too large",
+			url:        "/v1/accounts?limit=1001",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Limit must be between 1 and 1000"}`,
+		},
+
+		{
+			name:       "list payments, paginated",
+			url:        "/v1/payments?limit=1",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			setup:      setupPayments,
+			checkResponse: func(t *testing.T, resp string) {
+				// The cursor contains the creation time, so a custom compare is used
+				var r paymentsResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotEmpty(t, r.NextCursor)
+				require.Len(t, r.Payments, 1)
+				require.Equal(t, paymentIDs[0].String(), r.Payments[0].ID)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				// Follow the cursor to the last page
+				p, next, err := s.Payments(ctx, wallet.Page{Limit: 1})
+				require.NoError(t, err)
+				require.Len(t, p, 1)
+				require.NotEmpty(t, next)
+
+				p, next, err = s.Payments(ctx, wallet.Page{Limit: 5, Cursor: next})
+				require.NoError(t, err)
+				require.Empty(t, next)
+				require.Len(t, p, 2)
+				require.Equal(t, paymentIDs[1], p[0].ID)
+				require.Equal(t, paymentIDs[2], p[1].ID)
+			},
+		},
+
+		{
+			name:       "list payments, invalid limit",
+			url:        "/v1/payments?limit=0",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Limit must be between 1 and 1000"}`,
+		},
+
+		{
+			name:       "list account payments",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			setup:      setupPayments,
+			checkResponse: func(t *testing.T, resp string) {
+				// The timestamps are set by the database, so a custom compare is used
+				var r accountPaymentsResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.Empty(t, r.NextCursor)
+				require.Len(t, r.Payments, 3)
+
+				// Newest first, with signed amounts and a running balance
+				expected := []AccountPayment{
+					{
+						ID:           paymentIDs[2].String(),
+						Counterparty: toID.String(),
+						Amount:       "10.11",
+						Balance:      "79.78",
+					},
+					{
+						ID:           paymentIDs[1].String(),
+						Counterparty: toID.String(),
+						Amount:       "-30.33",
+						Balance:      "69.67",
+					},
+					{
+						ID:      paymentIDs[0].String(),
+						Amount:  "100.00",
+						Balance: "100.00",
+					},
+				}
+
+				for i, p := range r.Payments {
+					createdAt, err := time.Parse(time.RFC3339Nano, p.CreatedAt)
+					require.NoError(t, err)
+					require.False(t, createdAt.IsZero())
+
+					p.CreatedAt = ""
+					require.Equal(t, expected[i], p)
+				}
+			},
+		},
+
+		{
+			name:       "list account payments, paginated",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments?limit=2",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			setup:      setupPayments,
+			checkResponse: func(t *testing.T, resp string) {
+				var r accountPaymentsResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotEmpty(t, r.NextCursor)
+				require.Len(t, r.Payments, 2)
+				require.Equal(t, paymentIDs[2].String(), r.Payments[0].ID)
+				require.Equal(t, paymentIDs[1].String(), r.Payments[1].ID)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				// Follow the cursor to the last page
+				p, next, err := s.AccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 2})
+				require.NoError(t, err)
+				require.Len(t, p, 2)
+				require.NotEmpty(t, next)
+
+				p, next, err = s.AccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 2, Cursor: next})
+				require.NoError(t, err)
+				require.Len(t, p, 1)
+				require.Empty(t, next)
+				require.Equal(t, paymentIDs[0], p[0].PaymentID)
+				require.Equal(t, apd.New(10000, -2), p[0].Balance)
+			},
+		},
+
+		{
+			name:       "list account payments, outside of time range",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments?since=2000-01-01T00:00:00Z&until=2000-01-02T00:00:00Z",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   "{}",
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "list account payments, account does not exist",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "list account payments, invalid since",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments?since=yesterday",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid RFC 3339 time for field \"since\": parsing time \"yesterday\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"yesterday\" as \"2006\""}`,
+		},
+
+		{
+			name:       "list account payments, since after until",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538/payments?since=2000-01-02T00:00:00Z&until=2000-01-01T00:00:00Z",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"since must not be after until"}`,
+		},
+
+		{
+			name:       "transfer, different currencies, converted",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"10.00"}`, toID, fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, "10.00", r.Payment.Amount)
+				require.Equal(t, "7.34", r.Payment.ToAmount)
+				require.Equal(t, "0.7345", r.Payment.Rate)
+			},
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.SGD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[0],
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.SGD,
+					To:   wallet.USD,
+					Rate: apd.New(7345, -4),
+				})
+				require.NoError(t, err)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				to, err := s.accounts.Get(ctx, toID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(734, -2).Cmp(to.Balance))
+
+				from, err := s.accounts.Get(ctx, fromID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(9000, -2).Cmp(from.Balance))
+			},
+		},
+
+		{
+			name:       "list exchange rates, empty",
+			url:        "/v1/admin/rates",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   "{}",
+		},
+
+		{
+			name:       "list exchange rates",
+			url:        "/v1/admin/rates",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"rates":[{"from":"SGD","to":"USD","rate":"0.7345","updated_at":"*"},{"from":"USD","to":"SGD","rate":"1.3614","updated_at":"*"}]}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.USD,
+					To:   wallet.SGD,
+					Rate: apd.New(13614, -4),
+				})
+				require.NoError(t, err)
+
+				err = s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.SGD,
+					To:   wallet.USD,
+					Rate: apd.New(7345, -4),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "set exchange rate",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"SGD","to":"USD","rate":"0.7350"}`,
+			statusCode: http.StatusOK,
+			response:   `{"rate":{"from":"SGD","to":"USD","rate":"0.7350","updated_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.rates.Store(ctx, &wallet.ExchangeRate{
+					From: wallet.SGD,
+					To:   wallet.USD,
+					Rate: apd.New(7345, -4),
+				})
+				require.NoError(t, err)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				rate, err := s.rates.Rate(ctx, wallet.SGD, wallet.USD)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(735, -3).Cmp(rate))
+			},
+		},
+
+		{
+			name:       "set exchange rate, same currency",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"USD","to":"USD","rate":"1"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Exchange rates must be between different currencies"}`,
+		},
+
+		{
+			name:       "set exchange rate, invalid currency",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"USD","to":"XYZ","rate":"1"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid currency code"}`,
+		},
+
+		{
+			name:       "set exchange rate, zero rate",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"USD","to":"SGD","rate":"0"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Rate must be greater than 0"}`,
+		},
+
+		{
+			name:       "set exchange rate, missing rate",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"USD","to":"SGD"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"rate is required"}`,
+		},
+
+		{
+			name:       "exchange rates, bad method",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "set exchange rate, disabled currency",
+			url:        "/v1/admin/rates",
+			method:     http.MethodPut,
+			body:       `{"from":"USD","to":"JPY","rate":"157.5"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Currency is not enabled"}`,
+		},
+
+		{
+			name:       "create account, disabled currency",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{"currency":"KWD"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Currency is not enabled"}`,
+		},
+
+		{
+			name:       "create account, currency without minor unit",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":%q,"currency":"JPY"}`, toID),
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"b0505aa0-b927-4667-a484-906b4e2a410b","currency":"JPY","balance":"0","available_balance":"0","status":"active","created_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.currencies.Store(ctx, &wallet.Currency{
+					Code:     wallet.JPY,
+					Exponent: 0,
+					Enabled:  true,
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "list currencies",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"currencies":[{"code":"EUR","exponent":2,"enabled":true},{"code":"GBP","exponent":2,"enabled":true},{"code":"JPY","exponent":0,"enabled":false},{"code":"KWD","exponent":3,"enabled":false},{"code":"SGD","exponent":2,"enabled":true},{"code":"USD","exponent":2,"enabled":true}]}`,
+		},
+
+		{
+			name:       "set currency, new currency",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"CHF","exponent":2,"enabled":true}`,
+			statusCode: http.StatusOK,
+			response:   `{"currency":{"code":"CHF","exponent":2,"enabled":true}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				c, err := s.currencies.Get(ctx, "CHF")
+				require.NoError(t, err)
+				require.Equal(t, &wallet.Currency{
+					Code:     "CHF",
+					Exponent: 2,
+					Enabled:  true,
+				}, c)
+			},
+		},
+
+		{
+			name:       "set currency, enable existing currency",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"KWD","exponent":3,"enabled":true}`,
+			statusCode: http.StatusOK,
+			response:   `{"currency":{"code":"KWD","exponent":3,"enabled":true}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				c, err := s.currencies.Get(ctx, wallet.KWD)
+				require.NoError(t, err)
+				require.True(t, c.Enabled)
+			},
+		},
+
+		{
+			name:       "set currency, exponent changed",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"USD","exponent":3,"enabled":true}`,
+			statusCode: http.StatusConflict,
+			response:   `{"error":"The exponent of an existing currency cannot be changed"}`,
+		},
+
+		{
+			name:       "set currency, invalid code",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"usd","exponent":2,"enabled":true}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid currency code"}`,
+		},
+
+		{
+			name:       "set currency, invalid exponent",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"CHF","exponent":5,"enabled":true}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Currency exponent must be between 0 and 4"}`,
+		},
+
+		{
+			name:       "set currency, missing exponent",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPut,
+			body:       `{"code":"CHF","enabled":true}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"exponent is required"}`,
+		},
+
+		{
+			name:       "currencies, bad method",
+			url:        "/v1/admin/currencies",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "list fee schedules, empty",
+			url:        "/v1/admin/fees",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   "{}",
+		},
+
+		{
+			name:       "list fee schedules",
+			url:        "/v1/admin/fees",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"fees":[{"currency":"SGD","flat":"0","percent":"1.5","updated_at":"*"},{"currency":"USD","flat":"0.30","percent":"2.9","min":"0.50","updated_at":"*"}]}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.fees.Store(ctx, &wallet.FeeSchedule{
+					Currency: wallet.USD,
+					Flat:     apd.New(30, -2),
+					Percent:  apd.New(29, -1),
+					Min:      apd.New(50, -2),
+				})
+				require.NoError(t, err)
+
+				err = s.fees.Store(ctx, &wallet.FeeSchedule{
+					Currency: wallet.SGD,
+					Flat:     apd.New(0, 0),
+					Percent:  apd.New(15, -1),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "set fee schedule",
+			url:        "/v1/admin/fees",
+			method:     http.MethodPut,
+			body:       `{"currency":"USD","flat":"0.30","percent":"2.9","max":"5.00"}`,
+			statusCode: http.StatusOK,
+			response:   `{"fee":{"currency":"USD","flat":"0.30","percent":"2.9","max":"5.00","updated_at":"*"}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				schedule, err := s.fees.Schedule(ctx, wallet.USD)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(30, -2).Cmp(schedule.Flat))
+				require.Equal(t, 0, apd.New(29, -1).Cmp(schedule.Percent))
+				require.Nil(t, schedule.Min)
+				require.Equal(t, 0, apd.New(5, 0).Cmp(schedule.Max))
+			},
+		},
+
+		{
+			name:       "set fee schedule, percent too large",
+			url:        "/v1/admin/fees",
+			method:     http.MethodPut,
+			body:       `{"currency":"USD","percent":"101"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Percent must be between 0 and 100"}`,
+		},
+
+		{
+			name:       "set fee schedule, min exceeds max",
+			url:        "/v1/admin/fees",
+			method:     http.MethodPut,
+			body:       `{"currency":"USD","min":"2.00","max":"1.00"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Minimum fee must not be more than the maximum fee"}`,
+		},
+
+		{
+			name:       "set fee schedule, missing currency",
+			url:        "/v1/admin/fees",
+			method:     http.MethodPut,
+			body:       `{"flat":"0.30"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"currency is required"}`,
+		},
+
+		{
+			name:       "fee schedules, bad method",
+			url:        "/v1/admin/fees",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "transfer, with fee",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"10.00"}`, toID, fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r transferResponse
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.NoError(t, r.Err)
+				require.NotNil(t, r.Payment)
+				require.Equal(t, "10.00", r.Payment.Amount)
+				require.Equal(t, "0.59", r.Payment.Fee)
+			},
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       toID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+
+				err = s.payments.Store(ctx, &wallet.Payment{
+					ID:     paymentIDs[0],
+					To:     fromID,
+					From:   nil,
+					Amount: apd.New(100, 0),
+				})
+				require.NoError(t, err)
+
+				err = s.fees.Store(ctx, &wallet.FeeSchedule{
+					Currency: wallet.USD,
+					Flat:     apd.New(30, -2),
+					Percent:  apd.New(29, -1),
+				})
+				require.NoError(t, err)
+			},
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				from, err := s.accounts.Get(ctx, fromID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(8941, -2).Cmp(from.Balance))
+
+				to, err := s.accounts.Get(ctx, toID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(10, 0).Cmp(to.Balance))
+			},
+		},
+
+		{
+			name:       "transfer batch, atomic",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"mode":"atomic","transfers":[{"to":%q,"from":%q,"amount":"10.00"},{"to":%q,"from":%q,"amount":"2.50"}]}`, toID, fromID, fromID, toID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					Results []TransferResult `json:"results"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.Len(t, r.Results, 2)
+				for _, res := range r.Results {
+					require.Empty(t, res.Error)
+					require.Empty(t, res.Status)
+					require.NotNil(t, res.Payment)
+				}
+				require.Equal(t, "10.00", r.Results[0].Payment.Amount)
+				require.Equal(t, toID.String(), r.Results[0].Payment.To)
+				require.Equal(t, "2.50", r.Results[1].Payment.Amount)
+				require.Equal(t, fromID.String(), r.Results[1].Payment.To)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				from, err := s.accounts.Get(ctx, fromID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(7228, -2).Cmp(from.Balance))
+
+				to, err := s.accounts.Get(ctx, toID)
+				require.NoError(t, err)
+				require.Equal(t, 0, apd.New(2772, -2).Cmp(to.Balance))
+			},
+		},
+
+		{
+			name:       "transfer batch, atomic, a transfer fails",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"transfers":[{"to":%q,"from":%q,"amount":"10.00"},{"to":%q,"from":%q,"amount":"100.00"}]}`, toID, fromID, fromID, toID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"transfers[1]: Account has an insufficient balance"}`,
+			setup:      setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 100})
+				require.NoError(t, err)
+				require.Len(t, payments, 3)
+			},
+		},
+
+		{
+			name:       "transfer batch, best effort",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"mode":"best_effort","transfers":[{"to":%q,"from":%q,"amount":"10.00"},{"to":%q,"from":%q,"amount":"100.00"},{"to":%q,"from":%q,"amount":"1.00"}]}`, toID, fromID, fromID, toID, paymentIDs[0], fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					Results []TransferResult `json:"results"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.Len(t, r.Results, 3)
+				require.NotNil(t, r.Results[0].Payment)
+				require.Equal(t, "10.00", r.Results[0].Payment.Amount)
+
+				require.Nil(t, r.Results[1].Payment)
+				require.Equal(t, "Account has an insufficient balance", r.Results[1].Error)
+				require.Equal(t, http.StatusBadRequest, r.Results[1].Status)
+
+				require.Nil(t, r.Results[2].Payment)
+				require.Equal(t, "Account does not exist", r.Results[2].Error)
+				require.Equal(t, http.StatusNotFound, r.Results[2].Status)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 100})
+				require.NoError(t, err)
+				require.Len(t, payments, 4)
+			},
+		},
+
+		{
+			name:       "transfer batch, missing field",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"mode":"best_effort","transfers":[{"to":%q,"from":%q,"amount":"10.00"},{"from":%q,"amount":"1.00"}]}`, toID, fromID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"transfers[1]: to is required"}`,
+		},
+
+		{
+			name:       "transfer batch, empty",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       `{"transfers":[]}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"A batch must have between 1 and 1000 transfers"}`,
+		},
+
+		{
+			name:       "transfer batch, invalid mode",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"mode":"some","transfers":[{"to":%q,"from":%q,"amount":"10.00"}]}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Mode must be \"atomic\" or \"best_effort\""}`,
+		},
+
+		{
+			name:       "transfer batch, bad method",
+			url:        "/v1/transfers/batch",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "schedule transfer",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"12.50","execute_at":"2100-01-02T03:04:05Z"}`, toID, fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					ScheduledTransfer ScheduledTransfer `json:"scheduled_transfer"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				st := r.ScheduledTransfer
+				_, err = uuid.FromString(st.ID)
+				require.NoError(t, err)
+				require.Equal(t, toID.String(), st.To)
+				require.Equal(t, fromID.String(), st.From)
+				require.Equal(t, "12.50", st.Amount)
+				require.Equal(t, "2100-01-02T03:04:05Z", st.ExecuteAt)
+				require.Equal(t, wallet.ScheduledTransferPending, st.Status)
+				require.Empty(t, st.PaymentID)
+				require.Empty(t, st.Error)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				transfers, _, err := s.scheduled.List(ctx, "", wallet.Page{Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, transfers, 1)
+			},
+		},
+
+		{
+			name:       "schedule transfer, missing execute_at",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"12.50"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"execute_at is required"}`,
+		},
+
+		{
+			name:       "schedule transfer, invalid execute_at",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"12.50","execute_at":"tomorrow"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid RFC 3339 time for field \"execute_at\": parsing time \"tomorrow\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"tomorrow\" as \"2006\""}`,
+		},
+
+		{
+			name:       "schedule transfer, execute_at in the past",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"12.50","execute_at":"2019-10-16T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"execute_at must be in the future"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "list scheduled transfers, empty",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   "{}",
+		},
+
+		{
+			name:       "list scheduled transfers by status",
+			url:        "/v1/transfers/scheduled?status=canceled",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"scheduled_transfers":[{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"2.00","execute_at":"2100-01-02T03:04:05Z","status":"canceled","created_at":"*","updated_at":"*"}]}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				for _, id := range paymentIDs[:2] {
+					err := s.scheduled.Store(ctx, &wallet.ScheduledTransfer{
+						ID:        id,
+						To:        toID,
+						From:      fromID,
+						Amount:    apd.New(200, -2),
+						ExecuteAt: time.Date(2100, 1, 2, 3, 4, 5, 0, time.UTC),
+					})
+					require.NoError(t, err)
+				}
+
+				_, err := s.CancelScheduledTransfer(ctx, paymentIDs[1])
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "list scheduled transfers, invalid status",
+			url:        "/v1/transfers/scheduled?status=done",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid scheduled transfer status"}`,
+		},
+
+		{
+			name:       "scheduled transfers, bad method",
+			url:        "/v1/transfers/scheduled",
+			method:     http.MethodDelete,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "cancel scheduled transfer",
+			url:        "/v1/transfers/scheduled/1024abad-6de0-466f-9022-4499a97c3f87/cancel",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			response:   `{"scheduled_transfer":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"2.00","execute_at":"2100-01-02T03:04:05Z","status":"canceled","created_at":"*","updated_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				err := s.scheduled.Store(ctx, &wallet.ScheduledTransfer{
+					ID:        paymentIDs[1],
+					To:        toID,
+					From:      fromID,
+					Amount:    apd.New(200, -2),
+					ExecuteAt: time.Date(2100, 1, 2, 3, 4, 5, 0, time.UTC),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "cancel scheduled transfer, not pending",
+			url:        "/v1/transfers/scheduled/1024abad-6de0-466f-9022-4499a97c3f87/cancel",
+			method:     http.MethodPost,
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Scheduled transfer is not pending"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				err := s.scheduled.Store(ctx, &wallet.ScheduledTransfer{
+					ID:        paymentIDs[1],
+					To:        toID,
+					From:      fromID,
+					Amount:    apd.New(200, -2),
+					ExecuteAt: time.Date(2100, 1, 2, 3, 4, 5, 0, time.UTC),
+				})
+				require.NoError(t, err)
+
+				_, err = s.CancelScheduledTransfer(ctx, paymentIDs[1])
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "cancel scheduled transfer, not found",
+			url:        "/v1/transfers/scheduled/1024abad-6de0-466f-9022-4499a97c3f87/cancel",
+			method:     http.MethodPost,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Scheduled transfer does not exist"}`,
+		},
+
+		{
+			name:       "cancel scheduled transfer, invalid id",
+			url:        "/v1/transfers/scheduled/xyz/cancel",
+			method:     http.MethodPost,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid scheduled transfer ID for field \"id\": uuid: incorrect UUID length: xyz"}`,
+		},
+
+		{
+			name:       "cancel scheduled transfer, bad method",
+			url:        "/v1/transfers/scheduled/1024abad-6de0-466f-9022-4499a97c3f87/cancel",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+		{
+			name:       "create standing order",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"monthly","start_at":"2100-01-31T09:00:00Z","end_at":"2100-12-31T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					StandingOrder StandingOrder `json:"standing_order"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				o := r.StandingOrder
+				_, err = uuid.FromString(o.ID)
+				require.NoError(t, err)
+				require.Equal(t, toID.String(), o.To)
+				require.Equal(t, fromID.String(), o.From)
+				require.Equal(t, "25.00", o.Amount)
+				require.Equal(t, wallet.FrequencyMonthly, o.Frequency)
+				require.Equal(t, 1, o.Interval)
+				require.Equal(t, "2100-01-31T09:00:00Z", o.StartAt)
+				require.Equal(t, "2100-12-31T09:00:00Z", o.EndAt)
+				require.Equal(t, wallet.StandingOrderActive, o.Status)
+				require.Equal(t, 0, o.Occurrences)
+				require.Equal(t, "2100-01-31T09:00:00Z", o.NextRunAt)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				orders, _, err := s.standing.List(ctx, wallet.Page{Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, orders, 1)
+			},
+		},
+
+		{
+			name:       "create standing order, missing frequency",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","start_at":"2100-01-31T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"frequency is required"}`,
+		},
+
+		{
+			name:       "create standing order, missing start_at",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"weekly"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"start_at is required"}`,
+		},
+
+		{
+			name:       "create standing order, invalid end_at",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"weekly","start_at":"2100-01-31T09:00:00Z","end_at":"never"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid RFC 3339 time for field \"end_at\": parsing time \"never\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"never\" as \"2006\""}`,
+		},
+
+		{
+			name:       "create standing order, invalid frequency",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"hourly","start_at":"2100-01-31T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Frequency must be \"daily\", \"weekly\" or \"monthly\""}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "create standing order, invalid interval",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"daily","interval":0,"start_at":"2100-01-31T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Interval must be between 1 and 365"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "create standing order, start_at in the past",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"daily","start_at":"2019-10-16T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"start_at must be in the future"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "create standing order, end_at before start_at",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"25.00","frequency":"daily","start_at":"2100-01-31T09:00:00Z","end_at":"2100-01-30T09:00:00Z"}`, toID, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"end_at must not be before start_at"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "list standing orders, empty",
+			url:        "/v1/standing-orders",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   "{}",
+		},
+
+		{
+			name:       "list standing orders",
+			url:        "/v1/standing-orders",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"standing_orders":[{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"5.00","frequency":"weekly","interval":1,"start_at":"2100-01-04T09:00:00Z","status":"active","occurrences":0,"next_run_at":"2100-01-04T09:00:00Z","created_at":"*","updated_at":"*"}]}`,
+			setup:      setupStandingOrder,
+		},
+
+		{
+			name:       "standing orders, bad method",
+			url:        "/v1/standing-orders",
+			method:     http.MethodPut,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "get standing order",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"standing_order":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"5.00","frequency":"weekly","interval":1,"start_at":"2100-01-04T09:00:00Z","status":"active","occurrences":0,"next_run_at":"2100-01-04T09:00:00Z","created_at":"*","updated_at":"*"}}`,
+			setup:      setupStandingOrder,
+		},
+
+		{
+			name:       "get standing order, not found",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Standing order does not exist"}`,
+		},
+
+		{
+			name:       "get standing order, invalid id",
+			url:        "/v1/standing-orders/xyz",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid standing order ID for field \"id\": uuid: incorrect UUID length: xyz"}`,
+		},
+
+		{
+			name:       "update standing order",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodPut,
+			body:       `{"amount":"7.50","end_at":"2100-06-30T00:00:00Z"}`,
+			statusCode: http.StatusOK,
+			response:   `{"standing_order":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"7.50","frequency":"weekly","interval":1,"start_at":"2100-01-04T09:00:00Z","end_at":"2100-06-30T00:00:00Z","status":"active","occurrences":0,"next_run_at":"2100-01-04T09:00:00Z","created_at":"*","updated_at":"*"}}`,
+			setup:      setupStandingOrder,
+		},
+
+		{
+			name:       "update standing order, missing amount",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodPut,
+			body:       `{"end_at":"2100-06-30T00:00:00Z"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"amount is required"}`,
+			setup:      setupStandingOrder,
+		},
+
+		{
+			name:       "update standing order, not active",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodPut,
+			body:       `{"amount":"7.50"}`,
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Standing order is not active"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupStandingOrder(t, ctx, s)
+
+				_, err := s.CancelStandingOrder(ctx, paymentIDs[1])
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "cancel standing order",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodDelete,
+			statusCode: http.StatusOK,
+			response:   `{"standing_order":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","to":"b0505aa0-b927-4667-a484-906b4e2a410b","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"5.00","frequency":"weekly","interval":1,"start_at":"2100-01-04T09:00:00Z","status":"canceled","occurrences":0,"created_at":"*","updated_at":"*"}}`,
+			setup:      setupStandingOrder,
+		},
+
+		{
+			name:       "cancel standing order, not found",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodDelete,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Standing order does not exist"}`,
+		},
+
+		{
+			name:       "standing order, bad method",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "list standing order occurrences",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87/occurrences",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"occurrences":[{"id":"7e09ef65-1203-4d10-849a-9e56b9368166","scheduled_at":"2100-01-04T09:00:00Z","status":"executed","attempts":2,"payment_id":"e76c0e9d-499f-4759-ae40-895fec818035","created_at":"*","updated_at":"*"},{"id":"e76c0e9d-499f-4759-ae40-895fec818035","scheduled_at":"2100-01-11T09:00:00Z","status":"retrying","attempts":1,"error":"Account has an insufficient balance","created_at":"*","updated_at":"*"}]}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupStandingOrder(t, ctx, s)
+
+				err := s.uow.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+					for _, o := range []wallet.StandingOrderOccurrence{
+						{
+							ID:              paymentIDs[0],
+							StandingOrderID: paymentIDs[1],
+							ScheduledAt:     time.Date(2100, 1, 4, 9, 0, 0, 0, time.UTC),
+							Status:          wallet.OccurrenceExecuted,
+							Attempts:        2,
+							PaymentID:       &paymentIDs[2],
+						},
+						{
+							ID:              paymentIDs[2],
+							StandingOrderID: paymentIDs[1],
+							ScheduledAt:     time.Date(2100, 1, 11, 9, 0, 0, 0, time.UTC),
+							Status:          wallet.OccurrenceRetrying,
+							Attempts:        1,
+							Error:           errInsufficientBalance.Error(),
+						},
+					} {
+						o := o
+						if err := s.standing.StoreOccurrenceTx(ctx, tx, &o); err != nil {
+							return err
+						}
+					}
+					return nil
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "list standing order occurrences, not found",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87/occurrences",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Standing order does not exist"}`,
+		},
+
+		{
+			name:       "list standing order occurrences, bad method",
+			url:        "/v1/standing-orders/1024abad-6de0-466f-9022-4499a97c3f87/occurrences",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "create hold",
+			url:        "/v1/holds",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"25.00"}`, fromID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					Hold Hold `json:"hold"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				h := r.Hold
+				_, err = uuid.FromString(h.ID)
+				require.NoError(t, err)
+				require.Equal(t, fromID.String(), h.From)
+				require.Equal(t, "25.00", h.Amount)
+				require.Equal(t, wallet.HoldActive, h.Status)
+				require.Empty(t, h.PaymentID)
+
+				expiresAt, err := time.Parse(time.RFC3339Nano, h.ExpiresAt)
+				require.NoError(t, err)
+				require.WithinDuration(t, time.Now().Add(defaultHoldExpiry), expiresAt, time.Minute)
+			},
+			setup: setupPayments,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				a, err := s.accounts.Get(ctx, fromID)
+				require.NoError(t, err)
+				require.Equal(t, "79.78", a.Balance.String())
+				require.Equal(t, "25.00", a.Held.String())
+			},
+		},
+
+		{
+			name:       "create hold, missing from",
+			url:        "/v1/holds",
+			method:     http.MethodPost,
+			body:       `{"amount":"25.00"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"from is required"}`,
+		},
+
+		{
+			name:       "create hold, insufficient balance",
+			url:        "/v1/holds",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"79.79"}`, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Account has an insufficient balance"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "create hold, expires in the past",
+			url:        "/v1/holds",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"25.00","expires_at":"2000-01-01T00:00:00Z"}`, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"expires_at must be in the future, and within 30 days"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "create hold, invalid expires_at",
+			url:        "/v1/holds",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"25.00","expires_at":"tomorrow"}`, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid RFC 3339 time for field \"expires_at\": parsing time \"tomorrow\" as \"2006-01-02T15:04:05Z07:00\": cannot parse \"tomorrow\" as \"2006\""}`,
+		},
+
+		{
+			name:       "create hold, bad method",
+			url:        "/v1/holds",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "get hold",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"hold":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"25.00","status":"active","expires_at":"2100-01-01T00:00:00Z","created_at":"*","updated_at":"*"}}`,
+			setup:      setupHold,
+		},
+
+		{
+			name:       "get hold, not found",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Hold does not exist"}`,
+		},
+
+		{
+			name:       "get hold, invalid id",
+			url:        "/v1/holds/xyz",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid hold ID for field \"id\": uuid: incorrect UUID length: xyz"}`,
+		},
+
+		{
+			name:       "get account with a hold",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"79.78","available_balance":"54.78","status":"active","created_at":"*"}}`,
+			setup:      setupHold,
+		},
+
+		{
+			name:       "capture hold",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/capture",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"amount":"20.00"}`, toID),
+			statusCode: http.StatusOK,
+			checkResponse: func(t *testing.T, resp string) {
+				var r struct {
+					Payment Payment `json:"payment"`
+				}
+				err := json.Unmarshal([]byte(resp), &r)
+				require.NoError(t, err)
+
+				require.Equal(t, toID.String(), r.Payment.To)
+				require.Equal(t, fromID.String(), r.Payment.From)
+				require.Equal(t, "20.00", r.Payment.Amount)
+			},
+			setup: setupHold,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				h, err := s.holds.Get(ctx, paymentIDs[1])
+				require.NoError(t, err)
+				require.Equal(t, wallet.HoldCaptured, h.Status)
+				require.NotNil(t, h.PaymentID)
+
+				// The rest of the hold is released
+				a, err := s.accounts.Get(ctx, fromID)
+				require.NoError(t, err)
+				require.Equal(t, "59.78", a.Balance.String())
+				require.Equal(t, "0", a.Held.String())
+			},
+		},
+
+		{
+			name:       "capture hold, exceeds hold",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/capture",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"amount":"25.01"}`, toID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Capture amount exceeds the amount of the hold"}`,
+			setup:      setupHold,
+		},
+
+		{
+			name:       "capture hold, missing to",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/capture",
+			method:     http.MethodPost,
+			body:       `{"amount":"20.00"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"to is required"}`,
+			setup:      setupHold,
+		},
+
+		{
+			name:       "capture hold, not found",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/capture",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q}`, toID),
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Hold does not exist"}`,
+		},
+
+		{
+			name:       "capture hold, bad method",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/capture",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "release hold",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/release",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			response:   `{"hold":{"id":"1024abad-6de0-466f-9022-4499a97c3f87","from":"5136843a-0948-432d-8ce6-060362edb538","amount":"25.00","status":"released","expires_at":"2100-01-01T00:00:00Z","created_at":"*","updated_at":"*"}}`,
+			setup:      setupHold,
+		},
+
+		{
+			name:       "release hold, not active",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/release",
+			method:     http.MethodPost,
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Hold is not active"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupHold(t, ctx, s)
+
+				_, err := s.Release(ctx, paymentIDs[1])
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "release hold, bad method",
+			url:        "/v1/holds/1024abad-6de0-466f-9022-4499a97c3f87/release",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "freeze account",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/freeze",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"79.78","available_balance":"79.78","status":"frozen","created_at":"*"}}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "freeze account, not found",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/freeze",
+			method:     http.MethodPost,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "freeze account, invalid id",
+			url:        "/v1/admin/accounts/abc/freeze",
+			method:     http.MethodPost,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid account ID for field \"id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "freeze account, bad method",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/freeze",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "admin account, unknown action",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodPost,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Not Found"}`,
+		},
+
+		{
+			name:       "transfer, from frozen account",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, toID, fromID),
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"Account is frozen"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				_, err := s.SetAccountStatus(ctx, fromID, wallet.AccountFrozen)
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "unfreeze account",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/unfreeze",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"79.78","available_balance":"79.78","status":"active","created_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				_, err := s.SetAccountStatus(ctx, fromID, wallet.AccountFrozen)
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "close account",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/close",
+			method:     http.MethodPost,
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"0.00","available_balance":"0.00","status":"closed","created_at":"*"}}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				err := s.accounts.Store(ctx, &wallet.Account{
+					ID:       fromID,
+					Currency: wallet.USD,
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "close account, balance not zero",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/close",
+			method:     http.MethodPost,
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Account balance must be zero to close the account"}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "transfer, to closed account",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, toID, fromID),
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Account is closed"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				_, err := s.Withdraw(ctx, toID, apd.New(2022, -2), "")
+				require.NoError(t, err)
+				_, err = s.SetAccountStatus(ctx, toID, wallet.AccountClosed)
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "set spending limit, currency",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPut,
+			body:       `{"currency":"USD","max_amount":"100.00","hourly_count":10}`,
+			statusCode: http.StatusOK,
+			response:   `{"limit":{"currency":"USD","max_amount":"100.00","hourly_count":10,"updated_at":"*"}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				l, err := s.limits.Limit(ctx, fromID, wallet.USD)
+				require.NoError(t, err)
+				require.Nil(t, l.AccountID)
+				require.Equal(t, 0, apd.New(100, 0).Cmp(l.MaxAmount))
+				require.Nil(t, l.DailyAmount)
+				require.Equal(t, 10, *l.HourlyCount)
+			},
+		},
+
+		{
+			name:       "set spending limit, account",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPut,
+			body:       fmt.Sprintf(`{"account_id":%q,"daily_amount":"250.00"}`, fromID),
+			statusCode: http.StatusOK,
+			response:   `{"limit":{"account_id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","daily_amount":"250.00","updated_at":"*"}}`,
+			setup:      setupPayments,
+		},
+
+		{
+			name:       "set spending limit, account and currency",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPut,
+			body:       fmt.Sprintf(`{"account_id":%q,"currency":"USD","daily_amount":"250.00"}`, fromID),
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Exactly one of account_id or currency is required"}`,
+		},
+
+		{
+			name:       "set spending limit, unknown account",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPut,
+			body:       fmt.Sprintf(`{"account_id":%q,"daily_amount":"250.00"}`, fromID),
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "set spending limit, zero hourly count",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPut,
+			body:       `{"currency":"USD","hourly_count":0}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"hourly_count must be more than 0"}`,
+		},
+
+		{
+			name:       "list spending limits",
+			url:        "/v1/admin/limits",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"limits":[{"currency":"USD","max_amount":"100.00","updated_at":"*"},{"account_id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","daily_amount":"250.00","updated_at":"*"}]}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				_, err := s.SetSpendingLimit(ctx, wallet.SpendingLimit{
+					Currency:  wallet.USD,
+					MaxAmount: apd.New(10000, -2),
+				})
+				require.NoError(t, err)
+				_, err = s.SetSpendingLimit(ctx, wallet.SpendingLimit{
+					AccountID:   &fromID,
+					DailyAmount: apd.New(25000, -2),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "list spending limits, bad method",
+			url:        "/v1/admin/limits",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "transfer, spending limit exceeded",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"20.00"}`, toID, fromID),
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"Spending limit daily_amount exceeded, the remaining allowance is 10.00"}`,
+			setup: func(t *testing.T, ctx context.Context, s service) {
+				setupPayments(t, ctx, s)
+
+				// The 30.33 transfer of setupPayments counts against the limit
+				_, err := s.SetSpendingLimit(ctx, wallet.SpendingLimit{
+					AccountID:   &fromID,
+					DailyAmount: apd.New(4033, -2),
+				})
+				require.NoError(t, err)
+			},
+		},
+
+		{
+			name:       "create customer",
+			url:        "/v1/customers",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":%q,"name":"Alice"}`, customerID),
+			statusCode: http.StatusOK,
+			response:   `{"customer":{"id":"0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d","name":"Alice","created_at":"*"}}`,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				c, err := s.customers.Get(ctx, customerID)
+				require.NoError(t, err)
+				require.Equal(t, "Alice", c.Name)
+			},
+		},
+
+		{
+			name:       "create customer, missing name",
+			url:        "/v1/customers",
+			method:     http.MethodPost,
+			body:       `{}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"name is required"}`,
+		},
+
+		{
+			name:       "create customer, blank name",
+			url:        "/v1/customers",
+			method:     http.MethodPost,
+			body:       `{"name":"  "}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Customer name must not be blank or longer than 255 characters"}`,
+		},
+
+		{
+			name:       "create customer, invalid id",
+			url:        "/v1/customers",
+			method:     http.MethodPost,
+			body:       `{"id":"abc","name":"Alice"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid customer ID for field \"id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "create customer, duplicate id",
+			url:        "/v1/customers",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":%q,"name":"Bob"}`, customerID),
+			statusCode: http.StatusConflict,
+			response:   `{"error":"Customer already exists"}`,
+			setup:      setupCustomer,
+		},
+
+		{
+			name:       "create customer, bad method",
+			url:        "/v1/customers",
+			method:     http.MethodGet,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "get customer",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"customer":{"id":"0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d","name":"Alice","created_at":"*"}}`,
+			setup:      setupCustomer,
+		},
+
+		{
+			name:       "get customer, not found",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Customer does not exist"}`,
+		},
+
+		{
+			name:       "get customer, invalid id",
+			url:        "/v1/customers/abc",
+			method:     http.MethodGet,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid customer ID for field \"id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "create account, with owner",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"id":"8b4a2c1e-3f5d-4e6a-9b7c-1d2e3f4a5b6c","currency":"GBP","owner_id":%q}`, customerID),
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"8b4a2c1e-3f5d-4e6a-9b7c-1d2e3f4a5b6c","currency":"GBP","balance":"0.00","available_balance":"0.00","status":"active","owner_id":"0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d","created_at":"*"}}`,
+			setup:      setupCustomer,
+		},
+
+		{
+			name:       "create account, owner not found",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"currency":"USD","owner_id":%q}`, customerID),
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Customer does not exist"}`,
+		},
+
+		{
+			name:       "create account, invalid owner id",
+			url:        "/v1/accounts",
+			method:     http.MethodPost,
+			body:       `{"currency":"USD","owner_id":"abc"}`,
+			statusCode: http.StatusBadRequest,
+			response:   `{"error":"Invalid customer ID for field \"owner_id\": uuid: incorrect UUID length: abc"}`,
+		},
+
+		{
+			name:       "customer accounts",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/accounts?limit=1",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"accounts":[{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"EUR","balance":"3.00","available_balance":"3.00","status":"active","owner_id":"0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d","created_at":"*"}],"next_cursor":"NTEzNjg0M2EtMDk0OC00MzJkLThjZTYtMDYwMzYyZWRiNTM4"}`,
+			setup:      setupCustomer,
+		},
+
+		{
+			name:       "customer accounts, not found",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/accounts",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Customer does not exist"}`,
+		},
+
+		{
+			name:       "customer accounts, bad method",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/accounts",
+			method:     http.MethodPost,
+			statusCode: http.StatusMethodNotAllowed,
+			response:   `{"error":"Method Not Allowed"}`,
+		},
+
+		{
+			name:       "customer balances",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/balances",
+			method:     http.MethodGet,
+			statusCode: http.StatusOK,
+			response:   `{"balances":[{"currency":"EUR","balance":"3.00","accounts":1},{"currency":"USD","balance":"10.50","accounts":1}]}`,
+			setup:      setupCustomer,
+		},
+
+		{
+			name:       "customer balances, not found",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/balances",
+			method:     http.MethodGet,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Customer does not exist"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := log.NewNopLogger()
+			s, _ := newTestService(t)
+
+			ctx := context.Background()
+			if tc.setup != nil {
+				tc.setup(t, ctx, s.(service))
+			}
+
+			handler := MakeHandler(s, nil, nil, logger)
+			w := httptest.NewRecorder()
+
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			if tc.checkResponse != nil {
+				require.Empty(t, tc.response, "response should not be set when using checkResponse")
+				tc.checkResponse(t, string(body))
+			} else {
+				require.Equal(t, tc.response+"\n", normalizeTimestamps(t, string(body)))
+			}
+
+			require.Equal(t, tc.statusCode, resp.StatusCode)
+			require.Equal(t, "application/json; charset=utf-8", resp.Header.Get("Content-Type"))
+
+			if tc.checkDB != nil {
+				tc.checkDB(t, ctx, s.(service))
+			}
+		})
+	}
+}
+
+func TestAPIKeyAuth(t *testing.T) {
+	toID := uuid.Must(uuid.FromString("b0505aa0-b927-4667-a484-906b4e2a410b"))
+	fromID := uuid.Must(uuid.FromString("5136843a-0948-432d-8ce6-060362edb538"))
+	keyID := uuid.Must(uuid.FromString("2f8d6a4c-1b3e-4d5f-9a7b-8c6d4e2f0a1b"))
+	revokedKeyID := uuid.Must(uuid.FromString("9a7b8c6d-4e2f-4a1b-8d6a-4c1b3e4d5f2f"))
+
+	transfer := fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, toID, fromID)
+
+	cases := []struct {
+		name       string
+		url        string
+		method     string
+		body       string
+		key        string
+		statusCode int
+		response   string
+		checkDB    func(*testing.T, context.Context, service)
+	}{
+		{
+			name:       "no api key",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"API key is required in the X-Api-Key header"}`,
+		},
+
+		{
+			name:       "unknown api key",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			key:        "wk_unknown",
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"Invalid API key"}`,
+		},
+
+		{
+			name:       "revoked api key",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			key:        "wk_revoked",
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"Invalid API key"}`,
+		},
+
+		{
+			name:       "missing scope",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       transfer,
+			key:        "wk_read",
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"API key does not have the \"transfer:write\" scope"}`,
+		},
+
+		{
+			name:       "missing admin scope",
+			url:        "/v1/admin/accounts/5136843a-0948-432d-8ce6-060362edb538/freeze",
+			method:     http.MethodPost,
+			key:        "wk_write",
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"API key does not have the \"admin\" scope"}`,
+		},
+
+		{
+			name:       "read with scope",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodGet,
+			key:        "wk_read",
+			statusCode: http.StatusOK,
+			response:   `{"account":{"id":"5136843a-0948-432d-8ce6-060362edb538","currency":"USD","balance":"100.00","available_balance":"100.00","status":"active","created_at":"*"}}`,
+		},
+
+		{
+			name:       "transfer with scope",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       transfer,
+			key:        "wk_write",
+			statusCode: http.StatusOK,
+			checkDB: func(t *testing.T, ctx context.Context, s service) {
+				payments, _, err := s.payments.List(ctx, wallet.Page{Limit: 10})
+				require.NoError(t, err)
+				require.Len(t, payments, 2)
+				for _, p := range payments {
+					if !uuid.Equal(p.To, toID) {
+						// The deposit of the setup was not made with an API key
+						require.Nil(t, p.APIKeyID)
+						continue
+					}
+					require.NotNil(t, p.APIKeyID)
+					require.Equal(t, keyID, *p.APIKeyID)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := log.NewNopLogger()
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{toID, fromID} {
+				_, err := s.CreateAccount(ctx, id, wallet.USD, nil)
+				require.NoError(t, err)
+			}
+			_, err := s.Deposit(ctx, fromID, apd.New(100, 0), "")
+			require.NoError(t, err)
+
+			for _, k := range []struct {
+				id     uuid.UUID
+				token  string
+				scopes []string
+			}{
+				{keyID, "wk_write", []string{wallet.ScopeAccountsRead, wallet.ScopeTransferWrite}},
+				{uuid.Must(uuid.NewV4()), "wk_read", []string{wallet.ScopeAccountsRead}},
+				{revokedKeyID, "wk_revoked", wallet.Scopes},
+			} {
+				err := repos.apiKeys.Store(ctx, &wallet.APIKey{
+					ID:     k.id,
+					Name:   k.token,
+					Scopes: k.scopes,
+				}, wallet.HashAPIKeyToken(k.token))
+				require.NoError(t, err)
+			}
+			_, err = repos.apiKeys.Revoke(ctx, revokedKeyID)
+			require.NoError(t, err)
+
+			handler := MakeHandler(s, repos.apiKeys, nil, logger)
+			w := httptest.NewRecorder()
+
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			if tc.key != "" {
+				req.Header.Set(apiKeyHeader, tc.key)
+			}
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			if tc.response != "" {
+				require.Equal(t, tc.response+"\n", normalizeTimestamps(t, string(body)))
+			}
+			require.Equal(t, tc.statusCode, resp.StatusCode, string(body))
+
+			if tc.checkDB != nil {
+				tc.checkDB(t, ctx, s.(service))
+			}
+		})
+	}
+}
+
+func TestJWTAuth(t *testing.T) {
+	toID := uuid.Must(uuid.FromString("b0505aa0-b927-4667-a484-906b4e2a410b"))
+	fromID := uuid.Must(uuid.FromString("5136843a-0948-432d-8ce6-060362edb538"))
+	otherID := uuid.Must(uuid.FromString("7d3c5e1a-2b4f-4c6d-8e9a-1f3b5d7c9e2a"))
+	customerID := uuid.Must(uuid.FromString("0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d"))
+	otherCustomerID := uuid.Must(uuid.FromString("4a6c8e0b-1d3f-4b5a-9c7e-2f4a6c8e0b1d"))
+
+	hmacKey := []byte("secret")
+	token := func(subject string, exp time.Time) string {
+		return signJWT(t, "HS256", hmacKey, map[string]interface{}{
+			"sub": subject,
+			"exp": exp.Unix(),
+		})
+	}
+	valid := token(customerID.String(), time.Now().Add(time.Hour))
+
+	cases := []struct {
+		name       string
+		url        string
+		method     string
+		body       string
+		token      string
+		statusCode int
+		response   string
+		check      func(*testing.T, []byte)
+	}{
+		{
+			name:       "invalid token",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			token:      token(customerID.String(), time.Now().Add(time.Hour)) + "x",
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"Invalid bearer token: invalid signature"}`,
+		},
+
+		{
+			name:       "expired token",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			token:      token(customerID.String(), time.Now().Add(-time.Minute)),
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"Invalid bearer token: token has expired"}`,
+		},
+
+		{
+			name:       "subject not a customer ID",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			token:      token("alice", time.Now().Add(time.Hour)),
+			statusCode: http.StatusUnauthorized,
+			response:   `{"error":"Invalid bearer token: subject is not a customer ID"}`,
+		},
+
+		{
+			name:       "transfer from own account",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, toID, fromID),
+			token:      valid,
+			statusCode: http.StatusOK,
+		},
+
+		{
+			name:       "transfer from another customer's account",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, toID, otherID),
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "transfer from account without owner",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":%q,"amount":"1.00"}`, fromID, toID),
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "accounts are filtered",
+			url:        "/v1/accounts",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var resp struct {
+					Accounts []Account `json:"accounts"`
+				}
+				require.NoError(t, json.Unmarshal(body, &resp))
+				require.Len(t, resp.Accounts, 1)
+				require.Equal(t, fromID.String(), resp.Accounts[0].ID)
+			},
+		},
+
+		{
+			name:       "payments are filtered",
+			url:        "/v1/payments",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusOK,
+			check: func(t *testing.T, body []byte) {
+				var resp struct {
+					Payments []Payment `json:"payments"`
+				}
+				require.NoError(t, json.Unmarshal(body, &resp))
+				require.Len(t, resp.Payments, 1)
+				require.Equal(t, fromID.String(), resp.Payments[0].To)
+			},
+		},
+
+		{
+			name:       "own account",
+			url:        "/v1/accounts/5136843a-0948-432d-8ce6-060362edb538",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusOK,
+		},
+
+		{
+			name:       "another customer's account",
+			url:        "/v1/accounts/7d3c5e1a-2b4f-4c6d-8e9a-1f3b5d7c9e2a",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			// Accounts of other customers can't be told apart from accounts that do not exist
+			name:       "unknown account",
+			url:        "/v1/accounts/e6b1d3f5-8a2c-4e7b-9d1f-3c5a7e9b1d2f",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "another customer's account payments",
+			url:        "/v1/accounts/7d3c5e1a-2b4f-4c6d-8e9a-1f3b5d7c9e2a/payments",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "transfer from unknown account",
+			url:        "/v1/transfer",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"to":%q,"from":"e6b1d3f5-8a2c-4e7b-9d1f-3c5a7e9b1d2f","amount":"1.00"}`, toID),
+			token:      valid,
+			statusCode: http.StatusNotFound,
+			response:   `{"error":"Account does not exist"}`,
+		},
+
+		{
+			name:       "own customer",
+			url:        "/v1/customers/0e1e3a56-9b7c-4f5e-8d2a-6c4b1f0a9e3d/balances",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusOK,
+			response:   `{"balances":[{"currency":"USD","balance":"100.00","accounts":1}]}`,
+		},
+
+		{
+			name:       "another customer",
+			url:        "/v1/customers/4a6c8e0b-1d3f-4b5a-9c7e-2f4a6c8e0b1d",
+			method:     http.MethodGet,
+			token:      valid,
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"Customer is not the authenticated customer"}`,
+		},
+
+		{
+			name:       "api key endpoint",
+			url:        "/v1/withdraw",
+			method:     http.MethodPost,
+			body:       fmt.Sprintf(`{"from":%q,"amount":"1.00"}`, fromID),
+			token:      valid,
+			statusCode: http.StatusForbidden,
+			response:   `{"error":"Endpoint requires an API key"}`,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			logger := log.NewNopLogger()
+			s, repos := newTestService(t)
+
+			ctx := context.Background()
+
+			for _, id := range []uuid.UUID{customerID, otherCustomerID} {
+				_, err := s.CreateCustomer(ctx, id, "Customer")
+				require.NoError(t, err)
+			}
+			for _, a := range []struct {
+				id    uuid.UUID
+				owner *uuid.UUID
+			}{
+				{toID, nil},
+				{fromID, &customerID},
+				{otherID, &otherCustomerID},
+			} {
+				_, err := s.CreateAccount(ctx, a.id, wallet.USD, a.owner)
+				require.NoError(t, err)
+			}
+			for _, id := range []uuid.UUID{fromID, otherID} {
+				_, err := s.Deposit(ctx, id, apd.New(100, 0), "")
+				require.NoError(t, err)
+			}
+
+			verifier, err := NewHMACVerifier(hmacKey)
+			require.NoError(t, err)
+
+			handler := MakeHandler(s, repos.apiKeys, verifier, logger)
+			w := httptest.NewRecorder()
+
+			req, err := http.NewRequest(tc.method, tc.url, strings.NewReader(tc.body))
+			require.NoError(t, err)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+
+			handler.ServeHTTP(w, req)
+
+			resp := w.Result()
+			defer resp.Body.Close()
+
+			body, err := ioutil.ReadAll(resp.Body)
+			require.NoError(t, err)
+
+			if tc.response != "" {
+				require.Equal(t, tc.response+"\n", normalizeTimestamps(t, string(body)))
+			}
+			require.Equal(t, tc.statusCode, resp.StatusCode, string(body))
+
+			if tc.check != nil {
+				tc.check(t, body)
 			}
 		})
 	}
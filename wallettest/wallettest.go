@@ -0,0 +1,2211 @@
+// Package wallettest provides a conformance test suite for implementations
+// of the wallet storage interfaces. Every storage backend must pass it, so that
+// the service behaves the same with any backend.
+package wallettest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/apd"
+	uuid "github.com/satori/go.uuid"
+	"github.com/stretchr/testify/require"
+
+	wallet "github.com/xsleonard/gokit-example"
+)
+
+// Repositories are the repositories of a storage backend, and the UnitOfWork
+// that creates their transactions. The repositories must share the same storage.
+type Repositories struct {
+	UnitOfWork         wallet.UnitOfWork
+	Accounts           wallet.AccountRepository
+	Payments           wallet.PaymentRepository
+	Rates              wallet.RateRepository
+	Currencies         wallet.CurrencyRepository
+	Fees               wallet.FeeRepository
+	ScheduledTransfers wallet.ScheduledTransferRepository
+	StandingOrders     wallet.StandingOrderRepository
+	Holds              wallet.HoldRepository
+	Limits             wallet.LimitRepository
+	Customers          wallet.CustomerRepository
+	APIKeys            wallet.APIKeyRepository
+}
+
+// Setup creates repositories with empty storage, except for the default currencies
+// (see wallet.USD), and returns a teardown function
+type Setup func(t *testing.T) (Repositories, func())
+
+// Run runs the conformance tests. Each test is run with new repositories created by setup.
+func Run(t *testing.T, setup Setup) {
+	cases := []struct {
+		name string
+		test func(t *testing.T, ctx context.Context, r Repositories)
+	}{
+		{"account store and get", testAccountStoreGet},
+		{"account store errors", testAccountStoreErrors},
+		{"account status", testAccountStatus},
+		{"account list", testAccountList},
+		{"external account", testExternalAccount},
+		{"payment store", testPaymentStore},
+		{"payment store errors", testPaymentStoreErrors},
+		{"payment cross currency", testPaymentCrossCurrency},
+		{"payment idempotency key", testPaymentIdempotencyKey},
+		{"payment refund", testPaymentRefund},
+		{"payment negative balance", testPaymentNegativeBalance},
+		{"journal entry", testJournalEntry},
+		{"journal entry postings", testJournalEntryPostings},
+		{"payment fee", testPaymentFee},
+		{"balance drifts", testBalanceDrifts},
+		{"payment list", testPaymentList},
+		{"account payments", testAccountPayments},
+		{"account payments fee", testAccountPaymentsFee},
+		{"tx rollback", testTxRollback},
+		{"tx context", testTxContext},
+		{"tx savepoint", testTxSavepoint},
+		{"rates", testRates},
+		{"currencies", testCurrencies},
+		{"fee schedules", testFeeSchedules},
+		{"scheduled transfers", testScheduledTransfers},
+		{"standing orders", testStandingOrders},
+		{"standing order occurrences", testStandingOrderOccurrences},
+		{"holds", testHolds},
+		{"hold expiry", testHoldExpiry},
+		{"spending limits", testSpendingLimits},
+		{"spending", testSpending},
+		{"customers", testCustomers},
+		{"account owner", testAccountOwner},
+		{"customer balances", testCustomerBalances},
+		{"api keys", testAPIKeys},
+		{"payment api key", testPaymentAPIKey},
+		{"scheduled api key", testScheduledAPIKey},
+		{"payment owner", testPaymentOwner},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, teardown := setup(t)
+			defer teardown()
+
+			tc.test(t, context.Background(), r)
+		})
+	}
+}
+
+func newID(t *testing.T) uuid.UUID {
+	id, err := uuid.NewV4()
+	require.NoError(t, err)
+	return id
+}
+
+func newAccount(t *testing.T, ctx context.Context, r Repositories, currency string) uuid.UUID {
+	a := &wallet.Account{
+		ID:       newID(t),
+		Currency: currency,
+	}
+	require.NoError(t, r.Accounts.Store(ctx, a))
+	return a.ID
+}
+
+func deposit(t *testing.T, ctx context.Context, r Repositories, to uuid.UUID, amount *apd.Decimal) *wallet.Payment {
+	p := &wallet.Payment{
+		ID:     newID(t),
+		To:     to,
+		Amount: amount,
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	return p
+}
+
+func requireBalance(t *testing.T, ctx context.Context, r Repositories, id uuid.UUID, balance string) {
+	a, err := r.Accounts.Get(ctx, id)
+	require.NoError(t, err)
+	require.Equal(t, balance, a.Balance.String())
+}
+
+func requireDecimal(t *testing.T, expected string, d *apd.Decimal) {
+	require.NotNil(t, d)
+	require.Equal(t, expected, d.String())
+}
+
+func requirePostings(t *testing.T, expected, postings []wallet.Posting) {
+	require.Len(t, postings, len(expected))
+	for i, p := range postings {
+		require.Equal(t, expected[i].AccountID, p.AccountID)
+		requireDecimal(t, expected[i].Amount.String(), p.Amount)
+		require.Equal(t, expected[i].Currency, p.Currency)
+	}
+}
+
+func systemAccountID(t *testing.T, ctx context.Context, r Repositories, kind, currency string) uuid.UUID {
+	var id uuid.UUID
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		var err error
+		id, err = r.Accounts.SystemAccountIDTx(ctx, tx, kind, currency)
+		return err
+	}))
+	return id
+}
+
+func testAccountStoreGet(t *testing.T, ctx context.Context, r Repositories) {
+	a := &wallet.Account{
+		ID:       newID(t),
+		Currency: wallet.USD,
+	}
+	require.NoError(t, r.Accounts.Store(ctx, a))
+	require.Equal(t, wallet.AccountKindUser, a.Kind)
+	require.Equal(t, wallet.AccountActive, a.Status)
+	require.False(t, a.CreatedAt.IsZero())
+	require.Equal(t, time.UTC, a.CreatedAt.Location())
+
+	got, err := r.Accounts.Get(ctx, a.ID)
+	require.NoError(t, err)
+	require.Equal(t, a.ID, got.ID)
+	require.Equal(t, wallet.USD, got.Currency)
+	require.Equal(t, wallet.AccountKindUser, got.Kind)
+	require.True(t, got.IsUser())
+	require.True(t, a.CreatedAt.Equal(got.CreatedAt))
+	// The zero balance has the currency's decimal places
+	requireDecimal(t, "0.00", got.Balance)
+
+	_, err = r.Accounts.Get(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoAccount, err)
+}
+
+func testAccountStoreErrors(t *testing.T, ctx context.Context, r Repositories) {
+	id := newAccount(t, ctx, r, wallet.USD)
+
+	err := r.Accounts.Store(ctx, &wallet.Account{
+		ID:       id,
+		Currency: wallet.USD,
+	})
+	require.Equal(t, wallet.ErrAccountExists, err)
+
+	err = r.Accounts.Store(ctx, &wallet.Account{
+		ID:       newID(t),
+		Currency: "XYZ",
+	})
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	err = r.Accounts.Store(ctx, &wallet.Account{
+		Currency: wallet.USD,
+	})
+	require.Error(t, err)
+}
+
+func testAccountStatus(t *testing.T, ctx context.Context, r Repositories) {
+	id := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, id, apd.New(500, -2))
+
+	for _, status := range []string{wallet.AccountFrozen, wallet.AccountActive, wallet.AccountClosed} {
+		err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			a := &wallet.Account{
+				ID:     id,
+				Status: status,
+			}
+			require.NoError(t, r.Accounts.UpdateStatusTx(ctx, tx, a))
+			require.Equal(t, status, a.Status)
+			// The updated account is returned
+			require.Equal(t, wallet.USD, a.Currency)
+			requireDecimal(t, "5.00", a.Balance)
+			return nil
+		})
+		require.NoError(t, err)
+
+		a, err := r.Accounts.Get(ctx, id)
+		require.NoError(t, err)
+		require.Equal(t, status, a.Status)
+	}
+
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return r.Accounts.UpdateStatusTx(ctx, tx, &wallet.Account{
+			ID:     newID(t),
+			Status: wallet.AccountFrozen,
+		})
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+}
+
+func testAccountList(t *testing.T, ctx context.Context, r Repositories) {
+	ids := make(map[uuid.UUID]struct{})
+	for i := 0; i < 5; i++ {
+		ids[newAccount(t, ctx, r, wallet.USD)] = struct{}{}
+	}
+
+	// External accounts are not listed
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		_, err := r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		return err
+	}))
+
+	var listed []wallet.Account
+	page := wallet.Page{Limit: 2}
+	for i := 0; ; i++ {
+		require.True(t, i < 5, "too many pages")
+
+		accounts, next, err := r.Accounts.List(ctx, page)
+		require.NoError(t, err)
+		require.True(t, len(accounts) <= page.Limit)
+		listed = append(listed, accounts...)
+
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	require.Len(t, listed, len(ids))
+	for i, a := range listed {
+		require.Contains(t, ids, a.ID)
+		require.True(t, a.IsUser())
+		if i > 0 {
+			// Ordered by ID, the same as postgres orders UUIDs
+			require.True(t, listed[i-1].ID.String() < a.ID.String())
+		}
+	}
+
+	_, _, err := r.Accounts.List(ctx, wallet.Page{Limit: 2, Cursor: "foo"})
+	require.Equal(t, wallet.ErrInvalidCursor, err)
+}
+
+func testExternalAccount(t *testing.T, ctx context.Context, r Repositories) {
+	var usdID, usdID2, eurID uuid.UUID
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		var err error
+		usdID, err = r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		require.NoError(t, err)
+		usdID2, err = r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		require.NoError(t, err)
+		eurID, err = r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.EUR)
+		require.NoError(t, err)
+		return nil
+	}))
+
+	require.Equal(t, usdID, usdID2)
+	require.NotEqual(t, usdID, eurID)
+
+	// The account is the same in a later transaction
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		id, err := r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		require.NoError(t, err)
+		require.Equal(t, usdID, id)
+		return nil
+	}))
+
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		_, err := r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, "XYZ")
+		return err
+	})
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	a, err := r.Accounts.Get(ctx, usdID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.AccountKindExternal, a.Kind)
+	require.Equal(t, wallet.USD, a.Currency)
+	require.False(t, a.IsUser())
+
+	// Each kind of system account is a different account
+	exchangeID := systemAccountID(t, ctx, r, wallet.AccountKindExchange, wallet.USD)
+	require.NotEqual(t, usdID, exchangeID)
+	a, err = r.Accounts.Get(ctx, exchangeID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.AccountKindExchange, a.Kind)
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		_, err := r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindUser, wallet.USD)
+		return err
+	})
+	require.Error(t, err)
+}
+
+func testPaymentStore(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	credit := deposit(t, ctx, r, fromID, apd.New(100, 0))
+	require.False(t, credit.CreatedAt.IsZero())
+	requireDecimal(t, "0", credit.RefundedAmount)
+	requireBalance(t, ctx, r, fromID, "100.00")
+
+	p := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(3033, -2),
+	}
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return r.Payments.StoreTx(ctx, tx, p)
+	}))
+	require.False(t, p.CreatedAt.IsZero())
+
+	requireBalance(t, ctx, r, fromID, "69.67")
+	requireBalance(t, ctx, r, toID, "30.33")
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, p.ID)
+		require.NoError(t, err)
+		require.Equal(t, p.ID, got.ID)
+		require.Equal(t, toID, got.To)
+		require.NotNil(t, got.From)
+		require.Equal(t, fromID, *got.From)
+		requireDecimal(t, "30.33", got.Amount)
+		require.Nil(t, got.ToAmount)
+		require.Nil(t, got.Rate)
+		require.Nil(t, got.ReversalOf)
+		requireDecimal(t, "0", got.RefundedAmount)
+		require.True(t, p.CreatedAt.Equal(got.CreatedAt))
+
+		_, err = r.Payments.GetTx(ctx, tx, newID(t))
+		require.Equal(t, wallet.ErrNoPayment, err)
+		return nil
+	}))
+
+	// Amounts are stored with the currency's decimal places
+	rounded := deposit(t, ctx, r, toID, apd.New(5, 0))
+	requireDecimal(t, "5.00", rounded.Amount)
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, rounded.ID)
+		require.NoError(t, err)
+		requireDecimal(t, "5.00", got.Amount)
+		return nil
+	}))
+	requireBalance(t, ctx, r, toID, "35.33")
+}
+
+func testPaymentStoreErrors(t *testing.T, ctx context.Context, r Repositories) {
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     newID(t),
+		Amount: apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	err = r.Payments.Store(ctx, &wallet.Payment{
+		To:     toID,
+		Amount: apd.New(1, 0),
+	})
+	require.Error(t, err)
+
+	p := deposit(t, ctx, r, toID, apd.New(1, 0))
+	err = r.Payments.Store(ctx, &wallet.Payment{
+		ID:     p.ID,
+		To:     toID,
+		Amount: apd.New(1, 0),
+	})
+	require.Error(t, err)
+
+	requireBalance(t, ctx, r, toID, "1.00")
+}
+
+func testPaymentCrossCurrency(t *testing.T, ctx context.Context, r Repositories) {
+	require.NoError(t, r.Currencies.Store(ctx, &wallet.Currency{
+		Code:     wallet.JPY,
+		Exponent: 0,
+		Enabled:  true,
+	}))
+
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.JPY)
+	deposit(t, ctx, r, fromID, apd.New(10, 0))
+
+	p := &wallet.Payment{
+		ID:       newID(t),
+		To:       toID,
+		From:     &fromID,
+		Amount:   apd.New(150, -2),
+		ToAmount: apd.New(165, 0),
+		Rate:     apd.New(110, 0),
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+
+	requireBalance(t, ctx, r, fromID, "8.50")
+	requireBalance(t, ctx, r, toID, "165")
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, p.ID)
+		require.NoError(t, err)
+		requireDecimal(t, "1.50", got.Amount)
+		requireDecimal(t, "165", got.ToAmount)
+		requireDecimal(t, "110", got.Rate)
+		requireDecimal(t, "165", got.CreditAmount())
+		return nil
+	}))
+}
+
+func testPaymentIdempotencyKey(t *testing.T, ctx context.Context, r Repositories) {
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	p := &wallet.Payment{
+		ID:             newID(t),
+		To:             toID,
+		Amount:         apd.New(1, 0),
+		IdempotencyKey: "abc",
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:             newID(t),
+		To:             toID,
+		Amount:         apd.New(1, 0),
+		IdempotencyKey: "abc",
+	})
+	require.Equal(t, wallet.ErrIdempotencyKeyReused, err)
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetByIdempotencyKeyTx(ctx, tx, "abc")
+		require.NoError(t, err)
+		require.Equal(t, p.ID, got.ID)
+		require.Equal(t, "abc", got.IdempotencyKey)
+
+		_, err = r.Payments.GetByIdempotencyKeyTx(ctx, tx, "def")
+		require.Equal(t, wallet.ErrNoPayment, err)
+		return nil
+	}))
+
+	requireBalance(t, ctx, r, toID, "1.00")
+}
+
+func testPaymentRefund(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(100, 0))
+
+	original := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(10, 0),
+	}
+	require.NoError(t, r.Payments.Store(ctx, original))
+
+	refund := func(amount *apd.Decimal) error {
+		return r.Payments.Store(ctx, &wallet.Payment{
+			ID:         newID(t),
+			To:         fromID,
+			From:       &toID,
+			Amount:     amount,
+			ReversalOf: &original.ID,
+		})
+	}
+
+	require.NoError(t, refund(apd.New(4, 0)))
+	require.NoError(t, refund(apd.New(250, -2)))
+	// The refunded amount can't exceed the payment
+	require.Error(t, refund(apd.New(4, 0)))
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, original.ID)
+		require.NoError(t, err)
+		requireDecimal(t, "6.50", got.RefundedAmount)
+		return nil
+	}))
+
+	requireBalance(t, ctx, r, fromID, "96.50")
+	requireBalance(t, ctx, r, toID, "3.50")
+
+	// The original payment must exist
+	unknown := newID(t)
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:         newID(t),
+		To:         fromID,
+		From:       &toID,
+		Amount:     apd.New(1, 0),
+		ReversalOf: &unknown,
+	})
+	require.Error(t, err)
+	requireBalance(t, ctx, r, toID, "3.50")
+}
+
+func testPaymentNegativeBalance(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(1, 0))
+
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(101, -2),
+	})
+	require.Equal(t, wallet.ErrNegativeBalance, err)
+
+	requireBalance(t, ctx, r, fromID, "1.00")
+	requireBalance(t, ctx, r, toID, "0.00")
+
+	// External accounts have a negative balance
+	var externalID uuid.UUID
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		var err error
+		externalID, err = r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		if err != nil {
+			return err
+		}
+		return r.Payments.StoreTx(ctx, tx, &wallet.Payment{
+			ID:     newID(t),
+			To:     toID,
+			From:   &externalID,
+			Amount: apd.New(5, 0),
+		})
+	}))
+
+	// The external account was also debited by the first deposit, which has no "From" account
+	requireBalance(t, ctx, r, externalID, "-6.00")
+	requireBalance(t, ctx, r, toID, "5.00")
+}
+
+func testJournalEntry(t *testing.T, ctx context.Context, r Repositories) {
+	require.NoError(t, r.Currencies.Store(ctx, &wallet.Currency{
+		Code:     wallet.JPY,
+		Exponent: 0,
+		Enabled:  true,
+	}))
+
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	jpyID := newAccount(t, ctx, r, wallet.JPY)
+
+	// A credit without a "From" account debits the external account of its currency
+	credit := deposit(t, ctx, r, fromID, apd.New(100, 0))
+	externalID := systemAccountID(t, ctx, r, wallet.AccountKindExternal, wallet.USD)
+	requirePostings(t, []wallet.Posting{
+		{AccountID: externalID, Amount: apd.New(-10000, -2), Currency: wallet.USD},
+		{AccountID: fromID, Amount: apd.New(10000, -2), Currency: wallet.USD},
+	}, credit.Postings)
+
+	entry, err := r.Payments.JournalEntry(ctx, credit.ID)
+	require.NoError(t, err)
+	require.Equal(t, credit.ID, entry.PaymentID)
+	require.False(t, entry.CreatedAt.IsZero())
+	requirePostings(t, credit.Postings, entry.Postings)
+	requireBalance(t, ctx, r, externalID, "-100.00")
+
+	p := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(3033, -2),
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	entry, err = r.Payments.JournalEntry(ctx, p.ID)
+	require.NoError(t, err)
+	requirePostings(t, []wallet.Posting{
+		{AccountID: fromID, Amount: apd.New(-3033, -2), Currency: wallet.USD},
+		{AccountID: toID, Amount: apd.New(3033, -2), Currency: wallet.USD},
+	}, entry.Postings)
+
+	// Payments between currencies are exchanged through the exchange account of each currency
+	cross := &wallet.Payment{
+		ID:       newID(t),
+		To:       jpyID,
+		From:     &fromID,
+		Amount:   apd.New(150, -2),
+		ToAmount: apd.New(165, 0),
+		Rate:     apd.New(110, 0),
+	}
+	require.NoError(t, r.Payments.Store(ctx, cross))
+	usdExchangeID := systemAccountID(t, ctx, r, wallet.AccountKindExchange, wallet.USD)
+	jpyExchangeID := systemAccountID(t, ctx, r, wallet.AccountKindExchange, wallet.JPY)
+	entry, err = r.Payments.JournalEntry(ctx, cross.ID)
+	require.NoError(t, err)
+	requirePostings(t, []wallet.Posting{
+		{AccountID: fromID, Amount: apd.New(-150, -2), Currency: wallet.USD},
+		{AccountID: usdExchangeID, Amount: apd.New(150, -2), Currency: wallet.USD},
+		{AccountID: jpyExchangeID, Amount: apd.New(-165, 0), Currency: wallet.JPY},
+		{AccountID: jpyID, Amount: apd.New(165, 0), Currency: wallet.JPY},
+	}, entry.Postings)
+
+	requireBalance(t, ctx, r, fromID, "68.17")
+	requireBalance(t, ctx, r, usdExchangeID, "1.50")
+	requireBalance(t, ctx, r, jpyExchangeID, "-165")
+	requireBalance(t, ctx, r, jpyID, "165")
+
+	_, err = r.Payments.JournalEntry(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoPayment, err)
+}
+
+func testJournalEntryPostings(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	feeID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(100, 0))
+
+	// A payment can have more than two postings, such as a transfer with a fee
+	p := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(10, 0),
+		Postings: []wallet.Posting{
+			{AccountID: fromID, Amount: apd.New(-11, 0), Currency: wallet.USD},
+			{AccountID: toID, Amount: apd.New(10, 0), Currency: wallet.USD},
+			{AccountID: feeID, Amount: apd.New(1, 0), Currency: wallet.USD},
+		},
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	requirePostings(t, []wallet.Posting{
+		{AccountID: fromID, Amount: apd.New(-1100, -2), Currency: wallet.USD},
+		{AccountID: toID, Amount: apd.New(1000, -2), Currency: wallet.USD},
+		{AccountID: feeID, Amount: apd.New(100, -2), Currency: wallet.USD},
+	}, p.Postings)
+
+	entry, err := r.Payments.JournalEntry(ctx, p.ID)
+	require.NoError(t, err)
+	requirePostings(t, p.Postings, entry.Postings)
+
+	requireBalance(t, ctx, r, fromID, "89.00")
+	requireBalance(t, ctx, r, toID, "10.00")
+	requireBalance(t, ctx, r, feeID, "1.00")
+
+	cases := []struct {
+		name     string
+		postings []wallet.Posting
+		err      error
+	}{
+		{
+			name: "unbalanced",
+			postings: []wallet.Posting{
+				{AccountID: fromID, Amount: apd.New(-5, 0), Currency: wallet.USD},
+				{AccountID: toID, Amount: apd.New(4, 0), Currency: wallet.USD},
+			},
+			err: wallet.ErrUnbalancedEntry,
+		},
+		{
+			name: "one posting",
+			postings: []wallet.Posting{
+				{AccountID: toID, Amount: apd.New(5, 0), Currency: wallet.USD},
+			},
+			err: wallet.ErrUnbalancedEntry,
+		},
+		{
+			name: "zero amount",
+			postings: []wallet.Posting{
+				{AccountID: fromID, Amount: apd.New(0, 0), Currency: wallet.USD},
+				{AccountID: toID, Amount: apd.New(0, 0), Currency: wallet.USD},
+			},
+			err: wallet.ErrUnbalancedEntry,
+		},
+		{
+			name: "wrong currency",
+			postings: []wallet.Posting{
+				{AccountID: fromID, Amount: apd.New(-5, 0), Currency: wallet.EUR},
+				{AccountID: toID, Amount: apd.New(5, 0), Currency: wallet.EUR},
+			},
+			err: wallet.ErrNoAccount,
+		},
+		{
+			name: "negative balance",
+			postings: []wallet.Posting{
+				{AccountID: toID, Amount: apd.New(-11, 0), Currency: wallet.USD},
+				{AccountID: fromID, Amount: apd.New(11, 0), Currency: wallet.USD},
+			},
+			err: wallet.ErrNegativeBalance,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			p := &wallet.Payment{
+				ID:       newID(t),
+				To:       toID,
+				From:     &fromID,
+				Amount:   apd.New(5, 0),
+				Postings: tc.postings,
+			}
+			err := r.Payments.Store(ctx, p)
+			require.Equal(t, tc.err, err)
+
+			// Nothing was stored
+			_, err = r.Payments.JournalEntry(ctx, p.ID)
+			require.Equal(t, wallet.ErrNoPayment, err)
+			requireBalance(t, ctx, r, fromID, "89.00")
+			requireBalance(t, ctx, r, toID, "10.00")
+		})
+	}
+
+	drifts, err := r.Accounts.BalanceDrifts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func testPaymentFee(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(100, 0))
+
+	// The fee is rounded to the currency's decimal places, and is
+	// credited to the revenue account of the sender's currency
+	p := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(10, 0),
+		Fee:    apd.New(5, -1),
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	requireDecimal(t, "0.50", p.Fee)
+
+	revenueID := systemAccountID(t, ctx, r, wallet.AccountKindRevenue, wallet.USD)
+	requirePostings(t, []wallet.Posting{
+		{AccountID: fromID, Amount: apd.New(-1000, -2), Currency: wallet.USD},
+		{AccountID: toID, Amount: apd.New(1000, -2), Currency: wallet.USD},
+		{AccountID: fromID, Amount: apd.New(-50, -2), Currency: wallet.USD},
+		{AccountID: revenueID, Amount: apd.New(50, -2), Currency: wallet.USD},
+	}, p.Postings)
+
+	requireBalance(t, ctx, r, fromID, "89.50")
+	requireBalance(t, ctx, r, toID, "10.00")
+	requireBalance(t, ctx, r, revenueID, "0.50")
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, p.ID)
+		require.NoError(t, err)
+		requireDecimal(t, "0.50", got.Fee)
+		return nil
+	}))
+
+	// The balance must cover the amount and the fee
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(89, 0),
+		Fee:    apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNegativeBalance, err)
+
+	err = r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(1, 0),
+		Fee:    apd.New(0, 0),
+	})
+	require.Error(t, err)
+
+	requireBalance(t, ctx, r, fromID, "89.50")
+}
+
+func testBalanceDrifts(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(100, 0))
+	require.NoError(t, r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(3033, -2),
+	}))
+
+	// The stored balances are kept equal to the sum of the payments
+	drifts, err := r.Accounts.BalanceDrifts(ctx)
+	require.NoError(t, err)
+	require.Empty(t, drifts)
+}
+
+func testPaymentList(t *testing.T, ctx context.Context, r Repositories) {
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	ids := make(map[uuid.UUID]struct{})
+	for i := 0; i < 5; i++ {
+		ids[deposit(t, ctx, r, toID, apd.New(int64(i+1), 0)).ID] = struct{}{}
+	}
+
+	var listed []wallet.Payment
+	page := wallet.Page{Limit: 2}
+	for i := 0; ; i++ {
+		require.True(t, i < 5, "too many pages")
+
+		payments, next, err := r.Payments.List(ctx, page)
+		require.NoError(t, err)
+		require.True(t, len(payments) <= page.Limit)
+		listed = append(listed, payments...)
+
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	require.Len(t, listed, len(ids))
+	for i, p := range listed {
+		require.Contains(t, ids, p.ID)
+		if i > 0 {
+			// Oldest first
+			require.False(t, p.CreatedAt.Before(listed[i-1].CreatedAt))
+		}
+	}
+
+	_, _, err := r.Payments.List(ctx, wallet.Page{Limit: 2, Cursor: "foo"})
+	require.Equal(t, wallet.ErrInvalidCursor, err)
+}
+
+func testAccountPayments(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	credit := deposit(t, ctx, r, fromID, apd.New(100, 0))
+	debit := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(3033, -2),
+	}
+	require.NoError(t, r.Payments.Store(ctx, debit))
+
+	payments, next, err := r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, payments, 2)
+
+	// Newest first, with the balance after each payment
+	require.Equal(t, debit.ID, payments[0].PaymentID)
+	require.Equal(t, fromID, payments[0].AccountID)
+	require.NotNil(t, payments[0].Counterparty)
+	require.Equal(t, toID, *payments[0].Counterparty)
+	requireDecimal(t, "-30.33", payments[0].Amount)
+	requireDecimal(t, "69.67", payments[0].Balance)
+
+	require.Equal(t, credit.ID, payments[1].PaymentID)
+	require.Nil(t, payments[1].Counterparty)
+	requireDecimal(t, "100.00", payments[1].Amount)
+	requireDecimal(t, "100.00", payments[1].Balance)
+
+	// The balance includes payments outside of the page
+	payments, next, err = r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 1})
+	require.NoError(t, err)
+	require.NotEmpty(t, next)
+	require.Len(t, payments, 1)
+	require.Equal(t, debit.ID, payments[0].PaymentID)
+
+	payments, next, err = r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Empty(t, next)
+	require.Len(t, payments, 1)
+	require.Equal(t, credit.ID, payments[0].PaymentID)
+	requireDecimal(t, "100.00", payments[0].Balance)
+
+	// Time range filters
+	payments, _, err = r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{
+		Since: debit.CreatedAt.Add(time.Microsecond),
+	}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, payments)
+
+	payments, _, err = r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{
+		Until: credit.CreatedAt,
+	}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, payments)
+
+	payments, _, err = r.Payments.ListAccountPayments(ctx, toID, wallet.TimeRange{}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	require.NotNil(t, payments[0].Counterparty)
+	require.Equal(t, fromID, *payments[0].Counterparty)
+	requireDecimal(t, "30.33", payments[0].Amount)
+	requireDecimal(t, "30.33", payments[0].Balance)
+
+	_, _, err = r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 1, Cursor: "foo"})
+	require.Equal(t, wallet.ErrInvalidCursor, err)
+}
+
+func testAccountPaymentsFee(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	deposit(t, ctx, r, fromID, apd.New(10, 0))
+	debit := &wallet.Payment{
+		ID:     newID(t),
+		To:     toID,
+		From:   &fromID,
+		Amount: apd.New(5, 0),
+		Fee:    apd.New(1, 0),
+	}
+	require.NoError(t, r.Payments.Store(ctx, debit))
+
+	// The debit includes the fee, so the balance after the last payment is the account's balance
+	payments, _, err := r.Payments.ListAccountPayments(ctx, fromID, wallet.TimeRange{}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, payments, 2)
+	require.Equal(t, debit.ID, payments[0].PaymentID)
+	requireDecimal(t, "-6.00", payments[0].Amount)
+	requireDecimal(t, "1.00", payments[0].Fee)
+	requireDecimal(t, "4.00", payments[0].Balance)
+	require.Nil(t, payments[1].Fee)
+
+	a, err := r.Accounts.Get(ctx, fromID)
+	require.NoError(t, err)
+	requireDecimal(t, a.Balance.String(), payments[0].Balance)
+
+	// The receiver is credited the amount without the fee
+	payments, _, err = r.Payments.ListAccountPayments(ctx, toID, wallet.TimeRange{}, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, payments, 1)
+	requireDecimal(t, "5.00", payments[0].Amount)
+	require.Nil(t, payments[0].Fee)
+	requireBalance(t, ctx, r, toID, payments[0].Balance.String())
+}
+
+func testTxRollback(t *testing.T, ctx context.Context, r Repositories) {
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	errFailed := errors.New("failed")
+	p := &wallet.Payment{
+		ID:             newID(t),
+		To:             toID,
+		Amount:         apd.New(1, 0),
+		IdempotencyKey: "abc",
+	}
+	var externalID uuid.UUID
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		var err error
+		externalID, err = r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, wallet.USD)
+		require.NoError(t, err)
+
+		require.NoError(t, r.Payments.StoreTx(ctx, tx, p))
+
+		// The transaction sees its own changes
+		a, err := r.Accounts.GetTx(ctx, tx, toID)
+		require.NoError(t, err)
+		requireDecimal(t, "1.00", a.Balance)
+
+		return errFailed
+	})
+	require.Equal(t, errFailed, err)
+
+	// Nothing was stored
+	requireBalance(t, ctx, r, toID, "0.00")
+	_, err = r.Accounts.Get(ctx, externalID)
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	payments, _, err := r.Payments.List(ctx, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, payments)
+
+	// The idempotency key can be used again.
+	// The postings were set to those of the rolled back external account.
+	p.ID = newID(t)
+	p.Postings = nil
+	require.NoError(t, r.Payments.Store(ctx, p))
+	requireBalance(t, ctx, r, toID, "1.00")
+}
+
+func testRates(t *testing.T, ctx context.Context, r Repositories) {
+	_, err := r.Rates.Rate(ctx, wallet.SGD, wallet.USD)
+	require.Equal(t, wallet.ErrNoExchangeRate, err)
+
+	rate := &wallet.ExchangeRate{
+		From: wallet.SGD,
+		To:   wallet.USD,
+		Rate: apd.New(7345, -4),
+	}
+	require.NoError(t, r.Rates.Store(ctx, rate))
+	require.False(t, rate.UpdatedAt.IsZero())
+
+	got, err := r.Rates.Rate(ctx, wallet.SGD, wallet.USD)
+	require.NoError(t, err)
+	requireDecimal(t, "0.7345", got)
+
+	// Rates are directional
+	_, err = r.Rates.Rate(ctx, wallet.USD, wallet.SGD)
+	require.Equal(t, wallet.ErrNoExchangeRate, err)
+
+	// Storing replaces the rate
+	require.NoError(t, r.Rates.Store(ctx, &wallet.ExchangeRate{
+		From: wallet.SGD,
+		To:   wallet.USD,
+		Rate: apd.New(74, -2),
+	}))
+	require.NoError(t, r.Rates.Store(ctx, &wallet.ExchangeRate{
+		From: wallet.EUR,
+		To:   wallet.USD,
+		Rate: apd.New(11, -1),
+	}))
+
+	rates, err := r.Rates.All(ctx)
+	require.NoError(t, err)
+	require.Len(t, rates, 2)
+	require.Equal(t, wallet.EUR, rates[0].From)
+	requireDecimal(t, "1.1", rates[0].Rate)
+	require.Equal(t, wallet.SGD, rates[1].From)
+	requireDecimal(t, "0.74", rates[1].Rate)
+
+	err = r.Rates.Store(ctx, &wallet.ExchangeRate{
+		From: "XYZ",
+		To:   wallet.USD,
+		Rate: apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	err = r.Rates.Store(ctx, &wallet.ExchangeRate{
+		From: wallet.USD,
+		To:   wallet.EUR,
+		Rate: apd.New(0, 0),
+	})
+	require.Error(t, err)
+}
+
+func testCurrencies(t *testing.T, ctx context.Context, r Repositories) {
+	c, err := r.Currencies.Get(ctx, wallet.USD)
+	require.NoError(t, err)
+	require.Equal(t, wallet.Currency{Code: wallet.USD, Exponent: 2, Enabled: true}, *c)
+
+	c, err = r.Currencies.Get(ctx, wallet.JPY)
+	require.NoError(t, err)
+	require.Equal(t, wallet.Currency{Code: wallet.JPY, Exponent: 0, Enabled: false}, *c)
+
+	_, err = r.Currencies.Get(ctx, "CHF")
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	require.NoError(t, r.Currencies.Store(ctx, &wallet.Currency{Code: "CHF", Exponent: 2, Enabled: true}))
+	require.NoError(t, r.Currencies.Store(ctx, &wallet.Currency{Code: wallet.JPY, Exponent: 0, Enabled: true}))
+
+	c, err = r.Currencies.Get(ctx, wallet.JPY)
+	require.NoError(t, err)
+	require.True(t, c.Enabled)
+
+	err = r.Currencies.Store(ctx, &wallet.Currency{Code: wallet.USD, Exponent: 3, Enabled: true})
+	require.Equal(t, wallet.ErrCurrencyExponentChanged, err)
+
+	err = r.Currencies.Store(ctx, &wallet.Currency{Code: "usd", Exponent: 2, Enabled: true})
+	require.Error(t, err)
+
+	err = r.Currencies.Store(ctx, &wallet.Currency{Code: "ABC", Exponent: 5, Enabled: true})
+	require.Error(t, err)
+
+	currencies, err := r.Currencies.All(ctx)
+	require.NoError(t, err)
+	var codes []string
+	for _, c := range currencies {
+		codes = append(codes, c.Code)
+	}
+	require.Equal(t, []string{"CHF", "EUR", "GBP", "JPY", "KWD", "SGD", "USD"}, codes)
+}
+
+func testTxContext(t *testing.T, ctx context.Context, r Repositories) {
+	var id uuid.UUID
+	err := r.UnitOfWork.Do(ctx, func(txCtx context.Context, tx wallet.Tx) error {
+		require.Equal(t, tx, wallet.TxFromContext(txCtx))
+
+		// Methods without a Tx parameter use the transaction of the context
+		id = newAccount(t, txCtx, r, wallet.USD)
+		deposit(t, txCtx, r, id, apd.New(1, 0))
+		requireBalance(t, txCtx, r, id, "1.00")
+
+		a, err := r.Accounts.GetTx(txCtx, tx, id)
+		require.NoError(t, err)
+		requireDecimal(t, "1.00", a.Balance)
+
+		// Nothing is visible outside of the transaction until it commits
+		_, err = r.Accounts.Get(ctx, id)
+		require.Equal(t, wallet.ErrNoAccount, err)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	requireBalance(t, ctx, r, id, "1.00")
+}
+
+func testTxSavepoint(t *testing.T, ctx context.Context, r Repositories) {
+	errFailed := errors.New("failed")
+
+	var outerID, failedID, nestedID uuid.UUID
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		outerID = newAccount(t, ctx, r, wallet.USD)
+
+		// A nested call that fails only rolls back its own changes
+		err := r.UnitOfWork.Do(ctx, func(ctx context.Context, nestedTx wallet.Tx) error {
+			require.Equal(t, tx, nestedTx)
+			failedID = newAccount(t, ctx, r, wallet.USD)
+			deposit(t, ctx, r, outerID, apd.New(1, 0))
+			return errFailed
+		})
+		require.Equal(t, errFailed, err)
+
+		// The transaction can be used after a storage error in a nested call
+		err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			_, err := r.Accounts.SystemAccountIDTx(ctx, tx, wallet.AccountKindExternal, "XYZ")
+			return err
+		})
+		require.Equal(t, wallet.ErrNoCurrency, err)
+
+		err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			nestedID = newAccount(t, ctx, r, wallet.USD)
+			deposit(t, ctx, r, outerID, apd.New(2, 0))
+			return nil
+		})
+		require.NoError(t, err)
+
+		return nil
+	})
+	require.NoError(t, err)
+
+	requireBalance(t, ctx, r, outerID, "2.00")
+	requireBalance(t, ctx, r, nestedID, "0.00")
+	_, err = r.Accounts.Get(ctx, failedID)
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	// A nested call that succeeds is rolled back with the transaction
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+			deposit(t, ctx, r, outerID, apd.New(3, 0))
+			return nil
+		})
+		require.NoError(t, err)
+		return errFailed
+	})
+	require.Equal(t, errFailed, err)
+
+	requireBalance(t, ctx, r, outerID, "2.00")
+}
+
+func testFeeSchedules(t *testing.T, ctx context.Context, r Repositories) {
+	_, err := r.Fees.Schedule(ctx, wallet.USD)
+	require.Equal(t, wallet.ErrNoFeeSchedule, err)
+
+	// Flat and Percent default to 0
+	f := &wallet.FeeSchedule{
+		Currency: wallet.USD,
+		Flat:     apd.New(30, -2),
+	}
+	require.NoError(t, r.Fees.Store(ctx, f))
+	require.False(t, f.UpdatedAt.IsZero())
+	requireDecimal(t, "0", f.Percent)
+
+	got, err := r.Fees.Schedule(ctx, wallet.USD)
+	require.NoError(t, err)
+	require.Equal(t, wallet.USD, got.Currency)
+	requireDecimal(t, "0.30", got.Flat)
+	requireDecimal(t, "0", got.Percent)
+	require.Nil(t, got.Min)
+	require.Nil(t, got.Max)
+
+	// Storing replaces the schedule
+	require.NoError(t, r.Fees.Store(ctx, &wallet.FeeSchedule{
+		Currency: wallet.USD,
+		Percent:  apd.New(25, -1),
+		Min:      apd.New(1, 0),
+		Max:      apd.New(10, 0),
+	}))
+	require.NoError(t, r.Fees.Store(ctx, &wallet.FeeSchedule{
+		Currency: wallet.EUR,
+		Flat:     apd.New(1, 0),
+	}))
+
+	schedules, err := r.Fees.All(ctx)
+	require.NoError(t, err)
+	require.Len(t, schedules, 2)
+	require.Equal(t, wallet.EUR, schedules[0].Currency)
+	requireDecimal(t, "1", schedules[0].Flat)
+	require.Equal(t, wallet.USD, schedules[1].Currency)
+	requireDecimal(t, "0", schedules[1].Flat)
+	requireDecimal(t, "2.5", schedules[1].Percent)
+	requireDecimal(t, "1", schedules[1].Min)
+	requireDecimal(t, "10", schedules[1].Max)
+
+	err = r.Fees.Store(ctx, &wallet.FeeSchedule{
+		Currency: "XYZ",
+		Flat:     apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	for _, f := range []wallet.FeeSchedule{
+		{Currency: wallet.USD, Flat: apd.New(-1, 0)},
+		{Currency: wallet.USD, Percent: apd.New(101, 0)},
+		{Currency: wallet.USD, Min: apd.New(2, 0), Max: apd.New(1, 0)},
+	} {
+		err = r.Fees.Store(ctx, &f)
+		require.Error(t, err)
+	}
+}
+
+func testScheduledTransfers(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+	b := newAccount(t, ctx, r, wallet.USD)
+
+	now := time.Now().UTC()
+	store := func(executeAt time.Time) *wallet.ScheduledTransfer {
+		st := &wallet.ScheduledTransfer{
+			ID:        newID(t),
+			To:        b,
+			From:      a,
+			Amount:    apd.New(150, -2),
+			ExecuteAt: executeAt,
+		}
+		require.NoError(t, r.ScheduledTransfers.Store(ctx, st))
+		return st
+	}
+
+	// Stored transfers are pending
+	later := store(now.Add(time.Hour))
+	require.Equal(t, wallet.ScheduledTransferPending, later.Status)
+	require.Nil(t, later.PaymentID)
+	require.Empty(t, later.Error)
+	require.False(t, later.CreatedAt.IsZero())
+	require.False(t, later.UpdatedAt.IsZero())
+	requireDecimal(t, "1.50", later.Amount)
+
+	err := r.ScheduledTransfers.Store(ctx, &wallet.ScheduledTransfer{
+		ID:        newID(t),
+		To:        newID(t),
+		From:      a,
+		Amount:    apd.New(1, 0),
+		ExecuteAt: now,
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	// Nothing is due yet
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		_, err := r.ScheduledTransfers.NextDueTx(ctx, tx)
+		require.Equal(t, wallet.ErrNoScheduledTransfer, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The earliest due transfer is returned first
+	due2 := store(now.Add(-time.Minute))
+	due1 := store(now.Add(-time.Hour))
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		st, err := r.ScheduledTransfers.NextDueTx(ctx, tx)
+		require.NoError(t, err)
+		require.Equal(t, due1.ID, st.ID)
+
+		// Executed transfers are no longer due
+		paymentID := deposit(t, ctx, r, a, apd.New(10, 0)).ID
+		st.Status = wallet.ScheduledTransferExecuted
+		st.PaymentID = &paymentID
+		require.NoError(t, r.ScheduledTransfers.UpdateTx(ctx, tx, st))
+
+		st, err = r.ScheduledTransfers.NextDueTx(ctx, tx)
+		require.NoError(t, err)
+		require.Equal(t, due2.ID, st.ID)
+
+		st.Status = wallet.ScheduledTransferFailed
+		st.Error = "Account has an insufficient balance"
+		require.NoError(t, r.ScheduledTransfers.UpdateTx(ctx, tx, st))
+
+		_, err = r.ScheduledTransfers.NextDueTx(ctx, tx)
+		require.Equal(t, wallet.ErrNoScheduledTransfer, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		st, err := r.ScheduledTransfers.GetTx(ctx, tx, due1.ID)
+		require.NoError(t, err)
+		require.Equal(t, wallet.ScheduledTransferExecuted, st.Status)
+		require.NotNil(t, st.PaymentID)
+		require.Empty(t, st.Error)
+
+		st, err = r.ScheduledTransfers.GetTx(ctx, tx, due2.ID)
+		require.NoError(t, err)
+		require.Equal(t, wallet.ScheduledTransferFailed, st.Status)
+		require.Nil(t, st.PaymentID)
+		require.Equal(t, "Account has an insufficient balance", st.Error)
+
+		_, err = r.ScheduledTransfers.GetTx(ctx, tx, newID(t))
+		require.Equal(t, wallet.ErrNoScheduledTransfer, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Transfers are listed by execution time, and can be filtered by status
+	transfers, next, err := r.ScheduledTransfers.List(ctx, "", wallet.Page{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, transfers, 2)
+	require.NotEmpty(t, next)
+	require.Equal(t, due1.ID, transfers[0].ID)
+	require.Equal(t, due2.ID, transfers[1].ID)
+
+	transfers, next, err = r.ScheduledTransfers.List(ctx, "", wallet.Page{Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	require.Empty(t, next)
+	require.Equal(t, later.ID, transfers[0].ID)
+	require.True(t, later.ExecuteAt.Equal(transfers[0].ExecuteAt))
+
+	transfers, _, err = r.ScheduledTransfers.List(ctx, wallet.ScheduledTransferPending, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, transfers, 1)
+	require.Equal(t, later.ID, transfers[0].ID)
+}
+
+func testStandingOrders(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+	b := newAccount(t, ctx, r, wallet.USD)
+
+	now := time.Now().UTC()
+	store := func(nextRunAt time.Time) *wallet.StandingOrder {
+		o := &wallet.StandingOrder{
+			ID:        newID(t),
+			To:        b,
+			From:      a,
+			Amount:    apd.New(2500, -2),
+			Frequency: wallet.FrequencyWeekly,
+			Interval:  1,
+			StartAt:   nextRunAt,
+			NextRunAt: nextRunAt,
+		}
+		require.NoError(t, r.StandingOrders.Store(ctx, o))
+		return o
+	}
+
+	// Stored orders are active
+	later := store(now.Add(time.Hour))
+	require.Equal(t, wallet.StandingOrderActive, later.Status)
+	require.Equal(t, wallet.FrequencyWeekly, later.Frequency)
+	require.Equal(t, 1, later.Interval)
+	require.Nil(t, later.EndAt)
+	require.Zero(t, later.Occurrences)
+	require.Zero(t, later.FailedAttempts)
+	require.False(t, later.CreatedAt.IsZero())
+	require.False(t, later.UpdatedAt.IsZero())
+	requireDecimal(t, "25.00", later.Amount)
+
+	o, err := r.StandingOrders.Get(ctx, later.ID)
+	require.NoError(t, err)
+	require.Equal(t, later.ID, o.ID)
+	require.True(t, later.StartAt.Equal(o.StartAt))
+	require.True(t, later.NextRunAt.Equal(o.NextRunAt))
+
+	_, err = r.StandingOrders.Get(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoStandingOrder, err)
+
+	err = r.StandingOrders.Store(ctx, &wallet.StandingOrder{
+		ID:        newID(t),
+		To:        b,
+		From:      newID(t),
+		Amount:    apd.New(1, 0),
+		Frequency: wallet.FrequencyDaily,
+		Interval:  1,
+		StartAt:   now,
+		NextRunAt: now,
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	// Nothing is due yet
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		_, err := r.StandingOrders.NextDueTx(ctx, tx)
+		require.Equal(t, wallet.ErrNoStandingOrder, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// The order with the earliest next run is returned first
+	due2 := store(now.Add(-time.Minute))
+	due1 := store(now.Add(-time.Hour))
+
+	endAt := now.Add(30 * 24 * time.Hour)
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		o, err := r.StandingOrders.NextDueTx(ctx, tx)
+		require.NoError(t, err)
+		require.Equal(t, due1.ID, o.ID)
+
+		// Orders that are run later are no longer due
+		o.Occurrences = 1
+		o.NextRunAt = o.OccurrenceAt(1)
+		o.Amount = apd.New(3000, -2)
+		o.EndAt = &endAt
+		require.NoError(t, r.StandingOrders.UpdateTx(ctx, tx, o))
+		requireDecimal(t, "30.00", o.Amount)
+
+		o, err = r.StandingOrders.NextDueTx(ctx, tx)
+		require.NoError(t, err)
+		require.Equal(t, due2.ID, o.ID)
+
+		// Canceled orders are not due
+		o.Status = wallet.StandingOrderCanceled
+		require.NoError(t, r.StandingOrders.UpdateTx(ctx, tx, o))
+
+		_, err = r.StandingOrders.NextDueTx(ctx, tx)
+		require.Equal(t, wallet.ErrNoStandingOrder, err)
+
+		_, err = r.StandingOrders.GetTx(ctx, tx, newID(t))
+		require.Equal(t, wallet.ErrNoStandingOrder, err)
+		return nil
+	})
+	require.NoError(t, err)
+
+	o, err = r.StandingOrders.Get(ctx, due1.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderActive, o.Status)
+	require.Equal(t, 1, o.Occurrences)
+	require.True(t, due1.OccurrenceAt(1).Equal(o.NextRunAt))
+	require.NotNil(t, o.EndAt)
+	require.True(t, endAt.Truncate(time.Microsecond).Equal(*o.EndAt))
+	requireDecimal(t, "30.00", o.Amount)
+
+	o, err = r.StandingOrders.Get(ctx, due2.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.StandingOrderCanceled, o.Status)
+
+	// Orders are listed by creation time
+	orders, next, err := r.StandingOrders.List(ctx, wallet.Page{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, orders, 2)
+	require.NotEmpty(t, next)
+	require.Equal(t, later.ID, orders[0].ID)
+	require.Equal(t, due2.ID, orders[1].ID)
+
+	orders, next, err = r.StandingOrders.List(ctx, wallet.Page{Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, orders, 1)
+	require.Empty(t, next)
+	require.Equal(t, due1.ID, orders[0].ID)
+}
+
+func testStandingOrderOccurrences(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+	b := newAccount(t, ctx, r, wallet.USD)
+
+	startAt := time.Now().UTC().Add(-time.Hour)
+	order := &wallet.StandingOrder{
+		ID:        newID(t),
+		To:        b,
+		From:      a,
+		Amount:    apd.New(10, 0),
+		Frequency: wallet.FrequencyDaily,
+		Interval:  1,
+		StartAt:   startAt,
+		NextRunAt: startAt,
+	}
+	require.NoError(t, r.StandingOrders.Store(ctx, order))
+
+	first := &wallet.StandingOrderOccurrence{
+		ID:              newID(t),
+		StandingOrderID: order.ID,
+		ScheduledAt:     order.OccurrenceAt(0),
+		Status:          wallet.OccurrenceRetrying,
+		Attempts:        1,
+		Error:           "Account has an insufficient balance",
+	}
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		require.NoError(t, r.StandingOrders.StoreOccurrenceTx(ctx, tx, first))
+		require.Equal(t, wallet.OccurrenceRetrying, first.Status)
+		require.Nil(t, first.PaymentID)
+		require.False(t, first.CreatedAt.IsZero())
+		return nil
+	})
+	require.NoError(t, err)
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return r.StandingOrders.StoreOccurrenceTx(ctx, tx, &wallet.StandingOrderOccurrence{
+			ID:              newID(t),
+			StandingOrderID: newID(t),
+			ScheduledAt:     startAt,
+			Status:          wallet.OccurrenceFailed,
+			Attempts:        1,
+		})
+	})
+	require.Equal(t, wallet.ErrNoStandingOrder, err)
+
+	// Storing an occurrence with the same time again updates it
+	paymentID := deposit(t, ctx, r, a, apd.New(10, 0)).ID
+	retried := &wallet.StandingOrderOccurrence{
+		ID:              newID(t),
+		StandingOrderID: order.ID,
+		ScheduledAt:     order.OccurrenceAt(0),
+		Status:          wallet.OccurrenceExecuted,
+		Attempts:        2,
+		PaymentID:       &paymentID,
+	}
+	second := &wallet.StandingOrderOccurrence{
+		ID:              newID(t),
+		StandingOrderID: order.ID,
+		ScheduledAt:     order.OccurrenceAt(1),
+		Status:          wallet.OccurrenceFailed,
+		Attempts:        3,
+		Error:           "Account has an insufficient balance",
+	}
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		require.NoError(t, r.StandingOrders.StoreOccurrenceTx(ctx, tx, retried))
+		require.NoError(t, r.StandingOrders.StoreOccurrenceTx(ctx, tx, second))
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, first.ID, retried.ID)
+	require.True(t, first.CreatedAt.Equal(retried.CreatedAt))
+	require.Empty(t, retried.Error)
+
+	// Occurrences are listed by their scheduled time
+	occurrences, next, err := r.StandingOrders.Occurrences(ctx, order.ID, wallet.Page{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1)
+	require.NotEmpty(t, next)
+	require.Equal(t, first.ID, occurrences[0].ID)
+	require.Equal(t, wallet.OccurrenceExecuted, occurrences[0].Status)
+	require.Equal(t, 2, occurrences[0].Attempts)
+	require.NotNil(t, occurrences[0].PaymentID)
+	require.Equal(t, paymentID, *occurrences[0].PaymentID)
+
+	occurrences, next, err = r.StandingOrders.Occurrences(ctx, order.ID, wallet.Page{Limit: 1, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, occurrences, 1)
+	require.Empty(t, next)
+	require.Equal(t, second.ID, occurrences[0].ID)
+	require.Equal(t, wallet.OccurrenceFailed, occurrences[0].Status)
+	require.Equal(t, "Account has an insufficient balance", occurrences[0].Error)
+
+	occurrences, _, err = r.StandingOrders.Occurrences(ctx, newID(t), wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, occurrences)
+}
+
+func requireHeld(t *testing.T, ctx context.Context, r Repositories, id uuid.UUID, held, available string) {
+	a, err := r.Accounts.Get(ctx, id)
+	require.NoError(t, err)
+	requireDecimal(t, held, a.Held)
+	d, err := a.Available()
+	require.NoError(t, err)
+	requireDecimal(t, available, d)
+}
+
+func testHolds(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+	p := deposit(t, ctx, r, a, apd.New(1000, -2))
+	requireHeld(t, ctx, r, a, "0", "10.00")
+
+	expiresAt := time.Now().UTC().Add(time.Hour)
+	first := &wallet.Hold{
+		ID:        newID(t),
+		From:      a,
+		Amount:    apd.New(500, -2),
+		ExpiresAt: expiresAt,
+	}
+	second := &wallet.Hold{
+		ID:        newID(t),
+		From:      a,
+		Amount:    apd.New(250, -2),
+		ExpiresAt: expiresAt,
+	}
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		for _, h := range []*wallet.Hold{first, second} {
+			require.NoError(t, r.Holds.StoreTx(ctx, tx, h))
+		}
+
+		// Holds stored in the transaction are held from the account
+		account, err := r.Accounts.GetTx(ctx, tx, a)
+		require.NoError(t, err)
+		requireDecimal(t, "7.50", account.Held)
+		return nil
+	})
+	require.NoError(t, err)
+
+	// Stored holds are active
+	require.Equal(t, wallet.HoldActive, first.Status)
+	require.Nil(t, first.PaymentID)
+	require.True(t, expiresAt.Truncate(time.Millisecond).Equal(first.ExpiresAt.Truncate(time.Millisecond)))
+	require.False(t, first.CreatedAt.IsZero())
+	require.False(t, first.UpdatedAt.IsZero())
+	requireDecimal(t, "5.00", first.Amount)
+	requireHeld(t, ctx, r, a, "7.50", "2.50")
+	requireBalance(t, ctx, r, a, "10.00")
+
+	accounts, _, err := r.Accounts.List(ctx, wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, accounts, 1)
+	requireDecimal(t, "7.50", accounts[0].Held)
+
+	h, err := r.Holds.Get(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, first.ID, h.ID)
+	require.Equal(t, a, h.From)
+	require.Equal(t, wallet.HoldActive, h.Status)
+
+	_, err = r.Holds.Get(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoHold, err)
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		return r.Holds.StoreTx(ctx, tx, &wallet.Hold{
+			ID:        newID(t),
+			From:      newID(t),
+			Amount:    apd.New(1, 0),
+			ExpiresAt: expiresAt,
+		})
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	// Captured and released holds are no longer held
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		h, err := r.Holds.GetTx(ctx, tx, first.ID)
+		require.NoError(t, err)
+		h.Status = wallet.HoldCaptured
+		h.PaymentID = &p.ID
+		require.NoError(t, r.Holds.UpdateTx(ctx, tx, h))
+		require.Equal(t, wallet.HoldCaptured, h.Status)
+		require.Equal(t, p.ID, *h.PaymentID)
+
+		_, err = r.Holds.GetTx(ctx, tx, newID(t))
+		require.Equal(t, wallet.ErrNoHold, err)
+		return nil
+	})
+	require.NoError(t, err)
+	requireHeld(t, ctx, r, a, "2.50", "7.50")
+
+	err = r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		second.Status = wallet.HoldReleased
+		return r.Holds.UpdateTx(ctx, tx, second)
+	})
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldReleased, second.Status)
+	require.Nil(t, second.PaymentID)
+	requireHeld(t, ctx, r, a, "0", "10.00")
+
+	h, err = r.Holds.Get(ctx, first.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldCaptured, h.Status)
+	require.NotNil(t, h.PaymentID)
+	require.Equal(t, p.ID, *h.PaymentID)
+}
+
+func testHoldExpiry(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, a, apd.New(1000, -2))
+
+	now := time.Now().UTC()
+	expired := &wallet.Hold{
+		ID:        newID(t),
+		From:      a,
+		Amount:    apd.New(300, -2),
+		ExpiresAt: now.Add(-time.Minute),
+	}
+	active := &wallet.Hold{
+		ID:        newID(t),
+		From:      a,
+		Amount:    apd.New(400, -2),
+		ExpiresAt: now.Add(time.Hour),
+	}
+	err := r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		for _, h := range []*wallet.Hold{expired, active} {
+			require.NoError(t, r.Holds.StoreTx(ctx, tx, h))
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	requireHeld(t, ctx, r, a, "7.00", "3.00")
+
+	// Only active holds that have expired are expired
+	n, err := r.Holds.Expire(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, n)
+	requireHeld(t, ctx, r, a, "4.00", "6.00")
+
+	h, err := r.Holds.Get(ctx, expired.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldExpired, h.Status)
+
+	h, err = r.Holds.Get(ctx, active.ID)
+	require.NoError(t, err)
+	require.Equal(t, wallet.HoldActive, h.Status)
+
+	n, err = r.Holds.Expire(ctx)
+	require.NoError(t, err)
+	require.Zero(t, n)
+}
+
+func testSpendingLimits(t *testing.T, ctx context.Context, r Repositories) {
+	a := newAccount(t, ctx, r, wallet.USD)
+
+	_, err := r.Limits.Limit(ctx, a, wallet.USD)
+	require.Equal(t, wallet.ErrNoSpendingLimit, err)
+
+	// The currency's limit applies to accounts without their own limit
+	hourlyCount := 5
+	currencyLimit := &wallet.SpendingLimit{
+		Currency:    wallet.USD,
+		MaxAmount:   apd.New(10000, -2),
+		HourlyCount: &hourlyCount,
+	}
+	require.NoError(t, r.Limits.Store(ctx, currencyLimit))
+	require.False(t, currencyLimit.UpdatedAt.IsZero())
+
+	l, err := r.Limits.Limit(ctx, a, wallet.USD)
+	require.NoError(t, err)
+	require.Nil(t, l.AccountID)
+	require.Equal(t, wallet.USD, l.Currency)
+	requireDecimal(t, "100.00", l.MaxAmount)
+	require.Nil(t, l.DailyAmount)
+	require.Equal(t, 5, *l.HourlyCount)
+
+	// The account's limit replaces the currency's limit, and has the account's currency
+	accountLimit := &wallet.SpendingLimit{
+		AccountID:   &a,
+		DailyAmount: apd.New(50000, -2),
+	}
+	require.NoError(t, r.Limits.Store(ctx, accountLimit))
+	require.Equal(t, wallet.USD, accountLimit.Currency)
+
+	l, err = r.Limits.Limit(ctx, a, wallet.USD)
+	require.NoError(t, err)
+	require.Equal(t, a, *l.AccountID)
+	require.Nil(t, l.MaxAmount)
+	requireDecimal(t, "500.00", l.DailyAmount)
+	require.Nil(t, l.HourlyCount)
+
+	// Storing replaces the limit
+	accountLimit.DailyAmount = apd.New(20000, -2)
+	require.NoError(t, r.Limits.Store(ctx, accountLimit))
+	require.NoError(t, r.Limits.Store(ctx, &wallet.SpendingLimit{
+		Currency:  wallet.EUR,
+		MaxAmount: apd.New(1000, -2),
+	}))
+
+	limits, err := r.Limits.All(ctx)
+	require.NoError(t, err)
+	require.Len(t, limits, 3)
+	require.Equal(t, wallet.EUR, limits[0].Currency)
+	require.Nil(t, limits[0].AccountID)
+	require.Equal(t, wallet.USD, limits[1].Currency)
+	require.Nil(t, limits[1].AccountID)
+	require.Equal(t, a, *limits[2].AccountID)
+	requireDecimal(t, "200.00", limits[2].DailyAmount)
+
+	err = r.Limits.Store(ctx, &wallet.SpendingLimit{
+		Currency:  "XYZ",
+		MaxAmount: apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoCurrency, err)
+
+	unknown := newID(t)
+	err = r.Limits.Store(ctx, &wallet.SpendingLimit{
+		AccountID: &unknown,
+		MaxAmount: apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	// System accounts have no limits
+	external := systemAccountID(t, ctx, r, wallet.AccountKindExternal, wallet.USD)
+	err = r.Limits.Store(ctx, &wallet.SpendingLimit{
+		AccountID: &external,
+		MaxAmount: apd.New(1, 0),
+	})
+	require.Equal(t, wallet.ErrNoAccount, err)
+
+	zero := 0
+	for _, l := range []wallet.SpendingLimit{
+		{Currency: wallet.USD, MaxAmount: apd.New(0, 0)},
+		{Currency: wallet.USD, DailyAmount: apd.New(-1, 0)},
+		{Currency: wallet.USD, HourlyCount: &zero},
+	} {
+		err = r.Limits.Store(ctx, &l)
+		require.Error(t, err)
+	}
+}
+
+func testSpending(t *testing.T, ctx context.Context, r Repositories) {
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	deposit(t, ctx, r, fromID, apd.New(10000, -2))
+	since := time.Now().Add(-time.Minute)
+
+	s, err := r.Limits.Spending(ctx, fromID, since)
+	require.NoError(t, err)
+	requireDecimal(t, "0", s.Amount)
+	require.Zero(t, s.Count)
+
+	var transfers []*wallet.Payment
+	for _, amount := range []*apd.Decimal{apd.New(1000, -2), apd.New(525, -2)} {
+		p := &wallet.Payment{
+			ID:     newID(t),
+			To:     toID,
+			From:   &fromID,
+			Amount: amount,
+		}
+		require.NoError(t, r.Payments.Store(ctx, p))
+		transfers = append(transfers, p)
+	}
+
+	// Withdrawals are included, refunds are not
+	external := systemAccountID(t, ctx, r, wallet.AccountKindExternal, wallet.USD)
+	require.NoError(t, r.Payments.Store(ctx, &wallet.Payment{
+		ID:     newID(t),
+		To:     external,
+		From:   &fromID,
+		Amount: apd.New(100, -2),
+	}))
+	require.NoError(t, r.Payments.Store(ctx, &wallet.Payment{
+		ID:         newID(t),
+		To:         fromID,
+		From:       &toID,
+		Amount:     apd.New(100, -2),
+		ReversalOf: &transfers[0].ID,
+	}))
+
+	s, err = r.Limits.Spending(ctx, fromID, since)
+	require.NoError(t, err)
+	requireDecimal(t, "16.25", s.Amount)
+	require.Equal(t, 3, s.Count)
+
+	// The receiving account has not spent anything
+	s, err = r.Limits.Spending(ctx, toID, since)
+	require.NoError(t, err)
+	require.Zero(t, s.Count)
+
+	// Payments before the time are not included
+	s, err = r.Limits.Spending(ctx, fromID, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	requireDecimal(t, "0", s.Amount)
+	require.Zero(t, s.Count)
+}
+
+func newCustomer(t *testing.T, ctx context.Context, r Repositories) uuid.UUID {
+	c := &wallet.Customer{
+		ID:   newID(t),
+		Name: "Alice",
+	}
+	require.NoError(t, r.Customers.Store(ctx, c))
+	return c.ID
+}
+
+func newOwnedAccount(t *testing.T, ctx context.Context, r Repositories, ownerID uuid.UUID, currency string) uuid.UUID {
+	a := &wallet.Account{
+		ID:       newID(t),
+		Currency: currency,
+		OwnerID:  &ownerID,
+	}
+	require.NoError(t, r.Accounts.Store(ctx, a))
+	return a.ID
+}
+
+func testCustomers(t *testing.T, ctx context.Context, r Repositories) {
+	c := &wallet.Customer{
+		ID:   newID(t),
+		Name: "Alice",
+	}
+	require.NoError(t, r.Customers.Store(ctx, c))
+	require.False(t, c.CreatedAt.IsZero())
+	require.Equal(t, time.UTC, c.CreatedAt.Location())
+
+	got, err := r.Customers.Get(ctx, c.ID)
+	require.NoError(t, err)
+	require.Equal(t, c.ID, got.ID)
+	require.Equal(t, "Alice", got.Name)
+	require.True(t, c.CreatedAt.Equal(got.CreatedAt))
+
+	err = r.Customers.Store(ctx, &wallet.Customer{
+		ID:   c.ID,
+		Name: "Bob",
+	})
+	require.Equal(t, wallet.ErrCustomerExists, err)
+
+	err = r.Customers.Store(ctx, &wallet.Customer{
+		Name: "Bob",
+	})
+	require.Error(t, err)
+
+	_, err = r.Customers.Get(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoCustomer, err)
+}
+
+func testAccountOwner(t *testing.T, ctx context.Context, r Repositories) {
+	ownerID := newCustomer(t, ctx, r)
+	otherID := newCustomer(t, ctx, r)
+
+	ids := make(map[uuid.UUID]struct{})
+	for i := 0; i < 3; i++ {
+		ids[newOwnedAccount(t, ctx, r, ownerID, wallet.USD)] = struct{}{}
+	}
+	ids[newOwnedAccount(t, ctx, r, ownerID, wallet.EUR)] = struct{}{}
+	newOwnedAccount(t, ctx, r, otherID, wallet.USD)
+	unowned := newAccount(t, ctx, r, wallet.USD)
+
+	a, err := r.Accounts.Get(ctx, unowned)
+	require.NoError(t, err)
+	require.Nil(t, a.OwnerID)
+
+	missingID := newID(t)
+	err = r.Accounts.Store(ctx, &wallet.Account{
+		ID:       newID(t),
+		Currency: wallet.USD,
+		OwnerID:  &missingID,
+	})
+	require.Equal(t, wallet.ErrNoCustomer, err)
+
+	var listed []wallet.Account
+	page := wallet.Page{Limit: 3}
+	for i := 0; ; i++ {
+		require.True(t, i < 3, "too many pages")
+
+		accounts, next, err := r.Accounts.ListByOwner(ctx, ownerID, page)
+		require.NoError(t, err)
+		require.True(t, len(accounts) <= page.Limit)
+		listed = append(listed, accounts...)
+
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	require.Len(t, listed, len(ids))
+	for i, a := range listed {
+		require.Contains(t, ids, a.ID)
+		require.NotNil(t, a.OwnerID)
+		require.Equal(t, ownerID, *a.OwnerID)
+		if i > 0 {
+			require.True(t, listed[i-1].ID.String() < a.ID.String())
+		}
+	}
+
+	accounts, next, err := r.Accounts.ListByOwner(ctx, newID(t), wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, accounts)
+	require.Empty(t, next)
+
+	_, _, err = r.Accounts.ListByOwner(ctx, ownerID, wallet.Page{Limit: 2, Cursor: "foo"})
+	require.Equal(t, wallet.ErrInvalidCursor, err)
+}
+
+func testCustomerBalances(t *testing.T, ctx context.Context, r Repositories) {
+	ownerID := newCustomer(t, ctx, r)
+
+	balances, err := r.Customers.Balances(ctx, ownerID)
+	require.NoError(t, err)
+	require.Empty(t, balances)
+
+	usd1 := newOwnedAccount(t, ctx, r, ownerID, wallet.USD)
+	usd2 := newOwnedAccount(t, ctx, r, ownerID, wallet.USD)
+	eur := newOwnedAccount(t, ctx, r, ownerID, wallet.EUR)
+	deposit(t, ctx, r, usd1, apd.New(1050, -2))
+	deposit(t, ctx, r, usd2, apd.New(225, -2))
+	deposit(t, ctx, r, eur, apd.New(300, -2))
+
+	// The accounts of other customers, and accounts without an owner, are not included
+	deposit(t, ctx, r, newOwnedAccount(t, ctx, r, newCustomer(t, ctx, r), wallet.USD), apd.New(100, 0))
+	deposit(t, ctx, r, newAccount(t, ctx, r, wallet.USD), apd.New(100, 0))
+
+	balances, err = r.Customers.Balances(ctx, ownerID)
+	require.NoError(t, err)
+	require.Len(t, balances, 2)
+	require.Equal(t, wallet.EUR, balances[0].Currency)
+	requireDecimal(t, "3.00", balances[0].Balance)
+	require.Equal(t, 1, balances[0].Accounts)
+	require.Equal(t, wallet.USD, balances[1].Currency)
+	requireDecimal(t, "12.75", balances[1].Balance)
+	require.Equal(t, 2, balances[1].Accounts)
+}
+
+func newAPIKey(t *testing.T, ctx context.Context, r Repositories, scopes ...string) (*wallet.APIKey, string) {
+	token, err := wallet.NewAPIKeyToken()
+	require.NoError(t, err)
+
+	key := &wallet.APIKey{
+		ID:     newID(t),
+		Name:   "test",
+		Scopes: scopes,
+	}
+	require.NoError(t, r.APIKeys.Store(ctx, key, wallet.HashAPIKeyToken(token)))
+	return key, token
+}
+
+func testAPIKeys(t *testing.T, ctx context.Context, r Repositories) {
+	key, token := newAPIKey(t, ctx, r, wallet.ScopeAccountsRead, wallet.ScopeTransferWrite)
+	require.False(t, key.CreatedAt.IsZero())
+	require.Equal(t, time.UTC, key.CreatedAt.Location())
+	require.Nil(t, key.RevokedAt)
+
+	got, err := r.APIKeys.GetByHash(ctx, wallet.HashAPIKeyToken(token))
+	require.NoError(t, err)
+	require.Equal(t, key.ID, got.ID)
+	require.Equal(t, "test", got.Name)
+	require.Equal(t, []string{wallet.ScopeAccountsRead, wallet.ScopeTransferWrite}, got.Scopes)
+	require.True(t, key.CreatedAt.Equal(got.CreatedAt))
+	require.Nil(t, got.RevokedAt)
+
+	// The ID and the hash are unique
+	require.Error(t, r.APIKeys.Store(ctx, &wallet.APIKey{
+		ID:     key.ID,
+		Name:   "test",
+		Scopes: []string{wallet.ScopeAccountsRead},
+	}, wallet.HashAPIKeyToken("other")))
+	require.Error(t, r.APIKeys.Store(ctx, &wallet.APIKey{
+		ID:     newID(t),
+		Name:   "test",
+		Scopes: []string{wallet.ScopeAccountsRead},
+	}, wallet.HashAPIKeyToken(token)))
+
+	_, err = r.APIKeys.GetByHash(ctx, wallet.HashAPIKeyToken("other"))
+	require.Equal(t, wallet.ErrNoAPIKey, err)
+
+	revoked, err := r.APIKeys.Revoke(ctx, key.ID)
+	require.NoError(t, err)
+	require.NotNil(t, revoked.RevokedAt)
+
+	// Revoked keys are returned, so that they can be told apart from unknown keys
+	got, err = r.APIKeys.GetByHash(ctx, wallet.HashAPIKeyToken(token))
+	require.NoError(t, err)
+	require.NotNil(t, got.RevokedAt)
+	require.True(t, revoked.RevokedAt.Equal(*got.RevokedAt))
+
+	_, err = r.APIKeys.Revoke(ctx, key.ID)
+	require.Equal(t, wallet.ErrAPIKeyRevoked, err)
+	_, err = r.APIKeys.Revoke(ctx, newID(t))
+	require.Equal(t, wallet.ErrNoAPIKey, err)
+}
+
+func testPaymentAPIKey(t *testing.T, ctx context.Context, r Repositories) {
+	key, _ := newAPIKey(t, ctx, r, wallet.ScopeTransferWrite)
+	toID := newAccount(t, ctx, r, wallet.USD)
+
+	p := &wallet.Payment{
+		ID:       newID(t),
+		To:       toID,
+		Amount:   apd.New(100, 0),
+		APIKeyID: &key.ID,
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	credit := deposit(t, ctx, r, toID, apd.New(100, 0))
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.Payments.GetTx(ctx, tx, p.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.APIKeyID)
+		require.Equal(t, key.ID, *got.APIKeyID)
+
+		got, err = r.Payments.GetTx(ctx, tx, credit.ID)
+		require.NoError(t, err)
+		require.Nil(t, got.APIKeyID)
+		return nil
+	}))
+
+	unknownID := newID(t)
+	err := r.Payments.Store(ctx, &wallet.Payment{
+		ID:       newID(t),
+		To:       toID,
+		Amount:   apd.New(100, 0),
+		APIKeyID: &unknownID,
+	})
+	require.Equal(t, wallet.ErrNoAPIKey, err)
+}
+
+func testScheduledAPIKey(t *testing.T, ctx context.Context, r Repositories) {
+	key, _ := newAPIKey(t, ctx, r, wallet.ScopeTransferWrite)
+	toID := newAccount(t, ctx, r, wallet.USD)
+	fromID := newAccount(t, ctx, r, wallet.USD)
+	executeAt := time.Now().Add(time.Hour)
+
+	st := &wallet.ScheduledTransfer{
+		ID:        newID(t),
+		To:        toID,
+		From:      fromID,
+		Amount:    apd.New(100, 0),
+		ExecuteAt: executeAt,
+		APIKeyID:  &key.ID,
+	}
+	require.NoError(t, r.ScheduledTransfers.Store(ctx, st))
+	require.NotNil(t, st.APIKeyID)
+	require.Equal(t, key.ID, *st.APIKeyID)
+
+	o := &wallet.StandingOrder{
+		ID:        newID(t),
+		To:        toID,
+		From:      fromID,
+		Amount:    apd.New(100, 0),
+		Frequency: wallet.FrequencyDaily,
+		Interval:  1,
+		StartAt:   executeAt,
+		NextRunAt: executeAt,
+		APIKeyID:  &key.ID,
+	}
+	require.NoError(t, r.StandingOrders.Store(ctx, o))
+
+	require.NoError(t, r.UnitOfWork.Do(ctx, func(ctx context.Context, tx wallet.Tx) error {
+		got, err := r.ScheduledTransfers.GetTx(ctx, tx, st.ID)
+		require.NoError(t, err)
+		require.NotNil(t, got.APIKeyID)
+		require.Equal(t, key.ID, *got.APIKeyID)
+		return nil
+	}))
+
+	got, err := r.StandingOrders.Get(ctx, o.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.APIKeyID)
+	require.Equal(t, key.ID, *got.APIKeyID)
+
+	unknownID := newID(t)
+	err = r.ScheduledTransfers.Store(ctx, &wallet.ScheduledTransfer{
+		ID:        newID(t),
+		To:        toID,
+		From:      fromID,
+		Amount:    apd.New(100, 0),
+		ExecuteAt: executeAt,
+		APIKeyID:  &unknownID,
+	})
+	require.Equal(t, wallet.ErrNoAPIKey, err)
+
+	err = r.StandingOrders.Store(ctx, &wallet.StandingOrder{
+		ID:        newID(t),
+		To:        toID,
+		From:      fromID,
+		Amount:    apd.New(100, 0),
+		Frequency: wallet.FrequencyDaily,
+		Interval:  1,
+		StartAt:   executeAt,
+		NextRunAt: executeAt,
+		APIKeyID:  &unknownID,
+	})
+	require.Equal(t, wallet.ErrNoAPIKey, err)
+}
+
+func testPaymentOwner(t *testing.T, ctx context.Context, r Repositories) {
+	ownerID := newCustomer(t, ctx, r)
+	ownedID := newOwnedAccount(t, ctx, r, ownerID, wallet.USD)
+	otherID := newOwnedAccount(t, ctx, r, newCustomer(t, ctx, r), wallet.USD)
+	unownedID := newAccount(t, ctx, r, wallet.USD)
+
+	ids := make(map[uuid.UUID]struct{})
+	for i := 0; i < 3; i++ {
+		ids[deposit(t, ctx, r, ownedID, apd.New(10, 0)).ID] = struct{}{}
+	}
+
+	// Payments from the customer's accounts are included, as well as payments to them
+	p := &wallet.Payment{
+		ID:     newID(t),
+		From:   &ownedID,
+		To:     unownedID,
+		Amount: apd.New(5, 0),
+	}
+	require.NoError(t, r.Payments.Store(ctx, p))
+	ids[p.ID] = struct{}{}
+
+	deposit(t, ctx, r, otherID, apd.New(10, 0))
+	deposit(t, ctx, r, unownedID, apd.New(10, 0))
+
+	var listed []wallet.Payment
+	page := wallet.Page{Limit: 3}
+	for i := 0; ; i++ {
+		require.True(t, i < 3, "too many pages")
+
+		payments, next, err := r.Payments.ListByOwner(ctx, ownerID, page)
+		require.NoError(t, err)
+		require.True(t, len(payments) <= page.Limit)
+		listed = append(listed, payments...)
+
+		if next == "" {
+			break
+		}
+		page.Cursor = next
+	}
+
+	require.Len(t, listed, len(ids))
+	for i, p := range listed {
+		require.Contains(t, ids, p.ID)
+		if i > 0 {
+			// Oldest first
+			require.False(t, p.CreatedAt.Before(listed[i-1].CreatedAt))
+		}
+	}
+
+	payments, next, err := r.Payments.ListByOwner(ctx, newID(t), wallet.Page{Limit: 10})
+	require.NoError(t, err)
+	require.Empty(t, payments)
+	require.Empty(t, next)
+
+	_, _, err = r.Payments.ListByOwner(ctx, ownerID, wallet.Page{Limit: 2, Cursor: "foo"})
+	require.Equal(t, wallet.ErrInvalidCursor, err)
+}